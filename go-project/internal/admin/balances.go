@@ -0,0 +1,70 @@
+package admin
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"balance-service/internal/model"
+)
+
+// BalanceReader reads the wallets of a user.
+type BalanceReader interface {
+	ListUserBalances(ctx context.Context, tenantID string, userID uint) ([]model.Balance, error)
+}
+
+// RegisterBalances exposes the stored balances:
+//
+//	GET /admin/balances/{user_id}   every wallet of the user (?currency=EUR for one)
+//
+// A tenant token reads the wallets of its tenant. The admin token reads the
+// default tenant unless ?tenant= names another.
+func (s *Server) RegisterBalances(reader BalanceReader) {
+	s.HandleTenant("/admin/balances/", func(w http.ResponseWriter, r *http.Request, scope Scope) {
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+
+		rawID := strings.TrimPrefix(r.URL.Path, "/admin/balances/")
+		userID, err := strconv.ParseUint(rawID, 10, 64)
+		if err != nil || userID == 0 {
+			writeError(w, http.StatusNotFound, fmt.Errorf("unknown user %q", rawID))
+			return
+		}
+		currency := r.URL.Query().Get("currency")
+		if currency != "" && !model.ValidCurrency(currency) {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("currency %q is not a three-letter upper-case code", currency))
+			return
+		}
+
+		tenant, status, err := tenantFilter(r, scope)
+		if err != nil {
+			writeError(w, status, err)
+			return
+		}
+		tenantID := ""
+		if tenant != nil {
+			tenantID = *tenant
+		}
+
+		balances, err := reader.ListUserBalances(r.Context(), tenantID, uint(userID))
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		wallets := make([]model.Balance, 0, len(balances))
+		for _, b := range balances {
+			if currency == "" || b.Currency == currency {
+				wallets = append(wallets, b)
+			}
+		}
+		if len(wallets) == 0 {
+			writeError(w, http.StatusNotFound, fmt.Errorf("user %d has no balance", userID))
+			return
+		}
+		writeJSON(w, http.StatusOK, wallets)
+	})
+}
@@ -0,0 +1,138 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"balance-service/internal/model"
+	"balance-service/internal/processor"
+	"balance-service/internal/repository"
+)
+
+const maxPendingLimit = 1000
+
+// PendingReviewer resolves balance updates parked by the hold policy.
+type PendingReviewer interface {
+	List(ctx context.Context, tenant *string, status string, limit int) ([]model.PendingUpdate, error)
+	Get(ctx context.Context, id uint) (*model.PendingUpdate, error)
+	Approve(ctx context.Context, id uint, note string) (*model.PendingUpdate, error)
+	Reject(ctx context.Context, id uint, note string) (*model.PendingUpdate, error)
+}
+
+// RegisterPending exposes the review of held updates:
+//
+//	GET  /admin/pending                list open updates (?status=all|approved|..., ?limit=100)
+//	POST /admin/pending/{id}/approve   apply one, optionally with {"note": "..."}
+//	POST /admin/pending/{id}/reject    close one without applying it
+//
+// A tenant token sees and resolves the updates of its tenant only. The admin
+// token sees every tenant unless ?tenant= names one.
+func (s *Server) RegisterPending(reviewer PendingReviewer) {
+	s.HandleTenant("/admin/pending", func(w http.ResponseWriter, r *http.Request, scope Scope) {
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+
+		status := r.URL.Query().Get("status")
+		switch status {
+		case "":
+			status = model.PendingOpen
+		case "all":
+			status = ""
+		case model.PendingOpen, model.PendingApproved, model.PendingRejected, model.PendingSuperseded:
+		default:
+			writeError(w, http.StatusBadRequest, fmt.Errorf("unknown status %q", status))
+			return
+		}
+
+		limit := 100
+		if raw := r.URL.Query().Get("limit"); raw != "" {
+			parsed, err := strconv.Atoi(raw)
+			if err != nil || parsed < 1 || parsed > maxPendingLimit {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("limit must be between 1 and %d", maxPendingLimit))
+				return
+			}
+			limit = parsed
+		}
+
+		tenant, code, err := tenantFilter(r, scope)
+		if err != nil {
+			writeError(w, code, err)
+			return
+		}
+
+		pending, err := reviewer.List(r.Context(), tenant, status, limit)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if pending == nil {
+			pending = []model.PendingUpdate{}
+		}
+		writeJSON(w, http.StatusOK, pending)
+	})
+
+	s.HandleTenant("/admin/pending/", func(w http.ResponseWriter, r *http.Request, scope Scope) {
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, http.MethodPost)
+			return
+		}
+
+		rawID, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/admin/pending/"), "/")
+		id, err := strconv.ParseUint(rawID, 10, 64)
+		if err != nil || id == 0 {
+			writeError(w, http.StatusNotFound, fmt.Errorf("unknown pending update %q", rawID))
+			return
+		}
+
+		var body struct {
+			Note string `json:"note"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid JSON body: %w", err))
+			return
+		}
+
+		// Updates of other tenants do not exist for a tenant token.
+		pending, err := reviewer.Get(r.Context(), uint(id))
+		if err == nil && !scope.Allows(pending.TenantID) {
+			err = repository.ErrNotFound
+		}
+		if err != nil {
+			if errors.Is(err, repository.ErrNotFound) {
+				writeError(w, http.StatusNotFound, fmt.Errorf("pending update %d not found", id))
+			} else {
+				writeError(w, http.StatusInternalServerError, err)
+			}
+			return
+		}
+
+		switch action {
+		case "approve":
+			pending, err = reviewer.Approve(r.Context(), uint(id), body.Note)
+		case "reject":
+			pending, err = reviewer.Reject(r.Context(), uint(id), body.Note)
+		default:
+			writeError(w, http.StatusNotFound, fmt.Errorf("unknown action %q, expected approve or reject", action))
+			return
+		}
+
+		switch {
+		case errors.Is(err, repository.ErrNotFound):
+			writeError(w, http.StatusNotFound, fmt.Errorf("pending update %d not found", id))
+		case errors.Is(err, processor.ErrResolved):
+			writeError(w, http.StatusConflict, err)
+		case err != nil:
+			writeError(w, http.StatusInternalServerError, err)
+		default:
+			writeJSON(w, http.StatusOK, pending)
+		}
+	})
+}
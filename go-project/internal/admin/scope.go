@@ -0,0 +1,74 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"balance-service/internal/model"
+)
+
+// Scope is what the token of a request may see: every tenant for the admin
+// token, its own tenant for a tenant token. The default tenant is "".
+type Scope struct {
+	Tenant string
+	All    bool
+}
+
+// Allows reports whether the scope covers tenant.
+func (s Scope) Allows(tenant string) bool {
+	return s.All || s.Tenant == tenant
+}
+
+// HandleTenant registers handler behind the admin token or one of the tenant
+// tokens, and passes it the scope of the token that was presented.
+func (s *Server) HandleTenant(pattern string, handler func(w http.ResponseWriter, r *http.Request, scope Scope)) {
+	s.mux.Handle(pattern, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.token == "" && len(s.tenantTokens) == 0 {
+			writeError(w, http.StatusForbidden, errors.New("admin token is not configured"))
+			return
+		}
+		presented, ok := bearerToken(r)
+		if !ok {
+			writeError(w, http.StatusUnauthorized, errors.New("invalid admin token"))
+			return
+		}
+		got := []byte(presented)
+		if s.token != "" && subtle.ConstantTimeCompare(got, []byte(s.token)) == 1 {
+			handler(w, r, Scope{All: true})
+			return
+		}
+		for tenant, token := range s.tenantTokens {
+			if subtle.ConstantTimeCompare(got, []byte(token)) == 1 {
+				handler(w, r, Scope{Tenant: tenant})
+				return
+			}
+		}
+		writeError(w, http.StatusUnauthorized, errors.New("invalid admin token"))
+	}))
+}
+
+// tenantFilter reads the ?tenant= parameter, where "default" names the
+// default tenant. Without it the admin token sees every tenant, which is
+// returned as nil, and a tenant token its own.
+func tenantFilter(r *http.Request, scope Scope) (*string, int, error) {
+	raw := r.URL.Query().Get("tenant")
+	if raw == "" {
+		if scope.All {
+			return nil, http.StatusOK, nil
+		}
+		return &scope.Tenant, http.StatusOK, nil
+	}
+
+	tenant := raw
+	if raw == model.DefaultTenant {
+		tenant = ""
+	} else if !model.ValidTenantID(raw) {
+		return nil, http.StatusBadRequest, fmt.Errorf("tenant %q is not a valid tenant ID", raw)
+	}
+	if !scope.Allows(tenant) {
+		return nil, http.StatusForbidden, fmt.Errorf("the token does not cover tenant %q", raw)
+	}
+	return &tenant, http.StatusOK, nil
+}
@@ -0,0 +1,129 @@
+// Package admin serves the operational HTTP endpoints of the service:
+// liveness, readiness, metrics and the token protected admin API.
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"balance-service/internal/config"
+	"balance-service/internal/metrics"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	checkTimeout    = 2 * time.Second
+	shutdownTimeout = 5 * time.Second
+)
+
+// Check reports why a component is not ready, or nil when it is.
+type Check func(ctx context.Context) error
+
+type Server struct {
+	addr         string
+	token        string
+	tenantTokens map[string]string
+	log          *logrus.Logger
+	mux          *http.ServeMux
+
+	mu     sync.RWMutex
+	checks map[string]Check
+}
+
+func New(cfg config.AdminConfig, log *logrus.Logger) *Server {
+	s := &Server{
+		addr:         cfg.Addr,
+		token:        cfg.Token,
+		tenantTokens: cfg.TenantTokens,
+		log:          log,
+		mux:          http.NewServeMux(),
+		checks:       make(map[string]Check),
+	}
+
+	s.mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	s.mux.HandleFunc("/readyz", s.handleReady)
+	s.mux.Handle("/metrics", metrics.Handler())
+
+	return s
+}
+
+// AddReadinessCheck registers a named check evaluated on every /readyz call.
+func (s *Server) AddReadinessCheck(name string, check Check) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checks[name] = check
+}
+
+// Handle registers an additional endpoint on the admin server.
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// Run serves until ctx is cancelled.
+func (s *Server) Run(ctx context.Context) error {
+	srv := &http.Server{
+		Addr:              s.addr,
+		Handler:           s.mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	s.log.WithField("addr", s.addr).Info("admin server listening")
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	names := make([]string, 0, len(s.checks))
+	for name := range s.checks {
+		names = append(names, name)
+	}
+	s.mu.RUnlock()
+	sort.Strings(names)
+
+	ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+	defer cancel()
+
+	status := http.StatusOK
+	results := make(map[string]string, len(names))
+	for _, name := range names {
+		s.mu.RLock()
+		check := s.checks[name]
+		s.mu.RUnlock()
+
+		if err := check(ctx); err != nil {
+			status = http.StatusServiceUnavailable
+			results[name] = err.Error()
+			continue
+		}
+		results[name] = "ok"
+	}
+
+	body := map[string]interface{}{"status": "ok", "checks": results}
+	if status != http.StatusOK {
+		body["status"] = "unavailable"
+	}
+	writeJSON(w, status, body)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
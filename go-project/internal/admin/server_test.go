@@ -0,0 +1,109 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"balance-service/internal/config"
+	"github.com/sirupsen/logrus"
+)
+
+func testServer(cfg config.AdminConfig) *Server {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	return New(cfg, log)
+}
+
+// serve sends a request with token as the bearer token, if any.
+func serve(s *Server, method, target, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	s.mux.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestReadiness(t *testing.T) {
+	s := testServer(config.AdminConfig{})
+	if rec := serve(s, http.MethodGet, "/healthz", ""); rec.Code != http.StatusOK {
+		t.Fatalf("/healthz: %d", rec.Code)
+	}
+
+	down := errors.New("connection refused")
+	s.AddReadinessCheck("database", func(context.Context) error { return nil })
+	s.AddReadinessCheck("rabbitmq", func(context.Context) error { return down })
+
+	rec := serve(s, http.MethodGet, "/readyz", "")
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("/readyz with a failing check: %d, want 503", rec.Code)
+	}
+	var body struct {
+		Status string            `json:"status"`
+		Checks map[string]string `json:"checks"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Status != "unavailable" || body.Checks["database"] != "ok" || body.Checks["rabbitmq"] != down.Error() {
+		t.Errorf("/readyz body: %+v", body)
+	}
+
+	s.AddReadinessCheck("rabbitmq", func(context.Context) error { return nil })
+	if rec := serve(s, http.MethodGet, "/readyz", ""); rec.Code != http.StatusOK {
+		t.Errorf("/readyz with passing checks: %d, want 200", rec.Code)
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	s := testServer(config.AdminConfig{})
+	rec := serve(s, http.MethodGet, "/metrics", "")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "# TYPE") {
+		t.Errorf("/metrics: %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestProtectedEndpointsRequireBearerTokens(t *testing.T) {
+	s := testServer(config.AdminConfig{Token: "t0ken", TenantTokens: map[string]string{"acme": "acme-t0ken"}})
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) })
+	s.HandleProtected("/admin/protected", ok)
+	s.HandleTenant("/admin/tenant", func(w http.ResponseWriter, r *http.Request, scope Scope) { ok(w, r) })
+
+	for _, c := range []struct {
+		header string
+		want   int
+	}{
+		{"Bearer t0ken", http.StatusNoContent},
+		{"t0ken", http.StatusUnauthorized},
+		{"Basic t0ken", http.StatusUnauthorized},
+		{"bearer t0ken", http.StatusUnauthorized},
+		{"Bearer wrong", http.StatusUnauthorized},
+		{"", http.StatusUnauthorized},
+	} {
+		for _, target := range []string{"/admin/protected", "/admin/tenant"} {
+			req := httptest.NewRequest(http.MethodGet, target, nil)
+			if c.header != "" {
+				req.Header.Set("Authorization", c.header)
+			}
+			rec := httptest.NewRecorder()
+			s.mux.ServeHTTP(rec, req)
+			if rec.Code != c.want {
+				t.Errorf("%s with %q: %d, want %d", target, c.header, rec.Code, c.want)
+			}
+		}
+	}
+
+	if rec := serve(s, http.MethodGet, "/admin/tenant", "acme-t0ken"); rec.Code != http.StatusNoContent {
+		t.Errorf("/admin/tenant with a tenant token: %d", rec.Code)
+	}
+	if rec := serve(s, http.MethodGet, "/admin/protected", "acme-t0ken"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("/admin/protected with a tenant token: %d, want 401", rec.Code)
+	}
+}
@@ -0,0 +1,153 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"balance-service/internal/tuning"
+)
+
+// settingsView is the JSON form of tuning.Settings; durations are Go
+// duration strings such as "2s".
+type settingsView struct {
+	BatchSize     int    `json:"batch_size"`
+	BatchInterval string `json:"batch_interval"`
+	Prefetch      int    `json:"prefetch"`
+	LogLevel      string `json:"log_level"`
+	SyncInterval  string `json:"sync_interval"`
+}
+
+func viewOf(s tuning.Settings) settingsView {
+	return settingsView{
+		BatchSize:     s.BatchSize,
+		BatchInterval: s.BatchInterval.String(),
+		Prefetch:      s.Prefetch,
+		LogLevel:      s.LogLevel,
+		SyncInterval:  s.SyncInterval.String(),
+	}
+}
+
+// HandleProtected registers handler behind the admin bearer token. Without a
+// configured token the endpoint refuses every request.
+func (s *Server) HandleProtected(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.token == "" {
+			writeError(w, http.StatusForbidden, errors.New("admin token is not configured"))
+			return
+		}
+		got, ok := bearerToken(r)
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(s.token)) != 1 {
+			writeError(w, http.StatusUnauthorized, errors.New("invalid admin token"))
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+}
+
+// bearerToken returns the token of a "Bearer" Authorization header. Any
+// other header, including a bare token, carries none.
+func bearerToken(r *http.Request) (string, bool) {
+	return strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
+// RegisterSettings exposes the runtime settings:
+//
+//	GET   /admin/settings  current values
+//	PATCH /admin/settings  change some of them, e.g. {"batch_size": 200}
+//	POST  /admin/reload    re-read the config file and environment
+func (s *Server) RegisterSettings(store *tuning.Store, reload func() (tuning.Settings, error)) {
+	s.HandleProtected("/admin/settings", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, viewOf(store.Get()))
+		case http.MethodPatch:
+			next, err := patchSettings(store.Get(), r)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+			if _, err := store.Update(next); err != nil {
+				writeError(w, http.StatusUnprocessableEntity, err)
+				return
+			}
+			s.log.WithField("settings", viewOf(next)).Warn("runtime settings changed via admin API")
+			writeJSON(w, http.StatusOK, viewOf(next))
+		default:
+			methodNotAllowed(w, http.MethodGet, http.MethodPatch)
+		}
+	}))
+
+	s.HandleProtected("/admin/reload", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, http.MethodPost)
+			return
+		}
+		next, err := reload()
+		if err != nil {
+			writeError(w, http.StatusUnprocessableEntity, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, viewOf(next))
+	}))
+}
+
+// patchSettings applies the JSON object in the request body on top of
+// current. Keys that are not runtime-tunable are rejected by name.
+func patchSettings(current tuning.Settings, r *http.Request) (tuning.Settings, error) {
+	var patch map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		return current, fmt.Errorf("invalid JSON body: %w", err)
+	}
+
+	var unknown []string
+	for key, raw := range patch {
+		var err error
+		switch key {
+		case "batch_size":
+			err = json.Unmarshal(raw, &current.BatchSize)
+		case "batch_interval":
+			current.BatchInterval, err = parseDuration(raw)
+		case "prefetch":
+			err = json.Unmarshal(raw, &current.Prefetch)
+		case "log_level":
+			err = json.Unmarshal(raw, &current.LogLevel)
+		case "sync_interval":
+			current.SyncInterval, err = parseDuration(raw)
+		default:
+			unknown = append(unknown, key)
+			continue
+		}
+		if err != nil {
+			return current, fmt.Errorf("%s: %w", key, err)
+		}
+	}
+
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return current, fmt.Errorf("%s cannot be changed at runtime", strings.Join(unknown, ", "))
+	}
+	return current, nil
+}
+
+func parseDuration(raw json.RawMessage) (time.Duration, error) {
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return 0, errors.New(`expected a duration string such as "5s"`)
+	}
+	return time.ParseDuration(s)
+}
+
+func methodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
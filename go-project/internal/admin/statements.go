@@ -0,0 +1,99 @@
+package admin
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"balance-service/internal/model"
+	"balance-service/internal/statement"
+)
+
+// StatementBuilder builds the statement of a wallet over a period.
+type StatementBuilder interface {
+	Build(ctx context.Context, wallet model.WalletKey, from, to time.Time) (*statement.Statement, error)
+	Clock() statement.Clock
+}
+
+// RegisterStatements exposes account statements:
+//
+//	GET /admin/statements/{user_id}?month=2026-09             one month
+//	GET /admin/statements/{user_id}?from=2026-09-01&to=...    any days
+//
+// ?currency= picks the wallet (default: defaultCurrency) and ?format= the
+// output: json (default), csv or text. Tenants are scoped as for balances.
+func (s *Server) RegisterStatements(builder StatementBuilder, defaultCurrency string) {
+	s.HandleTenant("/admin/statements/", func(w http.ResponseWriter, r *http.Request, scope Scope) {
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+
+		query := r.URL.Query()
+		rawID := strings.TrimPrefix(r.URL.Path, "/admin/statements/")
+		userID, err := strconv.ParseUint(rawID, 10, 64)
+		if err != nil || userID == 0 {
+			writeError(w, http.StatusNotFound, fmt.Errorf("unknown user %q", rawID))
+			return
+		}
+		currency := query.Get("currency")
+		if currency == "" {
+			currency = defaultCurrency
+		}
+		if !model.ValidCurrency(currency) {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("currency %q is not a three-letter upper-case code", currency))
+			return
+		}
+		format := query.Get("format")
+		if format == "" {
+			format = statement.FormatJSON
+		}
+		contentType, ok := statement.ContentTypes[format]
+		if !ok {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("format %q is not json, csv or text", format))
+			return
+		}
+		from, to, err := statement.ParsePeriod(query.Get("month"), query.Get("from"), query.Get("to"))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		tenant, status, err := tenantFilter(r, scope)
+		if err != nil {
+			writeError(w, status, err)
+			return
+		}
+		tenantID := ""
+		if tenant != nil {
+			tenantID = *tenant
+		}
+
+		wallet := model.WalletKey{TenantID: tenantID, UserID: uint(userID), Currency: currency}
+		st, err := builder.Build(r.Context(), wallet, from, to)
+		switch {
+		case errors.Is(err, statement.ErrPeriod):
+			writeError(w, http.StatusBadRequest, err)
+			return
+		case errors.Is(err, statement.ErrNoSnapshot):
+			writeError(w, http.StatusNotFound, err)
+			return
+		case err != nil:
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		var body bytes.Buffer
+		if err := statement.Write(&body, st, format, builder.Clock().Location()); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		w.Header().Set("Content-Type", contentType)
+		_, _ = w.Write(body.Bytes())
+	})
+}
@@ -0,0 +1,303 @@
+// Package alert checks committed balance changes against configurable rules.
+// Hits are stored in the alerts table and passed to a sink; repeats of a rule
+// for the same wallet within its dedup window are only counted.
+package alert
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"balance-service/internal/config"
+	"balance-service/internal/metrics"
+	"balance-service/internal/model"
+	"balance-service/internal/processor"
+	"balance-service/internal/repository"
+	"github.com/sirupsen/logrus"
+)
+
+// Rule kinds.
+const (
+	KindBalanceBelow   = "balance_below"
+	KindChangeAbove    = "change_above"
+	KindRateAbove      = "rate_above"
+	KindNegativeAmount = "negative_amount"
+)
+
+const (
+	backlog = 1000
+	// maxTracked bounds the dedup and rate state before expired entries
+	// are pruned.
+	maxTracked = 10000
+	rateWindow = time.Minute
+	// sendTimeout bounds storing and sending one alert.
+	sendTimeout = 30 * time.Second
+)
+
+var (
+	alertsRaised     = metrics.NewCounter("balance_alerts_total", "Alerts raised, by rule.", "rule")
+	alertsSuppressed = metrics.NewCounter("balance_alerts_suppressed_total", "Repeated alerts silenced by the dedup window, by rule.", "rule")
+	alertsDropped    = metrics.NewCounter("balance_alerts_dropped_total", "Alerts lost because the backlog was full or they could not be stored.")
+	sinkFailures     = metrics.NewCounter("balance_alerts_sink_failures_total", "Alerts the sink failed to send.")
+)
+
+type rule struct {
+	name      string
+	kind      string
+	threshold float64
+	dedup     time.Duration
+}
+
+type firedKey struct {
+	rule   string
+	wallet model.WalletKey
+}
+
+type rateSample struct {
+	at      time.Time
+	updates uint
+}
+
+// Engine evaluates the rules on every committed batch. It implements
+// processor.CommitObserver; storing and sending happen in the background.
+type Engine struct {
+	rules []rule
+	rates bool // whether any rule needs the update rate
+	repo  *repository.AlertRepository
+	sink  Sink
+	log   *logrus.Logger
+
+	alerts chan model.Alert
+	done   chan struct{}
+
+	mu      sync.Mutex
+	fired   map[firedKey]time.Time
+	samples map[model.WalletKey][]rateSample
+	closed  bool
+}
+
+// New starts an engine for the rules of cfg. It takes over closing sink.
+func New(cfg config.AlertConfig, repo *repository.AlertRepository, sink Sink, log *logrus.Logger) *Engine {
+	e := &Engine{
+		repo:    repo,
+		sink:    sink,
+		log:     log,
+		alerts:  make(chan model.Alert, backlog),
+		done:    make(chan struct{}),
+		fired:   make(map[firedKey]time.Time),
+		samples: make(map[model.WalletKey][]rateSample),
+	}
+	for _, r := range cfg.Rules {
+		name, dedup := r.Name, r.DedupWindow
+		if name == "" {
+			name = r.Kind
+		}
+		if dedup == 0 {
+			dedup = cfg.DedupWindow
+		}
+		e.rules = append(e.rules, rule{name: name, kind: r.Kind, threshold: r.Threshold, dedup: dedup})
+		e.rates = e.rates || r.Kind == KindRateAbove
+	}
+
+	go e.run()
+	return e
+}
+
+// BatchCommitted implements processor.CommitObserver. It never blocks.
+func (e *Engine) BatchCommitted(_ context.Context, commit processor.Commit) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.closed {
+		return
+	}
+	now := time.Now()
+	for _, change := range commit.Changes {
+		var perMinute uint
+		if e.rates {
+			perMinute = e.countUpdatesLocked(change, now)
+		}
+		for _, r := range e.rules {
+			alert, hit := r.evaluate(change, perMinute)
+			if !hit {
+				continue
+			}
+			if !e.firstLocked(r, model.WalletKey{TenantID: change.TenantID, UserID: change.UserID, Currency: change.Currency}, now) {
+				alertsSuppressed.Inc(r.name)
+				continue
+			}
+
+			select {
+			case e.alerts <- alert:
+			default:
+				alertsDropped.Inc()
+				e.log.WithFields(logrus.Fields{
+					"rule":      r.name,
+					"user_id":   change.UserID,
+					"tenant_id": change.TenantID,
+					"currency":  change.Currency,
+				}).Warn("alert backlog is full, dropping alert")
+			}
+		}
+	}
+}
+
+// evaluate checks one change. perMinute is the wallet's update count over
+// the last minute, this change included.
+func (r rule) evaluate(c processor.BalanceChange, perMinute uint) (model.Alert, bool) {
+	var (
+		value   float64
+		message string
+	)
+	switch r.kind {
+	case KindBalanceBelow:
+		// Only the drop itself: a balance that stays low is not news.
+		if c.Amount >= r.threshold || (c.PreviousAmount != nil && *c.PreviousAmount < r.threshold) {
+			return model.Alert{}, false
+		}
+		value = c.Amount
+		message = fmt.Sprintf("%s balance dropped to %.2f, below %.2f", c.Currency, c.Amount, r.threshold)
+	case KindChangeAbove:
+		if c.PreviousAmount == nil {
+			return model.Alert{}, false
+		}
+		value = c.Amount - *c.PreviousAmount
+		if math.Abs(value) <= r.threshold {
+			return model.Alert{}, false
+		}
+		message = fmt.Sprintf("%s balance changed by %+.2f between versions %d and %d, more than %.2f",
+			c.Currency, value, c.PreviousVersion, c.Version, r.threshold)
+	case KindRateAbove:
+		if float64(perMinute) <= r.threshold {
+			return model.Alert{}, false
+		}
+		value = float64(perMinute)
+		message = fmt.Sprintf("%d updates in the last minute, more than %.0f", perMinute, r.threshold)
+	case KindNegativeAmount:
+		if c.Amount >= 0 {
+			return model.Alert{}, false
+		}
+		value = c.Amount
+		message = fmt.Sprintf("%s balance is negative: %.2f", c.Currency, c.Amount)
+	default:
+		return model.Alert{}, false
+	}
+
+	return model.Alert{
+		CreatedAt:      time.Now().UTC(),
+		Rule:           r.name,
+		Kind:           r.kind,
+		UserID:         c.UserID,
+		TenantID:       c.TenantID,
+		Currency:       c.Currency,
+		Amount:         c.Amount,
+		PreviousAmount: c.PreviousAmount,
+		Version:        c.Version,
+		Value:          value,
+		Threshold:      r.threshold,
+		EventID:        c.EventID,
+		Message:        message,
+	}, true
+}
+
+// countUpdatesLocked records change and returns the wallet's updates within
+// the rate window. A batch keeps only the newest update of a wallet, so the
+// versions it advanced are counted rather than the changes.
+func (e *Engine) countUpdatesLocked(c processor.BalanceChange, now time.Time) uint {
+	updates := uint(1)
+	if c.PreviousAmount != nil && c.Version > c.PreviousVersion {
+		updates = c.Version - c.PreviousVersion
+	}
+
+	if len(e.samples) >= maxTracked {
+		for wallet, samples := range e.samples {
+			if now.Sub(samples[len(samples)-1].at) >= rateWindow {
+				delete(e.samples, wallet)
+			}
+		}
+	}
+
+	wallet := model.WalletKey{TenantID: c.TenantID, UserID: c.UserID, Currency: c.Currency}
+	samples := e.samples[wallet]
+	for len(samples) > 0 && now.Sub(samples[0].at) >= rateWindow {
+		samples = samples[1:]
+	}
+	samples = append(samples, rateSample{at: now, updates: updates})
+	e.samples[wallet] = samples
+
+	var total uint
+	for _, s := range samples {
+		total += s.updates
+	}
+	return total
+}
+
+// firstLocked reports whether the rule has not fired for the wallet within
+// its dedup window, and starts a new window if so.
+func (e *Engine) firstLocked(r rule, wallet model.WalletKey, now time.Time) bool {
+	key := firedKey{rule: r.name, wallet: wallet}
+	if last, ok := e.fired[key]; ok && now.Sub(last) < r.dedup {
+		return false
+	}
+
+	if len(e.fired) >= maxTracked {
+		for k, last := range e.fired {
+			if now.Sub(last) >= e.dedupOf(k.rule) {
+				delete(e.fired, k)
+			}
+		}
+	}
+	e.fired[key] = now
+	return true
+}
+
+func (e *Engine) dedupOf(name string) time.Duration {
+	for _, r := range e.rules {
+		if r.name == name {
+			return r.dedup
+		}
+	}
+	return 0
+}
+
+func (e *Engine) run() {
+	defer close(e.done)
+
+	for alert := range e.alerts {
+		ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+		e.handle(ctx, alert)
+		cancel()
+	}
+}
+
+func (e *Engine) handle(ctx context.Context, alert model.Alert) {
+	fields := logrus.Fields{
+		"rule":    alert.Rule,
+		"user_id": alert.UserID,
+	}
+	if err := e.repo.SaveAlert(ctx, &alert); err != nil {
+		alertsDropped.Inc()
+		e.log.WithError(err).WithFields(fields).Error("failed to store alert")
+		return
+	}
+	alertsRaised.Inc(alert.Rule)
+
+	if err := e.sink.Send(ctx, alert); err != nil {
+		sinkFailures.Inc()
+		e.log.WithError(err).WithFields(fields).WithField("alert_id", alert.ID).Error("failed to send alert")
+	}
+}
+
+// Close stores and sends the queued alerts, then closes the sink. Later
+// batches are ignored.
+func (e *Engine) Close() {
+	e.mu.Lock()
+	e.closed = true
+	close(e.alerts)
+	e.mu.Unlock()
+
+	<-e.done
+	e.sink.Close()
+}
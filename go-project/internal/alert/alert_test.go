@@ -0,0 +1,100 @@
+package alert
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"balance-service/internal/config"
+	"balance-service/internal/database"
+	"balance-service/internal/model"
+	"balance-service/internal/processor"
+	"balance-service/internal/repository"
+	"balance-service/internal/webhook"
+)
+
+// endpoint answers the first request with a server error and accepts the
+// rest if they are signed with secret.
+type endpoint struct {
+	secret string
+
+	mu       sync.Mutex
+	requests int
+	rules    []string
+}
+
+func (e *endpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.requests++; e.requests == 1 {
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+
+	header := r.Header.Get(webhook.HeaderSignature)
+	ts, _, _ := strings.Cut(strings.TrimPrefix(header, "t="), ",")
+	sec, _ := strconv.ParseInt(ts, 10, 64)
+	if header != webhook.Sign(e.secret, time.Unix(sec, 0), body) || r.Header.Get(webhook.HeaderEvent) != EventType {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	var p struct{ Data model.Alert }
+	_ = json.Unmarshal(body, &p)
+	e.rules = append(e.rules, p.Data.Rule)
+}
+
+func TestEngineStoresAndSendsAlerts(t *testing.T) {
+	db, log := database.OpenTest(t)
+
+	ep := &endpoint{secret: "s3cret"}
+	server := httptest.NewServer(ep)
+	defer server.Close()
+	sink, err := NewSink(config.AlertSinkConfig{Type: "webhook", URL: server.URL, Secret: "s3cret", Timeout: time.Second}, config.RabbitConfig{}, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink.(*webhookSink).sender.InitialBackoff = time.Millisecond
+
+	engine := New(config.AlertConfig{
+		DedupWindow: time.Hour,
+		Rules: []config.AlertRule{
+			{Kind: KindBalanceBelow, Threshold: 10},
+			{Name: "large", Kind: KindChangeAbove, Threshold: 100},
+		},
+	}, repository.NewAlertRepository(db, log), sink, log)
+
+	prev := func(v float64) *float64 { return &v }
+	engine.BatchCommitted(context.Background(), processor.Commit{Changes: []processor.BalanceChange{
+		// Drops below 10 and by more than 100.
+		{UserID: 1, Currency: "USD", Amount: 5, Version: 2, PreviousAmount: prev(200), PreviousVersion: 1},
+		// Already below 10: not news.
+		{UserID: 2, Currency: "USD", Amount: 3, Version: 2, PreviousAmount: prev(4), PreviousVersion: 1},
+	}})
+	// Within the dedup window of the first drop.
+	engine.BatchCommitted(context.Background(), processor.Commit{Changes: []processor.BalanceChange{
+		{UserID: 1, Currency: "USD", Amount: 1, Version: 4, PreviousAmount: prev(50), PreviousVersion: 3},
+	}})
+	engine.Close()
+
+	var stored []model.Alert
+	if err := db.Order("rule").Find(&stored).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 2 || stored[0].Rule != KindBalanceBelow || stored[1].Rule != "large" || stored[1].Value != -195 {
+		t.Errorf("stored alerts %+v, want balance_below and large for user 1", stored)
+	}
+
+	sort.Strings(ep.rules)
+	if got := strings.Join(ep.rules, ","); got != "balance_below,large" {
+		t.Errorf("sent alerts %s, want balance_below,large after a retry", got)
+	}
+}
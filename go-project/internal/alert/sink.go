@@ -0,0 +1,134 @@
+package alert
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"balance-service/internal/config"
+	"balance-service/internal/model"
+	"balance-service/internal/publisher"
+	"balance-service/internal/webhook"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/sirupsen/logrus"
+)
+
+// EventType is the webhook event and AMQP message type of alerts.
+const EventType = "balance.alert"
+
+// The webhook sink retries briefly: the alert is stored in the alerts table
+// whether it is delivered or not.
+const (
+	webhookAttempts = 3
+	webhookBackoff  = time.Second
+)
+
+// Sink delivers stored alerts.
+type Sink interface {
+	Send(ctx context.Context, alert model.Alert) error
+	Close()
+}
+
+// NewSink creates the sink cfg selects. The amqp sink publishes through
+// the broker of rabbit.
+func NewSink(cfg config.AlertSinkConfig, rabbit config.RabbitConfig, log *logrus.Logger) (Sink, error) {
+	switch cfg.Type {
+	case "log":
+		return logSink{log: log}, nil
+	case "webhook":
+		return &webhookSink{
+			url:    cfg.URL,
+			secret: cfg.Secret,
+			sender: &webhook.Sender{
+				Client:         &http.Client{Timeout: cfg.Timeout},
+				UserAgent:      "balance-service-alerts",
+				MaxAttempts:    webhookAttempts,
+				InitialBackoff: webhookBackoff,
+			},
+		}, nil
+	case "amqp":
+		pub, err := publisher.New(rabbit, publisher.Options{Exchanges: []string{cfg.Exchange}}, log)
+		if err != nil {
+			return nil, err
+		}
+		return &amqpSink{pub: pub, exchange: cfg.Exchange, routingKey: cfg.RoutingKey}, nil
+	default:
+		return nil, fmt.Errorf("unknown alert sink %q", cfg.Type)
+	}
+}
+
+// logSink writes alerts to the service log.
+type logSink struct {
+	log *logrus.Logger
+}
+
+func (s logSink) Send(_ context.Context, alert model.Alert) error {
+	s.log.WithFields(logrus.Fields{
+		"alert_id":  alert.ID,
+		"rule":      alert.Rule,
+		"kind":      alert.Kind,
+		"user_id":   alert.UserID,
+		"tenant_id": alert.TenantID,
+		"currency":  alert.Currency,
+		"amount":    alert.Amount,
+		"version":   alert.Version,
+		"value":     alert.Value,
+		"threshold": alert.Threshold,
+	}).Warn("alert: " + alert.Message)
+	return nil
+}
+
+func (logSink) Close() {}
+
+// webhookSink posts alerts in the webhook payload format, signed the same
+// way when a secret is set.
+type webhookSink struct {
+	url    string
+	secret string
+	sender *webhook.Sender
+}
+
+func (s *webhookSink) Send(ctx context.Context, alert model.Alert) error {
+	id := "alert-" + strconv.FormatUint(uint64(alert.ID), 10)
+	body, err := json.Marshal(webhook.Payload{
+		ID:        id,
+		Type:      EventType,
+		CreatedAt: alert.CreatedAt,
+		Data:      alert,
+	})
+	if err != nil {
+		return err
+	}
+	return s.sender.Deliver(ctx, webhook.Message{URL: s.url, Secret: s.secret, ID: id, EventType: EventType, Body: body}, nil)
+}
+
+func (s *webhookSink) Close() {}
+
+// amqpSink publishes alerts as JSON to a topic exchange.
+type amqpSink struct {
+	pub        *publisher.Publisher
+	exchange   string
+	routingKey string
+}
+
+func (s *amqpSink) Send(ctx context.Context, alert model.Alert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	return s.pub.Send(ctx, s.exchange, s.routingKey, amqp.Publishing{
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+		Type:         EventType,
+		MessageId:    "alert-" + strconv.FormatUint(uint64(alert.ID), 10),
+		Timestamp:    alert.CreatedAt,
+		Body:         body,
+	})
+}
+
+func (s *amqpSink) Close() {
+	s.pub.Close()
+}
@@ -0,0 +1,181 @@
+// Package bootstrap seeds an empty store from a snapshot of the upstream
+// balances, so users whose balance never changes are known too. A load
+// records a watermark per source after every batch and resumes from it when
+// interrupted. Overlap with the updates consumed afterwards is harmless: the
+// version-guarded upsert keeps whichever side is newer.
+package bootstrap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"balance-service/internal/ledger"
+	"balance-service/internal/model"
+	"balance-service/internal/repository"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// Source yields snapshot rows in batches.
+type Source interface {
+	// ID names the source in the watermark table.
+	ID() string
+	// Fingerprint identifies the snapshot for resumes; empty if the source
+	// cannot tell.
+	Fingerprint() string
+	// SnapshotAt is when the snapshot was taken.
+	SnapshotAt() time.Time
+	// Resume skips what the watermark says was already loaded.
+	Resume(ctx context.Context, wm *model.BootstrapWatermark) error
+	// Next returns up to n rows, or io.EOF once the snapshot is exhausted.
+	Next(ctx context.Context, n int) ([]model.Balance, error)
+	// Progress is the fraction loaded so far, negative when unknown.
+	Progress() float64
+	Close() error
+}
+
+type Options struct {
+	BatchSize int
+	// Currency is given to rows without one, which is every row of the
+	// upstream table.
+	Currency string
+	// Restart discards the watermark and loads the whole snapshot again.
+	Restart          bool
+	ProgressInterval time.Duration
+}
+
+// Result summarises a load.
+type Result struct {
+	Source     string    `json:"source"`
+	SnapshotAt time.Time `json:"snapshot_at"`
+	Rows       int64     `json:"rows"`
+	Loaded     int64     `json:"loaded"` // rows written by this run
+	Resumed    bool      `json:"resumed"`
+	Skipped    bool      `json:"skipped"` // the source was already loaded
+	Duration   string    `json:"duration"`
+}
+
+// Run loads src into the balances table of db. Each batch and the advanced
+// watermark are committed together, so a resume never skips or repeats a
+// batch.
+func Run(ctx context.Context, db *gorm.DB, src Source, opts Options, log *logrus.Logger) (*Result, error) {
+	started := time.Now()
+	watermarks := repository.NewWatermarkRepository(db, log)
+
+	if opts.Restart {
+		if err := watermarks.DeleteWatermark(ctx, src.ID()); err != nil {
+			return nil, fmt.Errorf("reset watermark: %w", err)
+		}
+	}
+
+	wm, err := watermarks.GetWatermark(ctx, src.ID())
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		wm = &model.BootstrapWatermark{
+			Source:      src.ID(),
+			Fingerprint: src.Fingerprint(),
+			SnapshotAt:  src.SnapshotAt(),
+		}
+	case err != nil:
+		return nil, fmt.Errorf("read watermark: %w", err)
+	}
+
+	result := &Result{Source: src.ID(), SnapshotAt: wm.SnapshotAt}
+	if wm.CompletedAt != nil {
+		result.Rows, result.Skipped = wm.Rows, true
+		result.Duration = time.Since(started).String()
+		return result, nil
+	}
+
+	if wm.ID != 0 {
+		if wm.Fingerprint != src.Fingerprint() {
+			return nil, fmt.Errorf("source %s changed since the interrupted load (%s, now %s); load it again with a restart",
+				src.ID(), wm.Fingerprint, src.Fingerprint())
+		}
+		if err := src.Resume(ctx, wm); err != nil {
+			return nil, fmt.Errorf("resume after %d rows: %w", wm.Rows, err)
+		}
+		result.Resumed = true
+		log.WithFields(logrus.Fields{
+			"source":       wm.Source,
+			"rows":         wm.Rows,
+			"last_user_id": wm.LastUserID,
+		}).Info("resuming bootstrap")
+	}
+
+	progress := time.NewTicker(opts.ProgressInterval)
+	defer progress.Stop()
+
+	for {
+		batch, err := src.Next(ctx, opts.BatchSize)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		wm.Rows += int64(len(batch))
+		for i, b := range batch {
+			if b.Currency == "" {
+				batch[i].Currency = opts.Currency
+			}
+			if b.UserID > wm.LastUserID {
+				wm.LastUserID = b.UserID
+			}
+		}
+		if err := commit(ctx, db, batch, wm, log); err != nil {
+			return nil, err
+		}
+		result.Loaded += int64(len(batch))
+
+		select {
+		case <-progress.C:
+			logProgress(log, wm, src.Progress(), result.Loaded, started)
+		default:
+		}
+	}
+
+	now := time.Now().UTC()
+	wm.CompletedAt = &now
+	if err := watermarks.SaveWatermark(ctx, wm); err != nil {
+		return nil, fmt.Errorf("complete watermark: %w", err)
+	}
+
+	result.Rows = wm.Rows
+	result.Duration = time.Since(started).String()
+	return result, nil
+}
+
+func commit(ctx context.Context, db *gorm.DB, batch []model.Balance, wm *model.BootstrapWatermark, log *logrus.Logger) error {
+	err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		postings := make([]ledger.Posting, len(batch))
+		for i, b := range batch {
+			postings[i] = ledger.Posting{Kind: model.LedgerOpening, Balance: b}
+		}
+		if _, err := ledger.New(tx, log).Apply(ctx, postings); err != nil {
+			return err
+		}
+		return repository.NewWatermarkRepository(tx, log).SaveWatermark(ctx, wm)
+	})
+	if err != nil {
+		return fmt.Errorf("load batch ending at row %d: %w", wm.Rows, err)
+	}
+	return nil
+}
+
+func logProgress(log *logrus.Logger, wm *model.BootstrapWatermark, progress float64, loaded int64, started time.Time) {
+	fields := logrus.Fields{
+		"source":       wm.Source,
+		"rows":         wm.Rows,
+		"last_user_id": wm.LastUserID,
+		"rows_per_sec": int64(float64(loaded) / time.Since(started).Seconds()),
+	}
+	if progress >= 0 {
+		fields["percent"] = fmt.Sprintf("%.1f", progress*100)
+	}
+	log.WithFields(fields).Info("bootstrap progress")
+}
@@ -0,0 +1,301 @@
+package bootstrap
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"balance-service/internal/model"
+)
+
+// File formats a snapshot file can be in.
+const (
+	FormatCSV   = "csv"
+	FormatJSONL = "jsonl"
+)
+
+// row is one snapshot record. amount and new_amount are both accepted so
+// that an export of the balances table and a dump of messages both load.
+// Records without a currency are loaded into the default one, records
+// without a tenant into the default tenant.
+type row struct {
+	UserID    uint     `json:"user_id"`
+	TenantID  string   `json:"tenant_id"`
+	Currency  string   `json:"currency"`
+	Amount    *float64 `json:"amount"`
+	NewAmount *float64 `json:"new_amount"`
+	Version   *uint    `json:"version"`
+}
+
+// FileSource reads a CSV file with a header row (user_id, amount, version
+// and optionally currency and tenant_id) or a JSONL file with one balance per line.
+type FileSource struct {
+	path        string
+	format      string
+	fingerprint string
+	snapshotAt  time.Time
+
+	file    *os.File
+	size    int64
+	counted *countingReader
+	csv     *csv.Reader
+	columns map[string]int
+	lines   *bufio.Scanner
+	record  int64 // records read so far, the header not included
+}
+
+// OpenFile opens a snapshot file. An empty format is taken from the file
+// extension. The file's modification time is the snapshot time unless
+// snapshotAt is set.
+func OpenFile(path, format string, snapshotAt time.Time) (*FileSource, error) {
+	if format == "" {
+		switch ext := strings.ToLower(filepath.Ext(path)); ext {
+		case ".csv":
+			format = FormatCSV
+		case ".jsonl", ".ndjson":
+			format = FormatJSONL
+		default:
+			return nil, fmt.Errorf("cannot tell the format of %s from %q, expected .csv or .jsonl", path, ext)
+		}
+	}
+	if format != FormatCSV && format != FormatJSONL {
+		return nil, fmt.Errorf("unsupported format %q, expected %s or %s", format, FormatCSV, FormatJSONL)
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(abs)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if snapshotAt.IsZero() {
+		snapshotAt = info.ModTime().UTC()
+	}
+
+	s := &FileSource{
+		path:        abs,
+		format:      format,
+		fingerprint: fmt.Sprintf("size=%d mtime=%d", info.Size(), info.ModTime().Unix()),
+		snapshotAt:  snapshotAt,
+		file:        f,
+		size:        info.Size(),
+		counted:     &countingReader{r: f},
+	}
+
+	if format == FormatCSV {
+		s.csv = csv.NewReader(s.counted)
+		s.csv.ReuseRecord = true
+		if err := s.readHeader(); err != nil {
+			f.Close()
+			return nil, err
+		}
+	} else {
+		s.lines = bufio.NewScanner(s.counted)
+		s.lines.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	}
+	return s, nil
+}
+
+func (s *FileSource) readHeader() error {
+	header, err := s.csv.Read()
+	if err != nil {
+		return fmt.Errorf("%s: read header: %w", s.path, err)
+	}
+	s.columns = make(map[string]int, len(header))
+	for i, name := range header {
+		s.columns[strings.TrimSpace(strings.ToLower(name))] = i
+	}
+	if _, ok := s.columns["new_amount"]; ok {
+		if _, ok := s.columns["amount"]; !ok {
+			s.columns["amount"] = s.columns["new_amount"]
+		}
+	}
+	for _, required := range []string{"user_id", "amount", "version"} {
+		if _, ok := s.columns[required]; !ok {
+			return fmt.Errorf("%s: header has no %s column", s.path, required)
+		}
+	}
+	return nil
+}
+
+func (s *FileSource) ID() string            { return "file:" + s.path }
+func (s *FileSource) Fingerprint() string   { return s.fingerprint }
+func (s *FileSource) SnapshotAt() time.Time { return s.snapshotAt }
+
+// Resume skips the records an earlier run loaded.
+func (s *FileSource) Resume(ctx context.Context, wm *model.BootstrapWatermark) error {
+	for s.record < wm.Rows {
+		if _, err := s.next(); err != nil {
+			if errors.Is(err, io.EOF) {
+				return fmt.Errorf("%s has only %d records", s.path, s.record)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *FileSource) Next(ctx context.Context, n int) ([]model.Balance, error) {
+	batch := make([]model.Balance, 0, n)
+	for len(batch) < n {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		b, err := s.next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		batch = append(batch, b)
+	}
+	if len(batch) == 0 {
+		return nil, io.EOF
+	}
+	return batch, nil
+}
+
+// next reads and validates one record.
+func (s *FileSource) next() (model.Balance, error) {
+	var (
+		r   row
+		err error
+	)
+	if s.format == FormatCSV {
+		r, err = s.nextCSV()
+	} else {
+		r, err = s.nextJSON()
+	}
+	if err != nil {
+		return model.Balance{}, err
+	}
+	s.record++
+
+	b, err := r.balance()
+	if err != nil {
+		return model.Balance{}, fmt.Errorf("%s: record %d: %w", s.path, s.record, err)
+	}
+	return b, nil
+}
+
+func (s *FileSource) nextCSV() (row, error) {
+	record, err := s.csv.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return row{}, err
+		}
+		return row{}, fmt.Errorf("%s: %w", s.path, err)
+	}
+
+	field := func(name string) string { return strings.TrimSpace(record[s.columns[name]]) }
+	var r row
+
+	userID, err := strconv.ParseUint(field("user_id"), 10, 64)
+	if err != nil {
+		return row{}, fmt.Errorf("%s: record %d: user_id %q is not a positive integer", s.path, s.record+1, field("user_id"))
+	}
+	r.UserID = uint(userID)
+	if _, ok := s.columns["currency"]; ok {
+		r.Currency = field("currency")
+	}
+	if _, ok := s.columns["tenant_id"]; ok {
+		r.TenantID = field("tenant_id")
+	}
+
+	amount, err := strconv.ParseFloat(field("amount"), 64)
+	if err != nil {
+		return row{}, fmt.Errorf("%s: record %d: amount %q is not a number", s.path, s.record+1, field("amount"))
+	}
+	r.Amount = &amount
+
+	version, err := strconv.ParseUint(field("version"), 10, 64)
+	if err != nil {
+		return row{}, fmt.Errorf("%s: record %d: version %q is not a non-negative integer", s.path, s.record+1, field("version"))
+	}
+	v := uint(version)
+	r.Version = &v
+
+	return r, nil
+}
+
+func (s *FileSource) nextJSON() (row, error) {
+	for s.lines.Scan() {
+		line := strings.TrimSpace(s.lines.Text())
+		if line == "" {
+			continue
+		}
+		var r row
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			return row{}, fmt.Errorf("%s: record %d: %w", s.path, s.record+1, err)
+		}
+		return r, nil
+	}
+	if err := s.lines.Err(); err != nil {
+		return row{}, fmt.Errorf("%s: %w", s.path, err)
+	}
+	return row{}, io.EOF
+}
+
+func (r row) balance() (model.Balance, error) {
+	amount := r.Amount
+	if amount == nil {
+		amount = r.NewAmount
+	}
+	switch {
+	case r.UserID == 0:
+		return model.Balance{}, fmt.Errorf("user_id must be a positive integer")
+	case amount == nil:
+		return model.Balance{}, fmt.Errorf("amount is required")
+	case math.IsNaN(*amount) || math.Abs(*amount) >= model.MaxAmount:
+		return model.Balance{}, fmt.Errorf("amount %v is out of range", *amount)
+	case r.Version == nil:
+		// Without its version a row could overwrite a newer update.
+		return model.Balance{}, fmt.Errorf("version is required")
+	case r.Currency != "" && !model.ValidCurrency(r.Currency):
+		return model.Balance{}, fmt.Errorf("currency %q is not a three-letter upper-case code", r.Currency)
+	case r.TenantID != "" && !model.ValidTenantID(r.TenantID):
+		return model.Balance{}, fmt.Errorf("tenant_id %q is not a valid tenant ID", r.TenantID)
+	}
+	return model.Balance{UserID: r.UserID, TenantID: r.TenantID, Currency: r.Currency, Amount: *amount, Version: *r.Version}, nil
+}
+
+func (s *FileSource) Progress() float64 {
+	if s.size == 0 {
+		return 1
+	}
+	return float64(s.counted.n) / float64(s.size)
+}
+
+func (s *FileSource) Close() error {
+	return s.file.Close()
+}
+
+// countingReader counts the bytes read for progress reports.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,112 @@
+package bootstrap
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"balance-service/internal/database"
+	"balance-service/internal/model"
+)
+
+func writeSnapshot(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestFileSourceReadsBothFormats(t *testing.T) {
+	for name, content := range map[string]string{
+		"balances.csv":   "user_id,new_amount,version,currency\n1,10.50,3,\n2,-4,1,EUR\n",
+		"balances.jsonl": "{\"user_id\":1,\"amount\":10.5,\"version\":3}\n\n{\"user_id\":2,\"new_amount\":-4,\"version\":1,\"currency\":\"EUR\"}\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			src, err := OpenFile(writeSnapshot(t, name, content), "", time.Time{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer src.Close()
+
+			batch, err := src.Next(context.Background(), 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := []model.Balance{
+				{UserID: 1, Amount: 10.5, Version: 3},
+				{UserID: 2, Currency: "EUR", Amount: -4, Version: 1},
+			}
+			if len(batch) != len(want) {
+				t.Fatalf("%d rows, want %d", len(batch), len(want))
+			}
+			for i := range want {
+				if batch[i] != want[i] {
+					t.Errorf("row %d: %+v, want %+v", i, batch[i], want[i])
+				}
+			}
+			if _, err := src.Next(context.Background(), 10); !errors.Is(err, io.EOF) {
+				t.Errorf("Next after the last row: %v, want io.EOF", err)
+			}
+		})
+	}
+}
+
+func TestFileSourceRejectsInvalidRecords(t *testing.T) {
+	for content, want := range map[string]string{
+		"user_id,amount,version\n1,10000000000000,1\n": "out of range",
+		"user_id,amount,version\n0,1,1\n":              "user_id",
+		"user_id,amount\n1,1\n":                        "no version column",
+		"user_id,amount,version,currency\n1,1,1,usd\n": "currency",
+	} {
+		src, err := OpenFile(writeSnapshot(t, "balances.csv", content), "", time.Time{})
+		if err == nil {
+			_, err = src.Next(context.Background(), 10)
+			src.Close()
+		}
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: %v, want an error about %s", content, err, want)
+		}
+	}
+}
+
+func TestRunLoadsFileOnce(t *testing.T) {
+	db, log := database.OpenTest(t)
+	path := writeSnapshot(t, "balances.csv", "user_id,amount,version\n1,10,1\n2,20,2\n3,30,3\n")
+	opts := Options{BatchSize: 2, Currency: "USD", ProgressInterval: time.Minute}
+
+	load := func() *Result {
+		t.Helper()
+		src, err := OpenFile(path, "", time.Time{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer src.Close()
+		result, err := Run(context.Background(), db, src, opts, log)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	if result := load(); result.Rows != 3 || result.Loaded != 3 || result.Skipped {
+		t.Errorf("first load: %+v, want 3 rows loaded", result)
+	}
+	var balances []model.Balance
+	if err := db.Order("user_id").Find(&balances).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(balances) != 3 || balances[2].Amount != 30 || balances[2].Currency != "USD" {
+		t.Errorf("balances %+v, want the three rows in USD", balances)
+	}
+
+	if result := load(); !result.Skipped || result.Loaded != 0 {
+		t.Errorf("second load: %+v, want it skipped", result)
+	}
+}
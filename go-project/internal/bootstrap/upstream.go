@@ -0,0 +1,87 @@
+package bootstrap
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"balance-service/internal/database"
+	"balance-service/internal/model"
+	"balance-service/internal/repository"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// UpstreamSource reads the Laravel balances table inside one read-only
+// transaction, so every page comes from the same consistent snapshot.
+type UpstreamSource struct {
+	tx         *gorm.DB
+	balances   *repository.BalanceRepository
+	snapshotAt time.Time
+	total      int64
+	read       int64
+	after      uint
+}
+
+// OpenUpstream starts the snapshot transaction on db.
+func OpenUpstream(ctx context.Context, db *gorm.DB, log *logrus.Logger) (*UpstreamSource, error) {
+	snapshotAt := time.Now().UTC()
+	tx := db.WithContext(ctx).Begin(database.SnapshotTx(db, true))
+	if tx.Error != nil {
+		return nil, fmt.Errorf("start snapshot transaction: %w", tx.Error)
+	}
+
+	balances := repository.NewBalanceRepository(tx, log)
+	// The first read fixes the snapshot; the count doubles as the total for
+	// progress reports.
+	total, err := balances.CountBalances(ctx)
+	if err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("count upstream balances: %w", err)
+	}
+
+	return &UpstreamSource{
+		tx:         tx,
+		balances:   balances,
+		snapshotAt: snapshotAt,
+		total:      total,
+	}, nil
+}
+
+func (s *UpstreamSource) ID() string            { return "upstream" }
+func (s *UpstreamSource) Fingerprint() string   { return "" }
+func (s *UpstreamSource) SnapshotAt() time.Time { return s.snapshotAt }
+
+// Resume continues after the last loaded user. The snapshot is a newer one
+// than the interrupted run's; users loaded before keep their older state
+// until their next update, exactly as if the run had not been interrupted.
+func (s *UpstreamSource) Resume(_ context.Context, wm *model.BootstrapWatermark) error {
+	s.after, s.read = wm.LastUserID, wm.Rows
+	return nil
+}
+
+func (s *UpstreamSource) Next(ctx context.Context, n int) ([]model.Balance, error) {
+	page, err := s.balances.ListBalancesAfter(ctx, nil, s.after, n)
+	if err != nil {
+		return nil, fmt.Errorf("read upstream balances after user %d: %w", s.after, err)
+	}
+	if len(page) == 0 {
+		return nil, io.EOF
+	}
+
+	s.after = page[len(page)-1].UserID
+	s.read += int64(len(page))
+	return page, nil
+}
+
+func (s *UpstreamSource) Progress() float64 {
+	if s.total == 0 {
+		return 1
+	}
+	return float64(s.read) / float64(s.total)
+}
+
+func (s *UpstreamSource) Close() error {
+	return s.tx.Rollback().Error
+}
@@ -0,0 +1,164 @@
+// Package breaker implements the database circuit breaker shared by the
+// processor workers. When the breaker opens, consumption is paused until a
+// background probe sees the database healthy again.
+package breaker
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"balance-service/internal/metrics"
+	"github.com/sirupsen/logrus"
+)
+
+type State int
+
+const (
+	Closed State = iota
+	HalfOpen
+	Open
+)
+
+func (s State) String() string {
+	switch s {
+	case HalfOpen:
+		return "half-open"
+	case Open:
+		return "open"
+	default:
+		return "closed"
+	}
+}
+
+var (
+	stateGauge = metrics.NewGauge("balance_db_breaker_state", "Database circuit breaker state (0 closed, 1 half-open, 2 open).")
+	tripsTotal = metrics.NewCounter("balance_db_breaker_trips_total", "Number of times the database circuit breaker opened.")
+)
+
+// ProbeFunc checks whether the protected dependency is healthy again.
+type ProbeFunc func(ctx context.Context) error
+
+type Config struct {
+	Threshold  int           // consecutive failures before the breaker opens
+	MinBackoff time.Duration // first probe delay once open
+	MaxBackoff time.Duration // upper bound for the probe delay
+}
+
+type Breaker struct {
+	ctx   context.Context
+	cfg   Config
+	probe ProbeFunc
+	log   *logrus.Logger
+
+	mu        sync.Mutex
+	state     State
+	failures  int
+	listeners []func(State)
+}
+
+func New(ctx context.Context, cfg Config, probe ProbeFunc, log *logrus.Logger) *Breaker {
+	stateGauge.Set(float64(Closed))
+	return &Breaker{
+		ctx:   ctx,
+		cfg:   cfg,
+		probe: probe,
+		log:   log,
+	}
+}
+
+// OnStateChange registers fn to be called, outside the breaker lock, every
+// time the state changes.
+func (b *Breaker) OnStateChange(fn func(State)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.listeners = append(b.listeners, fn)
+}
+
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// Success records a successful database operation.
+func (b *Breaker) Success() {
+	b.mu.Lock()
+	b.failures = 0
+	if b.state != HalfOpen {
+		b.mu.Unlock()
+		return
+	}
+	listeners := b.setState(Closed)
+	b.mu.Unlock()
+
+	b.log.Info("database circuit breaker closed")
+	notify(listeners, Closed)
+}
+
+// Failure records a database operation that failed because the database is
+// unhealthy. A failure while half-open re-opens the breaker immediately.
+func (b *Breaker) Failure() {
+	b.mu.Lock()
+	b.failures++
+	if b.state == Open || (b.state == Closed && b.failures < b.cfg.Threshold) {
+		b.mu.Unlock()
+		return
+	}
+	listeners := b.setState(Open)
+	failures := b.failures
+	b.mu.Unlock()
+
+	tripsTotal.Inc()
+	b.log.WithField("failures", failures).Error("database circuit breaker opened, pausing consumption")
+	notify(listeners, Open)
+
+	go b.probeUntilHealthy()
+}
+
+func (b *Breaker) setState(state State) []func(State) {
+	b.state = state
+	stateGauge.Set(float64(state))
+	return append([]func(State){}, b.listeners...)
+}
+
+func (b *Breaker) probeUntilHealthy() {
+	delay := b.cfg.MinBackoff
+	for attempt := 1; ; attempt++ {
+		// Equal jitter (half fixed, half random) keeps several instances from
+		// probing in lockstep without ever retrying immediately.
+		wait := delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+		select {
+		case <-time.After(wait):
+		case <-b.ctx.Done():
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(b.ctx, 5*time.Second)
+		err := b.probe(ctx)
+		cancel()
+		if err == nil {
+			break
+		}
+
+		b.log.WithError(err).WithField("attempt", attempt).Warn("database probe failed")
+		if delay *= 2; delay > b.cfg.MaxBackoff {
+			delay = b.cfg.MaxBackoff
+		}
+	}
+
+	b.mu.Lock()
+	b.failures = 0
+	listeners := b.setState(HalfOpen)
+	b.mu.Unlock()
+
+	b.log.Info("database probe succeeded, resuming consumption")
+	notify(listeners, HalfOpen)
+}
+
+func notify(listeners []func(State), state State) {
+	for _, fn := range listeners {
+		fn(state)
+	}
+}
@@ -0,0 +1,73 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func testLogger() *logrus.Logger {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	return log
+}
+
+func TestBreakerOpensAtThresholdAndRecovers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var healthy atomic.Bool
+	probes := make(chan struct{}, 100)
+	probe := func(context.Context) error {
+		probes <- struct{}{}
+		if !healthy.Load() {
+			return errors.New("still down")
+		}
+		return nil
+	}
+	b := New(ctx, Config{Threshold: 3, MinBackoff: time.Millisecond, MaxBackoff: 4 * time.Millisecond}, probe, testLogger())
+
+	states := make(chan State, 10)
+	b.OnStateChange(func(s State) { states <- s })
+
+	b.Failure()
+	b.Failure()
+	b.Success() // resets the count
+	b.Failure()
+	b.Failure()
+	if got := b.State(); got != Closed {
+		t.Fatalf("state after failures below the threshold: %s, want closed", got)
+	}
+	b.Failure()
+	if got := <-states; got != Open {
+		t.Fatalf("state after %d failures: %s, want open", 3, got)
+	}
+
+	// The probe keeps failing until the database is back.
+	<-probes
+	<-probes
+	healthy.Store(true)
+	if got := <-states; got != HalfOpen {
+		t.Fatalf("state after a good probe: %s, want half-open", got)
+	}
+
+	// A failure while half-open re-opens at once.
+	healthy.Store(false)
+	b.Failure()
+	if got := <-states; got != Open {
+		t.Fatalf("state after a half-open failure: %s, want open", got)
+	}
+	healthy.Store(true)
+	if got := <-states; got != HalfOpen {
+		t.Fatalf("state after the second good probe: %s, want half-open", got)
+	}
+	b.Success()
+	if got := <-states; got != Closed {
+		t.Fatalf("state after a half-open success: %s, want closed", got)
+	}
+}
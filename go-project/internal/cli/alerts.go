@@ -0,0 +1,49 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+
+	"balance-service/internal/repository"
+)
+
+func init() {
+	register(command{
+		name:    "alerts",
+		summary: "print the latest alerts, optionally of one user (alerts -user 42)",
+		run:     runAlerts,
+	})
+}
+
+func runAlerts(ctx context.Context, env *env, args []string) int {
+	fs := flag.NewFlagSet("alerts", flag.ContinueOnError)
+	userID := fs.Uint("user", 0, "only this user")
+	limit := fs.Int("limit", 50, "number of latest alerts")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if *limit < 1 {
+		fmt.Fprintln(fs.Output(), "-limit must be positive")
+		return ExitUsage
+	}
+
+	db, code := openDatabase(env, true)
+	if code != ExitOK {
+		return code
+	}
+	defer closeDatabase(env, db)
+
+	alerts, err := repository.NewAlertRepository(db.DB, env.log).ListAlerts(ctx, *userID, *limit)
+	if err != nil {
+		env.log.WithError(err).Error("failed to read alerts")
+		return ExitFailure
+	}
+
+	enc := json.NewEncoder(env.stdout)
+	for _, alert := range alerts {
+		_ = enc.Encode(alert)
+	}
+	return ExitOK
+}
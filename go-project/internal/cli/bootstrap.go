@@ -0,0 +1,136 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"time"
+
+	"balance-service/internal/bootstrap"
+	"balance-service/internal/publisher"
+)
+
+func init() {
+	register(command{
+		name:    "bootstrap",
+		summary: "seed the balances from a CSV/JSONL snapshot or the upstream database (bootstrap -upstream -serve)",
+		run:     runBootstrap,
+	})
+}
+
+func runBootstrap(ctx context.Context, env *env, args []string) int {
+	fs := flag.NewFlagSet("bootstrap", flag.ContinueOnError)
+	file := fs.String("file", "", "snapshot file with user_id, amount, version and optionally currency")
+	format := fs.String("format", "", "snapshot file format: csv or jsonl (default: from the extension)")
+	snapshotAt := fs.String("snapshot-at", "", "RFC 3339 time the snapshot file was taken (default: its modification time)")
+	fromUpstream := fs.Bool("upstream", false, "read the snapshot from the upstream database")
+	batchSize := fs.Int("batch-size", 1000, "rows written per transaction")
+	restart := fs.Bool("restart", false, "ignore the watermark of an earlier run and load everything again")
+	skipDeclare := fs.Bool("skip-declare", false, "do not declare the RabbitMQ queue before taking the snapshot")
+	serve := fs.Bool("serve", false, "start consuming once the snapshot is loaded")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if (*file == "") == !*fromUpstream || *batchSize < 1 {
+		fmt.Fprintln(fs.Output(), "exactly one of -file and -upstream is required and -batch-size must be positive")
+		return ExitUsage
+	}
+	var takenAt time.Time
+	if *snapshotAt != "" {
+		var err error
+		if takenAt, err = time.Parse(time.RFC3339, *snapshotAt); err != nil {
+			fmt.Fprintf(fs.Output(), "invalid -snapshot-at: %v\n", err)
+			return ExitUsage
+		}
+	}
+	if *fromUpstream && !env.cfg.Upstream.Configured() {
+		env.log.Error("the upstream database is not configured, set upstream.driver or UPSTREAM_DB_DRIVER")
+		return ExitConfig
+	}
+
+	code := loadSnapshot(ctx, env, snapshotRequest{
+		file:         *file,
+		format:       *format,
+		snapshotAt:   takenAt,
+		fromUpstream: *fromUpstream,
+		skipDeclare:  *skipDeclare,
+		opts: bootstrap.Options{
+			BatchSize:        *batchSize,
+			Currency:         env.cfg.Currency.Default,
+			Restart:          *restart,
+			ProgressInterval: 10 * time.Second,
+		},
+	})
+	if code != ExitOK || !*serve {
+		return code
+	}
+	return runServe(ctx, env, nil)
+}
+
+type snapshotRequest struct {
+	file         string
+	format       string
+	snapshotAt   time.Time
+	fromUpstream bool
+	skipDeclare  bool
+	opts         bootstrap.Options
+}
+
+func loadSnapshot(ctx context.Context, env *env, req snapshotRequest) int {
+	db, code := openDatabase(env, true)
+	if code != ExitOK {
+		return code
+	}
+	defer closeDatabase(env, db)
+
+	// Updates published while the snapshot loads must wait in the queue, so
+	// it has to exist before the snapshot is taken.
+	if !req.skipDeclare {
+		pub, err := publisher.New(env.cfg.Rabbit, publisher.Options{}, env.log)
+		if err == nil {
+			err = pub.Declare()
+			pub.Close()
+		}
+		if err != nil {
+			env.log.WithError(err).Error("failed to declare the RabbitMQ queue")
+			return ExitUnavailable
+		}
+	}
+
+	var src bootstrap.Source
+	if req.fromUpstream {
+		upstream, code := openUpstream(env)
+		if code != ExitOK {
+			return code
+		}
+		defer closeDatabase(env, upstream)
+
+		s, err := bootstrap.OpenUpstream(ctx, upstream.DB, env.log)
+		if err != nil {
+			env.log.WithError(err).Error("failed to open the upstream snapshot")
+			return ExitUnavailable
+		}
+		src = s
+	} else {
+		s, err := bootstrap.OpenFile(req.file, req.format, req.snapshotAt)
+		if err != nil {
+			env.log.WithError(err).Error("failed to open the snapshot file")
+			return ExitUsage
+		}
+		src = s
+	}
+
+	result, err := bootstrap.Run(ctx, db.DB, src, req.opts, env.log)
+	_ = src.Close()
+	if err != nil {
+		env.log.WithError(err).Error("bootstrap failed, run it again to resume")
+		return ExitFailure
+	}
+
+	_ = json.NewEncoder(env.stdout).Encode(result)
+	if result.Skipped {
+		env.log.WithField("source", result.Source).Info("source already loaded, use -restart to load it again")
+	}
+	return ExitOK
+}
@@ -0,0 +1,152 @@
+// Package cli implements the subcommands of the balance-service binary. Every
+// command shares the same config loader and returns a process exit code.
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+
+	"balance-service/internal/config"
+	"balance-service/internal/database"
+	"balance-service/internal/logger"
+	"github.com/sirupsen/logrus"
+)
+
+// Exit codes shared by all commands.
+const (
+	ExitOK          = 0
+	ExitFailure     = 1 // the command ran but did not succeed
+	ExitUsage       = 2 // bad command line
+	ExitConfig      = 3 // configuration could not be loaded or is invalid
+	ExitNotFound    = 4 // the requested record does not exist
+	ExitUnavailable = 5 // a dependency (database, broker) is unreachable
+)
+
+// env is what every command gets: the effective config, a logger writing to
+// stderr and where to print results.
+type env struct {
+	cfg        *config.Config
+	configPath string
+	log        *logrus.Logger
+	stdout     io.Writer
+}
+
+type command struct {
+	name    string
+	summary string
+	run     func(ctx context.Context, env *env, args []string) int
+}
+
+var commands = map[string]command{}
+
+func register(cmd command) {
+	commands[cmd.name] = cmd
+}
+
+// Run parses global flags, loads the configuration and dispatches to a
+// subcommand. Without a subcommand the service is started.
+func Run(args []string) int {
+	global := flag.NewFlagSet("balance-service", flag.ContinueOnError)
+	configPath := global.String("config", "", "path to a YAML config file (defaults to $CONFIG_FILE)")
+	global.Usage = func() { usage(global) }
+	if err := global.Parse(args); err != nil {
+		return ExitUsage
+	}
+
+	rest := global.Args()
+	name := "serve"
+	if len(rest) > 0 {
+		name, rest = rest[0], rest[1:]
+	}
+
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+		usage(global)
+		return ExitUsage
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitConfig
+	}
+
+	log := logger.New()
+	level, _ := logrus.ParseLevel(cfg.Log.Level) // validated by config.Load
+	log.SetLevel(level)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	return cmd.run(ctx, &env{
+		cfg:        cfg,
+		configPath: *configPath,
+		log:        log,
+		stdout:     os.Stdout,
+	}, rest)
+}
+
+func usage(global *flag.FlagSet) {
+	out := global.Output()
+	fmt.Fprintf(out, "usage: balance-service [-config file] <command> [flags]\n\nglobal flags:\n")
+	global.PrintDefaults()
+	fmt.Fprintf(out, "\ncommands:\n")
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(out, "  %-14s %s\n", name, commands[name].summary)
+	}
+}
+
+// parseFlags parses a subcommand's flags and maps failures to ExitUsage.
+func parseFlags(fs *flag.FlagSet, args []string) (int, bool) {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return ExitOK, false
+		}
+		return ExitUsage, false
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(fs.Output(), "unexpected arguments: %v\n", fs.Args())
+		return ExitUsage, false
+	}
+	return ExitOK, true
+}
+
+// openDatabase connects to the configured database, migrating the schema
+// first when migrate is set and auto-migration is enabled.
+func openDatabase(env *env, migrate bool) (*database.Database, int) {
+	db, err := database.New(env.cfg.Database, env.log)
+	if err != nil {
+		env.log.WithError(err).Error("failed to initialize database")
+		return nil, ExitUnavailable
+	}
+
+	if migrate && env.cfg.Database.AutoMigrate {
+		if err := db.Migrate(env.cfg.Currency.Default); err != nil {
+			env.log.WithError(err).Error("failed to migrate database")
+			_ = db.Close()
+			return nil, ExitFailure
+		}
+	}
+
+	return db, ExitOK
+}
+
+func closeDatabase(env *env, db *database.Database) {
+	if err := db.Close(); err != nil {
+		env.log.WithError(err).Error("error closing database connection")
+	}
+}
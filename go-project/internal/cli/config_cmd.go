@@ -0,0 +1,28 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+func init() {
+	register(command{
+		name:    "config",
+		summary: "print the effective configuration with secrets redacted (config print)",
+		run:     runConfig,
+	})
+}
+
+func runConfig(_ context.Context, env *env, args []string) int {
+	if len(args) != 1 || args[0] != "print" {
+		fmt.Fprintln(os.Stderr, "usage: balance-service config print")
+		return ExitUsage
+	}
+
+	if err := env.cfg.Print(env.stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitFailure
+	}
+	return ExitOK
+}
@@ -0,0 +1,84 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"time"
+
+	"balance-service/internal/model"
+	"balance-service/internal/repository"
+)
+
+func init() {
+	register(command{
+		name:    "events",
+		summary: "print a user's event history, optionally following new events (events -user 42 -tenant acme -follow)",
+		run:     runEvents,
+	})
+}
+
+func runEvents(ctx context.Context, env *env, args []string) int {
+	fs := flag.NewFlagSet("events", flag.ContinueOnError)
+	userID := fs.Uint("user", 0, "user ID (required)")
+	tenant := fs.String("tenant", "", "tenant of the user (default: the default tenant)")
+	currency := fs.String("currency", "", "print only the events of the wallet in this currency")
+	limit := fs.Int("limit", 50, "number of latest events to print first")
+	follow := fs.Bool("follow", false, "keep polling for new events until interrupted")
+	interval := fs.Duration("interval", 2*time.Second, "poll interval with -follow")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if *userID == 0 || *limit < 1 {
+		fmt.Fprintln(fs.Output(), "-user is required and -limit must be positive")
+		return ExitUsage
+	}
+	if *currency != "" && !model.ValidCurrency(*currency) {
+		fmt.Fprintf(fs.Output(), "-currency %q is not a three-letter upper-case code\n", *currency)
+		return ExitUsage
+	}
+	if *tenant == model.DefaultTenant {
+		*tenant = ""
+	}
+	if *tenant != "" && !model.ValidTenantID(*tenant) {
+		fmt.Fprintf(fs.Output(), "-tenant %q is not a valid tenant ID\n", *tenant)
+		return ExitUsage
+	}
+
+	db, code := openDatabase(env, false)
+	if code != ExitOK {
+		return code
+	}
+	defer closeDatabase(env, db)
+
+	eventRepo := repository.NewEventRepository(db.DB, env.log)
+	enc := json.NewEncoder(env.stdout)
+
+	var lastID uint
+	for {
+		events, err := eventRepo.ListUserEvents(ctx, *tenant, *userID, *currency, lastID, *limit)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ExitOK
+			}
+			env.log.WithError(err).Error("failed to read events")
+			return ExitFailure
+		}
+
+		for _, event := range events {
+			_ = enc.Encode(event)
+			lastID = event.ID
+		}
+
+		if !*follow {
+			return ExitOK
+		}
+
+		select {
+		case <-ctx.Done():
+			return ExitOK
+		case <-time.After(*interval):
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+
+	"balance-service/internal/model"
+	"balance-service/internal/repository"
+	"github.com/sirupsen/logrus"
+)
+
+func init() {
+	register(command{
+		name:    "get-balance",
+		summary: "print the wallets of a user, one JSON line each (get-balance -user 42 [-currency EUR] [-tenant acme])",
+		run:     runGetBalance,
+	})
+}
+
+func runGetBalance(ctx context.Context, env *env, args []string) int {
+	fs := flag.NewFlagSet("get-balance", flag.ContinueOnError)
+	userID := fs.Uint("user", 0, "user ID (required)")
+	currency := fs.String("currency", "", "print only the wallet in this currency")
+	tenant := fs.String("tenant", "", "tenant of the user (default: the default tenant)")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if *userID == 0 {
+		fmt.Fprintln(fs.Output(), "-user is required")
+		return ExitUsage
+	}
+	if *currency != "" && !model.ValidCurrency(*currency) {
+		fmt.Fprintf(fs.Output(), "-currency %q is not a three-letter upper-case code\n", *currency)
+		return ExitUsage
+	}
+	if *tenant == model.DefaultTenant {
+		*tenant = ""
+	}
+	if *tenant != "" && !model.ValidTenantID(*tenant) {
+		fmt.Fprintf(fs.Output(), "-tenant %q is not a valid tenant ID\n", *tenant)
+		return ExitUsage
+	}
+
+	db, code := openDatabase(env, false)
+	if code != ExitOK {
+		return code
+	}
+	defer closeDatabase(env, db)
+
+	balances := repository.NewBalanceRepository(db.DB, env.log)
+	var wallets []model.Balance
+	if *currency != "" {
+		balance, err := balances.GetBalance(ctx, model.WalletKey{TenantID: *tenant, UserID: *userID, Currency: *currency})
+		if err == nil {
+			wallets = append(wallets, *balance)
+		} else if !errors.Is(err, repository.ErrNotFound) {
+			env.log.WithError(err).Error("failed to read balance")
+			return ExitFailure
+		}
+	} else {
+		var err error
+		if wallets, err = balances.ListUserBalances(ctx, *tenant, *userID); err != nil {
+			env.log.WithError(err).Error("failed to read balances")
+			return ExitFailure
+		}
+	}
+	if len(wallets) == 0 {
+		env.log.WithFields(logrus.Fields{"user_id": *userID, "tenant_id": *tenant, "currency": *currency}).Error("balance not found")
+		return ExitNotFound
+	}
+
+	enc := json.NewEncoder(env.stdout)
+	for _, wallet := range wallets {
+		_ = enc.Encode(wallet)
+	}
+	return ExitOK
+}
@@ -0,0 +1,123 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"balance-service/internal/database"
+	"balance-service/internal/ledger"
+	"balance-service/internal/model"
+	"balance-service/internal/repository"
+)
+
+func init() {
+	register(command{
+		name:    "ledger",
+		summary: "check the ledger invariants, open pre-ledger balances or list a wallet's entries (ledger check|open|entries)",
+		run:     runLedger,
+	})
+}
+
+const ledgerUsage = `usage: balance-service ledger <action> [flags]
+
+actions:
+  check
+  open [-batch-size 1000]
+  entries -user N [-tenant ID] [-currency EUR] [-after ID] [-limit 50]`
+
+func runLedger(ctx context.Context, env *env, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, ledgerUsage)
+		return ExitUsage
+	}
+	action, args := args[0], args[1:]
+
+	fs := flag.NewFlagSet("ledger "+action, flag.ContinueOnError)
+	var run func(ctx context.Context, db *database.Database) int
+
+	switch action {
+	case "check":
+		run = func(ctx context.Context, db *database.Database) int {
+			report, err := ledger.Check(ctx, db.DB, env.log)
+			if err != nil {
+				env.log.WithError(err).Error("ledger check failed")
+				return ExitFailure
+			}
+			enc := json.NewEncoder(env.stdout)
+			enc.SetIndent("", "  ")
+			_ = enc.Encode(report)
+
+			if !report.OK() {
+				return ExitFailure
+			}
+			return ExitOK
+		}
+	case "open":
+		batchSize := fs.Int("batch-size", 1000, "balances opened per transaction")
+		run = func(ctx context.Context, db *database.Database) int {
+			if *batchSize < 1 {
+				fmt.Fprintln(fs.Output(), "-batch-size must be positive")
+				return ExitUsage
+			}
+			opened, err := ledger.Open(ctx, db.DB, *batchSize, env.log)
+			if err != nil {
+				env.log.WithError(err).WithField("opened", opened).Error("failed to open wallets")
+				return ExitFailure
+			}
+			_ = json.NewEncoder(env.stdout).Encode(map[string]int{"opened": opened})
+			return ExitOK
+		}
+	case "entries":
+		userID := fs.Uint("user", 0, "user ID (required)")
+		tenantID := fs.String("tenant", "", "tenant ID (default: the default tenant)")
+		currency := fs.String("currency", env.cfg.Currency.Default, "currency code")
+		after := fs.Uint("after", 0, "only entries with a greater ID")
+		limit := fs.Int("limit", 50, "number of entries")
+		run = func(ctx context.Context, db *database.Database) int {
+			if *userID == 0 || *limit < 1 {
+				fmt.Fprintln(fs.Output(), "-user is required and -limit must be positive")
+				return ExitUsage
+			}
+			if !model.ValidCurrency(*currency) {
+				fmt.Fprintf(fs.Output(), "-currency %q is not a three-letter upper-case code\n", *currency)
+				return ExitUsage
+			}
+			if *tenantID == model.DefaultTenant {
+				*tenantID = ""
+			}
+			if *tenantID != "" && !model.ValidTenantID(*tenantID) {
+				fmt.Fprintf(fs.Output(), "-tenant %q is not a valid tenant ID\n", *tenantID)
+				return ExitUsage
+			}
+			wallet := model.WalletKey{TenantID: *tenantID, UserID: *userID, Currency: *currency}
+			entries, err := repository.NewLedgerRepository(db.DB, env.log).ListWalletEntries(ctx, wallet, *after, *limit)
+			if err != nil {
+				env.log.WithError(err).Error("failed to read ledger entries")
+				return ExitFailure
+			}
+			enc := json.NewEncoder(env.stdout)
+			for _, entry := range entries {
+				_ = enc.Encode(entry)
+			}
+			return ExitOK
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "unknown action %q\n\n%s\n", action, ledgerUsage)
+		return ExitUsage
+	}
+
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	db, code := openDatabase(env, false)
+	if code != ExitOK {
+		return code
+	}
+	defer closeDatabase(env, db)
+
+	return run(ctx, db)
+}
@@ -0,0 +1,254 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	"balance-service/internal/model"
+	"balance-service/internal/processor"
+	"balance-service/internal/publisher"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// loadgenRecent is how many sent messages are kept to draw duplicates from.
+	loadgenRecent = 1000
+	// loadgenProgress is how often progress is logged.
+	loadgenProgress = 10 * time.Second
+)
+
+func init() {
+	register(command{
+		name:    "loadgen",
+		summary: "publish simulated Laravel balance traffic (loadgen -rate 500 -users 1000 -duration 10m)",
+		run:     runLoadgen,
+	})
+}
+
+func runLoadgen(ctx context.Context, env *env, args []string) int {
+	fs := flag.NewFlagSet("loadgen", flag.ContinueOnError)
+	rate := fs.Float64("rate", 100, "messages per second")
+	users := fs.Int("users", 50, "number of simulated users")
+	userOffset := fs.Uint("user-offset", 0, "simulated user IDs start after this value")
+	group := fs.Int("group", 50, "users updated per round, like updateRandomGroup's batch size")
+	count := fs.Int("count", 0, "stop after this many messages, 0 for no limit")
+	duration := fs.Duration("duration", 0, "stop after this long, 0 to run until interrupted")
+	duplicates := fs.Float64("duplicate-ratio", 0, "probability of re-sending a recent message, same event_id, after each update")
+	reorder := fs.Float64("out-of-order-ratio", 0, "probability that an update is sent after the user's next version")
+	format := fs.String("format", publisher.FormatJSON, "message format: json, protobuf or cloudevents")
+	keyID := fs.String("sign-key-id", "", "sign messages with this key from rabbitmq.signing.keys")
+	tenantID := fs.String("tenant", "", "send the messages for this tenant")
+	seed := fs.Int64("seed", 0, "random seed, 0 to seed from the clock")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if *rate <= 0 || *users < 1 || *group < 1 || *count < 0 ||
+		!isRatio(*duplicates) || !isRatio(*reorder) {
+		fmt.Fprintln(fs.Output(), "-rate, -users and -group must be positive and ratios between 0 and 1")
+		return ExitUsage
+	}
+	if *tenantID != "" && !model.ValidTenantID(*tenantID) {
+		fmt.Fprintf(fs.Output(), "-tenant %q is not a valid tenant ID\n", *tenantID)
+		return ExitUsage
+	}
+
+	opts := publisher.Options{Format: *format}
+	if *keyID != "" {
+		key, ok := env.cfg.Rabbit.Signing.Keys[*keyID]
+		if !ok {
+			fmt.Fprintf(fs.Output(), "signing key %q is not configured in rabbitmq.signing.keys\n", *keyID)
+			return ExitUsage
+		}
+		opts.SigningKeyID, opts.SigningKey = *keyID, key
+	}
+
+	pub, err := publisher.New(env.cfg.Rabbit, opts, env.log)
+	if err != nil {
+		fmt.Fprintln(fs.Output(), err)
+		return ExitUsage
+	}
+	defer pub.Close()
+
+	if *duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *duration)
+		defer cancel()
+	}
+
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+	gen := newTrafficGenerator(rand.New(rand.NewSource(*seed)), *users, *userOffset, *group, *duplicates, *reorder)
+	pace := newPacer(*rate)
+
+	var stats struct {
+		Published  int     `json:"published"`
+		Duplicates int     `json:"duplicates"`
+		Reordered  int     `json:"reordered"`
+		Seconds    float64 `json:"seconds"`
+		Rate       float64 `json:"rate"`
+	}
+	start, lastProgress := time.Now(), time.Now()
+
+generate:
+	for *count == 0 || stats.Published < *count {
+		for _, g := range gen.round() {
+			if *count > 0 && stats.Published >= *count || !pace.wait(ctx) {
+				break generate
+			}
+			g.msg.TenantID = *tenantID
+			if err := pub.Publish(ctx, g.msg); err != nil {
+				if ctx.Err() != nil {
+					break generate
+				}
+				env.log.WithError(err).Error("giving up on publishing")
+				return ExitUnavailable
+			}
+
+			stats.Published++
+			if g.duplicate {
+				stats.Duplicates++
+			}
+			if g.reordered {
+				stats.Reordered++
+			}
+
+			if time.Since(lastProgress) >= loadgenProgress {
+				lastProgress = time.Now()
+				env.log.WithFields(logrus.Fields{
+					"published": stats.Published,
+					"rate":      math.Round(float64(stats.Published) / time.Since(start).Seconds()),
+				}).Info("loadgen progress")
+			}
+		}
+	}
+
+	stats.Seconds = math.Round(time.Since(start).Seconds()*100) / 100
+	if stats.Seconds > 0 {
+		stats.Rate = math.Round(float64(stats.Published) / stats.Seconds)
+	}
+	_ = json.NewEncoder(env.stdout).Encode(stats)
+	return ExitOK
+}
+
+func isRatio(v float64) bool {
+	return v >= 0 && v <= 1
+}
+
+// trafficGenerator mimics BalanceUpdaterService::updateRandomGroup: every
+// round updates a random group of distinct users by up to ±50.00, never
+// below zero, and bumps their versions.
+type trafficGenerator struct {
+	rng        *rand.Rand
+	users      []simulatedUser
+	userOffset uint
+	group      int
+	duplicates float64
+	reorder    float64
+	recent     []processor.BalanceMessage
+}
+
+type simulatedUser struct {
+	amount  float64
+	version uint
+}
+
+type generatedMessage struct {
+	msg       processor.BalanceMessage
+	duplicate bool
+	reordered bool
+}
+
+func newTrafficGenerator(rng *rand.Rand, users int, userOffset uint, group int, duplicates, reorder float64) *trafficGenerator {
+	g := &trafficGenerator{
+		rng:        rng,
+		users:      make([]simulatedUser, users),
+		userOffset: userOffset,
+		group:      min(group, users),
+		duplicates: duplicates,
+		reorder:    reorder,
+	}
+	for i := range g.users {
+		g.users[i].amount = float64(rng.Intn(100000)) / 100
+	}
+	return g
+}
+
+func (g *trafficGenerator) round() []generatedMessage {
+	var out []generatedMessage
+	for _, i := range g.rng.Perm(len(g.users))[:g.group] {
+		msg := g.next(i)
+		if g.rng.Float64() < g.reorder {
+			// The consumer sees the newer version first and must not let
+			// the older one overwrite it.
+			out = append(out, generatedMessage{msg: g.next(i)}, generatedMessage{msg: msg, reordered: true})
+		} else {
+			out = append(out, generatedMessage{msg: msg})
+		}
+
+		if g.rng.Float64() < g.duplicates {
+			dup := g.recent[g.rng.Intn(len(g.recent))]
+			out = append(out, generatedMessage{msg: dup, duplicate: true})
+		}
+	}
+	return out
+}
+
+func (g *trafficGenerator) next(i int) processor.BalanceMessage {
+	user := &g.users[i]
+	delta := float64(g.rng.Intn(10001)-5000) / 100
+	user.amount = math.Max(0, math.Round((user.amount+delta)*100)/100)
+	user.version++
+
+	amount := user.amount
+	msg := processor.BalanceMessage{
+		SchemaVersion: processor.SchemaV2,
+		UserID:        g.userOffset + uint(i) + 1,
+		NewAmount:     &amount,
+		Version:       user.version,
+		Timestamp:     time.Now().UTC().Format(time.RFC3339Nano),
+		EventID:       fmt.Sprintf("loadgen_%016x", g.rng.Uint64()),
+	}
+
+	if len(g.recent) < loadgenRecent {
+		g.recent = append(g.recent, msg)
+	} else {
+		g.recent[g.rng.Intn(loadgenRecent)] = msg
+	}
+	return msg
+}
+
+// pacer spaces calls to wait evenly at the target rate. After a stall it
+// resumes at the normal rate instead of bursting to catch up.
+type pacer struct {
+	interval time.Duration
+	next     time.Time
+}
+
+func newPacer(rate float64) *pacer {
+	return &pacer{interval: time.Duration(float64(time.Second) / rate), next: time.Now()}
+}
+
+func (p *pacer) wait(ctx context.Context) bool {
+	now := time.Now()
+	if p.next.Before(now.Add(-time.Second)) {
+		p.next = now
+	}
+	p.next = p.next.Add(p.interval)
+
+	if d := time.Until(p.next); d > 0 {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return false
+		case <-timer.C:
+		}
+	}
+	return ctx.Err() == nil
+}
@@ -0,0 +1,100 @@
+package cli
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestTrafficGenerator(t *testing.T) {
+	gen := newTrafficGenerator(rand.New(rand.NewSource(1)), 20, 1000, 5, 0, 0)
+	versions := make(map[uint]uint)
+	for r := 0; r < 50; r++ {
+		round := gen.round()
+		if len(round) != 5 {
+			t.Fatalf("round %d has %d messages, want a group of 5", r, len(round))
+		}
+		seen := make(map[uint]bool)
+		for _, g := range round {
+			msg := g.msg
+			if msg.UserID <= 1000 || msg.UserID > 1020 {
+				t.Fatalf("user %d outside the offset range", msg.UserID)
+			}
+			if seen[msg.UserID] {
+				t.Errorf("round %d updates user %d twice", r, msg.UserID)
+			}
+			seen[msg.UserID] = true
+
+			if msg.Version != versions[msg.UserID]+1 {
+				t.Errorf("user %d at v%d after v%d", msg.UserID, msg.Version, versions[msg.UserID])
+			}
+			versions[msg.UserID] = msg.Version
+			if a := *msg.NewAmount; a < 0 || math.Round(a*100)/100 != a {
+				t.Errorf("user %d amount %v, want cents not below zero", msg.UserID, a)
+			}
+			if err := msg.Validate(); err != nil {
+				t.Errorf("generated message %+v: %v", msg, err)
+			}
+		}
+	}
+}
+
+func TestTrafficGeneratorDuplicatesAndReorders(t *testing.T) {
+	gen := newTrafficGenerator(rand.New(rand.NewSource(2)), 10, 0, 3, 1, 1)
+	sent := make(map[string]uint)
+	for r := 0; r < 20; r++ {
+		round := gen.round()
+		// Every user brings a newer update, the one it overtook and a
+		// duplicate of a recent message.
+		if len(round) != 9 {
+			t.Fatalf("round %d has %d messages, want 9", r, len(round))
+		}
+		for i := 0; i < len(round); i += 3 {
+			newer, older, dup := round[i], round[i+1], round[i+2]
+			if newer.reordered || !older.reordered || !dup.duplicate {
+				t.Fatalf("round %d: flags %+v %+v %+v", r, newer, older, dup)
+			}
+			if newer.msg.UserID != older.msg.UserID || newer.msg.Version != older.msg.Version+1 {
+				t.Errorf("user %d v%d sent before user %d v%d", newer.msg.UserID, newer.msg.Version, older.msg.UserID, older.msg.Version)
+			}
+			sent[newer.msg.EventID] = newer.msg.Version
+			sent[older.msg.EventID] = older.msg.Version
+			if v, ok := sent[dup.msg.EventID]; !ok || v != dup.msg.Version {
+				t.Errorf("duplicate %s v%d was not sent before", dup.msg.EventID, dup.msg.Version)
+			}
+		}
+	}
+}
+
+func TestPacerRate(t *testing.T) {
+	ctx := context.Background()
+	p := newPacer(200)
+	start := time.Now()
+	for i := 0; i < 40; i++ {
+		if !p.wait(ctx) {
+			t.Fatal("wait failed")
+		}
+	}
+	// 40 messages at 200 per second take 200ms.
+	if elapsed := time.Since(start); elapsed < 190*time.Millisecond || elapsed > time.Second {
+		t.Errorf("40 waits at 200/s took %v, want about 200ms", elapsed)
+	}
+
+	// After a stall it does not burst to catch up.
+	p.next = time.Now().Add(-time.Minute)
+	start = time.Now()
+	for i := 0; i < 10; i++ {
+		p.wait(ctx)
+	}
+	if elapsed := time.Since(start); elapsed < 45*time.Millisecond {
+		t.Errorf("10 waits after a stall took %v, want about 50ms", elapsed)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if newPacer(1).wait(canceled) {
+		t.Error("wait succeeded on a canceled context")
+	}
+}
@@ -0,0 +1,35 @@
+package cli
+
+import (
+	"context"
+	"flag"
+)
+
+func init() {
+	register(command{
+		name:    "migrate",
+		summary: "create or update the database schema and exit",
+		run:     runMigrate,
+	})
+}
+
+func runMigrate(_ context.Context, env *env, args []string) int {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	db, code := openDatabase(env, false)
+	if code != ExitOK {
+		return code
+	}
+	defer closeDatabase(env, db)
+
+	if err := db.Migrate(env.cfg.Currency.Default); err != nil {
+		env.log.WithError(err).Error("migration failed")
+		return ExitFailure
+	}
+
+	env.log.Info("database schema is up to date")
+	return ExitOK
+}
@@ -0,0 +1,79 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+
+	"balance-service/internal/database"
+	"balance-service/internal/reconcile"
+)
+
+func init() {
+	register(command{
+		name:    "reconcile",
+		summary: "diff the balances against the upstream Laravel table, optionally repairing them (reconcile -repair)",
+		run:     runReconcile,
+	})
+}
+
+func runReconcile(ctx context.Context, env *env, args []string) int {
+	fs := flag.NewFlagSet("reconcile", flag.ContinueOnError)
+	repair := fs.Bool("repair", env.cfg.Reconcile.Repair, "write correction events for missing, behind and mismatching balances")
+	batchSize := fs.Int("batch-size", env.cfg.Reconcile.BatchSize, "balances read per query and repaired per transaction")
+	maxDetails := fs.Int("details", reconcile.MaxDetails, "maximum number of differences listed in the report")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if *batchSize < 1 || *maxDetails < 0 {
+		fmt.Fprintln(fs.Output(), "-batch-size must be positive and -details must not be negative")
+		return ExitUsage
+	}
+	if !env.cfg.Upstream.Configured() {
+		env.log.Error("the upstream database is not configured, set upstream.driver or UPSTREAM_DB_DRIVER")
+		return ExitConfig
+	}
+
+	db, code := openDatabase(env, false)
+	if code != ExitOK {
+		return code
+	}
+	defer closeDatabase(env, db)
+
+	upstream, code := openUpstream(env)
+	if code != ExitOK {
+		return code
+	}
+	defer closeDatabase(env, upstream)
+
+	report, err := reconcile.New(upstream.DB, db.DB, env.log).Run(ctx, reconcile.Options{
+		BatchSize:  *batchSize,
+		Repair:     *repair,
+		MaxDetails: *maxDetails,
+		Currency:   env.cfg.Currency.Default,
+	})
+	if err != nil {
+		env.log.WithError(err).Error("reconciliation failed")
+		return ExitFailure
+	}
+
+	enc := json.NewEncoder(env.stdout)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(report)
+
+	if report.Unresolved() > 0 {
+		return ExitFailure
+	}
+	return ExitOK
+}
+
+// openUpstream connects to the upstream database. It is never migrated.
+func openUpstream(env *env) (*database.Database, int) {
+	db, err := database.New(env.cfg.Upstream, env.log)
+	if err != nil {
+		env.log.WithError(err).Error("failed to initialize upstream database")
+		return nil, ExitUnavailable
+	}
+	return db, ExitOK
+}
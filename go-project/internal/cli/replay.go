@@ -0,0 +1,118 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"balance-service/internal/processor"
+	"balance-service/internal/repository"
+	"balance-service/internal/tenant"
+)
+
+const maxReplayLine = 1 << 20
+
+func init() {
+	register(command{
+		name:    "replay-file",
+		summary: "apply JSON lines of balance messages through the processor (replay-file -file updates.jsonl)",
+		run:     runReplayFile,
+	})
+}
+
+func runReplayFile(ctx context.Context, env *env, args []string) int {
+	fs := flag.NewFlagSet("replay-file", flag.ContinueOnError)
+	path := fs.String("file", "", "JSON lines file, - for stdin (required)")
+	batchSize := fs.Int("batch-size", env.cfg.Batch.Size, "messages applied per batch")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if *path == "" || *batchSize < 1 {
+		fmt.Fprintln(fs.Output(), "-file is required and -batch-size must be positive")
+		return ExitUsage
+	}
+
+	var in io.Reader = os.Stdin
+	if *path != "-" {
+		f, err := os.Open(*path)
+		if err != nil {
+			env.log.WithError(err).Error("failed to open replay file")
+			return ExitFailure
+		}
+		defer f.Close()
+		in = f
+	}
+
+	db, code := openDatabase(env, true)
+	if code != ExitOK {
+		return code
+	}
+	defer closeDatabase(env, db)
+
+	balanceRepo := repository.NewBalanceRepository(db.DB, env.log)
+	eventRepo := repository.NewEventRepository(db.DB, env.log)
+	var cache sync.Map
+	policy := processor.NewNegativePolicy(env.cfg.NegativeBalance)
+
+	var applied, rejected int
+	batch := make([]processor.BalanceMessage, 0, *batchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := processor.ProcessMessages(ctx, balanceRepo, eventRepo, &cache, batch, policy, env.log); err != nil {
+			return err
+		}
+		applied += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+
+	tenants := tenant.New(env.cfg.Tenants, nil, env.log)
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), maxReplayLine)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		msg, err := processor.DecodeMessage(scanner.Bytes())
+		if err == nil {
+			// Replays are not throttled; only the tenant itself is checked.
+			err = tenants.Resolve(&msg, tenant.Origin{})
+		}
+		if err != nil {
+			rejected++
+			env.log.WithError(err).WithField("line", line).Warn("skipping invalid message")
+			continue
+		}
+		msg.DefaultCurrency(env.cfg.Currency.Default)
+
+		batch = append(batch, msg)
+		if len(batch) >= *batchSize {
+			if err := flush(); err != nil {
+				env.log.WithError(err).WithField("line", line).Error("failed to apply batch")
+				return ExitFailure
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		env.log.WithError(err).Error("failed to read replay file")
+		return ExitFailure
+	}
+	if err := flush(); err != nil {
+		env.log.WithError(err).Error("failed to apply batch")
+		return ExitFailure
+	}
+
+	_ = json.NewEncoder(env.stdout).Encode(map[string]int{"applied": applied, "rejected": rejected})
+	if rejected > 0 {
+		return ExitFailure
+	}
+	return ExitOK
+}
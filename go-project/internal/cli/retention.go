@@ -0,0 +1,224 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"balance-service/internal/database"
+	"balance-service/internal/retention"
+	"github.com/sirupsen/logrus"
+)
+
+func init() {
+	register(command{
+		name:    "retention",
+		summary: "archive and delete old balance events, or restore archived ones (retention run|partition|list|restore)",
+		run:     runRetention,
+	})
+}
+
+const retentionUsage = `usage: balance-service retention <action> [flags]
+
+actions:
+  run
+  partition
+  list [-from 2026-01] [-to 2026-03]
+  restore -from 2026-01 [-to 2026-03] | -archive NAME [-batch-size 1000]`
+
+func runRetention(ctx context.Context, env *env, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, retentionUsage)
+		return ExitUsage
+	}
+	action, args := args[0], args[1:]
+
+	fs := flag.NewFlagSet("retention "+action, flag.ContinueOnError)
+	// run gets no database for the actions that only read the archive.
+	var run func(ctx context.Context, db *database.Database) int
+	needsDB := action != "list"
+
+	switch action {
+	case "run":
+		run = func(ctx context.Context, db *database.Database) int {
+			if env.cfg.Retention.Days < 1 {
+				env.log.Error("the retention period is not configured, set retention.days or RETENTION_DAYS")
+				return ExitConfig
+			}
+			job, code := newRetentionJob(env, db)
+			if code != ExitOK {
+				return code
+			}
+			report, err := job.Run(ctx)
+			enc := json.NewEncoder(env.stdout)
+			enc.SetIndent("", "  ")
+			_ = enc.Encode(report)
+			if err != nil {
+				env.log.WithError(err).Error("retention run failed")
+				return ExitFailure
+			}
+			return ExitOK
+		}
+	case "partition":
+		run = func(ctx context.Context, db *database.Database) int {
+			if env.cfg.Database.Driver != database.DriverMySQL {
+				env.log.Error("monthly partitions need the mysql driver")
+				return ExitConfig
+			}
+			names, err := retention.Partition(ctx, db.DB, env.log)
+			if err != nil {
+				env.log.WithError(err).Error("failed to partition balance_events")
+				return ExitFailure
+			}
+			_ = json.NewEncoder(env.stdout).Encode(map[string][]string{"partitions": names})
+			return ExitOK
+		}
+	case "list":
+		from := fs.String("from", "", "first month, YYYY-MM (default: every archive file)")
+		to := fs.String("to", "", "last month, YYYY-MM (default: -from)")
+		run = func(ctx context.Context, db *database.Database) int {
+			first, last, ok := parseMonths(fs, *from, *to)
+			if !ok {
+				return ExitUsage
+			}
+			store, code := openArchive(env)
+			if code != ExitOK {
+				return code
+			}
+			names, err := retention.Archives(ctx, store, first, last)
+			if err != nil {
+				env.log.WithError(err).Error("failed to list archive files")
+				return ExitUnavailable
+			}
+			enc := json.NewEncoder(env.stdout)
+			for _, name := range names {
+				_ = enc.Encode(map[string]string{"archive": name})
+			}
+			return ExitOK
+		}
+	case "restore":
+		from := fs.String("from", "", "first month to restore, YYYY-MM")
+		to := fs.String("to", "", "last month to restore, YYYY-MM (default: -from)")
+		archive := fs.String("archive", "", "restore only this archive file")
+		batchSize := fs.Int("batch-size", env.cfg.Retention.BatchSize, "events inserted per statement")
+		run = func(ctx context.Context, db *database.Database) int {
+			if (*from == "") == (*archive == "") || *batchSize < 1 {
+				fmt.Fprintln(fs.Output(), "either -from or -archive is required and -batch-size must be positive")
+				return ExitUsage
+			}
+			first, last, ok := parseMonths(fs, *from, *to)
+			if !ok {
+				return ExitUsage
+			}
+			store, code := openArchive(env)
+			if code != ExitOK {
+				return code
+			}
+
+			names := []string{*archive}
+			if *archive == "" {
+				var err error
+				if names, err = retention.Archives(ctx, store, first, last); err != nil {
+					env.log.WithError(err).Error("failed to list archive files")
+					return ExitUnavailable
+				}
+				if len(names) == 0 {
+					env.log.WithFields(logrus.Fields{"from": *from, "to": *to}).Error("no archive files in these months")
+					return ExitNotFound
+				}
+			}
+
+			results, err := retention.Restore(ctx, db.DB, store, names, *batchSize, env.log)
+			enc := json.NewEncoder(env.stdout)
+			for _, result := range results {
+				_ = enc.Encode(result)
+			}
+			if errors.Is(err, os.ErrNotExist) {
+				env.log.WithError(err).Error("archive file not found")
+				return ExitNotFound
+			}
+			if err != nil {
+				env.log.WithError(err).Error("restore failed")
+				return ExitFailure
+			}
+			return ExitOK
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "unknown action %q\n\n%s\n", action, retentionUsage)
+		return ExitUsage
+	}
+
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	if !needsDB {
+		return run(ctx, nil)
+	}
+	db, code := openDatabase(env, false)
+	if code != ExitOK {
+		return code
+	}
+	defer closeDatabase(env, db)
+
+	return run(ctx, db)
+}
+
+// newRetentionJob builds the job from the configuration.
+func newRetentionJob(env *env, db *database.Database) (*retention.Job, int) {
+	store, code := openArchive(env)
+	if code != ExitOK {
+		return nil, code
+	}
+	cfg := env.cfg.Retention
+	return retention.New(db.DB, store, retention.Options{
+		Days:        cfg.Days,
+		BatchSize:   cfg.BatchSize,
+		FileEvents:  cfg.FileEvents,
+		Partitioned: cfg.PartitionByMonth,
+		ClaimDays:   cfg.ClaimDays,
+	}, env.log), ExitOK
+}
+
+func openArchive(env *env) (retention.Store, int) {
+	store, err := retention.NewStore(env.cfg.Retention.Archive)
+	if err != nil {
+		env.log.WithError(err).Error("failed to open the archive")
+		return nil, ExitConfig
+	}
+	return store, ExitOK
+}
+
+// parseMonths reads the -from and -to months. An empty from selects every
+// month; an empty to is the same month as from.
+func parseMonths(fs *flag.FlagSet, from, to string) (time.Time, time.Time, bool) {
+	if from == "" {
+		if to != "" {
+			fmt.Fprintln(fs.Output(), "-to needs -from")
+			return time.Time{}, time.Time{}, false
+		}
+		return time.Time{}, time.Time{}, true
+	}
+	if to == "" {
+		to = from
+	}
+	first, err := time.Parse("2006-01", from)
+	if err != nil {
+		fmt.Fprintf(fs.Output(), "-from %q is not a month like 2026-01\n", from)
+		return time.Time{}, time.Time{}, false
+	}
+	last, err := time.Parse("2006-01", to)
+	if err != nil {
+		fmt.Fprintf(fs.Output(), "-to %q is not a month like 2026-01\n", to)
+		return time.Time{}, time.Time{}, false
+	}
+	if last.Before(first) {
+		fmt.Fprintln(fs.Output(), "-to must not be before -from")
+		return time.Time{}, time.Time{}, false
+	}
+	return first, last, true
+}
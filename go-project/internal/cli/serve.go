@@ -0,0 +1,357 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"balance-service/internal/admin"
+	"balance-service/internal/alert"
+	"balance-service/internal/breaker"
+	"balance-service/internal/consumer"
+	"balance-service/internal/ledger"
+	"balance-service/internal/processor"
+	"balance-service/internal/publisher"
+	"balance-service/internal/reconcile"
+	"balance-service/internal/repository"
+	"balance-service/internal/retention"
+	"balance-service/internal/statement"
+	cacheSync "balance-service/internal/sync"
+	"balance-service/internal/tenant"
+	"balance-service/internal/tuning"
+	"balance-service/internal/webhook"
+	"github.com/sirupsen/logrus"
+)
+
+func init() {
+	register(command{
+		name:    "serve",
+		summary: "consume balance updates from RabbitMQ (default)",
+		run:     runServe,
+	})
+}
+
+func runServe(ctx context.Context, env *env, args []string) int {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	cfg, log := env.cfg, env.log
+
+	log.WithFields(logrus.Fields{
+		"rabbitmq_queue": cfg.Rabbit.Queue,
+		"rabbitmq_host":  cfg.Rabbit.Host,
+		"db_host":        cfg.Database.Host,
+		"db_name":        cfg.Database.DBName,
+		"workers":        cfg.Rabbit.Workers,
+		"batch_size":     cfg.Batch.Size,
+		"batch_interval": cfg.Batch.Interval,
+	}).Info("starting balance service")
+
+	// Initialize database
+	db, code := openDatabase(env, true)
+	if code != ExitOK {
+		return code
+	}
+	defer closeDatabase(env, db)
+
+	// Initialize repositories
+	balanceRepo := repository.NewBalanceRepository(db.DB, log)
+	eventRepo := repository.NewEventRepository(db.DB, log)
+
+	// Periodic reconciliation against the upstream balances
+	if cfg.Reconcile.Interval > 0 {
+		upstream, code := openUpstream(env)
+		if code != ExitOK {
+			return code
+		}
+		defer closeDatabase(env, upstream)
+
+		go reconcile.Schedule(ctx, reconcile.New(upstream.DB, db.DB, log), cfg.Reconcile.Interval, reconcile.Options{
+			BatchSize:  cfg.Reconcile.BatchSize,
+			Repair:     cfg.Reconcile.Repair,
+			MaxDetails: reconcile.MaxDetails,
+			Currency:   cfg.Currency.Default,
+		}, log)
+		log.WithField("interval", cfg.Reconcile.Interval).Info("reconciliation scheduled")
+	}
+
+	// Periodic check of the ledger invariants
+	if cfg.Ledger.CheckInterval > 0 {
+		go ledger.Schedule(ctx, db.DB, cfg.Ledger.CheckInterval, log)
+		log.WithField("interval", cfg.Ledger.CheckInterval).Info("ledger check scheduled")
+	}
+
+	// Periodic archival of old balance events
+	if cfg.Retention.Interval > 0 {
+		job, code := newRetentionJob(env, db)
+		if code != ExitOK {
+			return code
+		}
+		go retention.Schedule(ctx, job, cfg.Retention.Interval, log)
+		log.WithFields(logrus.Fields{
+			"interval":   cfg.Retention.Interval,
+			"days":       cfg.Retention.Days,
+			"claim_days": cfg.Retention.ClaimDays,
+		}).Info("event retention scheduled")
+	}
+
+	// Daily balance snapshots for statements
+	clock, err := statement.NewClock(cfg.Snapshots)
+	if err != nil {
+		log.WithError(err).Error("invalid snapshot configuration")
+		return ExitConfig
+	}
+	if cfg.Snapshots.Enabled {
+		go statement.Schedule(ctx, statement.NewJob(db.DB, clock, cfg.Snapshots.BatchSize, log), log)
+		log.WithField("cutoff", clock.String()).Info("balance snapshots scheduled")
+	}
+
+	// The breaker pauses consumption while the database is unhealthy
+	dbBreaker := breaker.New(ctx, breaker.Config{
+		Threshold:  cfg.Breaker.Threshold,
+		MinBackoff: cfg.Breaker.ProbeInterval,
+		MaxBackoff: cfg.Breaker.ProbeMax,
+	}, db.Ping, log)
+
+	// Settings that can be changed without a restart
+	settings := tuning.NewStore(tuning.FromConfig(cfg))
+
+	// Create channel for incoming updates (buffered to handle bursts)
+	updates := make(chan processor.IncomingUpdate, cfg.Batch.Size*2)
+
+	// Processor workers outlive the shutdown signal: they stop once updates
+	// is closed during the drain. procCtx is only cancelled if the drain
+	// deadline passes.
+	procCtx, cancelProc := context.WithCancel(context.Background())
+	defer cancelProc()
+
+	// Version gaps ask the producer to re-send the user's state
+	var hooks processor.Hooks
+	if cfg.Rabbit.Resync.Enabled {
+		requester, err := publisher.NewResyncRequester(cfg.Rabbit, log)
+		if err != nil {
+			log.WithError(err).Error("failed to set up resync requests")
+			return ExitConfig
+		}
+		defer requester.Close()
+		hooks.Resync = requester
+	}
+
+	// Committed changes are pushed to the webhook subscriptions
+	if cfg.Webhooks.Enabled {
+		dispatcher := webhook.New(cfg.Webhooks, repository.NewWebhookRepository(db.DB, log), log)
+		if err := dispatcher.Start(ctx); err != nil {
+			log.WithError(err).Error("failed to start webhook dispatcher")
+			return ExitUnavailable
+		}
+		defer func() {
+			closeCtx, cancel := context.WithTimeout(context.Background(), cfg.Shutdown.Timeout)
+			defer cancel()
+			dispatcher.Close(closeCtx)
+		}()
+		hooks.Observers = append(hooks.Observers, dispatcher)
+		log.Info("webhook dispatcher started")
+	}
+
+	// Alert rules are checked against every committed change
+	if cfg.Alerts.Enabled {
+		sink, err := alert.NewSink(cfg.Alerts.Sink, cfg.Rabbit, log)
+		if err != nil {
+			log.WithError(err).Error("failed to set up the alert sink")
+			return ExitConfig
+		}
+		alerts := alert.New(cfg.Alerts, repository.NewAlertRepository(db.DB, log), sink, log)
+		defer alerts.Close()
+		hooks.Observers = append(hooks.Observers, alerts)
+		log.WithFields(logrus.Fields{
+			"rules": len(cfg.Alerts.Rules),
+			"sink":  cfg.Alerts.Sink.Type,
+		}).Info("alerting enabled")
+	}
+
+	// Tenants are resolved and limited in the consumer and counted once
+	// their changes are committed
+	tenants := tenant.New(cfg.Tenants, balanceRepo, log)
+	hooks.Observers = append(hooks.Observers, tenants)
+
+	// Initialize the RabbitMQ consumer; messages the database refuses go to
+	// its dead-letter queue
+	rmqConsumer := consumer.New(cfg.Rabbit, consumer.Options{
+		NegativePolicy:  processor.NewNegativePolicy(cfg.NegativeBalance),
+		DefaultCurrency: cfg.Currency.Default,
+		Tenants:         tenants,
+	}, log, updates)
+	hooks.DeadLetter = rmqConsumer
+
+	var cache sync.Map
+	pool := processor.StartProcessorPool(
+		procCtx,
+		balanceRepo,
+		eventRepo,
+		&cache,
+		updates,
+		dbBreaker,
+		cfg.Rabbit,
+		settings,
+		hooks,
+		log,
+	)
+	log.Info("batch processor started")
+
+	// Start cache synchronizer goroutine
+	go cacheSync.SyncCache(
+		ctx,
+		balanceRepo,
+		&cache,
+		cfg.Sync.BatchSize,
+		settings,
+		log,
+	)
+	log.Info("cache synchronizer started")
+
+	dbBreaker.OnStateChange(func(state breaker.State) {
+		var err error
+		switch state {
+		case breaker.Open:
+			err = rmqConsumer.Pause()
+		case breaker.HalfOpen:
+			err = rmqConsumer.Resume()
+		}
+		if err != nil {
+			log.WithError(err).WithField("breaker", state).Error("failed to apply breaker state to consumer")
+		}
+	})
+
+	// Apply runtime setting changes that aren't picked up by the workers
+	// themselves
+	go func() {
+		changes := settings.Subscribe()
+		applied := settings.Get()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-changes:
+			}
+
+			next := settings.Get()
+			if next.LogLevel != applied.LogLevel {
+				level, _ := logrus.ParseLevel(next.LogLevel)
+				log.SetLevel(level)
+			}
+			if next.Prefetch != applied.Prefetch {
+				if err := rmqConsumer.SetPrefetch(next.Prefetch); err != nil {
+					log.WithError(err).Error("failed to apply prefetch")
+				}
+			}
+			applied = next
+		}
+	}()
+
+	reload := func() (tuning.Settings, error) {
+		next, err := tuning.Reload(cfg, env.configPath, settings)
+		if err != nil {
+			log.WithError(err).Error("configuration reload rejected")
+			return next, err
+		}
+		log.WithField("settings", next).Info("configuration reloaded")
+		return next, nil
+	}
+
+	// Reload on SIGHUP
+	go func() {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		defer signal.Stop(hup)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				_, _ = reload()
+			}
+		}
+	}()
+
+	// Health, readiness, metrics and admin endpoints
+	if cfg.Admin.Addr != "" {
+		adminServer := admin.New(cfg.Admin, log)
+		adminServer.RegisterSettings(settings, reload)
+		adminServer.RegisterPending(processor.NewPendingReview(db.DB, &cache, log))
+		adminServer.RegisterBalances(balanceRepo)
+		adminServer.RegisterStatements(statement.NewBuilder(db.DB, clock, log), cfg.Currency.Default)
+		adminServer.AddReadinessCheck("database", func(ctx context.Context) error {
+			if state := dbBreaker.State(); state == breaker.Open {
+				return fmt.Errorf("circuit breaker %s", state)
+			}
+			return db.Ping(ctx)
+		})
+		adminServer.AddReadinessCheck("rabbitmq", rmqConsumer.Ready)
+		go func() {
+			if err := adminServer.Run(ctx); err != nil {
+				log.WithError(err).Error("admin server stopped")
+			}
+		}()
+	}
+
+	log.Info("balance service started, waiting for messages...")
+
+	// Start consuming messages (this blocks until context is cancelled)
+	// The supervisor keeps the RabbitMQ connection alive until shutdown
+	code = ExitOK
+	if err := rmqConsumer.Run(ctx); err != nil {
+		log.WithError(err).Error("consumer stopped unexpectedly")
+		code = ExitUnavailable
+	}
+
+	drain(cfg.Shutdown.Timeout, rmqConsumer, updates, pool, cancelProc, log)
+	return code
+}
+
+// drainer is the consumer as seen by drain.
+type drainer interface {
+	Drain(ctx context.Context)
+	Close()
+}
+
+// waiter is the processor pool as seen by drain.
+type waiter interface {
+	Wait(ctx context.Context) error
+}
+
+// drain shuts the pipeline down in order so that no ack is sent on a closed
+// channel: stop the subscription, let the consumer workers hand over what
+// they hold, wait for the processor's final flush and acks, and only then
+// close the channel.
+func drain(
+	timeout time.Duration,
+	rmqConsumer drainer,
+	updates chan processor.IncomingUpdate,
+	pool waiter,
+	cancelProc context.CancelFunc,
+	log *logrus.Logger,
+) {
+	log.WithField("timeout", timeout).Info("draining in-flight messages")
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	rmqConsumer.Drain(ctx)
+	close(updates)
+
+	if err := pool.Wait(ctx); err != nil {
+		log.Warn("drain deadline exceeded, aborting in-flight batches")
+		cancelProc()
+		_ = pool.Wait(context.Background())
+	}
+
+	log.Info("closing RabbitMQ consumer")
+	rmqConsumer.Close()
+	log.Info("graceful shutdown complete")
+}
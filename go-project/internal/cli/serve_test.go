@@ -0,0 +1,77 @@
+package cli
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"balance-service/internal/processor"
+	"github.com/sirupsen/logrus"
+)
+
+// shutdownSteps records the order drain shuts the pipeline down in.
+type shutdownSteps struct {
+	steps   []string
+	updates chan processor.IncomingUpdate
+	// stuck makes the first Wait run out of time.
+	stuck bool
+}
+
+func (s *shutdownSteps) Drain(ctx context.Context) {
+	if s.updatesClosed() {
+		s.steps = append(s.steps, "updates closed before Drain")
+	}
+	s.steps = append(s.steps, "drain")
+}
+
+func (s *shutdownSteps) Wait(ctx context.Context) error {
+	if !s.updatesClosed() {
+		s.steps = append(s.steps, "wait with updates open")
+	}
+	if s.stuck {
+		s.stuck = false
+		<-ctx.Done()
+		s.steps = append(s.steps, "wait timed out")
+		return ctx.Err()
+	}
+	s.steps = append(s.steps, "wait")
+	return nil
+}
+
+func (s *shutdownSteps) Close() { s.steps = append(s.steps, "close") }
+
+func (s *shutdownSteps) updatesClosed() bool {
+	select {
+	case _, ok := <-s.updates:
+		return !ok
+	default:
+		return false
+	}
+}
+
+func TestDrainOrder(t *testing.T) {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+
+	for _, c := range []struct {
+		name  string
+		stuck bool
+		want  []string
+	}{
+		{"in time", false, []string{"drain", "wait", "close"}},
+		// The processor is cancelled and waited for before the channel
+		// closes.
+		{"past the deadline", true, []string{"drain", "wait timed out", "cancel", "wait", "close"}},
+	} {
+		s := &shutdownSteps{updates: make(chan processor.IncomingUpdate, 1), stuck: c.stuck}
+		cancelProc := func() { s.steps = append(s.steps, "cancel") }
+
+		drain(20*time.Millisecond, s, s.updates, s, cancelProc, log)
+
+		if !reflect.DeepEqual(s.steps, c.want) {
+			t.Errorf("%s: shutdown steps %v, want %v", c.name, s.steps, c.want)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"time"
+
+	"balance-service/internal/statement"
+)
+
+func init() {
+	register(command{
+		name:    "snapshot",
+		summary: "take the balance snapshot of the last closed day if it is missing",
+		run:     runSnapshot,
+	})
+}
+
+func runSnapshot(ctx context.Context, env *env, args []string) int {
+	fs := flag.NewFlagSet("snapshot", flag.ContinueOnError)
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	clock, err := statement.NewClock(env.cfg.Snapshots)
+	if err != nil {
+		env.log.WithError(err).Error("invalid snapshot configuration")
+		return ExitConfig
+	}
+
+	db, code := openDatabase(env, false)
+	if code != ExitOK {
+		return code
+	}
+	defer closeDatabase(env, db)
+
+	job := statement.NewJob(db.DB, clock, env.cfg.Snapshots.BatchSize, env.log)
+	result, err := job.Take(ctx, time.Now())
+	if err != nil {
+		env.log.WithError(err).Error("balance snapshot failed")
+		return ExitFailure
+	}
+	if result.Late {
+		env.log.WithField("cutoff", result.Cutoff).Warn("balance snapshot taken late, it includes the updates applied since the cutoff")
+	}
+	_ = json.NewEncoder(env.stdout).Encode(result)
+	return ExitOK
+}
@@ -0,0 +1,87 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+
+	"balance-service/internal/model"
+	"balance-service/internal/statement"
+)
+
+func init() {
+	register(command{
+		name:    "statement",
+		summary: "print the statement of a wallet over a period (statement -user 42 -month 2026-09 [-format text|csv|json])",
+		run:     runStatement,
+	})
+}
+
+func runStatement(ctx context.Context, env *env, args []string) int {
+	fs := flag.NewFlagSet("statement", flag.ContinueOnError)
+	userID := fs.Uint("user", 0, "user ID (required)")
+	tenant := fs.String("tenant", "", "tenant of the user (default: the default tenant)")
+	currency := fs.String("currency", env.cfg.Currency.Default, "currency code")
+	month := fs.String("month", "", "the period is this month, YYYY-MM")
+	from := fs.String("from", "", "first day of the period, YYYY-MM-DD")
+	to := fs.String("to", "", "last day of the period, YYYY-MM-DD (default: -from)")
+	format := fs.String("format", statement.FormatText, "output format: text, csv or json")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if *userID == 0 {
+		fmt.Fprintln(fs.Output(), "-user is required")
+		return ExitUsage
+	}
+	if !model.ValidCurrency(*currency) {
+		fmt.Fprintf(fs.Output(), "-currency %q is not a three-letter upper-case code\n", *currency)
+		return ExitUsage
+	}
+	if *tenant == model.DefaultTenant {
+		*tenant = ""
+	}
+	if *tenant != "" && !model.ValidTenantID(*tenant) {
+		fmt.Fprintf(fs.Output(), "-tenant %q is not a valid tenant ID\n", *tenant)
+		return ExitUsage
+	}
+	if _, ok := statement.ContentTypes[*format]; !ok {
+		fmt.Fprintf(fs.Output(), "-format %q is not text, csv or json\n", *format)
+		return ExitUsage
+	}
+	first, last, err := statement.ParsePeriod(*month, *from, *to)
+	if err != nil {
+		fmt.Fprintln(fs.Output(), err)
+		return ExitUsage
+	}
+	clock, err := statement.NewClock(env.cfg.Snapshots)
+	if err != nil {
+		env.log.WithError(err).Error("invalid snapshot configuration")
+		return ExitConfig
+	}
+
+	db, code := openDatabase(env, false)
+	if code != ExitOK {
+		return code
+	}
+	defer closeDatabase(env, db)
+
+	wallet := model.WalletKey{TenantID: *tenant, UserID: *userID, Currency: *currency}
+	st, err := statement.NewBuilder(db.DB, clock, env.log).Build(ctx, wallet, first, last)
+	switch {
+	case errors.Is(err, statement.ErrPeriod):
+		fmt.Fprintln(fs.Output(), err)
+		return ExitUsage
+	case errors.Is(err, statement.ErrNoSnapshot):
+		env.log.WithError(err).Error("the period is not covered by balance snapshots")
+		return ExitNotFound
+	case err != nil:
+		env.log.WithError(err).Error("failed to build the statement")
+		return ExitFailure
+	}
+	if err := statement.Write(env.stdout, st, *format, clock.Location()); err != nil {
+		env.log.WithError(err).Error("failed to write the statement")
+		return ExitFailure
+	}
+	return ExitOK
+}
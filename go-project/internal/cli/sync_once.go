@@ -0,0 +1,57 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"sync"
+
+	"balance-service/internal/repository"
+	cacheSync "balance-service/internal/sync"
+)
+
+func init() {
+	register(command{
+		name:    "sync-once",
+		summary: "load every balance into a fresh cache once and compare with the database count",
+		run:     runSyncOnce,
+	})
+}
+
+func runSyncOnce(ctx context.Context, env *env, args []string) int {
+	fs := flag.NewFlagSet("sync-once", flag.ContinueOnError)
+	batchSize := fs.Int("batch-size", env.cfg.Sync.BatchSize, "balances fetched per query")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	db, code := openDatabase(env, false)
+	if code != ExitOK {
+		return code
+	}
+	defer closeDatabase(env, db)
+
+	balanceRepo := repository.NewBalanceRepository(db.DB, env.log)
+
+	var cache sync.Map
+	result, err := cacheSync.RunOnce(ctx, balanceRepo, &cache, *batchSize, env.log)
+	if err != nil {
+		env.log.WithError(err).Error("cache sync failed")
+		return ExitFailure
+	}
+
+	_ = json.NewEncoder(env.stdout).Encode(map[string]interface{}{
+		"total":    result.Total,
+		"synced":   result.Synced,
+		"duration": result.Duration.String(),
+	})
+
+	if result.Synced != result.Total {
+		env.log.WithFields(map[string]interface{}{
+			"total":  result.Total,
+			"synced": result.Synced,
+		}).Error("cache does not match the database")
+		return ExitFailure
+	}
+	return ExitOK
+}
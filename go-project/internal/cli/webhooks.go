@@ -0,0 +1,208 @@
+package cli
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"balance-service/internal/model"
+	"balance-service/internal/repository"
+)
+
+func init() {
+	register(command{
+		name:    "webhooks",
+		summary: "manage webhook subscriptions (webhooks add|list|pause|resume|remove|deliveries)",
+		run:     runWebhooks,
+	})
+}
+
+const webhooksUsage = `usage: balance-service webhooks <action> [flags]
+
+actions:
+  add -url URL [-tenant T] [-secret S] [-users 1,2] [-events balance.updated,balance.version_gap]
+  list [-tenant T]
+  pause -id N
+  resume -id N
+  remove -id N
+  deliveries [-tenant T] [-id N] [-limit 50]
+
+A subscription receives the events of one tenant. -tenant default names the
+default tenant; list and deliveries show every tenant without -tenant.`
+
+func runWebhooks(ctx context.Context, env *env, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, webhooksUsage)
+		return ExitUsage
+	}
+	action, args := args[0], args[1:]
+
+	fs := flag.NewFlagSet("webhooks "+action, flag.ContinueOnError)
+	var (
+		run    func(ctx context.Context, repo *repository.WebhookRepository) int
+		tenant *string
+		// scope is the tenant given by -tenant, nil if there was none.
+		scope *string
+	)
+
+	switch action {
+	case "add":
+		rawURL := fs.String("url", "", "endpoint URL (required)")
+		tenant = fs.String("tenant", "", "tenant whose events are sent (default: the default tenant)")
+		secret := fs.String("secret", "", "HMAC secret (default: generated and printed once)")
+		users := fs.String("users", "", "comma separated user IDs (default: all users)")
+		events := fs.String("events", "", "comma separated event types (default: all types)")
+		run = func(ctx context.Context, repo *repository.WebhookRepository) int {
+			sub, err := newSubscription(*rawURL, *secret, *users, *events)
+			if err != nil {
+				fmt.Fprintln(fs.Output(), err)
+				return ExitUsage
+			}
+			if scope != nil {
+				sub.TenantID = *scope
+			}
+			if err := repo.CreateSubscription(ctx, sub); err != nil {
+				env.log.WithError(err).Error("failed to create subscription")
+				return ExitFailure
+			}
+			// The secret is not part of the subscription's JSON form.
+			_ = json.NewEncoder(env.stdout).Encode(struct {
+				*model.WebhookSubscription
+				Secret string `json:"secret"`
+			}{sub, sub.Secret})
+			return ExitOK
+		}
+	case "list":
+		tenant = fs.String("tenant", "", "only the subscriptions of this tenant")
+		run = func(ctx context.Context, repo *repository.WebhookRepository) int {
+			subs, err := repo.ListSubscriptions(ctx, scope, false)
+			if err != nil {
+				env.log.WithError(err).Error("failed to list subscriptions")
+				return ExitFailure
+			}
+			enc := json.NewEncoder(env.stdout)
+			for _, sub := range subs {
+				_ = enc.Encode(sub)
+			}
+			return ExitOK
+		}
+	case "pause", "resume", "remove":
+		id := fs.Uint("id", 0, "subscription ID (required)")
+		run = func(ctx context.Context, repo *repository.WebhookRepository) int {
+			if *id == 0 {
+				fmt.Fprintln(fs.Output(), "-id is required")
+				return ExitUsage
+			}
+			var err error
+			if action == "remove" {
+				err = repo.DeleteSubscription(ctx, *id)
+			} else {
+				err = repo.SetSubscriptionActive(ctx, *id, action == "resume")
+			}
+			if errors.Is(err, repository.ErrNotFound) {
+				env.log.WithField("id", *id).Error("subscription not found")
+				return ExitNotFound
+			}
+			if err != nil {
+				env.log.WithError(err).Errorf("failed to %s subscription", action)
+				return ExitFailure
+			}
+			return ExitOK
+		}
+	case "deliveries":
+		tenant = fs.String("tenant", "", "only the subscriptions of this tenant")
+		id := fs.Uint("id", 0, "only this subscription")
+		limit := fs.Int("limit", 50, "number of latest attempts")
+		run = func(ctx context.Context, repo *repository.WebhookRepository) int {
+			if *limit < 1 {
+				fmt.Fprintln(fs.Output(), "-limit must be positive")
+				return ExitUsage
+			}
+			deliveries, err := repo.ListDeliveries(ctx, scope, *id, *limit)
+			if err != nil {
+				env.log.WithError(err).Error("failed to list deliveries")
+				return ExitFailure
+			}
+			enc := json.NewEncoder(env.stdout)
+			for _, d := range deliveries {
+				_ = enc.Encode(d)
+			}
+			return ExitOK
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "unknown action %q\n\n%s\n", action, webhooksUsage)
+		return ExitUsage
+	}
+
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if tenant != nil && *tenant != "" {
+		id := *tenant
+		if id == model.DefaultTenant {
+			id = ""
+		} else if !model.ValidTenantID(id) {
+			fmt.Fprintf(fs.Output(), "-tenant %q is not a valid tenant ID\n", id)
+			return ExitUsage
+		}
+		scope = &id
+	}
+
+	db, code := openDatabase(env, true)
+	if code != ExitOK {
+		return code
+	}
+	defer closeDatabase(env, db)
+
+	return run(ctx, repository.NewWebhookRepository(db.DB, env.log))
+}
+
+func newSubscription(rawURL, secret, users, events string) (*model.WebhookSubscription, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("-url must be an absolute http or https URL")
+	}
+
+	if secret == "" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		secret = hex.EncodeToString(b)
+	}
+
+	sub := &model.WebhookSubscription{URL: u.String(), Secret: secret, Active: true}
+	for _, raw := range splitList(users) {
+		id, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil || id == 0 {
+			return nil, fmt.Errorf("-users: %q is not a user ID", raw)
+		}
+		sub.UserIDs = append(sub.UserIDs, uint(id))
+	}
+	for _, eventType := range splitList(events) {
+		if eventType != model.WebhookBalanceUpdated && eventType != model.WebhookVersionGap {
+			return nil, fmt.Errorf("-events: unknown event type %q, expected %s or %s",
+				eventType, model.WebhookBalanceUpdated, model.WebhookVersionGap)
+		}
+		sub.EventTypes = append(sub.EventTypes, eventType)
+	}
+	return sub, nil
+}
+
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
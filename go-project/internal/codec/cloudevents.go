@@ -0,0 +1,187 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+
+	"balance-service/internal/processor"
+)
+
+// CloudEvents AMQP binding header prefixes for binary mode. The binding
+// specifies "cloudEvents:"; some clients use "cloudEvents_" because of
+// brokers that reject colons.
+var binaryPrefixes = []string{"cloudEvents:", "cloudEvents_"}
+
+// cloudEvent is the structured mode envelope (JSON event format).
+type cloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Time            string          `json:"time,omitempty"`
+	DataContentType string          `json:"datacontenttype,omitempty"`
+	Data            json.RawMessage `json:"data,omitempty"`
+	DataBase64      string          `json:"data_base64,omitempty"`
+}
+
+// BinaryHeaders returns the CloudEvents binary mode headers of a delivery
+// that carry a string value, by header name. These are the headers a
+// signature has to cover.
+func BinaryHeaders(headers map[string]interface{}) map[string]string {
+	found := make(map[string]string)
+	for key, value := range headers {
+		for _, prefix := range binaryPrefixes {
+			if !strings.HasPrefix(key, prefix) {
+				continue
+			}
+			switch v := value.(type) {
+			case string:
+				found[key] = v
+			case []byte:
+				found[key] = string(v)
+			}
+		}
+	}
+	return found
+}
+
+// binaryAttributes extracts the context attributes of a binary mode event.
+// ok is false when the headers do not carry a CloudEvent.
+func binaryAttributes(headers map[string]interface{}) (cloudEvent, bool) {
+	attrs := make(map[string]string)
+	for key, value := range BinaryHeaders(headers) {
+		for _, prefix := range binaryPrefixes {
+			if name, found := strings.CutPrefix(key, prefix); found {
+				attrs[name] = value
+			}
+		}
+	}
+	if _, ok := attrs["specversion"]; !ok {
+		return cloudEvent{}, false
+	}
+
+	return cloudEvent{
+		SpecVersion: attrs["specversion"],
+		ID:          attrs["id"],
+		Source:      attrs["source"],
+		Type:        attrs["type"],
+		Time:        attrs["time"],
+	}, true
+}
+
+// decodeBinary decodes the body of a binary mode event with the codec for the
+// delivery's content type, which is the event's datacontenttype.
+func (r *Registry) decodeBinary(event cloudEvent, contentType string, body []byte) (processor.BalanceMessage, error) {
+	if err := event.validate(); err != nil {
+		return processor.BalanceMessage{}, err
+	}
+
+	n, err := r.lookup(contentType)
+	if err != nil {
+		return processor.BalanceMessage{}, err
+	}
+	if n.name == "cloudevents" {
+		return processor.BalanceMessage{}, processor.Invalid(ReasonInvalidCloudEvent, "", "binary mode event with a structured mode content type")
+	}
+
+	msg, err := n.codec.Decode(body)
+	if err != nil {
+		return processor.BalanceMessage{}, err
+	}
+	event.apply(&msg)
+	return msg, nil
+}
+
+// decodeStructured decodes a structured mode event. JSON data is embedded as
+// is; any other data content type travels in data_base64.
+func (r *Registry) decodeStructured(body []byte) (processor.BalanceMessage, error) {
+	var event cloudEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return processor.BalanceMessage{}, processor.Invalid(ReasonInvalidCloudEvent, "", "%v", err)
+	}
+	if err := event.validate(); err != nil {
+		return processor.BalanceMessage{}, err
+	}
+
+	var (
+		msg processor.BalanceMessage
+		err error
+	)
+	switch {
+	case event.DataBase64 != "":
+		data, decodeErr := base64.StdEncoding.DecodeString(event.DataBase64)
+		if decodeErr != nil {
+			return msg, processor.Invalid(ReasonInvalidCloudEvent, "data_base64", "%v", decodeErr)
+		}
+		n, lookupErr := r.lookup(event.DataContentType)
+		if lookupErr != nil {
+			return msg, lookupErr
+		}
+		msg, err = n.codec.Decode(data)
+	case len(bytes.TrimSpace(event.Data)) > 0 && !bytes.Equal(bytes.TrimSpace(event.Data), []byte("null")):
+		if event.DataContentType != "" && !isJSON(event.DataContentType) {
+			return msg, processor.Invalid(ReasonInvalidCloudEvent, "datacontenttype",
+				"%q data must be sent in data_base64", event.DataContentType)
+		}
+		msg, err = processor.ParseMessage(event.Data)
+	default:
+		return msg, processor.Invalid(ReasonInvalidCloudEvent, "data", "event has no data")
+	}
+	if err != nil {
+		return processor.BalanceMessage{}, err
+	}
+
+	event.apply(&msg)
+	return msg, nil
+}
+
+func (e cloudEvent) validate() error {
+	switch {
+	case e.SpecVersion != "1.0":
+		return processor.Invalid(ReasonInvalidCloudEvent, "specversion", "%q is not supported, expected 1.0", e.SpecVersion)
+	case e.ID == "":
+		return processor.Invalid(ReasonInvalidCloudEvent, "id", "is required")
+	case e.Source == "":
+		return processor.Invalid(ReasonInvalidCloudEvent, "source", "is required")
+	case e.Type == "":
+		return processor.Invalid(ReasonInvalidCloudEvent, "type", "is required")
+	}
+	return nil
+}
+
+// apply fills what the data left out from the event attributes: the event
+// id doubles as event_id and the event time as timestamp.
+func (e cloudEvent) apply(msg *processor.BalanceMessage) {
+	if msg.EventID == "" {
+		msg.EventID = e.ID
+	}
+	if msg.GetTimestamp() == "" {
+		msg.Timestamp = e.Time
+	}
+}
+
+func isJSON(mediaType string) bool {
+	mediaType, _, _ = strings.Cut(mediaType, ";")
+	mediaType = strings.TrimSpace(mediaType)
+	return mediaType == MediaTypeJSON || strings.HasSuffix(mediaType, "+json")
+}
+
+// EncodeCloudEvent wraps msg, encoded as JSON, in a structured mode event of
+// the given type from source. The event id and time are taken from msg.
+func EncodeCloudEvent(msg processor.BalanceMessage, source, eventType string) ([]byte, error) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(cloudEvent{
+		SpecVersion:     "1.0",
+		ID:              msg.EventID,
+		Source:          source,
+		Type:            eventType,
+		Time:            msg.GetTimestamp(),
+		DataContentType: MediaTypeJSON,
+		Data:            data,
+	})
+}
@@ -0,0 +1,166 @@
+package codec
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+
+	"balance-service/internal/processor"
+)
+
+const eventData = `{"schema_version":2,"user_id":42,"new_amount":-12.5,"version":7,"currency":"EUR"}`
+
+func binaryHeaders(prefix string) map[string]interface{} {
+	return map[string]interface{}{
+		prefix + "specversion": "1.0",
+		prefix + "id":          "evt-42-7",
+		prefix + "source":      "/laravel/balances",
+		prefix + "type":        "com.example.balance.updated",
+		prefix + "time":        []byte("2026-03-01T12:30:45Z"),
+	}
+}
+
+func TestCloudEventsStructuredMode(t *testing.T) {
+	msg := fullMessage()
+	body, err := EncodeCloudEvent(msg, "/go/loadgen", "com.example.balance.updated")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := NewRegistry().Decode(MediaTypeCloudEventsJSON+"; charset=utf-8", nil, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.UserID != msg.UserID || got.EventID != msg.EventID || got.Timestamp != msg.Timestamp || *got.NewAmount != *msg.NewAmount {
+		t.Errorf("decoded %+v, want %+v", got, msg)
+	}
+
+	// The event id and time fill in what the data leaves out.
+	event := map[string]interface{}{
+		"specversion": "1.0", "id": "evt-42-7", "source": "/laravel", "type": "balance.updated",
+		"time": "2026-03-01T12:30:45Z", "data": json.RawMessage(eventData),
+	}
+	body, _ = json.Marshal(event)
+	got, err = NewRegistry().Decode(MediaTypeCloudEventsJSON, nil, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.EventID != "evt-42-7" || got.Timestamp != "2026-03-01T12:30:45Z" || *got.NewAmount != -12.5 {
+		t.Errorf("decoded %+v, want the event id, time and a negative amount", got)
+	}
+
+	// Other data content types travel in data_base64.
+	proto, err := EncodeProtobuf(fullMessage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	delete(event, "data")
+	event["datacontenttype"] = MediaTypeProtobuf
+	event["data_base64"] = base64.StdEncoding.EncodeToString(proto)
+	body, _ = json.Marshal(event)
+	if got, err = NewRegistry().Decode(MediaTypeCloudEventsJSON, nil, body); err != nil {
+		t.Fatal(err)
+	}
+	if got.TenantID != "acme" {
+		t.Errorf("decoded %+v from data_base64, want the protobuf message", got)
+	}
+}
+
+func TestCloudEventsStructuredModeRejections(t *testing.T) {
+	valid := func() map[string]interface{} {
+		return map[string]interface{}{
+			"specversion": "1.0", "id": "evt-1", "source": "/laravel", "type": "balance.updated",
+			"data": json.RawMessage(eventData),
+		}
+	}
+	for name, c := range map[string]struct {
+		edit  func(map[string]interface{})
+		field string
+	}{
+		"missing id":      {func(e map[string]interface{}) { delete(e, "id") }, "id"},
+		"empty id":        {func(e map[string]interface{}) { e["id"] = "" }, "id"},
+		"missing source":  {func(e map[string]interface{}) { delete(e, "source") }, "source"},
+		"old specversion": {func(e map[string]interface{}) { e["specversion"] = "0.3" }, "specversion"},
+		"no data":         {func(e map[string]interface{}) { delete(e, "data") }, "data"},
+		"null data":       {func(e map[string]interface{}) { e["data"] = nil }, "data"},
+		"binary data":     {func(e map[string]interface{}) { e["datacontenttype"] = MediaTypeProtobuf }, "datacontenttype"},
+		"bad data_base64": {func(e map[string]interface{}) { delete(e, "data"); e["data_base64"] = "%%%" }, "data_base64"},
+	} {
+		event := valid()
+		c.edit(event)
+		body, _ := json.Marshal(event)
+		_, err := NewRegistry().Decode(MediaTypeCloudEventsJSON, nil, body)
+		if r, f := reason(t, err); r != ReasonInvalidCloudEvent || f != c.field {
+			t.Errorf("%s: rejected with %s on %q, want %s on %s", name, r, f, ReasonInvalidCloudEvent, c.field)
+		}
+	}
+}
+
+func TestCloudEventsBinaryMode(t *testing.T) {
+	for _, prefix := range binaryPrefixes {
+		got, err := NewRegistry().Decode(MediaTypeJSON, binaryHeaders(prefix), []byte(eventData))
+		if err != nil {
+			t.Fatalf("prefix %s: %v", prefix, err)
+		}
+		if got.EventID != "evt-42-7" || got.Timestamp != "2026-03-01T12:30:45Z" {
+			t.Errorf("prefix %s: decoded %+v, want the id and time from the headers", prefix, got)
+		}
+	}
+
+	// The body's own event_id wins over the attribute.
+	body := `{"schema_version":2,"user_id":42,"new_amount":1,"version":7,"event_id":"from-body","timestamp":"2026-03-01T00:00:00Z"}`
+	got, err := NewRegistry().Decode(MediaTypeJSON, binaryHeaders("cloudEvents:"), []byte(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.EventID != "from-body" || got.Timestamp != "2026-03-01T00:00:00Z" {
+		t.Errorf("decoded %+v, want the body's event_id and timestamp", got)
+	}
+
+	// The data can be protobuf too.
+	proto, err := EncodeProtobuf(fullMessage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewRegistry().Decode(MediaTypeProtobuf, binaryHeaders("cloudEvents_"), proto); err != nil {
+		t.Errorf("protobuf data: %v", err)
+	}
+}
+
+func TestCloudEventsBinaryModeRejections(t *testing.T) {
+	headers := binaryHeaders("cloudEvents:")
+	delete(headers, "cloudEvents:id")
+	_, err := NewRegistry().Decode(MediaTypeJSON, headers, []byte(eventData))
+	if r, f := reason(t, err); r != ReasonInvalidCloudEvent || f != "id" {
+		t.Errorf("missing id rejected with %s on %q, want %s on id", r, f, ReasonInvalidCloudEvent)
+	}
+
+	_, err = NewRegistry().Decode(MediaTypeCloudEventsJSON, binaryHeaders("cloudEvents:"), []byte(eventData))
+	if r, _ := reason(t, err); r != ReasonInvalidCloudEvent {
+		t.Errorf("structured content type in binary mode rejected with %s, want %s", r, ReasonInvalidCloudEvent)
+	}
+
+	_, err = NewRegistry().Decode("text/plain", binaryHeaders("cloudEvents:"), []byte(eventData))
+	if r, _ := reason(t, err); r != ReasonUnsupportedContentType {
+		t.Errorf("unknown data content type rejected with %s, want %s", r, ReasonUnsupportedContentType)
+	}
+
+	// Without specversion the headers are not a CloudEvent and the body is
+	// plain JSON, which lacks the event_id schema version 2 requires.
+	headers = binaryHeaders("cloudEvents:")
+	delete(headers, "cloudEvents:specversion")
+	_, err = NewRegistry().Decode(MediaTypeJSON, headers, []byte(eventData))
+	if r, f := reason(t, err); r != processor.ReasonMissing {
+		t.Errorf("headers without specversion rejected with %s on %q, want %s", r, f, processor.ReasonMissing)
+	}
+}
+
+func TestBinaryHeaders(t *testing.T) {
+	headers := binaryHeaders("cloudEvents:")
+	headers["x-tenant-id"] = "acme"
+	headers["cloudEvents_extension"] = int32(5)
+
+	got := BinaryHeaders(headers)
+	if len(got) != 5 || got["cloudEvents:time"] != "2026-03-01T12:30:45Z" {
+		t.Errorf("BinaryHeaders = %v, want the five string attributes", got)
+	}
+}
@@ -0,0 +1,130 @@
+// Package codec turns message bodies into processor.BalanceMessage. The
+// codec is chosen by the content type of the delivery, and by its headers for
+// CloudEvents in binary mode, so producers can pick the encoding that suits
+// them without a separate queue.
+package codec
+
+import (
+	"mime"
+	"strings"
+
+	"balance-service/internal/metrics"
+	"balance-service/internal/processor"
+)
+
+// Media types understood by the default registry.
+const (
+	MediaTypeJSON            = "application/json"
+	MediaTypeProtobuf        = "application/x-protobuf"
+	MediaTypeCloudEventsJSON = "application/cloudevents+json"
+)
+
+// Rejection reasons added by the codecs on top of the processor's.
+const (
+	ReasonUnsupportedContentType = "unsupported_content_type"
+	ReasonMalformedProtobuf      = "malformed_protobuf"
+	ReasonInvalidCloudEvent      = "invalid_cloudevent"
+)
+
+var decoded = metrics.NewCounter("balance_messages_decoded_total", "Messages decoded, by codec.", "codec")
+
+// Codec decodes a body into a message. It does not validate the result;
+// Registry.Decode does that once envelope attributes have been applied.
+type Codec interface {
+	Decode(body []byte) (processor.BalanceMessage, error)
+}
+
+// CodecFunc adapts a function to Codec.
+type CodecFunc func(body []byte) (processor.BalanceMessage, error)
+
+func (f CodecFunc) Decode(body []byte) (processor.BalanceMessage, error) {
+	return f(body)
+}
+
+// Registry maps media types to codecs.
+type Registry struct {
+	codecs map[string]named
+}
+
+type named struct {
+	name  string
+	codec Codec
+}
+
+// NewRegistry returns a registry with JSON, protobuf and CloudEvents
+// structured mode registered.
+func NewRegistry() *Registry {
+	r := &Registry{codecs: make(map[string]named)}
+	r.Register("json", CodecFunc(processor.ParseMessage), MediaTypeJSON, "text/json")
+	r.Register("protobuf", CodecFunc(DecodeProtobuf), MediaTypeProtobuf, "application/protobuf", "application/vnd.google.protobuf")
+	r.Register("cloudevents", CodecFunc(r.decodeStructured), MediaTypeCloudEventsJSON)
+	return r
+}
+
+// Register makes c available under each media type; name labels metrics.
+func (r *Registry) Register(name string, c Codec, mediaTypes ...string) {
+	for _, mt := range mediaTypes {
+		r.codecs[mt] = named{name: name, codec: c}
+	}
+}
+
+// Decode decodes and validates a delivery. A missing content type means
+// JSON, which is what producers sent before content types were honoured.
+func (r *Registry) Decode(contentType string, headers map[string]interface{}, body []byte) (processor.BalanceMessage, error) {
+	msg, name, err := r.decode(contentType, headers, body)
+	if err != nil {
+		return processor.BalanceMessage{}, err
+	}
+
+	if err := msg.Validate(); err != nil {
+		return processor.BalanceMessage{}, err
+	}
+	decoded.Inc(name)
+	return msg, nil
+}
+
+// Peek decodes a delivery without validating it, for logging what a
+// rejected message was about. ok is false when the body cannot be decoded.
+func (r *Registry) Peek(contentType string, headers map[string]interface{}, body []byte) (msg processor.BalanceMessage, ok bool) {
+	msg, _, err := r.decode(contentType, headers, body)
+	return msg, err == nil
+}
+
+func (r *Registry) decode(contentType string, headers map[string]interface{}, body []byte) (processor.BalanceMessage, string, error) {
+	var (
+		msg  processor.BalanceMessage
+		name string
+		err  error
+	)
+	if attrs, ok := binaryAttributes(headers); ok {
+		name = "cloudevents_binary"
+		msg, err = r.decodeBinary(attrs, contentType, body)
+	} else {
+		var n named
+		if n, err = r.lookup(contentType); err == nil {
+			name = n.name
+			msg, err = n.codec.Decode(body)
+		}
+	}
+	if err != nil {
+		return processor.BalanceMessage{}, "", err
+	}
+	return msg, name, nil
+}
+
+func (r *Registry) lookup(contentType string) (named, error) {
+	mediaType := MediaTypeJSON
+	if strings.TrimSpace(contentType) != "" {
+		parsed, _, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			return named{}, processor.Invalid(ReasonUnsupportedContentType, "", "content type %q: %v", contentType, err)
+		}
+		mediaType = parsed
+	}
+
+	n, ok := r.codecs[mediaType]
+	if !ok {
+		return named{}, processor.Invalid(ReasonUnsupportedContentType, "", "no codec for content type %q", mediaType)
+	}
+	return n, nil
+}
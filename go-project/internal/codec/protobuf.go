@@ -0,0 +1,218 @@
+package codec
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+	"unicode/utf8"
+
+	"balance-service/internal/processor"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Field numbers of balance.v1.BalanceUpdate, see
+// proto/balance/v1/balance_update.proto.
+const (
+	fieldUserID    protowire.Number = 1
+	fieldNewAmount protowire.Number = 2
+	fieldVersion   protowire.Number = 3
+	fieldTimestamp protowire.Number = 4
+	fieldEventID   protowire.Number = 5
+	fieldCurrency  protowire.Number = 6
+	fieldTenantID  protowire.Number = 7
+
+	// google.protobuf.Timestamp
+	fieldSeconds protowire.Number = 1
+	fieldNanos   protowire.Number = 2
+)
+
+var protoFieldNames = map[protowire.Number]string{
+	fieldUserID:    "user_id",
+	fieldNewAmount: "new_amount",
+	fieldVersion:   "version",
+	fieldTimestamp: "timestamp",
+	fieldEventID:   "event_id",
+	fieldCurrency:  "currency",
+	fieldTenantID:  "tenant_id",
+}
+
+var errWireType = errors.New("unexpected wire type")
+
+// DecodeProtobuf decodes a balance.v1.BalanceUpdate. The result follows the
+// schema version 2 contract. Unknown fields are skipped, as protobuf
+// readers do, so producers can add fields first.
+func DecodeProtobuf(body []byte) (processor.BalanceMessage, error) {
+	msg := processor.BalanceMessage{SchemaVersion: processor.SchemaV2}
+
+	for len(body) > 0 {
+		num, typ, n := protowire.ConsumeTag(body)
+		if n < 0 {
+			return msg, malformed(protowire.ParseError(n))
+		}
+		body = body[n:]
+
+		var err error
+		switch num {
+		case fieldUserID:
+			var v uint64
+			v, n, err = consumeVarint(typ, body)
+			msg.UserID = uint(v)
+		case fieldNewAmount:
+			if typ != protowire.Fixed64Type {
+				err = errWireType
+				break
+			}
+			var bits uint64
+			if bits, n = protowire.ConsumeFixed64(body); n >= 0 {
+				amount := math.Float64frombits(bits)
+				msg.NewAmount = &amount
+			}
+		case fieldVersion:
+			var v uint64
+			v, n, err = consumeVarint(typ, body)
+			msg.Version = uint(v)
+		case fieldTimestamp:
+			var raw []byte
+			if raw, n, err = consumeBytes(typ, body); err == nil && n >= 0 {
+				var ts time.Time
+				if ts, err = decodeTimestamp(raw); err == nil {
+					msg.Timestamp = ts.Format(time.RFC3339Nano)
+				}
+			}
+		case fieldEventID:
+			var raw []byte
+			if raw, n, err = consumeBytes(typ, body); err == nil && n >= 0 {
+				if !utf8.Valid(raw) {
+					err = errors.New("not valid UTF-8")
+				}
+				msg.EventID = string(raw)
+			}
+		case fieldCurrency, fieldTenantID:
+			var raw []byte
+			if raw, n, err = consumeBytes(typ, body); err == nil && n >= 0 {
+				if !utf8.Valid(raw) {
+					err = errors.New("not valid UTF-8")
+				}
+				if num == fieldCurrency {
+					msg.Currency = string(raw)
+				} else {
+					msg.TenantID = string(raw)
+				}
+			}
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, body)
+		}
+
+		if err != nil {
+			return msg, processor.Invalid(processor.ReasonInvalidType, protoFieldNames[num], "%v", err)
+		}
+		if n < 0 {
+			return msg, malformed(protowire.ParseError(n))
+		}
+		body = body[n:]
+	}
+	return msg, nil
+}
+
+// EncodeProtobuf is the inverse of DecodeProtobuf, for Go producers.
+func EncodeProtobuf(msg processor.BalanceMessage) ([]byte, error) {
+	var b []byte
+	b = protowire.AppendTag(b, fieldUserID, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(msg.UserID))
+
+	if msg.NewAmount != nil || msg.Amount != nil {
+		b = protowire.AppendTag(b, fieldNewAmount, protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, math.Float64bits(msg.GetAmount()))
+	}
+
+	b = protowire.AppendTag(b, fieldVersion, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(msg.Version))
+
+	if msg.GetTimestamp() != "" {
+		ts, err := msg.ParseTimestamp()
+		if err != nil {
+			return nil, err
+		}
+		var inner []byte
+		inner = protowire.AppendTag(inner, fieldSeconds, protowire.VarintType)
+		inner = protowire.AppendVarint(inner, uint64(ts.Unix()))
+		if nanos := ts.Nanosecond(); nanos != 0 {
+			inner = protowire.AppendTag(inner, fieldNanos, protowire.VarintType)
+			inner = protowire.AppendVarint(inner, uint64(nanos))
+		}
+		b = protowire.AppendTag(b, fieldTimestamp, protowire.BytesType)
+		b = protowire.AppendBytes(b, inner)
+	}
+
+	if msg.EventID != "" {
+		b = protowire.AppendTag(b, fieldEventID, protowire.BytesType)
+		b = protowire.AppendString(b, msg.EventID)
+	}
+
+	if msg.Currency != "" {
+		b = protowire.AppendTag(b, fieldCurrency, protowire.BytesType)
+		b = protowire.AppendString(b, msg.Currency)
+	}
+
+	if msg.TenantID != "" {
+		b = protowire.AppendTag(b, fieldTenantID, protowire.BytesType)
+		b = protowire.AppendString(b, msg.TenantID)
+	}
+	return b, nil
+}
+
+func decodeTimestamp(b []byte) (time.Time, error) {
+	var seconds, nanos int64
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return time.Time{}, protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		var v uint64
+		var err error
+		switch num {
+		case fieldSeconds:
+			v, n, err = consumeVarint(typ, b)
+			seconds = int64(v)
+		case fieldNanos:
+			v, n, err = consumeVarint(typ, b)
+			nanos = int64(int32(v))
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if err != nil {
+			return time.Time{}, err
+		}
+		if n < 0 {
+			return time.Time{}, protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	if nanos < 0 || nanos >= int64(time.Second) {
+		return time.Time{}, fmt.Errorf("nanos %d out of range", nanos)
+	}
+	return time.Unix(seconds, nanos).UTC(), nil
+}
+
+func consumeVarint(typ protowire.Type, b []byte) (uint64, int, error) {
+	if typ != protowire.VarintType {
+		return 0, 0, errWireType
+	}
+	v, n := protowire.ConsumeVarint(b)
+	return v, n, nil
+}
+
+func consumeBytes(typ protowire.Type, b []byte) ([]byte, int, error) {
+	if typ != protowire.BytesType {
+		return nil, 0, errWireType
+	}
+	v, n := protowire.ConsumeBytes(b)
+	return v, n, nil
+}
+
+func malformed(err error) error {
+	return processor.Invalid(ReasonMalformedProtobuf, "", "%v", err)
+}
@@ -0,0 +1,179 @@
+package codec
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"balance-service/internal/processor"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func amount(v float64) *float64 { return &v }
+
+func fullMessage() processor.BalanceMessage {
+	return processor.BalanceMessage{
+		SchemaVersion: processor.SchemaV2,
+		UserID:        42,
+		NewAmount:     amount(1234.56),
+		Version:       7,
+		Timestamp:     "2026-03-01T12:30:45.123456789Z",
+		EventID:       "evt-42-7",
+		Currency:      "EUR",
+		TenantID:      "acme",
+	}
+}
+
+// reason returns the rejection reason and field of err, or fails the test.
+func reason(t *testing.T, err error) (string, string) {
+	t.Helper()
+	var invalid *processor.ValidationError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("error %v is not a *processor.ValidationError", err)
+	}
+	return invalid.Reason, invalid.Field
+}
+
+func TestProtobufRoundTrip(t *testing.T) {
+	want := fullMessage()
+	body, err := EncodeProtobuf(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := DecodeProtobuf(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.SchemaVersion != want.SchemaVersion || got.UserID != want.UserID || got.Version != want.Version ||
+		got.Timestamp != want.Timestamp || got.EventID != want.EventID || got.Currency != want.Currency ||
+		got.TenantID != want.TenantID {
+		t.Errorf("decoded %+v, want %+v", got, want)
+	}
+	if got.NewAmount == nil || *got.NewAmount != *want.NewAmount {
+		t.Errorf("decoded amount %v, want %v", got.NewAmount, *want.NewAmount)
+	}
+	if err := got.Validate(); err != nil {
+		t.Errorf("round-tripped message does not validate: %v", err)
+	}
+}
+
+func TestProtobufAmounts(t *testing.T) {
+	for _, v := range []float64{0, -0.01, -250.75, 1e12} {
+		msg := fullMessage()
+		msg.NewAmount = amount(v)
+		body, err := EncodeProtobuf(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := NewRegistry().Decode(MediaTypeProtobuf, nil, body)
+		if err != nil {
+			t.Errorf("amount %v: %v", v, err)
+			continue
+		}
+		// An explicit zero is an amount, not a missing field.
+		if got.NewAmount == nil || *got.NewAmount != v {
+			t.Errorf("amount %v decoded as %v", v, got.NewAmount)
+		}
+	}
+
+	// Without the field the amount is missing.
+	msg := fullMessage()
+	msg.NewAmount = nil
+	body, err := EncodeProtobuf(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewRegistry().Decode(MediaTypeProtobuf, nil, body)
+	if r, f := reason(t, err); r != processor.ReasonMissing || f != "new_amount" {
+		t.Errorf("missing amount rejected with %s on %q, want %s on new_amount", r, f, processor.ReasonMissing)
+	}
+
+	msg.NewAmount = amount(math.NaN())
+	if body, err = EncodeProtobuf(msg); err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewRegistry().Decode(MediaTypeProtobuf, nil, body)
+	if r, _ := reason(t, err); r != processor.ReasonInvalid {
+		t.Errorf("NaN amount rejected with %s, want %s", r, processor.ReasonInvalid)
+	}
+}
+
+func TestProtobufSkipsUnknownFields(t *testing.T) {
+	body, err := EncodeProtobuf(fullMessage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Fields a newer producer might add, of every wire type.
+	body = protowire.AppendTag(body, 20, protowire.VarintType)
+	body = protowire.AppendVarint(body, 99)
+	body = protowire.AppendTag(body, 21, protowire.BytesType)
+	body = protowire.AppendString(body, "new field")
+	body = protowire.AppendTag(body, 22, protowire.Fixed32Type)
+	body = protowire.AppendFixed32(body, 1)
+	body = protowire.AppendTag(body, 23, protowire.Fixed64Type)
+	body = protowire.AppendFixed64(body, 1)
+
+	got, err := DecodeProtobuf(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.UserID != 42 || got.EventID != "evt-42-7" {
+		t.Errorf("decoded %+v, want the known fields", got)
+	}
+}
+
+func TestProtobufRejectsWrongWireType(t *testing.T) {
+	for name, c := range map[string]struct {
+		body  []byte
+		field string
+	}{
+		"user_id as bytes": {
+			body:  protowire.AppendString(protowire.AppendTag(nil, fieldUserID, protowire.BytesType), "42"),
+			field: "user_id",
+		},
+		"new_amount as varint": {
+			body:  protowire.AppendVarint(protowire.AppendTag(nil, fieldNewAmount, protowire.VarintType), 10),
+			field: "new_amount",
+		},
+		"event_id as fixed32": {
+			body:  protowire.AppendFixed32(protowire.AppendTag(nil, fieldEventID, protowire.Fixed32Type), 1),
+			field: "event_id",
+		},
+	} {
+		_, err := DecodeProtobuf(c.body)
+		if r, f := reason(t, err); r != processor.ReasonInvalidType || f != c.field {
+			t.Errorf("%s: rejected with %s on %q, want %s on %s", name, r, f, processor.ReasonInvalidType, c.field)
+		}
+	}
+}
+
+func TestProtobufRejectsTruncatedInput(t *testing.T) {
+	full, err := EncodeProtobuf(fullMessage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, body := range map[string][]byte{
+		// A varint whose continuation bit promises another byte.
+		"truncated tag":    {0x80},
+		"truncated varint": append(protowire.AppendTag(nil, fieldVersion, protowire.VarintType), 0xff, 0xff),
+		"varint too long":  append(protowire.AppendTag(nil, fieldVersion, protowire.VarintType), 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01),
+		// A length prefix longer than what follows.
+		"truncated length":  append(protowire.AppendTag(nil, fieldEventID, protowire.BytesType), 10, 'e', 'v'),
+		"truncated fixed64": append(protowire.AppendTag(nil, fieldNewAmount, protowire.Fixed64Type), 1, 2, 3),
+		"truncated message": full[:len(full)-1],
+	} {
+		_, err := DecodeProtobuf(body)
+		if r, _ := reason(t, err); r != ReasonMalformedProtobuf {
+			t.Errorf("%s: rejected with %s, want %s", name, r, ReasonMalformedProtobuf)
+		}
+	}
+}
+
+func TestProtobufRejectsInvalidStrings(t *testing.T) {
+	body := protowire.AppendBytes(protowire.AppendTag(nil, fieldCurrency, protowire.BytesType), []byte{0xff, 0xfe})
+	_, err := DecodeProtobuf(body)
+	if r, f := reason(t, err); r != processor.ReasonInvalidType || f != "currency" {
+		t.Errorf("invalid UTF-8 rejected with %s on %q, want %s on currency", r, f, processor.ReasonInvalidType)
+	}
+}
@@ -1,108 +1,548 @@
 package config
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"os"
-	"strconv"
+	"path/filepath"
 	"time"
+
+	"balance-service/internal/secrets"
+	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
-	Database DatabaseConfig
-	Rabbit   RabbitConfig
-	Batch    BatchConfig
-	Sync     SyncConfig
+	Database DatabaseConfig `yaml:"database"`
+	// Upstream is the Laravel database, the source of truth the reconcile
+	// job compares against. An empty driver leaves it unconfigured.
+	Upstream  DatabaseConfig  `yaml:"upstream"`
+	Rabbit    RabbitConfig    `yaml:"rabbitmq"`
+	Batch     BatchConfig     `yaml:"batch"`
+	Sync      SyncConfig      `yaml:"sync"`
+	Reconcile ReconcileConfig `yaml:"reconcile"`
+	Ledger    LedgerConfig    `yaml:"ledger"`
+	Retention RetentionConfig `yaml:"retention"`
+	Snapshots SnapshotConfig  `yaml:"snapshots"`
+	Webhooks  WebhookConfig   `yaml:"webhooks"`
+	Alerts    AlertConfig     `yaml:"alerts"`
+	Currency  CurrencyConfig  `yaml:"currency"`
+	// Tenants are the brands sharing the deployment. Messages of tenants
+	// not listed here are dead-lettered.
+	Tenants []TenantConfig `yaml:"tenants"`
+	// NegativeBalance decides what happens to updates with a negative
+	// amount.
+	NegativeBalance NegativeBalanceConfig `yaml:"negative_balance"`
+	Breaker         BreakerConfig         `yaml:"breaker"`
+	Admin           AdminConfig           `yaml:"admin"`
+	Log             LogConfig             `yaml:"log"`
+	Shutdown        ShutdownConfig        `yaml:"shutdown"`
 }
 
 type DatabaseConfig struct {
-    Host     string
-    Port     int
-    User     string
-    Password string
-    DBName   string
+	Driver   string `yaml:"driver"`
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password" secret:"true"`
+	// PasswordRef points at the password instead, e.g. "file:/run/secrets/db".
+	// It wins over Password and is re-read whenever a connection is opened.
+	PasswordRef string    `yaml:"password_ref"`
+	DBName      string    `yaml:"name"`
+	Path        string    `yaml:"path"` // sqlite database file, ":memory:" for an in-process database
+	TLS         TLSConfig `yaml:"tls"`
+	// AutoMigrate lets serve create and update the schema on startup;
+	// disable it to run the migrate command as a separate deploy step.
+	AutoMigrate bool `yaml:"auto_migrate"`
+	// ReadOnly makes every session read-only, so a misconfigured job cannot
+	// write to a database it only reads.
+	ReadOnly bool `yaml:"read_only"`
+}
+
+// Configured reports whether a driver was chosen, for optional databases.
+func (d DatabaseConfig) Configured() bool {
+	return d.Driver != ""
 }
 
 type RabbitConfig struct {
-	Host     string
-	Port     int
-	User     string
-	Password string
-	VHost    string
-	Queue    string
-	Prefetch int
-	Workers  int
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password" secret:"true"`
+	// PasswordRef is re-read on every reconnect so rotated credentials are
+	// picked up without a restart.
+	PasswordRef string `yaml:"password_ref"`
+	VHost       string `yaml:"vhost"`
+	// Exchange is where producers publish; the queue is bound to it with
+	// its own name as the routing key.
+	Exchange string `yaml:"exchange"`
+	Queue    string `yaml:"queue"`
+	// DeadLetterQueue receives messages that fail schema validation, with
+	// the reason in their headers.
+	DeadLetterQueue string `yaml:"dead_letter_queue"`
+	Prefetch        int    `yaml:"prefetch"`
+	Workers         int    `yaml:"workers"`
+	ConsumerTag     string `yaml:"consumer_tag"`
+
+	TLS TLSConfig `yaml:"tls"`
+	// AuthMechanism is "plain" (user and password) or "external", which
+	// authenticates with the TLS client certificate.
+	AuthMechanism string `yaml:"auth_mechanism"`
+
+	Reconnect ReconnectConfig `yaml:"reconnect"`
+	Signing   SigningConfig   `yaml:"signing"`
+	Resync    ResyncConfig    `yaml:"resync"`
+}
+
+// ResyncConfig controls the requests sent back to the producer when the
+// processor notices versions that never arrived.
+type ResyncConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Queue   string `yaml:"queue"` // the producer consumes requests from here
+}
+
+// SigningConfig controls HMAC verification of incoming messages.
+type SigningConfig struct {
+	// Mode is "off", "report" (count and log bad signatures but process the
+	// message anyway, for rollouts) or "enforce" (quarantine them).
+	Mode string `yaml:"mode"`
+	// Keys maps key IDs to shared secrets. Every listed key is accepted, which
+	// lets producers rotate to a new key without a flag day.
+	Keys map[string]string `yaml:"keys" secret:"true"`
+	// KeysRef references the keys instead, one "id=secret" per line.
+	KeysRef string `yaml:"keys_ref"`
+	// QuarantineQueue receives unsigned and badly signed messages.
+	QuarantineQueue string `yaml:"quarantine_queue"`
+}
+
+// TLSConfig enables encryption in transit. CAFile verifies the server,
+// CertFile and KeyFile are the client certificate for mutual TLS.
+type TLSConfig struct {
+	Enabled            bool   `yaml:"enabled"`
+	CAFile             string `yaml:"ca_file"`
+	CertFile           string `yaml:"cert_file"`
+	KeyFile            string `yaml:"key_file"`
+	ServerName         string `yaml:"server_name"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+}
+
+// ReconnectConfig is the policy of the consumer's connection supervisor.
+type ReconnectConfig struct {
+	InitialBackoff time.Duration `yaml:"initial_backoff"`
+	MaxBackoff     time.Duration `yaml:"max_backoff"`
+	// MaxDowntime is how long the broker may stay unreachable before it is
+	// reported loudly; zero disables the limit. With ExitOnMaxDowntime the
+	// process exits instead, leaving the restart to the orchestrator.
+	MaxDowntime       time.Duration `yaml:"max_downtime"`
+	ExitOnMaxDowntime bool          `yaml:"exit_on_max_downtime"`
 }
 
 type BatchConfig struct {
-	Size     int
-	Interval time.Duration
+	Size     int           `yaml:"size"`
+	Interval time.Duration `yaml:"interval"`
 }
 
 type SyncConfig struct {
-	Interval  time.Duration
-	BatchSize int
+	Interval  time.Duration `yaml:"interval"`
+	BatchSize int           `yaml:"batch_size"`
 }
 
-func Load() *Config {
-// 	dbPort := intFromEnv("DB_PORT", 5432)
-	rmqPort := intFromEnv("RABBITMQ_PORT", 5672)
+// WebhookConfig controls the HTTP notifications about balance changes. The
+// subscriptions themselves live in the database.
+type WebhookConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// QueueSize bounds the deliveries waiting per endpoint; an endpoint
+	// that falls further behind loses the newest ones.
+	QueueSize      int           `yaml:"queue_size"`
+	MaxAttempts    int           `yaml:"max_attempts"`
+	InitialBackoff time.Duration `yaml:"initial_backoff"`
+	MaxBackoff     time.Duration `yaml:"max_backoff"`
+	Timeout        time.Duration `yaml:"timeout"`
+	// RefreshInterval is how often subscription changes are picked up.
+	RefreshInterval time.Duration `yaml:"refresh_interval"`
+}
 
+// AlertConfig holds the rules checked against every committed balance
+// change and the sink their hits are sent to.
+type AlertConfig struct {
+	Enabled bool        `yaml:"enabled"`
+	Rules   []AlertRule `yaml:"rules"`
+	// DedupWindow silences repeats of a rule for the same user; a rule may
+	// set its own.
+	DedupWindow time.Duration   `yaml:"dedup_window"`
+	Sink        AlertSinkConfig `yaml:"sink"`
+}
+
+// AlertRule is one check. Kind is "balance_below" (the amount dropped below
+// Threshold), "change_above" (it moved by more than Threshold),
+// "rate_above" (more than Threshold updates in a minute) or
+// "negative_amount".
+type AlertRule struct {
+	Name        string        `yaml:"name"` // defaults to the kind
+	Kind        string        `yaml:"kind"`
+	Threshold   float64       `yaml:"threshold"`
+	DedupWindow time.Duration `yaml:"dedup_window"`
+}
+
+// AlertSinkConfig says where alerts go besides the alerts table: "log",
+// "webhook" (a signed POST to URL) or "amqp" (Exchange on the configured
+// broker).
+type AlertSinkConfig struct {
+	Type       string        `yaml:"type"`
+	URL        string        `yaml:"url"`
+	Secret     string        `yaml:"secret" secret:"true"`
+	SecretRef  string        `yaml:"secret_ref"`
+	Timeout    time.Duration `yaml:"timeout"`
+	Exchange   string        `yaml:"exchange"`
+	RoutingKey string        `yaml:"routing_key"`
+}
+
+// NegativeBalanceConfig is the policy for updates with a negative amount.
+// Mode is "allow" (apply as is), "reject" (dead-letter), "clamp" (apply
+// zero instead) or "hold" (park for approval through the admin API).
+// Segments override the mode for some users; the first match wins.
+type NegativeBalanceConfig struct {
+	Mode     string            `yaml:"mode"`
+	Segments []NegativeSegment `yaml:"segments"`
+}
+
+// NegativeSegment selects users by ID, by an inclusive ID range, or both.
+type NegativeSegment struct {
+	Name      string `yaml:"name"`
+	Mode      string `yaml:"mode"`
+	UserIDs   []uint `yaml:"user_ids"`
+	MinUserID uint   `yaml:"min_user_id"`
+	MaxUserID uint   `yaml:"max_user_id"` // zero leaves the range open
+}
+
+// CurrencyConfig sets up the per-currency wallets. Default is the currency
+// of messages that carry none, of the balances migrated from the
+// single-balance schema and of the upstream Laravel table, which has no
+// currency column.
+type CurrencyConfig struct {
+	Default string `yaml:"default"`
+}
+
+// TenantConfig is one tenant. Messages name their tenant in tenant_id or the
+// x-tenant-id header, or arrive on the tenant's own queue; messages without
+// one belong to the default tenant, which has no limits.
+type TenantConfig struct {
+	ID string `yaml:"id"`
+	// Queue is consumed besides rabbitmq.queue and bound to the exchange
+	// under its own name. Every message in it belongs to the tenant.
+	Queue string `yaml:"queue"`
+	// MaxUsers caps the users with a balance; messages for further users
+	// are dead-lettered. Zero means no limit.
+	MaxUsers int `yaml:"max_users"`
+	// MaxMessagesPerSecond throttles the tenant's messages in the
+	// consumer. Zero means no limit.
+	MaxMessagesPerSecond float64 `yaml:"max_messages_per_second"`
+}
+
+// ReconcileConfig schedules the comparison with the upstream balances.
+type ReconcileConfig struct {
+	Interval  time.Duration `yaml:"interval"` // zero runs it only on demand
+	BatchSize int           `yaml:"batch_size"`
+	// Repair writes correction events for the differences it can fix
+	// instead of only reporting them.
+	Repair bool `yaml:"repair"`
+}
+
+// LedgerConfig schedules the check of the ledger invariants.
+type LedgerConfig struct {
+	CheckInterval time.Duration `yaml:"check_interval"` // zero runs it only on demand
+}
+
+// RetentionConfig archives the balance events older than Days and deletes
+// them from balance_events.
+type RetentionConfig struct {
+	Days      int           `yaml:"days"`     // zero keeps events forever
+	Interval  time.Duration `yaml:"interval"` // zero runs it only on demand
+	BatchSize int           `yaml:"batch_size"`
+	// FileEvents caps the events in one archive file.
+	FileEvents int `yaml:"file_events"`
+	// Format of the archive files. Only "jsonl", gzip-compressed JSON
+	// lines, is supported.
+	Format string `yaml:"format"`
+	// PartitionByMonth keeps balance_events in monthly MySQL partitions.
+	// The job adds them ahead of time and drops archived months whole
+	// instead of deleting their rows.
+	PartitionByMonth bool          `yaml:"partition_by_month"`
+	Archive          ArchiveConfig `yaml:"archive"`
+	// ClaimDays is how long the claims that deduplicate redelivered
+	// event IDs are kept; zero keeps them forever. It must be longer than
+	// the broker may redeliver a message, outages included.
+	ClaimDays int `yaml:"claim_days"`
+}
+
+// ArchiveConfig says where archive files go: "dir" (a local directory,
+// e.g. a mounted volume) or "s3" (a bucket of an S3-compatible store,
+// addressed path-style).
+type ArchiveConfig struct {
+	Type               string `yaml:"type"`
+	Dir                string `yaml:"dir"`
+	Endpoint           string `yaml:"endpoint"`
+	Region             string `yaml:"region"`
+	Bucket             string `yaml:"bucket"`
+	Prefix             string `yaml:"prefix"`
+	AccessKeyID        string `yaml:"access_key_id"`
+	SecretAccessKey    string `yaml:"secret_access_key" secret:"true"`
+	SecretAccessKeyRef string `yaml:"secret_access_key_ref"`
+}
+
+// SnapshotConfig schedules the end-of-day balance snapshots that
+// statements start and end with.
+type SnapshotConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Cutoff is the time of day, HH:MM in Timezone, at which a day closes
+	// and its balances are taken.
+	Cutoff    string `yaml:"cutoff"`
+	Timezone  string `yaml:"timezone"`
+	BatchSize int    `yaml:"batch_size"`
+}
+
+type BreakerConfig struct {
+	Threshold     int           `yaml:"threshold"`
+	ProbeInterval time.Duration `yaml:"probe_interval"`
+	ProbeMax      time.Duration `yaml:"probe_max"`
+}
+
+type AdminConfig struct {
+	Addr     string `yaml:"addr"`                // empty disables the admin server
+	Token    string `yaml:"token" secret:"true"` // bearer token for mutating endpoints
+	TokenRef string `yaml:"token_ref"`
+	// TenantTokens maps tenant IDs to bearer tokens that only see the
+	// balances and held updates of their tenant.
+	TenantTokens map[string]string `yaml:"tenant_tokens" secret:"true"`
+	// TenantTokensRef references the tenant tokens instead, one
+	// "tenant=token" per line.
+	TenantTokensRef string `yaml:"tenant_tokens_ref"`
+}
+
+type ShutdownConfig struct {
+	// Timeout bounds the whole drain: cancelling the subscription, flushing
+	// the last batches and acking them before the channel is closed.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+type LogConfig struct {
+	Level string `yaml:"level"`
+}
+
+// Defaults returns the configuration used when neither a config file nor
+// environment variables say otherwise.
+func Defaults() *Config {
 	return &Config{
 		Database: DatabaseConfig{
-			Host:     getenv("DB_HOST", "mysql-go"),
-			Port:     intFromEnv("DB_PORT", 3306),
-			User:     getenv("DB_USER", getenv("DB_USERNAME", "go")),
-			Password: getenv("DB_PASSWORD", "go"),
-			DBName:   getenv("DB_NAME", getenv("DB_DATABASE", "go_db")),
+			Driver:   "mysql",
+			Host:     "mysql-go",
+			Port:     3306,
+			User:     "go",
+			Password: "go",
+			DBName:   "go_db",
+			Path:     "balance.db",
+
+			AutoMigrate: true,
+		},
+		Upstream: DatabaseConfig{
+			Port:     3306,
+			ReadOnly: true,
 		},
 		Rabbit: RabbitConfig{
-			Host:     getenv("RABBITMQ_HOST", "localhost"),
-			Port:     rmqPort,
-			User:     getenv("RABBITMQ_USER", "guest"),
-			Password: getenv("RABBITMQ_PASSWORD", "guest"),
-			VHost:    getenv("RABBITMQ_VHOST", "/"),
-			Queue:    getenv("RABBITMQ_QUEUE", "balance_updates"),
-			Prefetch: intFromEnv("RABBITMQ_PREFETCH", 50),
-			Workers:  clamp(intFromEnv("RABBITMQ_WORKERS", 5), 5, 10),
+			Host:            "localhost",
+			Port:            5672,
+			User:            "guest",
+			Password:        "guest",
+			VHost:           "/",
+			Exchange:        "balance_exchange",
+			Queue:           "balance_updates",
+			DeadLetterQueue: "balance_updates.dead_letter",
+			Prefetch:        50,
+			Workers:         5,
+			ConsumerTag:     defaultConsumerTag(),
+
+			AuthMechanism: "plain",
+			Reconnect: ReconnectConfig{
+				InitialBackoff: time.Second,
+				MaxBackoff:     time.Minute,
+			},
+			Signing: SigningConfig{
+				Mode:            "off",
+				QuarantineQueue: "balance_updates.quarantine",
+			},
+			Resync: ResyncConfig{
+				Queue: "balance_resync_requests",
+			},
 		},
 		Batch: BatchConfig{
-			Size:     intFromEnv("BATCH_SIZE", 100),
-			Interval: time.Duration(intFromEnv("BATCH_INTERVAL_SECONDS", 5)) * time.Second,
+			Size:     100,
+			Interval: 5 * time.Second,
 		},
 		Sync: SyncConfig{
-			Interval:  time.Duration(intFromEnv("SYNC_INTERVAL_SECONDS", 30)) * time.Second,
-			BatchSize: intFromEnv("SYNC_BATCH_SIZE", 1000),
+			Interval:  30 * time.Second,
+			BatchSize: 1000,
+		},
+		Reconcile: ReconcileConfig{
+			BatchSize: 1000,
+		},
+		Retention: RetentionConfig{
+			BatchSize:  1000,
+			FileEvents: 100000,
+			Format:     "jsonl",
+			ClaimDays:  30,
+			Archive: ArchiveConfig{
+				Type:   "dir",
+				Dir:    "archive",
+				Region: "us-east-1",
+			},
+		},
+		Snapshots: SnapshotConfig{
+			Cutoff:    "00:00",
+			Timezone:  "UTC",
+			BatchSize: 1000,
+		},
+		Webhooks: WebhookConfig{
+			QueueSize:       1000,
+			MaxAttempts:     8,
+			InitialBackoff:  time.Second,
+			MaxBackoff:      5 * time.Minute,
+			Timeout:         10 * time.Second,
+			RefreshInterval: 30 * time.Second,
+		},
+		Alerts: AlertConfig{
+			DedupWindow: 15 * time.Minute,
+			Sink: AlertSinkConfig{
+				Type:       "log",
+				Timeout:    10 * time.Second,
+				Exchange:   "balance_alerts",
+				RoutingKey: "balance.alert",
+			},
+		},
+		Currency: CurrencyConfig{
+			Default: "USD",
+		},
+		NegativeBalance: NegativeBalanceConfig{
+			Mode: "allow",
+		},
+		Breaker: BreakerConfig{
+			Threshold:     5,
+			ProbeInterval: time.Second,
+			ProbeMax:      30 * time.Second,
+		},
+		Admin: AdminConfig{
+			Addr: ":8080",
+		},
+		Log: LogConfig{
+			Level: "info",
+		},
+		Shutdown: ShutdownConfig{
+			Timeout: 30 * time.Second,
 		},
 	}
 }
 
-func getenv(key, def string) string {
-	if val := os.Getenv(key); val != "" {
-		return val
+// Load builds the effective configuration: defaults, then the YAML file at
+// path (if any), then environment variables, and finally secrets given by
+// reference. Values that fail to parse, resolve or validate are reported
+// together instead of being replaced by defaults.
+func Load(path string) (*Config, error) {
+	cfg := Defaults()
+
+	if path == "" {
+		path = os.Getenv("CONFIG_FILE")
+	}
+	if path != "" {
+		if err := loadFile(path, cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := errors.Join(applyEnv(cfg), resolveSecrets(cfg), cfg.Validate()); err != nil {
+		return nil, err
 	}
 
-	return def
+	return cfg, nil
 }
 
-func intFromEnv(key string, def int) int {
-	val := getenv(key, "")
-	if val == "" {
-		return def
-	}
+// resolveSecrets replaces every secret that is given by reference with its
+// current value, so a missing or unreadable secret fails at startup.
+func resolveSecrets(cfg *Config) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 
-	if parsed, err := strconv.Atoi(val); err == nil {
-		return parsed
+	var errs []error
+	for _, s := range []struct {
+		ref string
+		dst *string
+	}{
+		{cfg.Database.PasswordRef, &cfg.Database.Password},
+		{cfg.Upstream.PasswordRef, &cfg.Upstream.Password},
+		{cfg.Rabbit.PasswordRef, &cfg.Rabbit.Password},
+		{cfg.Admin.TokenRef, &cfg.Admin.Token},
+		{cfg.Alerts.Sink.SecretRef, &cfg.Alerts.Sink.Secret},
+		{cfg.Retention.Archive.SecretAccessKeyRef, &cfg.Retention.Archive.SecretAccessKey},
+	} {
+		if s.ref == "" {
+			continue
+		}
+		value, err := secrets.Resolve(ctx, s.ref)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		*s.dst = value
 	}
 
-	return def
+	if ref := cfg.Admin.TenantTokensRef; ref != "" {
+		value, err := secrets.Resolve(ctx, ref)
+		if err == nil {
+			cfg.Admin.TenantTokens, err = parseKeys(value)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("admin.tenant_tokens_ref: %w", err))
+		}
+	}
+	if ref := cfg.Rabbit.Signing.KeysRef; ref != "" {
+		value, err := secrets.Resolve(ctx, ref)
+		if err == nil {
+			cfg.Rabbit.Signing.Keys, err = parseKeys(value)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("rabbitmq.signing.keys_ref: %w", err))
+		}
+	}
+	return errors.Join(errs...)
 }
 
-func clamp(value, min, max int) int {
-	if value < min {
-		return min
+func loadFile(path string, cfg *Config) error {
+	switch ext := filepath.Ext(path); ext {
+	case ".yaml", ".yml":
+	default:
+		return fmt.Errorf("config file %s: unsupported format %q, expected .yaml or .yml", path, ext)
 	}
-	if value > max {
-		return max
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
 	}
-	return value
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	return nil
 }
 
+// defaultConsumerTag makes the consumer recognisable in the RabbitMQ UI and
+// lets us cancel exactly our own subscription.
+func defaultConsumerTag() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("balance-service-%s-%d", host, os.Getpid())
+}
@@ -0,0 +1,106 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadAppliesFileThenEnvironment(t *testing.T) {
+	path := writeFile(t, "config.yaml", `
+database:
+  driver: sqlite
+  path: /var/lib/balance.db
+rabbitmq:
+  prefetch: 50
+log:
+  level: debug
+`)
+	t.Setenv("RABBITMQ_PREFETCH", "75")
+	t.Setenv("DB_PASSWORD_FILE", writeFile(t, "db-password", "s3cret\n"))
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Database.Driver != "sqlite" || cfg.Database.Path != "/var/lib/balance.db" {
+		t.Errorf("database: %s %s, want the file's sqlite settings", cfg.Database.Driver, cfg.Database.Path)
+	}
+	if cfg.Rabbit.Prefetch != 75 {
+		t.Errorf("prefetch %d, want 75 from the environment", cfg.Rabbit.Prefetch)
+	}
+	if cfg.Log.Level != "debug" {
+		t.Errorf("log level %q, want debug", cfg.Log.Level)
+	}
+	if cfg.Database.Password != "s3cret" {
+		t.Errorf("password %q, want the content of DB_PASSWORD_FILE", cfg.Database.Password)
+	}
+	if cfg.Batch.Size != Defaults().Batch.Size {
+		t.Errorf("batch size %d, want the default", cfg.Batch.Size)
+	}
+}
+
+func TestLoadRejectsUnknownKeys(t *testing.T) {
+	path := writeFile(t, "config.yaml", "rabbitmq:\n  prefetchh: 50\n")
+	if _, err := Load(path); err == nil || !strings.Contains(err.Error(), "prefetchh") {
+		t.Errorf("Load: %v, want an error naming the unknown key", err)
+	}
+}
+
+func TestLoadReportsEveryInvalidValue(t *testing.T) {
+	t.Setenv("RABBITMQ_PREFETCH", "many")
+	t.Setenv("SNAPSHOT_CUTOFF", "25:00")
+	t.Setenv("LOG_LEVEL", "chatty")
+
+	_, err := Load(writeFile(t, "config.yaml", ""))
+	if err == nil {
+		t.Fatal("Load succeeded, want errors")
+	}
+	for _, want := range []string{"RABBITMQ_PREFETCH", "snapshots.cutoff", "log.level"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error does not mention %s:\n%v", want, err)
+		}
+	}
+}
+
+func TestPrintRedactsSecrets(t *testing.T) {
+	cfg := Defaults()
+	cfg.Database.Password = "s3cret"
+	cfg.Admin.Token = "t0ken"
+
+	var out bytes.Buffer
+	if err := cfg.Print(&out); err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"s3cret", "t0ken"} {
+		if strings.Contains(out.String(), secret) {
+			t.Errorf("printed configuration contains %q", secret)
+		}
+	}
+	if !strings.Contains(out.String(), "password: '"+redacted+"'") {
+		t.Errorf("printed configuration does not redact the password:\n%s", out.String())
+	}
+}
+
+func TestDiffIgnoresSecretValues(t *testing.T) {
+	a, b := Defaults(), Defaults()
+	b.Database.Password = "rotated"
+	b.Batch.Size = a.Batch.Size + 1
+	b.Rabbit.PasswordRef = "file:/run/secrets/rabbitmq"
+
+	got := strings.Join(Diff(a, b), ",")
+	if want := "rabbitmq.password_ref,batch.size"; got != want {
+		t.Errorf("Diff = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,279 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"balance-service/internal/secrets"
+)
+
+// envBinding overrides one config field from the first non-empty variable in
+// keys. Later keys are accepted as aliases.
+type envBinding struct {
+	keys  []string
+	apply func(val string) error
+}
+
+func envBindings(cfg *Config) []envBinding {
+	return []envBinding{
+		str(&cfg.Database.Driver, "DB_DRIVER"),
+		str(&cfg.Database.Host, "DB_HOST"),
+		integer(&cfg.Database.Port, "DB_PORT"),
+		str(&cfg.Database.User, "DB_USER", "DB_USERNAME"),
+		str(&cfg.Database.Password, "DB_PASSWORD"),
+		fileRef(&cfg.Database.PasswordRef, "DB_PASSWORD_FILE"),
+		str(&cfg.Database.DBName, "DB_NAME", "DB_DATABASE"),
+		str(&cfg.Database.Path, "DB_SQLITE_PATH"),
+		boolean(&cfg.Database.AutoMigrate, "DB_AUTO_MIGRATE"),
+		boolean(&cfg.Database.TLS.Enabled, "DB_TLS"),
+		str(&cfg.Database.TLS.CAFile, "DB_TLS_CA_FILE"),
+		str(&cfg.Database.TLS.CertFile, "DB_TLS_CERT_FILE"),
+		str(&cfg.Database.TLS.KeyFile, "DB_TLS_KEY_FILE"),
+		str(&cfg.Database.TLS.ServerName, "DB_TLS_SERVER_NAME"),
+		boolean(&cfg.Database.TLS.InsecureSkipVerify, "DB_TLS_INSECURE_SKIP_VERIFY"),
+
+		str(&cfg.Upstream.Driver, "UPSTREAM_DB_DRIVER"),
+		str(&cfg.Upstream.Host, "UPSTREAM_DB_HOST"),
+		integer(&cfg.Upstream.Port, "UPSTREAM_DB_PORT"),
+		str(&cfg.Upstream.User, "UPSTREAM_DB_USERNAME"),
+		str(&cfg.Upstream.Password, "UPSTREAM_DB_PASSWORD"),
+		fileRef(&cfg.Upstream.PasswordRef, "UPSTREAM_DB_PASSWORD_FILE"),
+		str(&cfg.Upstream.DBName, "UPSTREAM_DB_DATABASE"),
+		str(&cfg.Upstream.Path, "UPSTREAM_DB_SQLITE_PATH"),
+		boolean(&cfg.Upstream.TLS.Enabled, "UPSTREAM_DB_TLS"),
+		str(&cfg.Upstream.TLS.CAFile, "UPSTREAM_DB_TLS_CA_FILE"),
+
+		str(&cfg.Rabbit.Host, "RABBITMQ_HOST"),
+		integer(&cfg.Rabbit.Port, "RABBITMQ_PORT"),
+		str(&cfg.Rabbit.User, "RABBITMQ_USER"),
+		str(&cfg.Rabbit.Password, "RABBITMQ_PASSWORD"),
+		fileRef(&cfg.Rabbit.PasswordRef, "RABBITMQ_PASSWORD_FILE"),
+		str(&cfg.Rabbit.VHost, "RABBITMQ_VHOST"),
+		str(&cfg.Rabbit.Exchange, "RABBITMQ_EXCHANGE"),
+		str(&cfg.Rabbit.Queue, "RABBITMQ_QUEUE"),
+		str(&cfg.Rabbit.DeadLetterQueue, "RABBITMQ_DEAD_LETTER_QUEUE"),
+		integer(&cfg.Rabbit.Prefetch, "RABBITMQ_PREFETCH"),
+		integer(&cfg.Rabbit.Workers, "RABBITMQ_WORKERS"),
+		str(&cfg.Rabbit.ConsumerTag, "RABBITMQ_CONSUMER_TAG"),
+		boolean(&cfg.Rabbit.TLS.Enabled, "RABBITMQ_TLS"),
+		str(&cfg.Rabbit.TLS.CAFile, "RABBITMQ_TLS_CA_FILE"),
+		str(&cfg.Rabbit.TLS.CertFile, "RABBITMQ_TLS_CERT_FILE"),
+		str(&cfg.Rabbit.TLS.KeyFile, "RABBITMQ_TLS_KEY_FILE"),
+		str(&cfg.Rabbit.TLS.ServerName, "RABBITMQ_TLS_SERVER_NAME"),
+		boolean(&cfg.Rabbit.TLS.InsecureSkipVerify, "RABBITMQ_TLS_INSECURE_SKIP_VERIFY"),
+		str(&cfg.Rabbit.AuthMechanism, "RABBITMQ_AUTH_MECHANISM"),
+		seconds(&cfg.Rabbit.Reconnect.InitialBackoff, "RABBITMQ_RECONNECT_INITIAL_SECONDS"),
+		seconds(&cfg.Rabbit.Reconnect.MaxBackoff, "RABBITMQ_RECONNECT_MAX_SECONDS"),
+		seconds(&cfg.Rabbit.Reconnect.MaxDowntime, "RABBITMQ_MAX_DOWNTIME_SECONDS"),
+		boolean(&cfg.Rabbit.Reconnect.ExitOnMaxDowntime, "RABBITMQ_EXIT_ON_MAX_DOWNTIME"),
+		str(&cfg.Rabbit.Signing.Mode, "RABBITMQ_SIGNING_MODE"),
+		keyMap(&cfg.Rabbit.Signing.Keys, "RABBITMQ_SIGNING_KEYS"),
+		fileRef(&cfg.Rabbit.Signing.KeysRef, "RABBITMQ_SIGNING_KEYS_FILE"),
+		str(&cfg.Rabbit.Signing.QuarantineQueue, "RABBITMQ_QUARANTINE_QUEUE"),
+		boolean(&cfg.Rabbit.Resync.Enabled, "RABBITMQ_RESYNC_ENABLED"),
+		str(&cfg.Rabbit.Resync.Queue, "RABBITMQ_RESYNC_QUEUE"),
+
+		integer(&cfg.Batch.Size, "BATCH_SIZE"),
+		seconds(&cfg.Batch.Interval, "BATCH_INTERVAL_SECONDS"),
+
+		seconds(&cfg.Sync.Interval, "SYNC_INTERVAL_SECONDS"),
+		integer(&cfg.Sync.BatchSize, "SYNC_BATCH_SIZE"),
+		seconds(&cfg.Reconcile.Interval, "RECONCILE_INTERVAL_SECONDS"),
+		integer(&cfg.Reconcile.BatchSize, "RECONCILE_BATCH_SIZE"),
+		boolean(&cfg.Reconcile.Repair, "RECONCILE_REPAIR"),
+		seconds(&cfg.Ledger.CheckInterval, "LEDGER_CHECK_INTERVAL_SECONDS"),
+		integer(&cfg.Retention.Days, "RETENTION_DAYS"),
+		seconds(&cfg.Retention.Interval, "RETENTION_INTERVAL_SECONDS"),
+		integer(&cfg.Retention.BatchSize, "RETENTION_BATCH_SIZE"),
+		integer(&cfg.Retention.FileEvents, "RETENTION_FILE_EVENTS"),
+		str(&cfg.Retention.Format, "RETENTION_FORMAT"),
+		boolean(&cfg.Retention.PartitionByMonth, "RETENTION_PARTITION_BY_MONTH"),
+		integer(&cfg.Retention.ClaimDays, "RETENTION_CLAIM_DAYS"),
+		str(&cfg.Retention.Archive.Type, "ARCHIVE_TYPE"),
+		str(&cfg.Retention.Archive.Dir, "ARCHIVE_DIR"),
+		str(&cfg.Retention.Archive.Endpoint, "ARCHIVE_S3_ENDPOINT"),
+		str(&cfg.Retention.Archive.Region, "ARCHIVE_S3_REGION"),
+		str(&cfg.Retention.Archive.Bucket, "ARCHIVE_S3_BUCKET"),
+		str(&cfg.Retention.Archive.Prefix, "ARCHIVE_S3_PREFIX"),
+		str(&cfg.Retention.Archive.AccessKeyID, "ARCHIVE_S3_ACCESS_KEY_ID"),
+		str(&cfg.Retention.Archive.SecretAccessKey, "ARCHIVE_S3_SECRET_ACCESS_KEY"),
+		fileRef(&cfg.Retention.Archive.SecretAccessKeyRef, "ARCHIVE_S3_SECRET_ACCESS_KEY_FILE"),
+		boolean(&cfg.Snapshots.Enabled, "SNAPSHOTS_ENABLED"),
+		str(&cfg.Snapshots.Cutoff, "SNAPSHOT_CUTOFF"),
+		str(&cfg.Snapshots.Timezone, "SNAPSHOT_TIMEZONE"),
+		integer(&cfg.Snapshots.BatchSize, "SNAPSHOT_BATCH_SIZE"),
+		boolean(&cfg.Webhooks.Enabled, "WEBHOOKS_ENABLED"),
+		integer(&cfg.Webhooks.QueueSize, "WEBHOOK_QUEUE_SIZE"),
+		integer(&cfg.Webhooks.MaxAttempts, "WEBHOOK_MAX_ATTEMPTS"),
+		seconds(&cfg.Webhooks.InitialBackoff, "WEBHOOK_INITIAL_BACKOFF_SECONDS"),
+		seconds(&cfg.Webhooks.MaxBackoff, "WEBHOOK_MAX_BACKOFF_SECONDS"),
+		seconds(&cfg.Webhooks.Timeout, "WEBHOOK_TIMEOUT_SECONDS"),
+		seconds(&cfg.Webhooks.RefreshInterval, "WEBHOOK_REFRESH_INTERVAL_SECONDS"),
+		boolean(&cfg.Alerts.Enabled, "ALERTS_ENABLED"),
+		alertRule(&cfg.Alerts.Rules, "balance_below", "ALERT_BALANCE_BELOW"),
+		alertRule(&cfg.Alerts.Rules, "change_above", "ALERT_CHANGE_ABOVE"),
+		alertRule(&cfg.Alerts.Rules, "rate_above", "ALERT_UPDATES_PER_MINUTE"),
+		alertRule(&cfg.Alerts.Rules, "negative_amount", "ALERT_NEGATIVE_AMOUNT"),
+		seconds(&cfg.Alerts.DedupWindow, "ALERT_DEDUP_WINDOW_SECONDS"),
+		str(&cfg.Alerts.Sink.Type, "ALERT_SINK"),
+		str(&cfg.Alerts.Sink.URL, "ALERT_WEBHOOK_URL"),
+		str(&cfg.Alerts.Sink.Secret, "ALERT_WEBHOOK_SECRET"),
+		fileRef(&cfg.Alerts.Sink.SecretRef, "ALERT_WEBHOOK_SECRET_FILE"),
+		str(&cfg.Alerts.Sink.Exchange, "ALERT_EXCHANGE"),
+		str(&cfg.Alerts.Sink.RoutingKey, "ALERT_ROUTING_KEY"),
+		str(&cfg.NegativeBalance.Mode, "NEGATIVE_BALANCE_MODE"),
+		str(&cfg.Currency.Default, "DEFAULT_CURRENCY"),
+
+		integer(&cfg.Breaker.Threshold, "DB_BREAKER_THRESHOLD"),
+		seconds(&cfg.Breaker.ProbeInterval, "DB_BREAKER_PROBE_INTERVAL_SECONDS"),
+		seconds(&cfg.Breaker.ProbeMax, "DB_BREAKER_PROBE_MAX_SECONDS"),
+
+		str(&cfg.Admin.Addr, "ADMIN_ADDR"),
+		str(&cfg.Admin.Token, "ADMIN_TOKEN"),
+		fileRef(&cfg.Admin.TokenRef, "ADMIN_TOKEN_FILE"),
+		keyMap(&cfg.Admin.TenantTokens, "ADMIN_TENANT_TOKENS"),
+		fileRef(&cfg.Admin.TenantTokensRef, "ADMIN_TENANT_TOKENS_FILE"),
+
+		str(&cfg.Log.Level, "LOG_LEVEL"),
+
+		seconds(&cfg.Shutdown.Timeout, "SHUTDOWN_TIMEOUT_SECONDS"),
+	}
+}
+
+func applyEnv(cfg *Config) error {
+	var errs []error
+	for _, b := range envBindings(cfg) {
+		for _, key := range b.keys {
+			val := os.Getenv(key)
+			if val == "" {
+				continue
+			}
+			if err := b.apply(val); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", key, err))
+			}
+			break
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func str(dst *string, keys ...string) envBinding {
+	return envBinding{keys: keys, apply: func(val string) error {
+		*dst = val
+		return nil
+	}}
+}
+
+// fileRef binds the *_FILE convention of Docker and Kubernetes secrets: the
+// variable holds the path of a file whose content is the secret.
+func fileRef(dst *string, keys ...string) envBinding {
+	return envBinding{keys: keys, apply: func(val string) error {
+		*dst = secrets.FileRef(strings.TrimSpace(val))
+		return nil
+	}}
+}
+
+func keyMap(dst *map[string]string, keys ...string) envBinding {
+	return envBinding{keys: keys, apply: func(val string) error {
+		parsed, err := parseKeys(val)
+		if err != nil {
+			return err
+		}
+		*dst = parsed
+		return nil
+	}}
+}
+
+// parseKeys reads "id=secret" pairs separated by commas or newlines.
+func parseKeys(val string) (map[string]string, error) {
+	parsed := make(map[string]string)
+	for _, pair := range strings.FieldsFunc(val, func(r rune) bool { return r == ',' || r == '\n' }) {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		id, secret, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, errors.New("invalid key entry, expected id=secret")
+		}
+		parsed[strings.TrimSpace(id)] = strings.TrimSpace(secret)
+	}
+	return parsed, nil
+}
+
+func integer(dst *int, keys ...string) envBinding {
+	return envBinding{keys: keys, apply: func(val string) error {
+		parsed, err := strconv.Atoi(strings.TrimSpace(val))
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", val)
+		}
+		*dst = parsed
+		return nil
+	}}
+}
+
+func seconds(dst *time.Duration, keys ...string) envBinding {
+	return envBinding{keys: keys, apply: func(val string) error {
+		parsed, err := strconv.Atoi(strings.TrimSpace(val))
+		if err != nil {
+			return fmt.Errorf("invalid number of seconds %q", val)
+		}
+		*dst = time.Duration(parsed) * time.Second
+		return nil
+	}}
+}
+
+// alertRule sets the threshold of the rule of the given kind, adding the rule
+// if the config file has none. negative_amount has no threshold and takes a
+// boolean instead; false removes the rule.
+func alertRule(rules *[]AlertRule, kind string, keys ...string) envBinding {
+	return envBinding{keys: keys, apply: func(val string) error {
+		var threshold float64
+		if kind == "negative_amount" {
+			enabled, err := strconv.ParseBool(strings.TrimSpace(val))
+			if err != nil {
+				return fmt.Errorf("invalid boolean %q", val)
+			}
+			if !enabled {
+				kept := (*rules)[:0]
+				for _, r := range *rules {
+					if r.Kind != kind {
+						kept = append(kept, r)
+					}
+				}
+				*rules = kept
+				return nil
+			}
+		} else {
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(val), 64)
+			if err != nil {
+				return fmt.Errorf("invalid number %q", val)
+			}
+			threshold = parsed
+		}
+
+		for i := range *rules {
+			if (*rules)[i].Kind == kind {
+				(*rules)[i].Threshold = threshold
+				return nil
+			}
+		}
+		*rules = append(*rules, AlertRule{Kind: kind, Threshold: threshold})
+		return nil
+	}}
+}
+
+func boolean(dst *bool, keys ...string) envBinding {
+	return envBinding{keys: keys, apply: func(val string) error {
+		parsed, err := strconv.ParseBool(strings.TrimSpace(val))
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", val)
+		}
+		*dst = parsed
+		return nil
+	}}
+}
@@ -0,0 +1,83 @@
+package config
+
+import (
+	"io"
+	"reflect"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const redacted = "********"
+
+// Print writes the effective configuration as YAML. Fields tagged
+// secret:"true" are redacted and durations use their string form, so the
+// output can be fed back as a config file.
+func (c *Config) Print(w io.Writer) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(printable(reflect.ValueOf(*c))); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+func printable(v reflect.Value) interface{} {
+	if d, ok := v.Interface().(time.Duration); ok {
+		return d.String()
+	}
+	if v.Kind() != reflect.Struct {
+		return v.Interface()
+	}
+
+	node := &yaml.Node{Kind: yaml.MappingNode}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := field.Tag.Get("yaml")
+		if name == "" || name == "-" {
+			continue
+		}
+
+		var value interface{} = printable(v.Field(i))
+		if field.Tag.Get("secret") == "true" && !v.Field(i).IsZero() {
+			value = redacted
+		}
+
+		var key, val yaml.Node
+		_ = key.Encode(name)
+		_ = val.Encode(value)
+		node.Content = append(node.Content, &key, &val)
+	}
+	return node
+}
+
+// Diff returns the YAML paths of the settings that differ between a and b.
+// Secrets are left out: their values change whenever a referenced secret is
+// rotated, which is not a configuration change. Their refs are compared.
+func Diff(a, b *Config) []string {
+	return diff(reflect.ValueOf(*a), reflect.ValueOf(*b), "")
+}
+
+func diff(a, b reflect.Value, prefix string) []string {
+	if a.Kind() != reflect.Struct {
+		if reflect.DeepEqual(a.Interface(), b.Interface()) {
+			return nil
+		}
+		return []string{prefix}
+	}
+
+	var changed []string
+	t := a.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := t.Field(i).Tag.Get("yaml")
+		if name == "" || name == "-" || t.Field(i).Tag.Get("secret") == "true" {
+			continue
+		}
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+		changed = append(changed, diff(a.Field(i), b.Field(i), name)...)
+	}
+	return changed
+}
@@ -0,0 +1,312 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+	// The runtime image has no zoneinfo for snapshots.timezone.
+	_ "time/tzdata"
+
+	"balance-service/internal/model"
+	"github.com/sirupsen/logrus"
+)
+
+const maxWorkers = 64
+
+// Validate reports every invalid setting at once.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	errs = append(errs, c.Database.validate("database")...)
+	if c.Upstream.Configured() {
+		errs = append(errs, c.Upstream.validate("upstream")...)
+	}
+	errs = append(errs, c.Rabbit.TLS.validate("rabbitmq.tls")...)
+
+	switch c.Rabbit.AuthMechanism {
+	case "plain":
+	case "external":
+		check(c.Rabbit.TLS.Enabled && c.Rabbit.TLS.CertFile != "",
+			"rabbitmq.auth_mechanism external needs rabbitmq.tls enabled with a client certificate")
+	default:
+		check(false, "rabbitmq.auth_mechanism %q is not one of plain, external", c.Rabbit.AuthMechanism)
+	}
+
+	check(c.Rabbit.Host != "", "rabbitmq.host is required")
+	check(validPort(c.Rabbit.Port), "rabbitmq.port %d is out of range", c.Rabbit.Port)
+	check(c.Rabbit.Exchange != "", "rabbitmq.exchange is required")
+	check(c.Rabbit.Queue != "", "rabbitmq.queue is required")
+	check(c.Rabbit.DeadLetterQueue != "", "rabbitmq.dead_letter_queue is required")
+	check(c.Rabbit.DeadLetterQueue != c.Rabbit.Queue, "rabbitmq.dead_letter_queue must differ from rabbitmq.queue")
+	check(c.Rabbit.Prefetch >= 0, "rabbitmq.prefetch must not be negative, got %d", c.Rabbit.Prefetch)
+	check(c.Rabbit.Workers >= 1 && c.Rabbit.Workers <= maxWorkers,
+		"rabbitmq.workers must be between 1 and %d, got %d", maxWorkers, c.Rabbit.Workers)
+	check(c.Rabbit.ConsumerTag != "", "rabbitmq.consumer_tag is required")
+	check(c.Rabbit.Reconnect.InitialBackoff > 0,
+		"rabbitmq.reconnect.initial_backoff must be positive, got %s", c.Rabbit.Reconnect.InitialBackoff)
+	check(c.Rabbit.Reconnect.MaxBackoff >= c.Rabbit.Reconnect.InitialBackoff,
+		"rabbitmq.reconnect.max_backoff (%s) must not be shorter than initial_backoff (%s)",
+		c.Rabbit.Reconnect.MaxBackoff, c.Rabbit.Reconnect.InitialBackoff)
+	check(c.Rabbit.Reconnect.MaxDowntime >= 0,
+		"rabbitmq.reconnect.max_downtime must not be negative, got %s", c.Rabbit.Reconnect.MaxDowntime)
+	check(!c.Rabbit.Reconnect.ExitOnMaxDowntime || c.Rabbit.Reconnect.MaxDowntime > 0,
+		"rabbitmq.reconnect.exit_on_max_downtime needs a positive max_downtime")
+
+	switch c.Rabbit.Signing.Mode {
+	case "off":
+	case "report", "enforce":
+		check(len(c.Rabbit.Signing.Keys) > 0, "rabbitmq.signing.mode %s needs at least one key", c.Rabbit.Signing.Mode)
+		check(c.Rabbit.Signing.QuarantineQueue != "" || c.Rabbit.Signing.Mode == "report",
+			"rabbitmq.signing.quarantine_queue is required in enforce mode")
+		check(c.Rabbit.Signing.QuarantineQueue != c.Rabbit.Queue,
+			"rabbitmq.signing.quarantine_queue must differ from rabbitmq.queue")
+	default:
+		check(false, "rabbitmq.signing.mode %q is not one of off, report, enforce", c.Rabbit.Signing.Mode)
+	}
+	for id, key := range c.Rabbit.Signing.Keys {
+		check(id != "" && key != "", "rabbitmq.signing.keys: key IDs and secrets must not be empty")
+	}
+	if c.Rabbit.Resync.Enabled {
+		check(c.Rabbit.Resync.Queue != "", "rabbitmq.resync.queue is required when resync is enabled")
+		check(c.Rabbit.Resync.Queue != c.Rabbit.Queue, "rabbitmq.resync.queue must differ from rabbitmq.queue")
+	}
+
+	check(c.Batch.Size >= 1, "batch.size must be at least 1, got %d", c.Batch.Size)
+	check(c.Batch.Interval > 0, "batch.interval must be positive, got %s", c.Batch.Interval)
+
+	check(c.Sync.Interval > 0, "sync.interval must be positive, got %s", c.Sync.Interval)
+	check(c.Sync.BatchSize >= 1, "sync.batch_size must be at least 1, got %d", c.Sync.BatchSize)
+
+	check(c.Reconcile.Interval >= 0, "reconcile.interval must not be negative, got %s", c.Reconcile.Interval)
+	check(c.Reconcile.BatchSize >= 1, "reconcile.batch_size must be at least 1, got %d", c.Reconcile.BatchSize)
+	check(c.Reconcile.Interval == 0 || c.Upstream.Configured(), "reconcile.interval needs the upstream database to be configured")
+	check(c.Ledger.CheckInterval >= 0, "ledger.check_interval must not be negative, got %s", c.Ledger.CheckInterval)
+	errs = append(errs, c.validateRetention()...)
+	_, err := time.Parse("15:04", c.Snapshots.Cutoff)
+	check(err == nil, "snapshots.cutoff %q is not a time of day like 00:00", c.Snapshots.Cutoff)
+	_, err = time.LoadLocation(c.Snapshots.Timezone)
+	check(err == nil, "snapshots.timezone %q is not a known time zone", c.Snapshots.Timezone)
+	check(c.Snapshots.BatchSize >= 1, "snapshots.batch_size must be at least 1, got %d", c.Snapshots.BatchSize)
+
+	check(c.Webhooks.QueueSize >= 1, "webhooks.queue_size must be at least 1, got %d", c.Webhooks.QueueSize)
+	check(c.Webhooks.MaxAttempts >= 1, "webhooks.max_attempts must be at least 1, got %d", c.Webhooks.MaxAttempts)
+	check(c.Webhooks.InitialBackoff > 0, "webhooks.initial_backoff must be positive, got %s", c.Webhooks.InitialBackoff)
+	check(c.Webhooks.MaxBackoff >= c.Webhooks.InitialBackoff,
+		"webhooks.max_backoff (%s) must not be shorter than webhooks.initial_backoff (%s)", c.Webhooks.MaxBackoff, c.Webhooks.InitialBackoff)
+	check(c.Webhooks.Timeout > 0, "webhooks.timeout must be positive, got %s", c.Webhooks.Timeout)
+	check(c.Webhooks.RefreshInterval > 0, "webhooks.refresh_interval must be positive, got %s", c.Webhooks.RefreshInterval)
+
+	errs = append(errs, c.Alerts.validate()...)
+	check(model.ValidCurrency(c.Currency.Default), "currency.default %q is not a three-letter upper-case code", c.Currency.Default)
+	errs = append(errs, c.validateTenants()...)
+	errs = append(errs, c.NegativeBalance.validate()...)
+
+	check(c.Breaker.Threshold >= 1, "breaker.threshold must be at least 1, got %d", c.Breaker.Threshold)
+	check(c.Breaker.ProbeInterval > 0, "breaker.probe_interval must be positive, got %s", c.Breaker.ProbeInterval)
+	check(c.Breaker.ProbeMax >= c.Breaker.ProbeInterval,
+		"breaker.probe_max (%s) must not be shorter than breaker.probe_interval (%s)", c.Breaker.ProbeMax, c.Breaker.ProbeInterval)
+
+	check(c.Shutdown.Timeout > 0, "shutdown.timeout must be positive, got %s", c.Shutdown.Timeout)
+
+	_, err = logrus.ParseLevel(c.Log.Level)
+	check(err == nil, "log.level %q is not a valid level", c.Log.Level)
+
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	return nil
+}
+
+func (a AlertConfig) validate() []error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("alerts."+format, args...))
+		}
+	}
+
+	check(a.DedupWindow >= 0, "dedup_window must not be negative, got %s", a.DedupWindow)
+	check(!a.Enabled || len(a.Rules) > 0, "enabled needs at least one rule")
+
+	names := make(map[string]bool, len(a.Rules))
+	for i, r := range a.Rules {
+		name := r.Name
+		if name == "" {
+			name = r.Kind
+		}
+		check(!names[name], "rules[%d]: name %q is used twice", i, name)
+		names[name] = true
+
+		switch r.Kind {
+		case "balance_below", "negative_amount":
+		case "change_above":
+			check(r.Threshold > 0, "rules[%d]: change_above needs a positive threshold, got %v", i, r.Threshold)
+		case "rate_above":
+			check(r.Threshold >= 1, "rules[%d]: rate_above needs a threshold of at least 1, got %v", i, r.Threshold)
+		default:
+			check(false, "rules[%d]: kind %q is not one of balance_below, change_above, rate_above, negative_amount", i, r.Kind)
+		}
+		check(r.DedupWindow >= 0, "rules[%d]: dedup_window must not be negative, got %s", i, r.DedupWindow)
+	}
+
+	switch a.Sink.Type {
+	case "log":
+	case "webhook":
+		u, err := url.Parse(a.Sink.URL)
+		check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "",
+			"sink.url must be an absolute http or https URL for the webhook sink")
+		check(a.Sink.Timeout > 0, "sink.timeout must be positive, got %s", a.Sink.Timeout)
+	case "amqp":
+		check(a.Sink.Exchange != "", "sink.exchange is required for the amqp sink")
+	default:
+		check(false, "sink.type %q is not one of log, webhook, amqp", a.Sink.Type)
+	}
+	return errs
+}
+
+func (c *Config) validateRetention() []error {
+	var errs []error
+	r := c.Retention
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("retention."+format, args...))
+		}
+	}
+
+	check(r.Days >= 0, "days must not be negative, got %d", r.Days)
+	check(r.Interval >= 0, "interval must not be negative, got %s", r.Interval)
+	check(r.Interval == 0 || r.Days >= 1, "interval needs days to be set")
+	check(r.BatchSize >= 1, "batch_size must be at least 1, got %d", r.BatchSize)
+	check(r.FileEvents >= 1, "file_events must be at least 1, got %d", r.FileEvents)
+	check(r.ClaimDays >= 0, "claim_days must not be negative, got %d", r.ClaimDays)
+	switch r.Format {
+	case "jsonl":
+	case "parquet":
+		check(false, "format parquet is not supported, archives are written as gzip-compressed JSON lines (format jsonl)")
+	default:
+		check(false, "format %q is not one of jsonl", r.Format)
+	}
+	check(!r.PartitionByMonth || c.Database.Driver == "mysql", "partition_by_month needs the mysql driver")
+
+	switch r.Archive.Type {
+	case "dir":
+		check(r.Archive.Dir != "", "archive.dir is required for the dir archive")
+	case "s3":
+		u, err := url.Parse(r.Archive.Endpoint)
+		check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "",
+			"archive.endpoint must be an absolute http or https URL for the s3 archive")
+		check(r.Archive.Bucket != "", "archive.bucket is required for the s3 archive")
+		check(r.Archive.Region != "", "archive.region is required for the s3 archive")
+		check(r.Archive.AccessKeyID != "" && r.Archive.SecretAccessKey != "",
+			"archive.access_key_id and archive.secret_access_key are required for the s3 archive")
+	default:
+		check(false, "archive.type %q is not one of dir, s3", r.Archive.Type)
+	}
+	return errs
+}
+
+func (n NegativeBalanceConfig) validate() []error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("negative_balance."+format, args...))
+		}
+	}
+	validMode := func(mode string) bool {
+		switch mode {
+		case "allow", "reject", "clamp", "hold":
+			return true
+		}
+		return false
+	}
+
+	check(validMode(n.Mode), "mode %q is not one of allow, reject, clamp, hold", n.Mode)
+	for i, s := range n.Segments {
+		check(validMode(s.Mode), "segments[%d].mode %q is not one of allow, reject, clamp, hold", i, s.Mode)
+		check(len(s.UserIDs) > 0 || s.MinUserID > 0 || s.MaxUserID > 0,
+			"segments[%d] selects no users, set user_ids or an ID range", i)
+		check(s.MaxUserID == 0 || s.MaxUserID >= s.MinUserID,
+			"segments[%d].max_user_id %d is below min_user_id %d", i, s.MaxUserID, s.MinUserID)
+	}
+	return errs
+}
+
+func (d DatabaseConfig) validate(prefix string) []error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(prefix+"."+format, args...))
+		}
+	}
+
+	switch d.Driver {
+	case "mysql":
+		check(d.Host != "", "host is required")
+		check(validPort(d.Port), "port %d is out of range", d.Port)
+		check(d.User != "", "user is required")
+		check(d.DBName != "", "name is required")
+	case "sqlite":
+		check(d.Path != "", "path is required for the sqlite driver")
+		check(!d.TLS.Enabled, "tls is only supported by the mysql driver")
+	default:
+		check(false, "driver %q is not one of mysql, sqlite", d.Driver)
+	}
+
+	return append(errs, d.TLS.validate(prefix+".tls")...)
+}
+
+func (t TLSConfig) validate(prefix string) []error {
+	var errs []error
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		errs = append(errs, fmt.Errorf("%s.cert_file and %s.key_file must be set together", prefix, prefix))
+	}
+	if !t.Enabled && (t.CAFile != "" || t.CertFile != "") {
+		errs = append(errs, fmt.Errorf("%s has certificate files but is not enabled", prefix))
+	}
+	return errs
+}
+
+func validPort(port int) bool {
+	return port > 0 && port <= 65535
+}
+
+func (c *Config) validateTenants() []error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	ids := make(map[string]bool, len(c.Tenants))
+	queues := map[string]bool{c.Rabbit.Queue: true, c.Rabbit.DeadLetterQueue: true}
+	for i, t := range c.Tenants {
+		prefix := fmt.Sprintf("tenants[%d]", i)
+		check(model.ValidTenantID(t.ID), "%s.id %q must be 1 to 64 lower-case letters, digits, dashes or underscores", prefix, t.ID)
+		check(t.ID != model.DefaultTenant, "%s.id %q is reserved for the default tenant", prefix, t.ID)
+		check(!ids[t.ID], "%s.id %q is listed twice", prefix, t.ID)
+		ids[t.ID] = true
+		if t.Queue != "" {
+			check(!queues[t.Queue], "%s.queue %q is already used by another queue", prefix, t.Queue)
+			queues[t.Queue] = true
+		}
+		check(t.MaxUsers >= 0, "%s.max_users must not be negative, got %d", prefix, t.MaxUsers)
+		check(t.MaxMessagesPerSecond >= 0, "%s.max_messages_per_second must not be negative, got %v", prefix, t.MaxMessagesPerSecond)
+	}
+
+	tokens := make(map[string]bool, len(c.Admin.TenantTokens))
+	for id, token := range c.Admin.TenantTokens {
+		check(ids[id], "admin.tenant_tokens: tenant %q is not listed in tenants", id)
+		check(token != "", "admin.tenant_tokens: the token of tenant %q is empty", id)
+		check(token == "" || (token != c.Admin.Token && !tokens[token]),
+			"admin.tenant_tokens: the token of tenant %q is not unique", id)
+		tokens[token] = true
+	}
+	return errs
+}
@@ -2,211 +2,269 @@ package consumer
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 
+	"balance-service/internal/codec"
 	"balance-service/internal/config"
 	"balance-service/internal/processor"
+	"balance-service/internal/signing"
+	"balance-service/internal/tenant"
 	amqp "github.com/rabbitmq/amqp091-go"
 	"github.com/sirupsen/logrus"
 )
 
 const (
-	reconnectDelay = 5 * time.Second
-	maxReconnectAttempts = 10
 	consumerTimeout = 30 * time.Second
 )
 
+// Options are the processing rules the consumer applies to decoded
+// messages before handing them to the processor.
+type Options struct {
+	// NegativePolicy decides about negative amounts; nil allows them.
+	NegativePolicy *processor.NegativePolicy
+	// DefaultCurrency is given to messages without a currency.
+	DefaultCurrency string
+	// Tenants resolves the tenant of messages and applies its limits; their
+	// queues are consumed besides the main one.
+	Tenants *tenant.Registry
+}
+
 type Consumer struct {
 	cfg     config.RabbitConfig
 	log     *logrus.Logger
 	updates chan<- processor.IncomingUpdate
 
+	verifier *signing.Verifier
+	codecs   *codec.Registry
+	policy   *processor.NegativePolicy
+	currency string
+	tenants  *tenant.Registry
+
 	conn    *amqp.Connection
 	channel *amqp.Channel
-	mu      sync.RWMutex
-
+	// publisher is a separate channel in confirm mode for moving messages to
+	// the dead-letter and quarantine queues.
+	publisher *amqp.Channel
+	paused    bool
+	draining  bool
+	// downSince is when the current outage began; zero while connected.
+	downSince time.Time
+	mu        sync.RWMutex
+
+	// ctx scopes the consumer workers. It is only cancelled by Close or when
+	// Drain runs out of time, so that a shutdown signal doesn't drop messages
+	// the workers already hold.
 	ctx    context.Context
 	cancel context.CancelFunc
 	wg     sync.WaitGroup
 }
 
-func New(cfg config.RabbitConfig, log *logrus.Logger, updates chan<- processor.IncomingUpdate) (*Consumer, error) {
+// New prepares a consumer. The connection is established by Run, which keeps
+// it alive for the lifetime of the service.
+func New(cfg config.RabbitConfig, opts Options, log *logrus.Logger, updates chan<- processor.IncomingUpdate) *Consumer {
 	ctx, cancel := context.WithCancel(context.Background())
 
-	c := &Consumer{
-		cfg:     cfg,
-		log:     log,
-		updates: updates,
-		ctx:     ctx,
-		cancel:  cancel,
+	var verifier *signing.Verifier
+	if cfg.Signing.Mode != "off" {
+		verifier = signing.NewVerifier(cfg.Signing.Keys)
 	}
 
-	if err := c.connect(); err != nil {
-		cancel()
-		return nil, fmt.Errorf("failed to connect: %w", err)
+	return &Consumer{
+		verifier:  verifier,
+		codecs:    codec.NewRegistry(),
+		policy:    opts.NegativePolicy,
+		currency:  opts.DefaultCurrency,
+		tenants:   opts.Tenants,
+		cfg:       cfg,
+		log:       log,
+		updates:   updates,
+		downSince: time.Now(),
+		ctx:       ctx,
+		cancel:    cancel,
 	}
-
-	return c, nil
 }
 
-func (c *Consumer) connect() error {
-	dsn := fmt.Sprintf("amqp://%s:%s@%s:%d%s",
-		c.cfg.User, c.cfg.Password, c.cfg.Host, c.cfg.Port, c.cfg.VHost)
-
+// Drain stops the subscription and waits until the workers have handed every
+// delivery they received over to the processor. If ctx expires first, the
+// workers are stopped and the deliveries they still hold are requeued.
+func (c *Consumer) Drain(ctx context.Context) {
+	c.mu.Lock()
+	c.draining = true
+	channel, paused := c.channel, c.paused
+	c.mu.Unlock()
 
-	conn, err := amqp.DialConfig(dsn, amqp.Config{
-            Heartbeat: 60 * time.Second,
-            Dial:      amqp.DefaultDial(time.Second * 30),
-        })
-	if err != nil {
-		return fmt.Errorf("failed to dial RabbitMQ: %w", err)
+	c.log.Info("stopping consumer workers")
+	if channel != nil && !paused {
+		// The deliveries channel is closed once everything already sent by
+		// the broker has been read, which lets the workers exit naturally.
+		if err := c.cancelSubscriptions(channel); err != nil {
+			c.log.WithError(err).Warn("failed to cancel consumer, stopping workers")
+			c.cancel()
+		}
 	}
 
-	ch, err := conn.Channel()
-	if err != nil {
-		conn.Close()
-		return fmt.Errorf("failed to open channel: %w", err)
-	}
+	done := make(chan struct{})
+	go func() {
+		c.wg.Wait()
+		close(done)
+	}()
 
-	if _, err := ch.QueueDeclare(
-		c.cfg.Queue,
-		true,  // durable
-		false, // delete when unused
-		false, // exclusive
-		false, // no-wait
-		nil,   // arguments
-	); err != nil {
-		ch.Close()
-		conn.Close()
-		return fmt.Errorf("failed to declare queue: %w", err)
+	select {
+	case <-done:
+	case <-ctx.Done():
+		c.log.Warn("drain deadline exceeded, requeueing deliveries not yet handed over")
+		c.cancel()
+		<-done
 	}
+}
 
-	if err := ch.Qos(c.cfg.Prefetch, 0, false); err != nil {
-		ch.Close()
-		conn.Close()
-		return fmt.Errorf("failed to set QoS: %w", err)
+// subscription is a queue the consumer reads, with the tenant every message
+// in it belongs to; the main queue has none.
+type subscription struct {
+	queue  string
+	tag    string
+	tenant string
+}
+
+// subscriptions lists the main queue and the tenant queues.
+func (c *Consumer) subscriptions() []subscription {
+	subs := []subscription{{queue: c.cfg.Queue, tag: c.cfg.ConsumerTag}}
+	queues := c.tenants.Queues()
+	ids := make([]string, 0, len(queues))
+	for id := range queues {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		subs = append(subs, subscription{queue: queues[id], tag: c.cfg.ConsumerTag + "." + id, tenant: id})
 	}
+	return subs
+}
 
-	c.mu.Lock()
-	c.conn = conn
-	c.channel = ch
-	c.mu.Unlock()
+// consumeLocked subscribes to the queues on channel under the configured
+// consumer tag and starts the workers that read from each subscription. The
+// caller holds c.mu, which serialises it with Pause, Resume and reconnects.
+func (c *Consumer) consumeLocked(channel *amqp.Channel) error {
+	for _, sub := range c.subscriptions() {
+		msgs, err := channel.Consume(
+			sub.queue,
+			sub.tag,
+			false, // auto-ack
+			false, // exclusive
+			false, // no-local
+			false, // no-wait
+			nil,   // arguments
+		)
+		if err != nil {
+			return fmt.Errorf("failed to start consuming %s: %w", sub.queue, err)
+		}
 
-	c.log.WithFields(logrus.Fields{
-		"host":  c.cfg.Host,
-		"queue": c.cfg.Queue,
-	}).Info("connected to RabbitMQ")
+		c.log.WithFields(logrus.Fields{
+			"queue":   sub.queue,
+			"workers": c.cfg.Workers,
+		}).Info("starting consumer workers")
 
-	// Monitor connection for errors
-	go c.monitorConnection()
+		for i := 0; i < c.cfg.Workers; i++ {
+			c.wg.Add(1)
+			go c.worker(c.ctx, msgs, i, sub.tenant)
+		}
+	}
 
 	return nil
 }
 
-func (c *Consumer) monitorConnection() {
-	c.mu.RLock()
-	conn := c.conn
-	c.mu.RUnlock()
-
-	if conn == nil {
-		return
-	}
-
-	notifyClose := conn.NotifyClose(make(chan *amqp.Error))
-
-	select {
-	case err := <-notifyClose:
-		if err != nil {
-			c.log.WithError(err).Error("RabbitMQ connection closed unexpectedly")
-			c.reconnect()
+// cancelSubscriptions cancels every subscription on channel.
+func (c *Consumer) cancelSubscriptions(channel *amqp.Channel) error {
+	for _, sub := range c.subscriptions() {
+		if err := channel.Cancel(sub.tag, false); err != nil {
+			return err
 		}
-	case <-c.ctx.Done():
-		return
 	}
+	return nil
 }
 
-func (c *Consumer) reconnect() {
+// Pause cancels the subscription so RabbitMQ stops delivering. Messages that
+// were already delivered keep flowing to the processor and get settled as
+// usual. Workers exit once the server confirms the cancel. A pause survives
+// reconnects.
+func (c *Consumer) Pause() error {
 	c.mu.Lock()
-	if c.channel != nil {
-		c.channel.Close()
-		c.channel = nil
+	defer c.mu.Unlock()
+
+	if c.paused || c.draining {
+		return nil
 	}
-	if c.conn != nil {
-		c.conn.Close()
-		c.conn = nil
+	c.paused = true
+
+	if c.channel != nil {
+		if err := c.cancelSubscriptions(c.channel); err != nil {
+			return fmt.Errorf("failed to cancel consumer: %w", err)
+		}
 	}
-	c.mu.Unlock()
 
-	for attempt := 1; attempt <= maxReconnectAttempts; attempt++ {
-		c.log.WithField("attempt", attempt).Info("attempting to reconnect to RabbitMQ")
+	c.log.WithField("consumer_tag", c.cfg.ConsumerTag).Warn("consumption paused")
+	return nil
+}
 
-		if err := c.connect(); err == nil {
-			c.log.Info("successfully reconnected to RabbitMQ")
-			// Restart consuming in a new goroutine
-			go func() {
-				if err := c.Start(c.ctx); err != nil && c.ctx.Err() == nil {
-					c.log.WithError(err).Error("failed to restart consumer after reconnect")
-				}
-			}()
-			return
-		}
+// Resume re-subscribes after Pause. Without a connection the subscription is
+// made by the supervisor once it reconnects.
+func (c *Consumer) Resume() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-		delay := reconnectDelay * time.Duration(attempt)
-		c.log.WithFields(logrus.Fields{
-			"attempt": attempt,
-			"delay":   delay,
-		}).Warn("reconnection failed, retrying")
+	if !c.paused || c.draining {
+		return nil
+	}
+	c.paused = false
 
-		select {
-		case <-time.After(delay):
-		case <-c.ctx.Done():
-			return
+	if c.channel != nil {
+		if err := c.consumeLocked(c.channel); err != nil {
+			c.paused = true
+			return err
 		}
 	}
 
-	c.log.Error("max reconnection attempts reached, giving up")
+	c.log.WithField("consumer_tag", c.cfg.ConsumerTag).Info("consumption resumed")
+	return nil
 }
 
-func (c *Consumer) Start(ctx context.Context) error {
-	c.mu.RLock()
-	channel := c.channel
-	c.mu.RUnlock()
+// SetPrefetch changes the QoS prefetch count on the open channel and for
+// every channel opened after a reconnect.
+func (c *Consumer) SetPrefetch(prefetch int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	if channel == nil {
-		return fmt.Errorf("channel is not initialized")
+	c.cfg.Prefetch = prefetch
+	if c.channel == nil {
+		return nil
 	}
-
-	msgs, err := channel.Consume(
-		c.cfg.Queue,
-		"",    // consumer tag
-		false, // auto-ack
-		false, // exclusive
-		false, // no-local
-		false, // no-wait
-		nil,   // arguments
-	)
-	if err != nil {
-		return fmt.Errorf("failed to start consuming: %w", err)
+	if err := c.channel.Qos(prefetch, 0, false); err != nil {
+		return fmt.Errorf("failed to set QoS: %w", err)
 	}
 
-	c.log.WithField("workers", c.cfg.Workers).Info("starting consumer workers")
-
-	for i := 0; i < c.cfg.Workers; i++ {
-		c.wg.Add(1)
-		go c.worker(ctx, msgs, i)
-	}
+	c.log.WithField("prefetch", prefetch).Info("consumer prefetch changed")
+	return nil
+}
 
-	<-ctx.Done()
-	c.log.Info("stopping consumer workers")
-	c.wg.Wait()
+// Ready reports whether the consumer holds an open channel and is subscribed.
+func (c *Consumer) Ready(context.Context) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 
+	switch {
+	case !c.downSince.IsZero():
+		return fmt.Errorf("not connected to RabbitMQ for %s", time.Since(c.downSince).Round(time.Second))
+	case c.paused:
+		return fmt.Errorf("consumption paused")
+	}
 	return nil
 }
 
-func (c *Consumer) worker(ctx context.Context, msgs <-chan amqp.Delivery, workerID int) {
+func (c *Consumer) worker(ctx context.Context, msgs <-chan amqp.Delivery, workerID int, queueTenant string) {
 	defer c.wg.Done()
 
 	c.log.WithField("worker_id", workerID).Debug("worker started")
@@ -219,56 +277,77 @@ func (c *Consumer) worker(ctx context.Context, msgs <-chan amqp.Delivery, worker
 
 		case msg, ok := <-msgs:
 			if !ok {
-				c.log.WithField("worker_id", workerID).Warn("message channel closed")
+				c.mu.RLock()
+				stopped := c.paused || c.draining
+				c.mu.RUnlock()
+
+				if stopped {
+					c.log.WithField("worker_id", workerID).Debug("worker stopped, subscription cancelled")
+				} else {
+					c.log.WithField("worker_id", workerID).Warn("message channel closed")
+				}
 				return
 			}
 
-			c.processMessage(ctx, msg, workerID)
+			c.processMessage(ctx, msg, workerID, queueTenant)
 		}
 	}
 }
 
-func (c *Consumer) processMessage(ctx context.Context, msg amqp.Delivery, workerID int) {
+func (c *Consumer) processMessage(ctx context.Context, msg amqp.Delivery, workerID int, queueTenant string) {
 	ctx, cancel := context.WithTimeout(ctx, consumerTimeout)
 	defer cancel()
 
-	var payload processor.BalanceMessage
-	if err := json.Unmarshal(msg.Body, &payload); err != nil {
-		c.log.WithFields(logrus.Fields{
-			"worker_id": workerID,
-			"error":     err,
-			"body":      string(msg.Body),
-		}).Error("failed to unmarshal message")
-
-		// Reject and don't requeue malformed messages
-		_ = msg.Nack(false, false)
+	signed, ok := c.verifySignature(ctx, msg, workerID)
+	if !ok {
 		return
 	}
 
-	// Validate payload
-	if payload.UserID == 0 {
-		c.log.WithFields(logrus.Fields{
-			"worker_id": workerID,
-			"payload":   payload,
-		}).Error("invalid user_id in message")
-		_ = msg.Nack(false, false)
+	payload, err := c.codecs.Decode(msg.ContentType, msg.Headers, msg.Body)
+	if err != nil {
+		c.deadLetter(ctx, msg, err, workerID)
 		return
 	}
+	origin := tenant.Origin{
+		Header:        headerString(msg.Headers, tenant.Header),
+		HeaderSigned:  signed,
+		RequireSigned: c.verifier != nil,
+		Queue:         queueTenant,
+	}
+	if err := c.tenants.Resolve(&payload, origin); err != nil {
+		c.deadLetter(ctx, msg, err, workerID)
+		return
+	}
+	if err := c.tenants.Admit(ctx, payload); err != nil {
+		var invalid *processor.ValidationError
+		if errors.As(err, &invalid) {
+			c.deadLetter(ctx, msg, err, workerID)
+		} else {
+			c.log.WithError(err).WithField("worker_id", workerID).Warn("tenant limits not checked, requeueing message")
+			_ = msg.Nack(false, true)
+		}
+		return
+	}
+	payload.DefaultCurrency(c.currency)
 
-	// Validate amount (should be non-negative)
-	amount := payload.GetAmount()
-	if amount < 0 {
+	decision := c.policy.Decide(payload)
+	if decision != "" {
 		c.log.WithFields(logrus.Fields{
 			"worker_id": workerID,
 			"user_id":   payload.UserID,
-			"amount":     amount,
-		}).Warn("negative amount in message, will process anyway")
+			"tenant_id": payload.TenantID,
+			"currency":  payload.Currency,
+			"amount":    payload.GetAmount(),
+			"policy":    decision,
+		}).Warn("negative amount in message")
 	}
-
+	// A rejected update is recorded by the processor like any other, which
+	// dead-letters the message once the record is committed.
 	select {
 	case c.updates <- processor.IncomingUpdate{
 		Payload:  payload,
 		Delivery: msg,
+		Decision: decision,
 	}:
 		// Message sent to processor successfully
 		c.log.WithFields(logrus.Fields{
@@ -294,6 +373,7 @@ func (c *Consumer) Close() {
 		c.channel.Close()
 		c.channel = nil
 	}
+	c.publisher = nil
 
 	if c.conn != nil {
 		c.conn.Close()
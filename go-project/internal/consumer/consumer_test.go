@@ -0,0 +1,132 @@
+package consumer
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"balance-service/internal/config"
+	"balance-service/internal/processor"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/sirupsen/logrus"
+)
+
+// settlements records how deliveries were settled by delivery tag.
+type settlements struct {
+	mu       sync.Mutex
+	acked    []uint64
+	requeued []uint64
+	dropped  []uint64
+}
+
+func (s *settlements) Ack(tag uint64, _ bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.acked = append(s.acked, tag)
+	return nil
+}
+
+func (s *settlements) Nack(tag uint64, _ bool, requeue bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if requeue {
+		s.requeued = append(s.requeued, tag)
+	} else {
+		s.dropped = append(s.dropped, tag)
+	}
+	return nil
+}
+
+func (s *settlements) Reject(tag uint64, requeue bool) error {
+	return s.Nack(tag, false, requeue)
+}
+
+func testConsumer(updates chan<- processor.IncomingUpdate) *Consumer {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	return New(config.RabbitConfig{Queue: "balance_updates", Signing: config.SigningConfig{Mode: "off"}},
+		Options{DefaultCurrency: "USD"}, log, updates)
+}
+
+func delivery(ack amqp.Acknowledger, tag uint64) amqp.Delivery {
+	return amqp.Delivery{
+		Acknowledger: ack,
+		DeliveryTag:  tag,
+		ContentType:  "application/json",
+		Body:         []byte(`{"user_id": 1, "new_amount": 10, "version": 1}`),
+	}
+}
+
+// startWorkers runs consumer workers on deliveries the way a subscription
+// does.
+func startWorkers(c *Consumer, deliveries <-chan amqp.Delivery, n int) {
+	for i := 0; i < n; i++ {
+		c.wg.Add(1)
+		go c.worker(c.ctx, deliveries, i, "")
+	}
+}
+
+func TestDrainRequeuesWhatIsNotHandedOverInTime(t *testing.T) {
+	// Nobody reads updates: the processor is stuck.
+	updates := make(chan processor.IncomingUpdate)
+	c := testConsumer(updates)
+	ack := &settlements{}
+	deliveries := make(chan amqp.Delivery, 2)
+	deliveries <- delivery(ack, 1)
+	deliveries <- delivery(ack, 2)
+	startWorkers(c, deliveries, 2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		c.Drain(ctx)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Drain did not return after its deadline")
+	}
+
+	if len(ack.requeued) != 2 || len(ack.acked) != 0 || len(ack.dropped) != 0 {
+		t.Errorf("requeued %v, acked %v, dropped %v; want both deliveries requeued", ack.requeued, ack.acked, ack.dropped)
+	}
+	if c.ctx.Err() == nil {
+		t.Error("workers not stopped after the deadline")
+	}
+}
+
+func TestDrainWaitsForWorkersToHandOver(t *testing.T) {
+	updates := make(chan processor.IncomingUpdate, 3)
+	c := testConsumer(updates)
+	ack := &settlements{}
+	deliveries := make(chan amqp.Delivery, 3)
+	for tag := uint64(1); tag <= 3; tag++ {
+		deliveries <- delivery(ack, tag)
+	}
+	// Cancelling the subscription closes the deliveries once the broker's
+	// last ones are read.
+	close(deliveries)
+	startWorkers(c, deliveries, 2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c.Drain(ctx)
+
+	if ctx.Err() != nil {
+		t.Fatal("Drain waited for its deadline")
+	}
+	if len(updates) != 3 {
+		t.Errorf("%d updates handed over, want 3", len(updates))
+	}
+	if len(ack.acked)+len(ack.requeued)+len(ack.dropped) != 0 {
+		t.Errorf("deliveries settled by the consumer: acked %v, requeued %v, dropped %v", ack.acked, ack.requeued, ack.dropped)
+	}
+	// The processor settles them later, so the workers' context stays alive.
+	if c.ctx.Err() != nil {
+		t.Error("workers cancelled by a drain that finished in time")
+	}
+}
@@ -0,0 +1,165 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"balance-service/internal/metrics"
+	"balance-service/internal/processor"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/sirupsen/logrus"
+)
+
+// Headers added to dead-lettered messages.
+const (
+	HeaderDeadLetterReason = "x-dead-letter-reason"
+	HeaderDeadLetterField  = "x-dead-letter-field"
+	HeaderDeadLetterDetail = "x-dead-letter-detail"
+)
+
+var deadLettered = metrics.NewCounter("balance_messages_dead_lettered_total", "Messages moved to the dead-letter queue, by reason.", "reason")
+
+// openPublisher opens a channel in confirm mode for moving messages out of
+// the main queue and declares the queues it publishes to.
+func (c *Consumer) openPublisher(conn *amqp.Connection) (*amqp.Channel, error) {
+	publisher, err := conn.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open publishing channel: %w", err)
+	}
+
+	queues := []string{c.cfg.DeadLetterQueue}
+	if c.cfg.Signing.Mode == "enforce" {
+		queues = append(queues, c.cfg.Signing.QuarantineQueue)
+	}
+	for _, queue := range queues {
+		if _, err := publisher.QueueDeclare(
+			queue,
+			true,  // durable
+			false, // delete when unused
+			false, // exclusive
+			false, // no-wait
+			nil,   // arguments
+		); err != nil {
+			return nil, fmt.Errorf("failed to declare queue %s: %w", queue, err)
+		}
+	}
+
+	if err := publisher.Confirm(false); err != nil {
+		return nil, fmt.Errorf("failed to enable publisher confirms: %w", err)
+	}
+	return publisher, nil
+}
+
+// deadLetter moves a message that failed validation or that the database
+// refused to the dead-letter queue, recording why in its headers. It reports whether msg was moved.
+func (c *Consumer) deadLetter(ctx context.Context, msg amqp.Delivery, cause error, workerID int) bool {
+	reason, headers := "invalid", amqp.Table{HeaderDeadLetterDetail: cause.Error()}
+
+	var validationErr *processor.ValidationError
+	if errors.As(cause, &validationErr) {
+		reason = validationErr.Reason
+		headers[HeaderDeadLetterField] = validationErr.Field
+	}
+	headers[HeaderDeadLetterReason] = reason
+
+	// The body stays in the dead-letter copy; logging it would put balances
+	// into the logs and flood them during a poison-message storm.
+	fields := logrus.Fields{
+		"worker_id":   workerID,
+		"reason":      reason,
+		"message_id":  msg.MessageId,
+		"body_length": len(msg.Body),
+	}
+	if payload, ok := c.codecs.Peek(msg.ContentType, msg.Headers, msg.Body); ok {
+		fields["event_id"] = payload.EventID
+		fields["user_id"] = payload.UserID
+	}
+	c.log.WithFields(fields).WithError(cause).Error("dead-lettering message")
+
+	if !c.divert(ctx, msg, c.cfg.DeadLetterQueue, headers, workerID) {
+		return false
+	}
+	deadLettered.Inc(reason)
+	return true
+}
+
+// DeadLetter moves a message the processor could not store to the
+// dead-letter queue. It implements processor.DeadLetterer.
+func (c *Consumer) DeadLetter(ctx context.Context, msg amqp.Delivery, cause error, workerID int) bool {
+	return c.deadLetter(ctx, msg, cause, workerID)
+}
+
+// divert copies msg to queue with extra headers and acks the original once
+// the broker confirmed the copy. When the copy cannot be published the
+// message is requeued rather than lost. It reports whether msg was moved.
+func (c *Consumer) divert(ctx context.Context, msg amqp.Delivery, queue string, extra amqp.Table, workerID int) bool {
+	headers := amqp.Table{
+		"x-original-exchange":    msg.Exchange,
+		"x-original-routing-key": msg.RoutingKey,
+	}
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	for k, v := range extra {
+		headers[k] = v
+	}
+
+	err := c.republish(ctx, queue, amqp.Publishing{
+		Headers:         headers,
+		ContentType:     msg.ContentType,
+		ContentEncoding: msg.ContentEncoding,
+		DeliveryMode:    amqp.Persistent,
+		MessageId:       msg.MessageId,
+		Timestamp:       msg.Timestamp,
+		Type:            msg.Type,
+		Body:            msg.Body,
+	})
+	if err != nil {
+		c.log.WithError(err).WithFields(logrus.Fields{
+			"worker_id": workerID,
+			"queue":     queue,
+		}).Error("failed to move message, requeueing")
+		_ = msg.Nack(false, true)
+		return false
+	}
+
+	_ = msg.Ack(false)
+	return true
+}
+
+// republish publishes to queue through the default exchange on the
+// confirming channel and waits until the broker has taken the message.
+func (c *Consumer) republish(ctx context.Context, queue string, msg amqp.Publishing) error {
+	c.mu.RLock()
+	publisher := c.publisher
+	c.mu.RUnlock()
+
+	if publisher == nil {
+		return errors.New("no open publishing channel")
+	}
+
+	confirm, err := publisher.PublishWithDeferredConfirmWithContext(ctx, "", queue, false, false, msg)
+	if err != nil {
+		return fmt.Errorf("failed to publish to %s: %w", queue, err)
+	}
+	acked, err := confirm.WaitContext(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to publish to %s: %w", queue, err)
+	}
+	if !acked {
+		return fmt.Errorf("broker refused the message for %s", queue)
+	}
+	return nil
+}
+
+func headerString(headers amqp.Table, key string) string {
+	switch v := headers[key].(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	default:
+		return ""
+	}
+}
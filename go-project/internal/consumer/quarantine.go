@@ -0,0 +1,70 @@
+package consumer
+
+import (
+	"context"
+
+	"balance-service/internal/codec"
+	"balance-service/internal/metrics"
+	"balance-service/internal/signing"
+	"balance-service/internal/tenant"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/sirupsen/logrus"
+)
+
+// HeaderQuarantineReason tells why a message was moved out of the main queue.
+const HeaderQuarantineReason = "x-quarantine-reason"
+
+var (
+	signatureFailures = metrics.NewCounter("balance_signature_failures_total", "Messages that failed signature verification, by reason.", "reason")
+	signatureVerified = metrics.NewCounter("balance_signature_verified_total", "Messages with a valid signature, by key ID.", "key_id")
+	quarantined       = metrics.NewCounter("balance_messages_quarantined_total", "Messages moved to the quarantine queue, by reason.", "reason")
+)
+
+// verifySignature checks the HMAC headers of msg. signed reports a valid
+// signature, which covers the tenant header too; ok is false when the
+// message has been settled here and must not be processed.
+func (c *Consumer) verifySignature(ctx context.Context, msg amqp.Delivery, workerID int) (signed, ok bool) {
+	if c.verifier == nil {
+		return false, true
+	}
+
+	keyID := headerString(msg.Headers, signing.HeaderKeyID)
+	err := c.verifier.Verify(keyID, headerString(msg.Headers, signing.HeaderSignature),
+		headerString(msg.Headers, signing.HeaderVersion), signedMessage(msg))
+	if err == nil {
+		signatureVerified.Inc(keyID)
+		return true, true
+	}
+
+	reason := signing.Reason(err)
+	signatureFailures.Inc(reason)
+	c.log.WithFields(logrus.Fields{
+		"worker_id":   workerID,
+		"key_id":      keyID,
+		"reason":      reason,
+		"delivery_id": msg.DeliveryTag,
+	}).Warn("message failed signature verification")
+
+	if c.cfg.Signing.Mode != "enforce" {
+		return false, true
+	}
+	c.quarantine(ctx, msg, reason, workerID)
+	return false, false
+}
+
+// signedMessage is what the signature of msg has to cover.
+func signedMessage(msg amqp.Delivery) signing.Message {
+	return signing.Message{
+		ContentType: msg.ContentType,
+		TenantID:    headerString(msg.Headers, tenant.Header),
+		Attributes:  codec.BinaryHeaders(msg.Headers),
+		Body:        msg.Body,
+	}
+}
+
+// quarantine moves msg to the quarantine queue with the reason in a header.
+func (c *Consumer) quarantine(ctx context.Context, msg amqp.Delivery, reason string, workerID int) {
+	if c.divert(ctx, msg, c.cfg.Signing.QuarantineQueue, amqp.Table{HeaderQuarantineReason: reason}, workerID) {
+		quarantined.Inc(reason)
+	}
+}
@@ -0,0 +1,273 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"balance-service/internal/metrics"
+	"balance-service/internal/rabbitmq"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrMaxDowntime is returned by Run when the broker stayed unreachable for
+// longer than the configured policy allows and the policy says to exit.
+var ErrMaxDowntime = errors.New("RabbitMQ unreachable for longer than the allowed downtime")
+
+var (
+	connectedGauge  = metrics.NewGauge("balance_rabbitmq_connected", "1 while the consumer holds an open RabbitMQ channel.")
+	connectFailures = metrics.NewCounter("balance_rabbitmq_connect_failures_total", "Failed attempts to establish a RabbitMQ session.")
+	sessionsLost    = metrics.NewCounter("balance_rabbitmq_sessions_lost_total", "RabbitMQ connections or channels that closed unexpectedly.")
+)
+
+// stableSession is how long a session has to stay up for the reconnect
+// backoff to start over. A broker that accepts connections and drops them
+// right away is retried with growing backoff like one that refuses them.
+const stableSession = 30 * time.Second
+
+// Run is the supervisor of the connection and channel lifecycle. It connects,
+// subscribes and then waits for the connection or the channel to close,
+// reconnecting with jittered exponential backoff for as long as ctx lives.
+// It returns nil when ctx is cancelled; the connection is left open so that
+// Drain can still settle in-flight messages.
+func (c *Consumer) Run(ctx context.Context) error {
+	policy := c.cfg.Reconnect
+	retry := newBackoff(policy.InitialBackoff, policy.MaxBackoff)
+
+	for attempt := 1; ; attempt++ {
+		closed, err := c.establish()
+		if err == nil {
+			attempt = 0
+			up := time.Now()
+
+			select {
+			case <-ctx.Done():
+				return nil
+			case amqpErr := <-closed:
+				c.markDown()
+				sessionsLost.Inc()
+				c.log.WithField("error", amqpErr).Error("RabbitMQ connection or channel closed unexpectedly")
+				if !sleep(ctx, retry.sessionEnded(time.Since(up))) {
+					return nil
+				}
+				continue
+			}
+		}
+
+		connectFailures.Inc()
+		downFor := c.downtime()
+		c.log.WithError(err).WithFields(logrus.Fields{
+			"attempt":  attempt,
+			"down_for": downFor.Round(time.Second).String(),
+		}).Warn("failed to connect to RabbitMQ")
+
+		if policy.MaxDowntime > 0 && downFor > policy.MaxDowntime {
+			if policy.ExitOnMaxDowntime {
+				return fmt.Errorf("%w: %s", ErrMaxDowntime, downFor.Round(time.Second))
+			}
+			c.log.WithField("down_for", downFor.Round(time.Second).String()).Error("RabbitMQ still unreachable, readiness is failing")
+		}
+
+		if !sleep(ctx, retry.next()) {
+			return nil
+		}
+	}
+}
+
+// backoff is the jittered exponential delay between reconnects.
+type backoff struct {
+	initial, max time.Duration
+	current      time.Duration
+	jitter       func(n int64) int64 // a random number in [0, n)
+}
+
+func newBackoff(initial, max time.Duration) *backoff {
+	return &backoff{initial: initial, max: max, current: initial, jitter: rand.Int63n}
+}
+
+// next returns the delay before the next attempt and doubles the bound for
+// the one after, up to max. Full jitter spreads reconnects of many instances
+// after a broker restart.
+func (b *backoff) next() time.Duration {
+	wait := time.Duration(b.jitter(int64(b.current)) + 1)
+	if b.current *= 2; b.current > b.max {
+		b.current = b.max
+	}
+	return wait
+}
+
+// sessionEnded returns the delay before reconnecting after a session that
+// lasted the given time: none after a stable session, which also starts
+// the backoff over, and the next backoff delay otherwise.
+func (b *backoff) sessionEnded(lasted time.Duration) time.Duration {
+	if lasted >= stableSession {
+		b.current = b.initial
+		return 0
+	}
+	return b.next()
+}
+
+// sleep waits for d and reports whether ctx is still alive.
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// establish opens a connection and a channel, declares the queue, applies QoS
+// and subscribes unless paused. The returned channel yields once the
+// connection or the channel closes.
+func (c *Consumer) establish() (<-chan *amqp.Error, error) {
+	conn, err := rabbitmq.Dial(c.cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to open channel: %w", err)
+	}
+
+	if _, err := ch.QueueDeclare(
+		c.cfg.Queue,
+		true,  // durable
+		false, // delete when unused
+		false, // exclusive
+		false, // no-wait
+		nil,   // arguments
+	); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to declare queue: %w", err)
+	}
+	if err := c.declareTenantQueues(ch); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	// The library blocks on unbuffered notification channels nobody reads,
+	// so each gets room for its single close error.
+	connClosed := conn.NotifyClose(make(chan *amqp.Error, 1))
+	var chanClosed <-chan *amqp.Error = ch.NotifyClose(make(chan *amqp.Error, 1))
+
+	publisher, err := c.openPublisher(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	// A failed publish closes the channel; treat it like the consuming
+	// channel so the session is rebuilt.
+	chanClosed = mergeClosed(chanClosed, publisher.NotifyClose(make(chan *amqp.Error, 1)))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := ch.Qos(c.cfg.Prefetch, 0, false); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to set QoS: %w", err)
+	}
+
+	if !c.paused && !c.draining {
+		if err := c.consumeLocked(ch); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	c.conn, c.channel, c.publisher, c.downSince = conn, ch, publisher, time.Time{}
+	connectedGauge.Set(1)
+
+	c.log.WithFields(logrus.Fields{
+		"host":  c.cfg.Host,
+		"queue": c.cfg.Queue,
+	}).Info("connected to RabbitMQ")
+
+	closed := make(chan *amqp.Error, 1)
+	go func() {
+		select {
+		case err := <-connClosed:
+			closed <- err
+		case err := <-chanClosed:
+			// A channel-level error leaves the connection open; close it
+			// so the next session starts clean.
+			conn.Close()
+			closed <- err
+		}
+	}()
+
+	return closed, nil
+}
+
+// declareTenantQueues declares the tenant queues, bound to the exchange
+// under their own names like the main queue.
+func (c *Consumer) declareTenantQueues(ch *amqp.Channel) error {
+	queues := c.tenants.Queues()
+	if len(queues) == 0 {
+		return nil
+	}
+	if c.cfg.Exchange != "" {
+		if err := ch.ExchangeDeclare(c.cfg.Exchange, "direct", true, false, false, false, nil); err != nil {
+			return fmt.Errorf("failed to declare exchange: %w", err)
+		}
+	}
+	for id, queue := range queues {
+		if _, err := ch.QueueDeclare(queue, true, false, false, false, nil); err != nil {
+			return fmt.Errorf("failed to declare queue of tenant %s: %w", id, err)
+		}
+		if c.cfg.Exchange == "" {
+			continue
+		}
+		if err := ch.QueueBind(queue, queue, c.cfg.Exchange, false, nil); err != nil {
+			return fmt.Errorf("failed to bind queue of tenant %s: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// mergeClosed forwards the first close error of either channel.
+func mergeClosed(a, b <-chan *amqp.Error) <-chan *amqp.Error {
+	merged := make(chan *amqp.Error, 1)
+	go func() {
+		select {
+		case err := <-a:
+			merged <- err
+		case err := <-b:
+			merged <- err
+		}
+	}()
+	return merged
+}
+
+// markDown forgets the broken session. Its workers exit on their own once
+// the deliveries channel closes; what they held is redelivered by the broker.
+func (c *Consumer) markDown() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.conn, c.channel, c.publisher = nil, nil, nil
+	connectedGauge.Set(0)
+	if c.downSince.IsZero() {
+		c.downSince = time.Now()
+	}
+}
+
+func (c *Consumer) downtime() time.Duration {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.downSince.IsZero() {
+		return 0
+	}
+	return time.Since(c.downSince)
+}
@@ -0,0 +1,51 @@
+package consumer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoffBounds(t *testing.T) {
+	b := newBackoff(time.Second, 10*time.Second)
+	// The largest jitter shows the bound of every attempt.
+	b.jitter = func(n int64) int64 { return n - 1 }
+
+	for i, want := range []time.Duration{1, 2, 4, 8, 10, 10} {
+		if got := b.next(); got != want*time.Second {
+			t.Errorf("attempt %d waits up to %v, want %v", i+1, got, want*time.Second)
+		}
+	}
+
+	b.jitter = func(int64) int64 { return 0 }
+	if got := b.next(); got != 1 {
+		t.Errorf("smallest wait %v, want 1ns", got)
+	}
+
+	// With real jitter every wait stays within (0, max].
+	b = newBackoff(time.Millisecond, 50*time.Millisecond)
+	for i := 0; i < 100; i++ {
+		if wait := b.next(); wait <= 0 || wait > 50*time.Millisecond {
+			t.Fatalf("wait %v outside (0, 50ms]", wait)
+		}
+	}
+}
+
+func TestBackoffResetsAfterAStableSession(t *testing.T) {
+	b := newBackoff(time.Second, time.Minute)
+	b.jitter = func(n int64) int64 { return n - 1 }
+	for i := 0; i < 4; i++ {
+		b.next()
+	}
+
+	// A session that drops right away keeps backing off.
+	if wait := b.sessionEnded(time.Second); wait != 16*time.Second {
+		t.Errorf("after a short session: wait %v, want 16s", wait)
+	}
+	// A stable one reconnects at once and starts over.
+	if wait := b.sessionEnded(stableSession); wait != 0 {
+		t.Errorf("after a stable session: wait %v, want none", wait)
+	}
+	if wait := b.next(); wait != time.Second {
+		t.Errorf("first failure after a stable session waits %v, want 1s", wait)
+	}
+}
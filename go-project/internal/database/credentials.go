@@ -0,0 +1,84 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"balance-service/internal/metrics"
+	"balance-service/internal/secrets"
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"github.com/sirupsen/logrus"
+)
+
+// erAccessDenied is the MySQL error for rejected credentials.
+const erAccessDenied = 1045
+
+var authFailures = metrics.NewCounter("balance_db_auth_failures_total", "New database connections rejected because of their credentials.")
+
+// credentialConnector opens the pool's MySQL connections. A password given by
+// reference is fetched again before every new connection, and when the server
+// rejects the credentials the idle connections are dropped, so the pool is
+// rebuilt with the current secret instead of living on sessions opened with
+// the old one.
+type credentialConnector struct {
+	driver.Connector
+	pool      *sql.DB
+	log       *logrus.Logger
+	resetting atomic.Bool
+}
+
+func newCredentialPool(dsnConf *mysqldriver.Config, passwordRef string, log *logrus.Logger) (*sql.DB, error) {
+	if passwordRef != "" {
+		err := dsnConf.Apply(mysqldriver.BeforeConnect(func(ctx context.Context, conf *mysqldriver.Config) error {
+			password, err := secrets.Resolve(ctx, passwordRef)
+			if err != nil {
+				return err
+			}
+			conf.Passwd = password
+			return nil
+		}))
+		if err != nil {
+			return nil, fmt.Errorf("database credentials: %w", err)
+		}
+	}
+
+	base, err := mysqldriver.NewConnector(dsnConf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create connector: %w", err)
+	}
+
+	c := &credentialConnector{Connector: base, log: log}
+	c.pool = sql.OpenDB(c)
+	return c.pool, nil
+}
+
+func (c *credentialConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+
+	var mysqlErr *mysqldriver.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == erAccessDenied {
+		authFailures.Inc()
+		c.resetPool()
+	}
+	return conn, err
+}
+
+// resetPool closes every idle connection. It runs in the background because
+// Connect is called by database/sql while it manages the pool.
+func (c *credentialConnector) resetPool() {
+	if !c.resetting.CompareAndSwap(false, true) {
+		return
+	}
+
+	go func() {
+		defer c.resetting.Store(false)
+
+		c.log.Warn("database rejected the credentials, recreating the connection pool")
+		c.pool.SetMaxIdleConns(0)
+		c.pool.SetMaxIdleConns(maxIdleConns)
+	}()
+}
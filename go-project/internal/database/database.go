@@ -1,29 +1,40 @@
 package database
 
 import (
+	"context"
 	"fmt"
 	"time"
 
 	"balance-service/internal/config"
 	"balance-service/internal/model"
+	"balance-service/internal/tlsutil"
+	"github.com/glebarez/sqlite"
+	mysqldriver "github.com/go-sql-driver/mysql"
 	"github.com/sirupsen/logrus"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 )
 
+const (
+	DriverMySQL  = "mysql"
+	DriverSQLite = "sqlite"
+
+	maxOpenConns = 100
+	maxIdleConns = 25
+)
+
 type Database struct {
 	DB *gorm.DB
 }
 
 func New(cfg config.DatabaseConfig, log *logrus.Logger) (*Database, error) {
-	// MySQL DSN format: user:password@tcp(host:port)/dbname?parseTime=true
-	dsn := fmt.Sprintf(
-		"%s:%s@tcp(%s:%d)/%s?parseTime=true&charset=utf8mb4&collation=utf8mb4_unicode_ci",
-		cfg.User, cfg.Password, cfg.Host, cfg.Port, cfg.DBName,
-	)
+	dialector, err := dialectorFor(cfg, log)
+	if err != nil {
+		return nil, err
+	}
 
-	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{
+	db, err := gorm.Open(dialector, &gorm.Config{
 		Logger: logger.Default.LogMode(logger.Silent),
 	})
 	if err != nil {
@@ -35,19 +46,192 @@ func New(cfg config.DatabaseConfig, log *logrus.Logger) (*Database, error) {
 		return nil, fmt.Errorf("failed to get sql DB: %w", err)
 	}
 
-	sqlDB.SetMaxOpenConns(100)
-	sqlDB.SetMaxIdleConns(25)
-	sqlDB.SetConnMaxLifetime(time.Hour)
-
-	if err := db.AutoMigrate(&model.Balance{}, &model.BalanceEvent{}); err != nil {
-		return nil, fmt.Errorf("failed to migrate schema: %w", err)
+	if cfg.Driver == DriverSQLite {
+		// SQLite serialises writers anyway; a single connection avoids
+		// SQLITE_BUSY between workers and keeps ":memory:" databases shared.
+		sqlDB.SetMaxOpenConns(1)
+	} else {
+		sqlDB.SetMaxOpenConns(maxOpenConns)
+		sqlDB.SetMaxIdleConns(maxIdleConns)
+		sqlDB.SetConnMaxLifetime(time.Hour)
 	}
 
-	log.WithFields(logrus.Fields{
-		"host": cfg.Host,
-		"port": cfg.Port,
-		"db":   cfg.DBName,
-	}).Info("connected to MySQL")
+	if cfg.Driver == DriverSQLite {
+		log.WithField("path", cfg.Path).Info("connected to SQLite")
+	} else {
+		log.WithFields(logrus.Fields{
+			"host": cfg.Host,
+			"port": cfg.Port,
+			"db":   cfg.DBName,
+		}).Info("connected to MySQL")
+	}
 
 	return &Database{DB: db}, nil
 }
+
+func dialectorFor(cfg config.DatabaseConfig, log *logrus.Logger) (gorm.Dialector, error) {
+	switch cfg.Driver {
+	case "", DriverMySQL:
+		// MySQL DSN format: user:password@tcp(host:port)/dbname?parseTime=true.
+		// The password is set on the parsed config so it needs no escaping.
+		dsnConf, err := mysqldriver.ParseDSN(fmt.Sprintf(
+			"%s@tcp(%s:%d)/%s?parseTime=true&charset=utf8mb4&collation=utf8mb4_unicode_ci",
+			cfg.User, cfg.Host, cfg.Port, cfg.DBName,
+		))
+		if err != nil {
+			return nil, fmt.Errorf("invalid database address: %w", err)
+		}
+		dsnConf.Passwd = cfg.Password
+		if cfg.ReadOnly {
+			// Unknown DSN parameters are applied with SET on every new
+			// connection.
+			dsnConf.Params = map[string]string{"transaction_read_only": "1"}
+		}
+
+		if cfg.TLS.Enabled {
+			tlsCfg, err := tlsutil.Load(cfg.TLS)
+			if err != nil {
+				return nil, fmt.Errorf("database TLS: %w", err)
+			}
+			if tlsCfg.ServerName == "" {
+				tlsCfg.ServerName = cfg.Host
+			}
+			dsnConf.TLS = tlsCfg
+		}
+
+		conn, err := newCredentialPool(dsnConf, cfg.PasswordRef, log)
+		if err != nil {
+			return nil, err
+		}
+		return mysql.New(mysql.Config{Conn: conn, DSNConfig: dsnConf}), nil
+	case DriverSQLite:
+		dsn := cfg.Path + "?_pragma=busy_timeout(5000)&_pragma=foreign_keys(1)"
+		if cfg.ReadOnly {
+			dsn += "&_pragma=query_only(1)"
+		} else if cfg.Path != ":memory:" {
+			dsn += "&_pragma=journal_mode(WAL)"
+		}
+		return sqlite.Open(dsn), nil
+	default:
+		return nil, fmt.Errorf("unsupported database driver %q", cfg.Driver)
+	}
+}
+
+// Migrate creates or updates the schema of every table the service owns.
+// Rows written before balances were kept per tenant and currency are
+// assigned to the default tenant and defaultCurrency.
+func (d *Database) Migrate(defaultCurrency string) error {
+	if err := d.migrateWallets(defaultCurrency); err != nil {
+		return fmt.Errorf("failed to migrate to per-wallet balances: %w", err)
+	}
+	claimsExisted := d.DB.Migrator().HasTable(&model.ProcessedEvent{})
+	if err := d.DB.AutoMigrate(
+		&model.Balance{},
+		&model.BalanceEvent{},
+		&model.ProcessedEvent{},
+		&model.BootstrapWatermark{},
+		&model.WebhookSubscription{},
+		&model.WebhookDelivery{},
+		&model.Alert{},
+		&model.PendingUpdate{},
+		&model.LedgerAccount{},
+		&model.LedgerJournal{},
+		&model.LedgerEntry{},
+		&model.BalanceSnapshot{},
+		&model.SnapshotDay{},
+	); err != nil {
+		return fmt.Errorf("failed to migrate schema: %w", err)
+	}
+	if !claimsExisted {
+		if err := d.claimStoredEvents(); err != nil {
+			return fmt.Errorf("failed to claim stored event IDs: %w", err)
+		}
+	}
+	return nil
+}
+
+// claimStoredEvents fills a new processed_event_ids table from the events
+// recorded before it existed, so that their redeliveries stay deduplicated.
+func (d *Database) claimStoredEvents() error {
+	return d.DB.Exec(`INSERT INTO ` + model.ProcessedEvent{}.TableName() + ` (tenant_id, event_id, created_at)
+		SELECT tenant_id, event_id, MIN(created_at) FROM ` + model.BalanceEvent{}.TableName() + `
+		WHERE event_id <> '' GROUP BY tenant_id, event_id`).Error
+}
+
+// walletTables are the tables keyed by wallet, and the indexes they had
+// before the tenant and the currency became part of the key: the unique keys
+// of the older schema and the user index of the events, which the index
+// named after the table replaced.
+var walletTables = []struct {
+	model      interface{}
+	oldIndexes []string
+}{
+	{&model.Balance{}, []string{"idx_user_id", "idx_user_currency"}},
+	{&model.BalanceEvent{}, []string{"idx_user_id"}},
+	{&model.PendingUpdate{}, []string{"idx_pending_user_version", "idx_pending_wallet_version"}},
+	{&model.Alert{}, nil},
+}
+
+// migrateWallets moves an older schema to one balance per tenant, user and
+// currency. AutoMigrate cannot add a NOT NULL column to a filled table
+// without a default, so the wallet columns are added here with a default
+// for the existing rows: defaultCurrency, and the default tenant. The
+// indexes of the older keys are dropped before AutoMigrate creates the new
+// ones.
+func (d *Database) migrateWallets(defaultCurrency string) error {
+	columns := []struct {
+		name       string
+		definition string
+	}{
+		{"currency", fmt.Sprintf("VARCHAR(3) NOT NULL DEFAULT '%s'", defaultCurrency)},
+		{"tenant_id", "VARCHAR(64) NOT NULL DEFAULT ''"},
+	}
+
+	m := d.DB.Migrator()
+	for _, t := range walletTables {
+		if !m.HasTable(t.model) {
+			continue
+		}
+		stmt := &gorm.Statement{DB: d.DB}
+		if err := stmt.Parse(t.model); err != nil {
+			return err
+		}
+		for _, c := range columns {
+			if m.HasColumn(t.model, c.name) {
+				continue
+			}
+			err := d.DB.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s",
+				d.DB.Statement.Quote(stmt.Schema.Table), c.name, c.definition)).Error
+			if err != nil {
+				return fmt.Errorf("add %s to %s: %w", c.name, stmt.Schema.Table, err)
+			}
+		}
+		for _, index := range t.oldIndexes {
+			if !m.HasIndex(t.model, index) {
+				continue
+			}
+			if err := m.DropIndex(t.model, index); err != nil {
+				return fmt.Errorf("drop index %s: %w", index, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Close releases the connection pool.
+func (d *Database) Close() error {
+	sqlDB, err := d.DB.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
+
+// Ping checks that the database accepts connections.
+func (d *Database) Ping(ctx context.Context) error {
+	sqlDB, err := d.DB.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
@@ -0,0 +1,36 @@
+package database
+
+import (
+	"testing"
+
+	"balance-service/internal/model"
+)
+
+func TestMigrateDropsTheOldEventUserIndex(t *testing.T) {
+	db, _ := OpenTest(t)
+	m := db.Migrator()
+	// The index of the user column carried this name before it was named
+	// after the events table.
+	if err := db.Exec("CREATE INDEX idx_user_id ON balance_events (user_id)").Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// SQLite may rebuild the table in AutoMigrate, which MySQL does not, so
+	// the step that drops the index is checked on its own.
+	d := &Database{DB: db}
+	if err := d.migrateWallets("USD"); err != nil {
+		t.Fatal(err)
+	}
+	if m.HasIndex(&model.BalanceEvent{}, "idx_user_id") {
+		t.Error("idx_user_id is left on balance_events")
+	}
+	if err := d.Migrate("USD"); err != nil {
+		t.Fatal(err)
+	}
+	if !m.HasIndex(&model.BalanceEvent{}, "idx_balance_events_user_id") {
+		t.Error("idx_balance_events_user_id is missing")
+	}
+	if !m.HasIndex(&model.Balance{}, "idx_wallet") {
+		t.Error("the wallet key of balances is missing")
+	}
+}
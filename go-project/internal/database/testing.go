@@ -0,0 +1,28 @@
+package database
+
+import (
+	"io"
+	"testing"
+
+	"balance-service/internal/config"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// OpenTest opens a migrated in-memory SQLite database for a test, with USD
+// as the default currency, and closes it when the test ends. The logger it
+// returns discards its output.
+func OpenTest(t testing.TB) (*gorm.DB, *logrus.Logger) {
+	t.Helper()
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	db, err := New(config.DatabaseConfig{Driver: DriverSQLite, Path: ":memory:"}, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := db.Migrate("USD"); err != nil {
+		t.Fatal(err)
+	}
+	return db.DB, log
+}
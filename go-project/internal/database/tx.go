@@ -0,0 +1,17 @@
+package database
+
+import (
+	"database/sql"
+
+	"gorm.io/gorm"
+)
+
+// SnapshotTx returns the options of a transaction that reads one consistent
+// snapshot of db, read-only if readOnly is set. SQLite transactions are
+// snapshots already and its driver rejects isolation levels, so it gets nil.
+func SnapshotTx(db *gorm.DB, readOnly bool) *sql.TxOptions {
+	if db.Dialector.Name() == DriverSQLite {
+		return nil
+	}
+	return &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: readOnly}
+}
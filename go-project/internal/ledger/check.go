@@ -0,0 +1,231 @@
+package ledger
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"balance-service/internal/database"
+	"balance-service/internal/metrics"
+	"balance-service/internal/model"
+	"balance-service/internal/repository"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// Kinds of invariant violation.
+const (
+	// ViolationTotals is a tenant and currency whose balances do not sum to
+	// its wallet accounts, or whose accounts do not sum to zero.
+	ViolationTotals = "totals"
+	// ViolationJournal is a journal whose entries do not balance.
+	ViolationJournal = "unbalanced_journal"
+	// ViolationAccount is an account whose running balance is not the sum
+	// of its entries.
+	ViolationAccount = "account_drift"
+	// ViolationProjection is a stored balance that differs from its wallet
+	// account, or has none.
+	ViolationProjection = "projection_drift"
+)
+
+var (
+	checks     = metrics.NewCounter("balance_ledger_checks_total", "Ledger invariant checks, by result.", "result")
+	violations = metrics.NewGauge("balance_ledger_violations", "Invariant violations found by the last ledger check, by kind.", "kind")
+)
+
+// Total compares one tenant and currency: the balances table, the wallet
+// accounts and the counter-accounts.
+type Total struct {
+	TenantID string  `json:"tenant_id,omitempty"`
+	Currency string  `json:"currency"`
+	Balances float64 `json:"balances"`
+	Wallets  float64 `json:"wallets"`
+	Counters float64 `json:"counters"`
+}
+
+// Balanced reports whether the balances equal the wallet accounts and the
+// ledger as a whole sums to zero.
+func (t Total) Balanced() bool {
+	return round(t.Balances-t.Wallets) == 0 && round(t.Wallets+t.Counters) == 0
+}
+
+// Report is the result of a check. Every violation kind lists at most
+// MaxDetails findings; a kind with more is counted as MaxDetails+1 and
+// marks the report Truncated.
+type Report struct {
+	CheckedAt          time.Time                    `json:"checked_at"`
+	Duration           string                       `json:"duration"`
+	Totals             []Total                      `json:"totals"`
+	Violations         map[string]int               `json:"violations"`
+	UnbalancedJournals []uint                       `json:"unbalanced_journals,omitempty"`
+	DriftingAccounts   []repository.AccountDrift    `json:"drifting_accounts,omitempty"`
+	DriftingBalances   []repository.ProjectionDrift `json:"drifting_balances,omitempty"`
+	Truncated          bool                         `json:"truncated,omitempty"`
+}
+
+// OK reports whether every invariant holds.
+func (r *Report) OK() bool {
+	for _, n := range r.Violations {
+		if n > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// MaxDetails caps the findings a report lists per kind.
+const MaxDetails = 100
+
+// Check asserts the ledger invariants: the balances of every tenant and
+// currency sum to their wallet accounts and the ledger to zero, every
+// journal balances, every account's running balance is the sum of its
+// entries, and every stored balance matches its wallet account. All of it
+// is read in one transaction, so batches committed while the check runs
+// cannot make consistent tables look inconsistent.
+func Check(ctx context.Context, db *gorm.DB, log *logrus.Logger) (*Report, error) {
+	var report *Report
+	err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		report, err = check(ctx, repository.NewLedgerRepository(tx, log))
+		return err
+	}, database.SnapshotTx(db, true))
+	if err != nil {
+		checks.Inc("failed")
+		return nil, err
+	}
+	checks.Inc("ok")
+	for _, kind := range []string{ViolationTotals, ViolationJournal, ViolationAccount, ViolationProjection} {
+		violations.Set(float64(report.Violations[kind]), kind)
+	}
+	return report, nil
+}
+
+func check(ctx context.Context, repo *repository.LedgerRepository) (*Report, error) {
+	report := &Report{CheckedAt: time.Now().UTC(), Violations: map[string]int{ViolationTotals: 0}}
+	started := time.Now()
+
+	totals, err := repo.Totals(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("sum balances: %w", err)
+	}
+	byWallet := make(map[[2]string]*Total)
+	for _, t := range totals {
+		key := [2]string{t.TenantID, t.Currency}
+		total, ok := byWallet[key]
+		if !ok {
+			total = &Total{TenantID: t.TenantID, Currency: t.Currency}
+			byWallet[key] = total
+		}
+		switch t.Kind {
+		case "":
+			total.Balances = round(total.Balances + t.Total)
+		case model.LedgerWallet:
+			total.Wallets = round(total.Wallets + t.Total)
+		default:
+			total.Counters = round(total.Counters + t.Total)
+		}
+	}
+	for _, total := range byWallet {
+		report.Totals = append(report.Totals, *total)
+		if !total.Balanced() {
+			report.Violations[ViolationTotals]++
+		}
+	}
+	sort.Slice(report.Totals, func(i, j int) bool {
+		a, b := report.Totals[i], report.Totals[j]
+		if a.TenantID != b.TenantID {
+			return a.TenantID < b.TenantID
+		}
+		return a.Currency < b.Currency
+	})
+
+	if report.UnbalancedJournals, err = repo.UnbalancedJournals(ctx, MaxDetails+1); err != nil {
+		return nil, fmt.Errorf("check journals: %w", err)
+	}
+	if report.DriftingAccounts, err = repo.DriftingAccounts(ctx, MaxDetails+1); err != nil {
+		return nil, fmt.Errorf("check accounts: %w", err)
+	}
+	if report.DriftingBalances, err = repo.DriftingBalances(ctx, MaxDetails+1); err != nil {
+		return nil, fmt.Errorf("check balances: %w", err)
+	}
+	report.Violations[ViolationJournal] = len(report.UnbalancedJournals)
+	report.Violations[ViolationAccount] = len(report.DriftingAccounts)
+	report.Violations[ViolationProjection] = len(report.DriftingBalances)
+	if len(report.UnbalancedJournals) > MaxDetails {
+		report.UnbalancedJournals, report.Truncated = report.UnbalancedJournals[:MaxDetails], true
+	}
+	if len(report.DriftingAccounts) > MaxDetails {
+		report.DriftingAccounts, report.Truncated = report.DriftingAccounts[:MaxDetails], true
+	}
+	if len(report.DriftingBalances) > MaxDetails {
+		report.DriftingBalances, report.Truncated = report.DriftingBalances[:MaxDetails], true
+	}
+
+	report.Duration = time.Since(started).Round(time.Millisecond).String()
+	return report, nil
+}
+
+// Schedule runs Check every interval until ctx is cancelled.
+func Schedule(ctx context.Context, db *gorm.DB, interval time.Duration, log *logrus.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		report, err := Check(ctx, db, log)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.WithError(err).Error("ledger check failed")
+			}
+			continue
+		}
+
+		entry := log.WithFields(logrus.Fields{
+			"violations": report.Violations,
+			"duration":   report.Duration,
+		})
+		if report.OK() {
+			entry.Info("ledger check passed")
+		} else {
+			entry.Error("ledger invariants violated")
+		}
+	}
+}
+
+// Open posts opening journals for the stored balances that have no wallet
+// account yet, such as those from before the ledger, batchSize at a time.
+// It returns how many wallets it opened.
+func Open(ctx context.Context, db *gorm.DB, batchSize int, log *logrus.Logger) (int, error) {
+	repo := repository.NewLedgerRepository(db, log)
+	var opened int
+	var after uint
+	for {
+		page, err := repo.ListUnopenedBalances(ctx, after, batchSize)
+		if err != nil {
+			return opened, fmt.Errorf("list unopened balances: %w", err)
+		}
+		if len(page) == 0 {
+			return opened, nil
+		}
+
+		postings := make([]Posting, len(page))
+		for i, b := range page {
+			postings[i] = Posting{Kind: model.LedgerOpening, Balance: b}
+		}
+		err = db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			_, err := New(tx, log).Apply(ctx, postings)
+			return err
+		})
+		if err != nil {
+			return opened, fmt.Errorf("open wallets after balance %d: %w", after, err)
+		}
+		opened += len(page)
+		after = page[len(page)-1].ID
+	}
+}
@@ -0,0 +1,374 @@
+// Package ledger keeps the double-entry record behind the balances. Every
+// applied change of a wallet is a journal of two immutable entries that sum
+// to zero: one on the wallet's account and one on the counter-account of the
+// change's kind, such as adjustments for updates from the producer. Accounts
+// keep running balances, and the balances table is a projection of the
+// wallet accounts written in the same transaction as the entries.
+//
+// Every batch moves the counter-accounts it posts to, and the row stays
+// locked until its transaction commits, so a single counter-account per
+// tenant and currency would let only one batch of a tenant commit at a time
+// whatever wallets it touched. Counter-accounts are therefore split into
+// CounterShards accounts, numbered by their user ID, and each batch posts to
+// one of them picked at random; their sum is the counter-account.
+package ledger
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+
+	"balance-service/internal/model"
+	"balance-service/internal/repository"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// CounterShards is how many accounts each counter-account is split into.
+// Concurrent batches of a tenant and currency wait for each other only when
+// they pick the same one.
+const CounterShards = 16
+
+// Posting is the new state of a wallet, reached through a change of Kind.
+// Like the balances upsert, a posting older than the wallet's version is
+// ignored.
+type Posting struct {
+	Kind    string        // model.LedgerAdjustment, LedgerReconciliation or LedgerOpening
+	Balance model.Balance // the wallet, its new amount and version
+	EventID string
+}
+
+// Ledger posts to the ledger tables and the balances projection of one
+// database handle. Build it on a transaction so that the postings commit
+// together with the caller's other writes.
+type Ledger struct {
+	ledger   *repository.LedgerRepository
+	balances *repository.BalanceRepository
+	log      *logrus.Logger
+}
+
+func New(db *gorm.DB, log *logrus.Logger) *Ledger {
+	return &Ledger{
+		ledger:   repository.NewLedgerRepository(db, log),
+		balances: repository.NewBalanceRepository(db, log),
+		log:      log,
+	}
+}
+
+// counterKey identifies a counter-account.
+type counterKey struct {
+	kind     string
+	tenantID string
+	currency string
+}
+
+// movement is a journal of a batch before it is saved.
+type movement struct {
+	journal model.LedgerJournal
+	wallet  *model.LedgerAccount
+	amount  float64 // moved into the wallet account
+	after   float64 // the wallet account's balance after it
+	counter counterKey
+}
+
+// Apply posts the changes that take the wallets to the states in postings
+// and projects the wallet accounts into the balances table. The wallet
+// accounts stay locked until the surrounding transaction ends, so concurrent
+// batches post one after the other. Postings older than their wallet are
+// skipped; Apply returns the ones it applied, in wallet order.
+func (l *Ledger) Apply(ctx context.Context, postings []Posting) ([]Posting, error) {
+	if len(postings) == 0 {
+		return nil, nil
+	}
+	postings = append([]Posting(nil), postings...)
+	sort.SliceStable(postings, func(i, j int) bool {
+		return postings[i].Balance.Key().Less(postings[j].Balance.Key())
+	})
+
+	shard := uint(rand.Intn(CounterShards))
+	wallets, err := l.lockWallets(ctx, postings, shard)
+	if err != nil {
+		return nil, err
+	}
+
+	var moves []movement
+	touched := make(map[model.WalletKey]bool)
+	move := func(account *model.LedgerAccount, kind string, amount float64, version uint, eventID string) {
+		delta := round(amount - account.Balance)
+		previous := account.Version
+		account.Version = version
+		touched[account.Wallet()] = true
+		if delta == 0 {
+			return
+		}
+		account.Balance = round(account.Balance + delta)
+		moves = append(moves, movement{
+			journal: model.LedgerJournal{
+				Kind:            kind,
+				UserID:          account.UserID,
+				TenantID:        account.TenantID,
+				Currency:        account.Currency,
+				Version:         version,
+				PreviousVersion: previous,
+				EventID:         eventID,
+			},
+			wallet:  account,
+			amount:  delta,
+			after:   account.Balance,
+			counter: counterKey{kind: kind, tenantID: account.TenantID, currency: account.Currency},
+		})
+	}
+
+	// Balances from before the ledger are opened the first time their
+	// wallet is posted to.
+	if err := l.openProjected(ctx, wallets, move); err != nil {
+		return nil, err
+	}
+	applied := postings[:0]
+	for _, p := range postings {
+		account := wallets[p.Balance.Key()]
+		if p.Balance.Version < account.Version {
+			continue
+		}
+		move(account, p.Kind, p.Balance.Amount, p.Balance.Version, p.EventID)
+		applied = append(applied, p)
+	}
+
+	if err := l.save(ctx, moves, shard); err != nil {
+		return nil, err
+	}
+
+	projection := make([]model.Balance, 0, len(touched))
+	for key := range touched {
+		account := wallets[key]
+		if err := l.ledger.SetAccount(ctx, account); err != nil {
+			return nil, err
+		}
+		projection = append(projection, model.Balance{
+			UserID:   account.UserID,
+			TenantID: account.TenantID,
+			Currency: account.Currency,
+			Amount:   account.Balance,
+			Version:  account.Version,
+		})
+	}
+	sort.Slice(projection, func(i, j int) bool { return projection[i].Key().Less(projection[j].Key()) })
+	if err := l.balances.SaveBalancesBatch(ctx, projection); err != nil {
+		return nil, err
+	}
+	return applied, nil
+}
+
+// Lock creates the wallet accounts of wallets and locks every wallet account
+// of their users until the surrounding transaction ends, as Apply does. It
+// returns the stored balances of those users, which no other batch can move
+// until then, so that the caller can compare its postings with the state
+// they will be applied to.
+func (l *Ledger) Lock(ctx context.Context, wallets []model.WalletKey) ([]model.Balance, error) {
+	if len(wallets) == 0 {
+		return nil, nil
+	}
+	accounts := make([]model.LedgerAccount, 0, len(wallets))
+	userIDs := make([]uint, 0, len(wallets))
+	for _, key := range wallets {
+		accounts = append(accounts, model.LedgerAccount{Kind: model.LedgerWallet, TenantID: key.TenantID, UserID: key.UserID, Currency: key.Currency})
+		userIDs = append(userIDs, key.UserID)
+	}
+	if err := l.ledger.EnsureAccounts(ctx, accounts); err != nil {
+		return nil, fmt.Errorf("create ledger accounts: %w", err)
+	}
+	if _, err := l.ledger.AccountsOf(ctx, model.LedgerWallet, userIDs, true); err != nil {
+		return nil, fmt.Errorf("lock wallet accounts: %w", err)
+	}
+	return l.balances.GetBalancesByUserIDs(ctx, userIDs)
+}
+
+// lockWallets creates the accounts the postings need, with the counter-
+// accounts of shard, and locks the wallet accounts.
+func (l *Ledger) lockWallets(ctx context.Context, postings []Posting, shard uint) (map[model.WalletKey]*model.LedgerAccount, error) {
+	var (
+		accounts []model.LedgerAccount
+		userIDs  []uint
+		seen     = make(map[model.WalletKey]bool)
+		counters = make(map[counterKey]bool)
+	)
+	for _, p := range postings {
+		key := p.Balance.Key()
+		if !seen[key] {
+			seen[key] = true
+			userIDs = append(userIDs, key.UserID)
+			accounts = append(accounts, model.LedgerAccount{Kind: model.LedgerWallet, TenantID: key.TenantID, UserID: key.UserID, Currency: key.Currency})
+		}
+		for _, kind := range []string{p.Kind, model.LedgerOpening} {
+			c := counterKey{kind: kind, tenantID: key.TenantID, currency: key.Currency}
+			if !counters[c] {
+				counters[c] = true
+				accounts = append(accounts, model.LedgerAccount{Kind: kind, TenantID: key.TenantID, UserID: shard, Currency: key.Currency})
+			}
+		}
+	}
+	if err := l.ledger.EnsureAccounts(ctx, accounts); err != nil {
+		return nil, fmt.Errorf("create ledger accounts: %w", err)
+	}
+
+	locked, err := l.ledger.AccountsOf(ctx, model.LedgerWallet, userIDs, true)
+	if err != nil {
+		return nil, fmt.Errorf("lock wallet accounts: %w", err)
+	}
+	wallets := make(map[model.WalletKey]*model.LedgerAccount, len(seen))
+	for i := range locked {
+		if key := locked[i].Wallet(); seen[key] {
+			wallets[key] = &locked[i]
+		}
+	}
+	if len(wallets) != len(seen) {
+		return nil, fmt.Errorf("lock wallet accounts: found %d of %d", len(wallets), len(seen))
+	}
+	return wallets, nil
+}
+
+// openProjected opens the wallet accounts that were never posted to from
+// the balances table, as an opening journal.
+func (l *Ledger) openProjected(ctx context.Context, wallets map[model.WalletKey]*model.LedgerAccount, move func(*model.LedgerAccount, string, float64, uint, string)) error {
+	var userIDs []uint
+	for key, account := range wallets {
+		if account.Version == 0 && account.Balance == 0 {
+			userIDs = append(userIDs, key.UserID)
+		}
+	}
+	if len(userIDs) == 0 {
+		return nil
+	}
+
+	projected, err := l.balances.GetBalancesByUserIDs(ctx, userIDs)
+	if err != nil {
+		return err
+	}
+	sort.Slice(projected, func(i, j int) bool { return projected[i].Key().Less(projected[j].Key()) })
+	for _, b := range projected {
+		account, ok := wallets[b.Key()]
+		if !ok || account.Version != 0 || account.Balance != 0 || (b.Version == 0 && b.Amount == 0) {
+			continue
+		}
+		move(account, model.LedgerOpening, b.Amount, b.Version, "")
+	}
+	return nil
+}
+
+// save writes the journals and their entries, and moves the counter-accounts
+// of shard.
+func (l *Ledger) save(ctx context.Context, moves []movement, shard uint) error {
+	if len(moves) == 0 {
+		return nil
+	}
+
+	journals := make([]model.LedgerJournal, len(moves))
+	for i, m := range moves {
+		journals[i] = m.journal
+	}
+	if err := l.ledger.SaveJournals(ctx, journals); err != nil {
+		return fmt.Errorf("save ledger journals: %w", err)
+	}
+
+	counters, err := l.moveCounters(ctx, moves, shard)
+	if err != nil {
+		return err
+	}
+
+	entries := make([]model.LedgerEntry, 0, 2*len(moves))
+	for i, m := range moves {
+		counter := counters[m.counter]
+		entries = append(entries,
+			model.LedgerEntry{JournalID: journals[i].ID, AccountID: m.wallet.ID, Amount: m.amount, BalanceAfter: m.after},
+			model.LedgerEntry{JournalID: journals[i].ID, AccountID: counter.id, Amount: -m.amount, BalanceAfter: counter.next(-m.amount)},
+		)
+	}
+	if err := l.ledger.SaveEntries(ctx, entries); err != nil {
+		return fmt.Errorf("save ledger entries: %w", err)
+	}
+	return nil
+}
+
+// counterBalance walks a counter-account's running balance through the
+// entries of a batch.
+type counterBalance struct {
+	id      uint
+	balance float64
+}
+
+func (c *counterBalance) next(amount float64) float64 {
+	c.balance = round(c.balance + amount)
+	return c.balance
+}
+
+// moveCounters adds the batch's total to each counter-account of shard in
+// one update and returns the balances the accounts had before it.
+func (l *Ledger) moveCounters(ctx context.Context, moves []movement, shard uint) (map[counterKey]*counterBalance, error) {
+	totals := make(map[counterKey]float64)
+	var kinds []string
+	for _, m := range moves {
+		if _, ok := totals[m.counter]; !ok {
+			kinds = append(kinds, m.counter.kind)
+		}
+		totals[m.counter] = round(totals[m.counter] - m.amount)
+	}
+
+	ids := make(map[counterKey]uint)
+	for _, kind := range dedupe(kinds) {
+		accounts, err := l.ledger.AccountsOf(ctx, kind, []uint{shard}, false)
+		if err != nil {
+			return nil, fmt.Errorf("read counter-accounts: %w", err)
+		}
+		for _, a := range accounts {
+			ids[counterKey{kind: a.Kind, tenantID: a.TenantID, currency: a.Currency}] = a.ID
+		}
+	}
+
+	keys := make([]counterKey, 0, len(totals))
+	for key := range totals {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.kind != b.kind {
+			return a.kind < b.kind
+		}
+		if a.tenantID != b.tenantID {
+			return a.tenantID < b.tenantID
+		}
+		return a.currency < b.currency
+	})
+
+	counters := make(map[counterKey]*counterBalance, len(keys))
+	for _, key := range keys {
+		id, ok := ids[key]
+		if !ok {
+			return nil, fmt.Errorf("counter-account %s of tenant %q in %s does not exist", key.kind, key.tenantID, key.currency)
+		}
+		after, err := l.ledger.AddToAccount(ctx, id, totals[key])
+		if err != nil {
+			return nil, fmt.Errorf("move counter-account %s: %w", key.kind, err)
+		}
+		counters[key] = &counterBalance{id: id, balance: round(after - totals[key])}
+	}
+	return counters, nil
+}
+
+func dedupe(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	out := values[:0]
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// round keeps amounts at the cents of the decimal columns.
+func round(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
@@ -0,0 +1,289 @@
+package ledger
+
+import (
+	"context"
+	"testing"
+
+	"balance-service/internal/database"
+	"balance-service/internal/model"
+	"balance-service/internal/repository"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+func apply(t *testing.T, db *gorm.DB, log *logrus.Logger, postings ...Posting) []Posting {
+	t.Helper()
+	var applied []Posting
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		applied, err = New(tx, log).Apply(context.Background(), postings)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return applied
+}
+
+func posting(userID uint, amount float64, version uint) Posting {
+	return Posting{
+		Kind:    model.LedgerAdjustment,
+		Balance: model.Balance{UserID: userID, Currency: "USD", Amount: amount, Version: version},
+	}
+}
+
+func balanceOf(t *testing.T, db *gorm.DB, log *logrus.Logger, userID uint) *model.Balance {
+	t.Helper()
+	b, err := repository.NewBalanceRepository(db, log).GetBalance(context.Background(), model.WalletKey{UserID: userID, Currency: "USD"})
+	if err != nil {
+		t.Fatalf("user %d: %v", userID, err)
+	}
+	return b
+}
+
+func checkOK(t *testing.T, db *gorm.DB, log *logrus.Logger) {
+	t.Helper()
+	report, err := Check(context.Background(), db, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() {
+		t.Errorf("ledger invariants violated: %+v", report)
+	}
+}
+
+func TestApplyBalancesEveryJournal(t *testing.T) {
+	db, log := database.OpenTest(t)
+	apply(t, db, log, posting(1, 100, 1), posting(2, 50.5, 1))
+	apply(t, db, log, posting(1, 40.25, 2), posting(2, -10, 2))
+	apply(t, db, log, Posting{Kind: model.LedgerReconciliation, Balance: model.Balance{UserID: 1, Currency: "USD", Amount: 41, Version: 3}})
+	// Many batches spread over the counter-account shards.
+	for v := uint(3); v < 3+2*CounterShards; v++ {
+		apply(t, db, log, posting(2, float64(v), v))
+	}
+
+	var sums []struct {
+		JournalID uint
+		Total     float64
+		Entries   int
+	}
+	err := db.Model(&model.LedgerEntry{}).
+		Select("journal_id, SUM(amount) AS total, COUNT(*) AS entries").
+		Group("journal_id").
+		Scan(&sums).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sums) != 5+2*CounterShards {
+		t.Errorf("%d journals, want %d", len(sums), 5+2*CounterShards)
+	}
+	for _, s := range sums {
+		if round(s.Total) != 0 || s.Entries != 2 {
+			t.Errorf("journal %d has %d entries summing to %v", s.JournalID, s.Entries, s.Total)
+		}
+	}
+
+	var counters []model.LedgerAccount
+	if err := db.Where("kind <> ?", model.LedgerWallet).Find(&counters).Error; err != nil {
+		t.Fatal(err)
+	}
+	var total float64
+	for _, c := range counters {
+		if c.UserID >= CounterShards {
+			t.Errorf("counter-account %d in shard %d of %d", c.ID, c.UserID, CounterShards)
+		}
+		total += c.Balance
+	}
+	if want := -(41 + float64(2+2*CounterShards)); round(total) != want {
+		t.Errorf("counter-accounts sum to %v, want %v", round(total), want)
+	}
+
+	if b := balanceOf(t, db, log, 1); b.Amount != 41 || b.Version != 3 {
+		t.Errorf("user 1 at %v v%d, want 41 v3", b.Amount, b.Version)
+	}
+	checkOK(t, db, log)
+}
+
+func TestApplySkipsStalePostings(t *testing.T) {
+	db, log := database.OpenTest(t)
+	apply(t, db, log, posting(1, 100, 5))
+	if applied := apply(t, db, log, posting(1, 30, 3)); len(applied) != 0 {
+		t.Errorf("applied %+v, want the stale posting skipped", applied)
+	}
+	// Within a batch, postings older than one applied before them are
+	// skipped too.
+	applied := apply(t, db, log, posting(1, 70, 7), posting(1, 60, 6), posting(1, 10, 4), posting(2, 1, 1))
+	if len(applied) != 2 || applied[0].Balance.Version != 7 || applied[1].Balance.UserID != 2 {
+		t.Errorf("applied %+v, want user 1 at v7 and user 2", applied)
+	}
+
+	if b := balanceOf(t, db, log, 1); b.Amount != 70 || b.Version != 7 {
+		t.Errorf("user 1 at %v v%d, want 70 v7", b.Amount, b.Version)
+	}
+	var journals []model.LedgerJournal
+	if err := db.Where("user_id = ?", 1).Order("id").Find(&journals).Error; err != nil {
+		t.Fatal(err)
+	}
+	var versions []uint
+	for _, j := range journals {
+		versions = append(versions, j.Version)
+	}
+	if len(versions) != 2 || versions[0] != 5 || versions[1] != 7 {
+		t.Errorf("journals of user 1 of versions %v, want 5 and 7", versions)
+	}
+	checkOK(t, db, log)
+}
+
+func TestApplyRecordsVersionWithoutMovement(t *testing.T) {
+	db, log := database.OpenTest(t)
+	apply(t, db, log, posting(1, 100, 1))
+	apply(t, db, log, posting(1, 100, 2))
+
+	var journals int64
+	if err := db.Model(&model.LedgerJournal{}).Count(&journals).Error; err != nil {
+		t.Fatal(err)
+	}
+	if journals != 1 {
+		t.Errorf("%d journals, want none for an unchanged amount", journals)
+	}
+	if b := balanceOf(t, db, log, 1); b.Version != 2 {
+		t.Errorf("user 1 at v%d, want v2", b.Version)
+	}
+	checkOK(t, db, log)
+}
+
+func TestOpenPreLedgerBalances(t *testing.T) {
+	db, log := database.OpenTest(t)
+	ctx := context.Background()
+	// Balances written before the ledger have no accounts.
+	err := repository.NewBalanceRepository(db, log).SaveBalancesBatch(ctx, []model.Balance{
+		{UserID: 1, Currency: "USD", Amount: 10, Version: 2},
+		{UserID: 2, Currency: "USD", Amount: 20, Version: 1},
+		{UserID: 3, Currency: "EUR", Amount: 30, Version: 4},
+		{UserID: 4, TenantID: "acme", Currency: "USD", Amount: 40, Version: 1},
+		{UserID: 5, Currency: "USD", Amount: 50, Version: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// User 5 is opened by its first posting instead.
+	apply(t, db, log, posting(5, 55, 2))
+
+	report, err := Check(ctx, db, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Violations[ViolationProjection] != 4 {
+		t.Errorf("%d unopened balances reported, want 4", report.Violations[ViolationProjection])
+	}
+
+	opened, err := Open(ctx, db, 3, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opened != 4 {
+		t.Errorf("opened %d wallets, want 4", opened)
+	}
+	if again, err := Open(ctx, db, 3, log); err != nil || again != 0 {
+		t.Errorf("second Open opened %d, %v; want none", again, err)
+	}
+
+	var journals []model.LedgerJournal
+	if err := db.Order("user_id").Find(&journals).Error; err != nil {
+		t.Fatal(err)
+	}
+	kinds := make(map[uint][]string)
+	for _, j := range journals {
+		kinds[j.UserID] = append(kinds[j.UserID], j.Kind)
+	}
+	for user := uint(1); user <= 4; user++ {
+		if len(kinds[user]) != 1 || kinds[user][0] != model.LedgerOpening {
+			t.Errorf("user %d has journals %v, want one opening", user, kinds[user])
+		}
+	}
+	if len(kinds[5]) != 2 || kinds[5][0] != model.LedgerOpening || kinds[5][1] != model.LedgerAdjustment {
+		t.Errorf("user 5 has journals %v, want opening then adjustment", kinds[5])
+	}
+	if b := balanceOf(t, db, log, 1); b.Amount != 10 || b.Version != 2 {
+		t.Errorf("user 1 at %v v%d after opening, want 10 v2", b.Amount, b.Version)
+	}
+	checkOK(t, db, log)
+}
+
+func TestCheckFindsViolations(t *testing.T) {
+	for name, c := range map[string]struct {
+		corrupt string
+		want    map[string]int
+	}{
+		"projection amount": {
+			corrupt: "UPDATE balances SET amount = amount + 1 WHERE user_id = 1",
+			want:    map[string]int{ViolationTotals: 1, ViolationProjection: 1},
+		},
+		"projection version": {
+			corrupt: "UPDATE balances SET version = 9 WHERE user_id = 1",
+			want:    map[string]int{ViolationProjection: 1},
+		},
+		"balance without an account": {
+			corrupt: "INSERT INTO balances (user_id, tenant_id, currency, amount, version) VALUES (9, '', 'USD', 5, 1)",
+			want:    map[string]int{ViolationTotals: 1, ViolationProjection: 1},
+		},
+		"counter-account": {
+			corrupt: "UPDATE ledger_accounts SET balance = balance - 1 WHERE kind = 'adjustment'",
+			want:    map[string]int{ViolationTotals: 1, ViolationAccount: 1},
+		},
+		"wallet account": {
+			corrupt: "UPDATE ledger_accounts SET balance = balance + 1 WHERE kind = 'wallet' AND user_id = 2",
+			want:    map[string]int{ViolationTotals: 1, ViolationAccount: 1, ViolationProjection: 1},
+		},
+		"extra entry": {
+			corrupt: "INSERT INTO ledger_entries (journal_id, account_id, amount, balance_after) " +
+				"SELECT MIN(id), (SELECT id FROM ledger_accounts WHERE kind = 'wallet' AND user_id = 1), 0, 0 FROM ledger_journals",
+			want: map[string]int{ViolationJournal: 1},
+		},
+		"unbalanced entry": {
+			corrupt: "INSERT INTO ledger_entries (journal_id, account_id, amount, balance_after) " +
+				"SELECT MIN(id), (SELECT id FROM ledger_accounts WHERE kind = 'wallet' AND user_id = 1), 3, 0 FROM ledger_journals",
+			want: map[string]int{ViolationJournal: 1, ViolationAccount: 1},
+		},
+	} {
+		db, log := database.OpenTest(t)
+		// A single batch keeps the counter-account in one shard.
+		apply(t, db, log, posting(1, 10, 1), posting(2, 20, 1))
+		if err := db.Exec(c.corrupt).Error; err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		report, err := Check(context.Background(), db, log)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		for _, kind := range []string{ViolationTotals, ViolationJournal, ViolationAccount, ViolationProjection} {
+			if report.Violations[kind] != c.want[kind] {
+				t.Errorf("%s: %d %s violations, want %d", name, report.Violations[kind], kind, c.want[kind])
+			}
+		}
+		if report.OK() {
+			t.Errorf("%s: report OK", name)
+		}
+	}
+}
+
+func TestCheckTruncatesDetails(t *testing.T) {
+	db, log := database.OpenTest(t)
+	balances := make([]model.Balance, MaxDetails+5)
+	for i := range balances {
+		balances[i] = model.Balance{UserID: uint(i + 1), Currency: "USD", Amount: 1, Version: 1}
+	}
+	if err := repository.NewBalanceRepository(db, log).SaveBalancesBatch(context.Background(), balances); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := Check(context.Background(), db, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.DriftingBalances) != MaxDetails || !report.Truncated || report.Violations[ViolationProjection] != MaxDetails+1 {
+		t.Errorf("listed %d of %d drifting balances, truncated %v", len(report.DriftingBalances),
+			report.Violations[ViolationProjection], report.Truncated)
+	}
+}
@@ -0,0 +1,204 @@
+// Package metrics is a small, dependency free metrics registry that renders
+// the Prometheus text exposition format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Registry holds every metric that is exported on /metrics.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []collector
+	names   map[string]bool
+}
+
+type collector interface {
+	name() string
+	write(w io.Writer)
+}
+
+// Default is the registry used by the package level constructors.
+var Default = NewRegistry()
+
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.names[c.name()] {
+		panic(fmt.Sprintf("metrics: %s registered twice", c.name()))
+	}
+	r.names[c.name()] = true
+	r.metrics = append(r.metrics, c)
+}
+
+// Write renders all metrics in registration order.
+func (r *Registry) Write(w io.Writer) {
+	r.mu.Lock()
+	metrics := append([]collector(nil), r.metrics...)
+	r.mu.Unlock()
+
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+// Handler serves the registry in the Prometheus text format.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.Write(w)
+	})
+}
+
+// Handler serves the default registry.
+func Handler() http.Handler {
+	return Default.Handler()
+}
+
+// vec stores one float64 per label value combination.
+type vec struct {
+	metricName string
+	help       string
+	kind       string
+	labels     []string
+
+	mu     sync.RWMutex
+	series map[string]*series
+}
+
+type series struct {
+	labelValues []string
+	bits        uint64
+}
+
+func newVec(name, help, kind string, labels []string) *vec {
+	return &vec{
+		metricName: name,
+		help:       help,
+		kind:       kind,
+		labels:     labels,
+		series:     make(map[string]*series),
+	}
+}
+
+func (v *vec) name() string { return v.metricName }
+
+func (v *vec) get(labelValues []string) *series {
+	if len(labelValues) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.metricName, len(v.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+
+	v.mu.RLock()
+	s, ok := v.series[key]
+	v.mu.RUnlock()
+	if ok {
+		return s
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if s, ok = v.series[key]; !ok {
+		s = &series{labelValues: append([]string(nil), labelValues...)}
+		v.series[key] = s
+	}
+	return s
+}
+
+func (s *series) add(delta float64) {
+	for {
+		old := atomic.LoadUint64(&s.bits)
+		updated := math.Float64bits(math.Float64frombits(old) + delta)
+		if atomic.CompareAndSwapUint64(&s.bits, old, updated) {
+			return
+		}
+	}
+}
+
+func (s *series) set(value float64) {
+	atomic.StoreUint64(&s.bits, math.Float64bits(value))
+}
+
+func (s *series) value() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&s.bits))
+}
+
+func (v *vec) write(w io.Writer) {
+	v.mu.RLock()
+	all := make([]*series, 0, len(v.series))
+	for _, s := range v.series {
+		all = append(all, s)
+	}
+	v.mu.RUnlock()
+
+	sort.Slice(all, func(i, j int) bool {
+		return strings.Join(all[i].labelValues, ",") < strings.Join(all[j].labelValues, ",")
+	})
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", v.metricName, v.help, v.metricName, v.kind)
+	for _, s := range all {
+		fmt.Fprintf(w, "%s%s %v\n", v.metricName, formatLabels(v.labels, s.labelValues), s.value())
+	}
+}
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = fmt.Sprintf("%s=%q", name, values[i])
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Counter is a monotonically increasing value, optionally split by labels.
+type Counter struct{ v *vec }
+
+// NewCounter registers a counter in the default registry.
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{v: newVec(name, help, "counter", labels)}
+	Default.register(c.v)
+	return c
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.v.get(labelValues).add(1)
+}
+
+func (c *Counter) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		panic("metrics: counter cannot decrease")
+	}
+	c.v.get(labelValues).add(delta)
+}
+
+// Gauge is a value that can go up and down, optionally split by labels.
+type Gauge struct{ v *vec }
+
+// NewGauge registers a gauge in the default registry.
+func NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{v: newVec(name, help, "gauge", labels)}
+	Default.register(g.v)
+	return g
+}
+
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	g.v.get(labelValues).set(value)
+}
+
+func (g *Gauge) Add(delta float64, labelValues ...string) {
+	g.v.get(labelValues).add(delta)
+}
@@ -0,0 +1,28 @@
+package model
+
+import "time"
+
+// Alert is a hit of an alerting rule on a committed balance change.
+type Alert struct {
+	ID             uint      `gorm:"primarykey" json:"id"`
+	CreatedAt      time.Time `gorm:"index:idx_alerts_created_at" json:"created_at"`
+	Rule           string    `gorm:"size:100;not null" json:"rule"`
+	Kind           string    `gorm:"size:50;not null" json:"kind"`
+	UserID         uint      `gorm:"index:idx_alerts_user_id;not null" json:"user_id"`
+	TenantID       string    `gorm:"size:64" json:"tenant_id,omitempty"`
+	Currency       string    `gorm:"size:3" json:"currency"`
+	Amount         float64   `gorm:"type:decimal(15,2);not null" json:"amount"`
+	PreviousAmount *float64  `gorm:"type:decimal(15,2)" json:"previous_amount,omitempty"`
+	Version        uint      `gorm:"not null" json:"version"`
+	// Value is what the rule measured: the amount, the change or the
+	// updates in the last minute.
+	Value     float64 `json:"value"`
+	Threshold float64 `json:"threshold"`
+	EventID   string  `gorm:"size:255" json:"event_id,omitempty"`
+	Message   string  `gorm:"size:500" json:"message"`
+}
+
+// TableName specifies the table name
+func (Alert) TableName() string {
+	return "alerts"
+}
@@ -0,0 +1,26 @@
+package model
+
+import "time"
+
+// RestoredEvent is a balance event restored from the archive for an audit.
+// Restored events are kept apart from balance_events, so that retention does
+// not archive them again and the service never lists them as history.
+type RestoredEvent struct {
+	ID         uint          `gorm:"primarykey" json:"id"` // the ID the event had in balance_events
+	CreatedAt  time.Time     `json:"created_at"`
+	UserID     uint          `gorm:"index:idx_restored_events_wallet;not null" json:"user_id"`
+	TenantID   string        `gorm:"index:idx_restored_events_wallet;size:64;not null" json:"tenant_id,omitempty"`
+	Currency   string        `gorm:"index:idx_restored_events_wallet;size:3;not null" json:"currency"`
+	Amount     float64       `gorm:"type:decimal(15,2);not null" json:"amount"`
+	Version    uint          `gorm:"not null" json:"version"`
+	UpdatedAt  time.Time     `json:"updated_at"`
+	EventID    string        `gorm:"index:idx_restored_events_event_id;size:255" json:"event_id"`
+	Metadata   EventMetadata `gorm:"type:text" json:"metadata"`
+	Archive    string        `gorm:"size:255;not null" json:"archive"` // the archive file it came from
+	RestoredAt time.Time     `gorm:"not null" json:"restored_at"`
+}
+
+// TableName specifies the table name
+func (RestoredEvent) TableName() string {
+	return "balance_events_restored"
+}
@@ -1,15 +1,20 @@
 package model
 
 import (
+	"database/sql/driver"
+	"encoding/json"
 	"time"
 )
 
-// Balance represents the current balance state for a user
+// Balance represents the current balance state of one wallet of a user.
+// A user holds one wallet per tenant and currency.
 type Balance struct {
 	ID        uint      `gorm:"primarykey" json:"id"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
-	UserID    uint      `gorm:"uniqueIndex:idx_user_id;not null" json:"user_id"`
+	UserID    uint      `gorm:"uniqueIndex:idx_wallet;not null" json:"user_id"`
+	TenantID  string    `gorm:"uniqueIndex:idx_wallet;index:idx_balances_tenant;size:64;not null" json:"tenant_id,omitempty"`
+	Currency  string    `gorm:"uniqueIndex:idx_wallet;size:3;not null" json:"currency"`
 	Amount    float64   `gorm:"type:decimal(15,2);not null;default:0" json:"amount"`
 	Version   uint      `gorm:"not null;default:0" json:"version"`
 }
@@ -19,18 +24,149 @@ func (Balance) TableName() string {
 	return "balances"
 }
 
-// BalanceEvent represents a balance update event from RabbitMQ
+// MaxAmount bounds the absolute value of an amount so that it fits the
+// decimal(15,2) amount columns.
+const MaxAmount = 1e13
+
+// Key identifies the wallet the balance belongs to.
+func (b Balance) Key() WalletKey {
+	return WalletKey{TenantID: b.TenantID, UserID: b.UserID, Currency: b.Currency}
+}
+
+// WalletKey identifies a wallet: balances, versions and cache entries are
+// kept per tenant, user and currency. The empty TenantID is the default
+// tenant.
+type WalletKey struct {
+	TenantID string
+	UserID   uint
+	Currency string
+}
+
+// Less orders wallets by user, then tenant, then currency.
+func (k WalletKey) Less(o WalletKey) bool {
+	if k.UserID != o.UserID {
+		return k.UserID < o.UserID
+	}
+	if k.TenantID != o.TenantID {
+		return k.TenantID < o.TenantID
+	}
+	return k.Currency < o.Currency
+}
+
+// ValidCurrency reports whether code is an ISO 4217 style code of three
+// upper-case letters.
+func ValidCurrency(code string) bool {
+	if len(code) != 3 {
+		return false
+	}
+	for _, c := range code {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// DefaultTenant names the default tenant, whose wallets have an empty
+// TenantID, in metrics and admin queries. No tenant can be configured under
+// this ID.
+const DefaultTenant = "default"
+
+// ValidTenantID reports whether id can name a tenant: 1 to 64 lower-case
+// letters, digits, dashes and underscores, starting with a letter or digit.
+func ValidTenantID(id string) bool {
+	if id == "" || len(id) > 64 || id[0] == '-' || id[0] == '_' {
+		return false
+	}
+	for _, c := range id {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' && c != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+// BalanceEvent represents a balance update event from RabbitMQ. CreatedAt
+// is NOT NULL because monthly partitions make it part of the primary key.
 type BalanceEvent struct {
-	ID        uint      `gorm:"primarykey" json:"id"`
-	CreatedAt time.Time `json:"created_at"`
-	UserID    uint      `gorm:"index:idx_user_id;index:idx_created_at;not null" json:"user_id"`
-	Amount    float64   `gorm:"type:decimal(15,2);not null" json:"amount"`
-	Version   uint      `gorm:"not null" json:"version"`
-	UpdatedAt time.Time `gorm:"index:idx_created_at" json:"updated_at"`
-	EventID   string    `gorm:"index:idx_event_id;size:255" json:"event_id"`
+	ID        uint          `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time     `gorm:"not null" json:"created_at"`
+	UserID    uint          `gorm:"index:idx_balance_events_user_id;index:idx_created_at;not null" json:"user_id"`
+	TenantID  string        `gorm:"size:64;not null" json:"tenant_id,omitempty"`
+	Currency  string        `gorm:"size:3;not null" json:"currency"`
+	Amount    float64       `gorm:"type:decimal(15,2);not null" json:"amount"`
+	Version   uint          `gorm:"not null" json:"version"`
+	UpdatedAt time.Time     `gorm:"index:idx_created_at" json:"updated_at"`
+	EventID   string        `gorm:"index:idx_event_id;size:255" json:"event_id"`
+	Metadata  EventMetadata `gorm:"type:text" json:"metadata"`
 }
 
 // TableName specifies the table name
 func (BalanceEvent) TableName() string {
 	return "balance_events"
 }
+
+// ProcessedEvent claims an event ID for a tenant. Its primary key is what
+// records a redelivered event once: balance_events cannot have a unique
+// event_id index, since a table partitioned by created_at only allows
+// unique keys that include it. Claims outlive the retention of the events,
+// so that a late redelivery is still recognised, and are deleted by the
+// retention job once older than its claim horizon.
+type ProcessedEvent struct {
+	TenantID  string    `gorm:"primaryKey;size:64" json:"tenant_id,omitempty"`
+	EventID   string    `gorm:"primaryKey;size:255" json:"event_id"`
+	CreatedAt time.Time `gorm:"index:idx_processed_event_ids_created_at;not null" json:"created_at"`
+}
+
+// TableName specifies the table name
+func (ProcessedEvent) TableName() string {
+	return "processed_event_ids"
+}
+
+// Version anomalies recorded in EventMetadata.
+const (
+	AnomalyGap       = "gap"       // versions between the applied one and this event never arrived
+	AnomalyStale     = "stale"     // older than the applied version
+	AnomalyDuplicate = "duplicate" // the applied version arrived again
+)
+
+// SourceReconcile marks events the reconcile job wrote to correct drift.
+const SourceReconcile = "reconcile"
+
+// EventMetadata records what the processor noticed about an event. It is
+// stored as JSON and is NULL for events without findings.
+type EventMetadata struct {
+	Anomaly         string `json:"anomaly,omitempty"`
+	AppliedVersion  uint   `json:"applied_version,omitempty"` // version applied before this event
+	MissingVersions uint   `json:"missing_versions,omitempty"`
+
+	// Synthetic events name their Source and the Correction they made.
+	Source         string   `json:"source,omitempty"`
+	Correction     string   `json:"correction,omitempty"`
+	PreviousAmount *float64 `json:"previous_amount,omitempty"`
+
+	// Policy is the negative balance policy applied to the update, and
+	// Review how a held one was resolved.
+	Policy         string   `json:"policy,omitempty"`
+	OriginalAmount *float64 `json:"original_amount,omitempty"` // the amount before clamping
+	PendingID      uint     `json:"pending_id,omitempty"`
+	Review         string   `json:"review,omitempty"`
+}
+
+// Value implements driver.Valuer.
+func (m EventMetadata) Value() (driver.Value, error) {
+	if m == (EventMetadata{}) {
+		return nil, nil
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// Scan implements sql.Scanner.
+func (m *EventMetadata) Scan(src interface{}) error {
+	*m = EventMetadata{}
+	return scanJSON(src, m)
+}
@@ -0,0 +1,26 @@
+package model
+
+import "time"
+
+// BootstrapWatermark records how far a bootstrap load from one source got,
+// so an interrupted load resumes instead of starting over.
+type BootstrapWatermark struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Source    string    `gorm:"uniqueIndex:idx_bootstrap_source;size:255;not null" json:"source"`
+	// Fingerprint identifies the snapshot a file source was read from; a
+	// resume against a different file is refused.
+	Fingerprint string `gorm:"size:255" json:"fingerprint,omitempty"`
+	// SnapshotAt is when the snapshot was taken. Updates older than it may
+	// still be queued; the version guard drops the ones already covered.
+	SnapshotAt  time.Time  `json:"snapshot_at"`
+	Rows        int64      `gorm:"not null;default:0" json:"rows"`
+	LastUserID  uint       `gorm:"not null;default:0" json:"last_user_id"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+// TableName specifies the table name
+func (BootstrapWatermark) TableName() string {
+	return "bootstrap_watermarks"
+}
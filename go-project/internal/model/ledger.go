@@ -0,0 +1,89 @@
+package model
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Kinds of ledger accounts. A wallet account mirrors one wallet; the other
+// kinds are the counter-accounts of the journals of that kind, one per
+// tenant and currency, split into shards numbered by their user ID.
+const (
+	LedgerWallet         = "wallet"
+	LedgerAdjustment     = "adjustment"     // updates from the producer and approved held updates
+	LedgerReconciliation = "reconciliation" // repairs from the upstream table
+	LedgerOpening        = "opening"        // bootstrap loads and balances from before the ledger
+)
+
+// ErrImmutable is returned when a journal or an entry would be changed.
+var ErrImmutable = errors.New("ledger journals and entries are immutable")
+
+// LedgerAccount holds the running balance of a wallet or a counter-account.
+// The entries of every journal sum to zero, so the balances of all accounts
+// of a tenant and currency do too.
+type LedgerAccount struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Kind      string    `gorm:"uniqueIndex:idx_ledger_account;size:20;not null" json:"kind"`
+	TenantID  string    `gorm:"uniqueIndex:idx_ledger_account;size:64;not null" json:"tenant_id,omitempty"`
+	UserID    uint      `gorm:"uniqueIndex:idx_ledger_account;not null" json:"user_id,omitempty"` // the shard of counter-accounts
+	Currency  string    `gorm:"uniqueIndex:idx_ledger_account;size:3;not null" json:"currency"`
+	Balance   float64   `gorm:"type:decimal(20,2);not null;default:0" json:"balance"`
+	// Version is the wallet version the balance reflects; counter-accounts
+	// keep it at zero.
+	Version uint `gorm:"not null;default:0" json:"version"`
+}
+
+// TableName specifies the table name
+func (LedgerAccount) TableName() string {
+	return "ledger_accounts"
+}
+
+// Wallet is the wallet a wallet account mirrors.
+func (a LedgerAccount) Wallet() WalletKey {
+	return WalletKey{TenantID: a.TenantID, UserID: a.UserID, Currency: a.Currency}
+}
+
+// LedgerJournal is one applied change of a wallet: the entries moving the
+// change between the wallet account and the counter-account of Kind.
+type LedgerJournal struct {
+	ID              uint      `gorm:"primarykey" json:"id"`
+	CreatedAt       time.Time `json:"created_at"`
+	Kind            string    `gorm:"size:20;not null" json:"kind"`
+	UserID          uint      `gorm:"index:idx_ledger_journals_wallet;not null" json:"user_id"`
+	TenantID        string    `gorm:"index:idx_ledger_journals_wallet;size:64;not null" json:"tenant_id,omitempty"`
+	Currency        string    `gorm:"index:idx_ledger_journals_wallet;size:3;not null" json:"currency"`
+	Version         uint      `gorm:"not null" json:"version"`
+	PreviousVersion uint      `gorm:"not null" json:"previous_version"`
+	EventID         string    `gorm:"index:idx_ledger_journals_event_id;size:255" json:"event_id,omitempty"`
+}
+
+// TableName specifies the table name
+func (LedgerJournal) TableName() string {
+	return "ledger_journals"
+}
+
+func (LedgerJournal) BeforeUpdate(*gorm.DB) error { return ErrImmutable }
+func (LedgerJournal) BeforeDelete(*gorm.DB) error { return ErrImmutable }
+
+// LedgerEntry moves Amount into an account; a negative amount moves it out.
+// BalanceAfter is the account's running balance including the entry.
+type LedgerEntry struct {
+	ID           uint      `gorm:"primarykey" json:"id"`
+	CreatedAt    time.Time `json:"created_at"`
+	JournalID    uint      `gorm:"index:idx_ledger_entries_journal;not null" json:"journal_id"`
+	AccountID    uint      `gorm:"index:idx_ledger_entries_account;not null" json:"account_id"`
+	Amount       float64   `gorm:"type:decimal(20,2);not null" json:"amount"`
+	BalanceAfter float64   `gorm:"type:decimal(20,2);not null" json:"balance_after"`
+}
+
+// TableName specifies the table name
+func (LedgerEntry) TableName() string {
+	return "ledger_entries"
+}
+
+func (LedgerEntry) BeforeUpdate(*gorm.DB) error { return ErrImmutable }
+func (LedgerEntry) BeforeDelete(*gorm.DB) error { return ErrImmutable }
@@ -0,0 +1,34 @@
+package model
+
+import "time"
+
+// Statuses of a PendingUpdate.
+const (
+	PendingOpen       = "pending"
+	PendingApproved   = "approved"
+	PendingRejected   = "rejected"
+	PendingSuperseded = "superseded" // approved, but a newer version was applied meanwhile
+)
+
+// PendingUpdate is a balance update the hold policy parked for manual
+// review instead of applying it.
+type PendingUpdate struct {
+	ID         uint       `gorm:"primarykey" json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	UserID     uint       `gorm:"uniqueIndex:idx_pending_wallet;not null" json:"user_id"`
+	TenantID   string     `gorm:"uniqueIndex:idx_pending_wallet;size:64;not null" json:"tenant_id,omitempty"`
+	Currency   string     `gorm:"uniqueIndex:idx_pending_wallet;size:3;not null" json:"currency"`
+	Version    uint       `gorm:"uniqueIndex:idx_pending_wallet;not null" json:"version"`
+	Amount     float64    `gorm:"type:decimal(15,2);not null" json:"amount"`
+	EventID    string     `gorm:"size:255" json:"event_id,omitempty"`
+	EventTime  time.Time  `json:"event_time"`
+	Status     string     `gorm:"size:20;index:idx_pending_status;not null" json:"status"`
+	Note       string     `gorm:"size:500" json:"note,omitempty"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+}
+
+// TableName specifies the table name
+func (PendingUpdate) TableName() string {
+	return "pending_balance_updates"
+}
@@ -0,0 +1,37 @@
+package model
+
+import "time"
+
+// BalanceSnapshot is the balance of a wallet at the close of a day, read
+// from balances at the configured cutoff. Statements open and close with
+// snapshots. A wallet created after the snapshot of a day has no row for
+// that day; SnapshotDay tells whether the day has a snapshot at all.
+type BalanceSnapshot struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Date      time.Time `gorm:"type:date;uniqueIndex:idx_balance_snapshots_date_wallet;not null" json:"date"` // the day that closed
+	UserID    uint      `gorm:"uniqueIndex:idx_balance_snapshots_date_wallet;not null" json:"user_id"`
+	TenantID  string    `gorm:"uniqueIndex:idx_balance_snapshots_date_wallet;size:64;not null" json:"tenant_id,omitempty"`
+	Currency  string    `gorm:"uniqueIndex:idx_balance_snapshots_date_wallet;size:3;not null" json:"currency"`
+	Amount    float64   `gorm:"type:decimal(15,2);not null" json:"amount"`
+	Version   uint      `gorm:"not null" json:"version"`
+}
+
+// TableName specifies the table name
+func (BalanceSnapshot) TableName() string {
+	return "balance_snapshots"
+}
+
+// SnapshotDay records a day whose snapshot was taken, in the same
+// transaction as its rows.
+type SnapshotDay struct {
+	Date     time.Time `gorm:"type:date;primarykey" json:"date"`
+	Cutoff   time.Time `gorm:"not null" json:"cutoff"`
+	TakenAt  time.Time `gorm:"not null" json:"taken_at"` // when balances was read; after the cutoff if the snapshot was late
+	Balances int64     `gorm:"not null" json:"balances"`
+}
+
+// TableName specifies the table name
+func (SnapshotDay) TableName() string {
+	return "balance_snapshot_days"
+}
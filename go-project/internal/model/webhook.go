@@ -0,0 +1,138 @@
+package model
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Webhook event types.
+const (
+	WebhookBalanceUpdated = "balance.updated"
+	WebhookVersionGap     = "balance.version_gap"
+)
+
+// WebhookSubscription is an HTTP endpoint notified about balance changes of
+// one tenant, the default one if TenantID is empty. Empty filters match
+// everything within the tenant.
+type WebhookSubscription struct {
+	ID         uint       `gorm:"primarykey" json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	TenantID   string     `gorm:"size:64;not null;default:''" json:"tenant_id,omitempty"`
+	URL        string     `gorm:"size:2048;not null" json:"url"`
+	Secret     string     `gorm:"size:255;not null" json:"-"` // HMAC key of the payloads
+	UserIDs    UintList   `gorm:"type:text" json:"user_ids,omitempty"`
+	EventTypes StringList `gorm:"type:text" json:"event_types,omitempty"`
+	Active     bool       `gorm:"not null;default:true" json:"active"`
+}
+
+// TableName specifies the table name
+func (WebhookSubscription) TableName() string {
+	return "webhook_subscriptions"
+}
+
+// Matches reports whether the subscription wants an event of eventType about
+// a wallet of userID in tenantID.
+func (s *WebhookSubscription) Matches(eventType, tenantID string, userID uint) bool {
+	if !s.Active || s.TenantID != tenantID {
+		return false
+	}
+	if len(s.EventTypes) > 0 && !s.EventTypes.Contains(eventType) {
+		return false
+	}
+	if len(s.UserIDs) == 0 {
+		return true
+	}
+	for _, id := range s.UserIDs {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookDelivery logs one attempt to deliver an event to a subscription.
+type WebhookDelivery struct {
+	ID             uint      `gorm:"primarykey" json:"id"`
+	CreatedAt      time.Time `gorm:"index:idx_webhook_deliveries_created_at" json:"created_at"`
+	SubscriptionID uint      `gorm:"index:idx_webhook_deliveries_subscription;not null" json:"subscription_id"`
+	DeliveryID     string    `gorm:"index:idx_webhook_deliveries_delivery_id;size:64;not null" json:"delivery_id"`
+	EventType      string    `gorm:"size:64;not null" json:"event_type"`
+	UserID         uint      `gorm:"not null" json:"user_id"`
+	Attempt        int       `gorm:"not null" json:"attempt"`
+	StatusCode     int       `json:"status_code,omitempty"`
+	Error          string    `gorm:"size:1024" json:"error,omitempty"`
+	DurationMs     int64     `json:"duration_ms"`
+	// Outcome is "delivered", "retrying" or "failed".
+	Outcome string `gorm:"size:16;not null" json:"outcome"`
+}
+
+// TableName specifies the table name
+func (WebhookDelivery) TableName() string {
+	return "webhook_deliveries"
+}
+
+// UintList is stored as a JSON array.
+type UintList []uint
+
+// Value implements driver.Valuer.
+func (l UintList) Value() (driver.Value, error) {
+	return jsonValue(l, len(l))
+}
+
+// Scan implements sql.Scanner.
+func (l *UintList) Scan(src interface{}) error {
+	*l = nil
+	return scanJSON(src, l)
+}
+
+// StringList is stored as a JSON array.
+type StringList []string
+
+// Value implements driver.Valuer.
+func (l StringList) Value() (driver.Value, error) {
+	return jsonValue(l, len(l))
+}
+
+// Scan implements sql.Scanner.
+func (l *StringList) Scan(src interface{}) error {
+	*l = nil
+	return scanJSON(src, l)
+}
+
+// Contains reports whether s is in the list.
+func (l StringList) Contains(s string) bool {
+	for _, v := range l {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonValue stores empty lists as NULL.
+func jsonValue(v interface{}, n int) (driver.Value, error) {
+	if n == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+func scanJSON(src, dst interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		return nil
+	case string:
+		return json.Unmarshal([]byte(v), dst)
+	case []byte:
+		return json.Unmarshal(v, dst)
+	default:
+		return fmt.Errorf("cannot scan %T into %T", src, dst)
+	}
+}
@@ -0,0 +1,107 @@
+package processor
+
+import (
+	"context"
+	"time"
+
+	"balance-service/internal/model"
+	"github.com/rabbitmq/amqp091-go"
+	"github.com/sirupsen/logrus"
+)
+
+// BalanceChange is a balance a committed batch moved. Updates the version
+// guard ignored are not changes.
+type BalanceChange struct {
+	UserID          uint
+	TenantID        string
+	Currency        string
+	Amount          float64
+	Version         uint
+	PreviousAmount  *float64 // nil for a wallet seen for the first time
+	PreviousVersion uint
+	EventID         string
+	UpdatedAt       time.Time
+}
+
+// Commit is what a batch changed, reported once it is committed and acked.
+type Commit struct {
+	Changes []BalanceChange
+	Gaps    []VersionGap
+}
+
+// CommitObserver is told about every committed batch. It is called on the
+// worker's goroutine and must not block.
+type CommitObserver interface {
+	BatchCommitted(ctx context.Context, commit Commit)
+}
+
+// DeadLetterer moves a message the database or the negative balance policy
+// rejected for good to the dead-letter queue. It settles the delivery: acked once the copy is
+// published, requeued if it could not be. workerID is the processor worker,
+// for the log.
+type DeadLetterer interface {
+	DeadLetter(ctx context.Context, msg amqp091.Delivery, cause error, workerID int) bool
+}
+
+// Hooks are the optional consumers of committed batches, and where messages
+// the database rejects go.
+type Hooks struct {
+	Resync     Resyncer
+	Observers  []CommitObserver
+	DeadLetter DeadLetterer
+}
+
+// deadLetter settles a delivery whose update the database or the negative
+// balance policy rejected for good. Without a DeadLetterer the message is
+// dropped. Updates that did not come from RabbitMQ have no delivery to
+// settle.
+func (h Hooks) deadLetter(ctx context.Context, msg amqp091.Delivery, cause error, workerID int) {
+	if msg.Acknowledger == nil {
+		return
+	}
+	if h.DeadLetter == nil {
+		_ = msg.Nack(false, false)
+		return
+	}
+	h.DeadLetter.DeadLetter(ctx, msg, cause, workerID)
+}
+
+func (h Hooks) committed(ctx context.Context, commit Commit, log *logrus.Logger) {
+	requestResyncs(ctx, h.Resync, commit.Gaps, log)
+	if len(commit.Changes) == 0 && len(commit.Gaps) == 0 {
+		return
+	}
+	for _, o := range h.Observers {
+		o.BatchCommitted(ctx, commit)
+	}
+}
+
+// changedBalances compares the balances read back after the upsert with the
+// ones read before it.
+func changedBalances(before map[model.WalletKey]model.Balance, after []model.Balance, applied map[model.WalletKey]IncomingUpdate) []BalanceChange {
+	var changes []BalanceChange
+	for _, b := range after {
+		prev, known := before[b.Key()]
+		if known && prev.Version == b.Version && prev.Amount == b.Amount {
+			continue
+		}
+
+		change := BalanceChange{
+			UserID:    b.UserID,
+			TenantID:  b.TenantID,
+			Currency:  b.Currency,
+			Amount:    b.Amount,
+			Version:   b.Version,
+			UpdatedAt: b.UpdatedAt,
+		}
+		if known {
+			amount := prev.Amount
+			change.PreviousAmount, change.PreviousVersion = &amount, prev.Version
+		}
+		if upd, ok := applied[b.Key()]; ok && upd.Payload.Version == b.Version {
+			change.EventID, change.UpdatedAt = upd.Payload.EventID, upd.eventTime()
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
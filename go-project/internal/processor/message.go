@@ -0,0 +1,304 @@
+package processor
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"strings"
+	"time"
+
+	"balance-service/internal/model"
+)
+
+// Schema versions of the balance message contract. Messages without a
+// schema_version are version 1, the format the Laravel producer used before
+// the field existed.
+const (
+	SchemaV1            = 1
+	SchemaV2            = 2
+	LatestSchemaVersion = SchemaV2
+)
+
+// Rejection reasons reported by ValidationError, stable enough to alert on.
+const (
+	ReasonMalformed          = "malformed_json"
+	ReasonUnsupportedVersion = "unsupported_schema_version"
+	ReasonUnknownField       = "unknown_field"
+	ReasonInvalidType        = "invalid_type"
+	ReasonMissing            = "missing_field"
+	ReasonInvalid            = "invalid_value"
+	ReasonConflict           = "conflicting_fields"
+	// ReasonRejected is given to messages the database refused to store,
+	// e.g. for a constraint violation.
+	ReasonRejected = "rejected_by_database"
+)
+
+// v1TimestampFormats are the layouts version 1 producers have been seen to
+// send. Version 2 only accepts RFC 3339.
+var v1TimestampFormats = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05",
+}
+
+// ErrNoTimestamp is returned by ParseTimestamp for a version 1 message that
+// carried no timestamp.
+var ErrNoTimestamp = errors.New("message has no timestamp")
+
+// BalanceMessage represents the message format from RabbitMQ. Amounts are
+// pointers so that an explicit zero is told apart from a missing field.
+type BalanceMessage struct {
+	SchemaVersion int      `json:"schema_version,omitempty"`
+	UserID        uint     `json:"user_id"`
+	NewAmount     *float64 `json:"new_amount,omitempty"` // PHP sends "new_amount"
+	Amount        *float64 `json:"amount,omitempty"`     // version 1 alias of new_amount
+	Version       uint     `json:"version"`
+	Timestamp     string   `json:"timestamp,omitempty"`  // ISO8601 format
+	UpdatedAt     string   `json:"updated_at,omitempty"` // version 1 alias of timestamp
+	EventID       string   `json:"event_id,omitempty"`
+	// Currency is the wallet the update is for. Messages from before
+	// wallets existed carry none and are given the configured default.
+	Currency string `json:"currency,omitempty"`
+	// TenantID is the tenant the user belongs to, empty for the default
+	// tenant. The x-tenant-id header and tenant queues can set it too.
+	TenantID string `json:"tenant_id,omitempty"`
+}
+
+// ValidationError explains why a message does not satisfy its contract.
+type ValidationError struct {
+	Reason string // one of the Reason constants
+	Field  string // JSON field at fault, empty for the message as a whole
+	Detail string
+}
+
+func (e *ValidationError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("%s: %s", e.Reason, e.Detail)
+	}
+	return fmt.Sprintf("%s: %s: %s", e.Reason, e.Field, e.Detail)
+}
+
+// Invalid builds a ValidationError.
+func Invalid(reason, field, format string, args ...interface{}) *ValidationError {
+	return &ValidationError{Reason: reason, Field: field, Detail: fmt.Sprintf(format, args...)}
+}
+
+// DecodeMessage parses a JSON body according to its schema_version and
+// validates it against that version's contract. Version 2 rejects unknown
+// fields and the version 1 aliases; version 1 stays lenient for existing
+// producers.
+func DecodeMessage(body []byte) (BalanceMessage, error) {
+	msg, err := ParseMessage(body)
+	if err != nil {
+		return BalanceMessage{}, err
+	}
+	if err := msg.Validate(); err != nil {
+		return BalanceMessage{}, err
+	}
+	return msg, nil
+}
+
+// ParseMessage is DecodeMessage without the validation, for callers that
+// fill in fields from an envelope first.
+func ParseMessage(body []byte) (BalanceMessage, error) {
+	var probe struct {
+		SchemaVersion *json.RawMessage `json:"schema_version"`
+	}
+	if err := json.Unmarshal(body, &probe); err != nil {
+		return BalanceMessage{}, decodeError(err)
+	}
+
+	version := SchemaV1
+	if probe.SchemaVersion != nil {
+		if err := json.Unmarshal(*probe.SchemaVersion, &version); err != nil {
+			return BalanceMessage{}, Invalid(ReasonInvalidType, "schema_version", "must be an integer")
+		}
+	}
+
+	var msg BalanceMessage
+	dec := json.NewDecoder(bytes.NewReader(body))
+	switch version {
+	case SchemaV1:
+	case SchemaV2:
+		dec.DisallowUnknownFields()
+	default:
+		return BalanceMessage{}, Invalid(ReasonUnsupportedVersion, "schema_version",
+			"%d is not supported, expected %d to %d", version, SchemaV1, LatestSchemaVersion)
+	}
+	if err := dec.Decode(&msg); err != nil {
+		return BalanceMessage{}, decodeError(err)
+	}
+	if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+		return BalanceMessage{}, Invalid(ReasonMalformed, "", "unexpected data after the message")
+	}
+	msg.SchemaVersion = version
+	return msg, nil
+}
+
+func decodeError(err error) error {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return Invalid(ReasonInvalidType, typeErr.Field, "must be %s, got %s", jsonKind(typeErr.Type), typeErr.Value)
+	}
+	// encoding/json has no typed error for unknown fields.
+	if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		return Invalid(ReasonUnknownField, strings.Trim(field, `"`), "is not part of the schema")
+	}
+	return Invalid(ReasonMalformed, "", "%v", err)
+}
+
+func jsonKind(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return jsonKind(t.Elem())
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "a non-negative integer"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	default:
+		return "an object"
+	}
+}
+
+// Validate checks the message against the contract of its schema version.
+func (m *BalanceMessage) Validate() error {
+	if m.UserID == 0 {
+		return Invalid(ReasonMissing, "user_id", "must be a positive integer")
+	}
+	if m.Currency != "" && !model.ValidCurrency(m.Currency) {
+		return Invalid(ReasonInvalid, "currency", "%q is not a three-letter upper-case code", m.Currency)
+	}
+	if m.TenantID != "" && !model.ValidTenantID(m.TenantID) {
+		return Invalid(ReasonInvalid, "tenant_id", "%q is not a valid tenant ID", m.TenantID)
+	}
+
+	switch m.SchemaVersion {
+	case 0, SchemaV1:
+		return m.validateV1()
+	case SchemaV2:
+		return m.validateV2()
+	default:
+		return Invalid(ReasonUnsupportedVersion, "schema_version", "%d is not supported", m.SchemaVersion)
+	}
+}
+
+func (m *BalanceMessage) validateV1() error {
+	switch {
+	case m.NewAmount == nil && m.Amount == nil:
+		return Invalid(ReasonMissing, "new_amount", "is required")
+	case m.NewAmount != nil && m.Amount != nil && *m.NewAmount != *m.Amount:
+		return Invalid(ReasonConflict, "amount", "differs from new_amount")
+	}
+	if err := checkAmount(m.GetAmount()); err != nil {
+		return err
+	}
+
+	if m.Timestamp != "" && m.UpdatedAt != "" && m.Timestamp != m.UpdatedAt {
+		return Invalid(ReasonConflict, "updated_at", "differs from timestamp")
+	}
+	if _, err := m.ParseTimestamp(); err != nil && !errors.Is(err, ErrNoTimestamp) {
+		return err
+	}
+	return nil
+}
+
+func (m *BalanceMessage) validateV2() error {
+	switch {
+	case m.NewAmount == nil:
+		return Invalid(ReasonMissing, "new_amount", "is required")
+	case m.Amount != nil:
+		return Invalid(ReasonUnknownField, "amount", "was replaced by new_amount in schema version 2")
+	case m.UpdatedAt != "":
+		return Invalid(ReasonUnknownField, "updated_at", "was replaced by timestamp in schema version 2")
+	case m.Version == 0:
+		return Invalid(ReasonMissing, "version", "must be a positive integer")
+	case m.Timestamp == "":
+		return Invalid(ReasonMissing, "timestamp", "is required")
+	case m.EventID == "":
+		return Invalid(ReasonMissing, "event_id", "is required")
+	}
+	if err := checkAmount(*m.NewAmount); err != nil {
+		return err
+	}
+	_, err := m.ParseTimestamp()
+	return err
+}
+
+func checkAmount(amount float64) error {
+	if math.IsNaN(amount) || math.Abs(amount) >= model.MaxAmount {
+		return Invalid(ReasonInvalid, "new_amount", "%v is out of range", amount)
+	}
+	return nil
+}
+
+// DefaultCurrency sets the currency of a message that carries none.
+func (m *BalanceMessage) DefaultCurrency(code string) {
+	if m.Currency == "" {
+		m.Currency = code
+	}
+}
+
+// Wallet returns the wallet the message updates.
+func (m *BalanceMessage) Wallet() model.WalletKey {
+	return model.WalletKey{TenantID: m.TenantID, UserID: m.UserID, Currency: m.Currency}
+}
+
+// GetAmount returns the amount value (handles both field names). An
+// explicit zero is a valid amount.
+func (m *BalanceMessage) GetAmount() float64 {
+	switch {
+	case m.NewAmount != nil:
+		return *m.NewAmount
+	case m.Amount != nil:
+		return *m.Amount
+	default:
+		return 0
+	}
+}
+
+// GetTimestamp returns the timestamp (handles both field names)
+func (m *BalanceMessage) GetTimestamp() string {
+	if m.Timestamp != "" {
+		return m.Timestamp
+	}
+	return m.UpdatedAt
+}
+
+// ParseTimestamp parses the timestamp string to time.Time. It returns
+// ErrNoTimestamp when a version 1 message has none, and a ValidationError when
+// the value does not parse; it never makes a time up.
+func (m *BalanceMessage) ParseTimestamp() (time.Time, error) {
+	ts := m.GetTimestamp()
+	if ts == "" {
+		if m.SchemaVersion >= SchemaV2 {
+			return time.Time{}, Invalid(ReasonMissing, "timestamp", "is required")
+		}
+		return time.Time{}, ErrNoTimestamp
+	}
+
+	field := "timestamp"
+	if m.Timestamp == "" {
+		field = "updated_at"
+	}
+
+	if t, err := time.Parse(time.RFC3339Nano, ts); err == nil {
+		return t, nil
+	}
+	if m.SchemaVersion < SchemaV2 {
+		for _, format := range v1TimestampFormats {
+			if t, err := time.Parse(format, ts); err == nil {
+				return t, nil
+			}
+		}
+	}
+	return time.Time{}, Invalid(ReasonInvalid, field, "%q is not an RFC 3339 timestamp", ts)
+}
@@ -0,0 +1,142 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"balance-service/internal/ledger"
+	"balance-service/internal/model"
+	"balance-service/internal/repository"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// ErrResolved is returned for a held update that was already approved or
+// rejected.
+var ErrResolved = errors.New("pending update is already resolved")
+
+// PendingReview resolves the updates parked by the hold policy.
+type PendingReview struct {
+	db    *gorm.DB
+	cache *sync.Map
+	log   *logrus.Logger
+}
+
+func NewPendingReview(db *gorm.DB, cache *sync.Map, log *logrus.Logger) *PendingReview {
+	return &PendingReview{db: db, cache: cache, log: log}
+}
+
+// List returns up to limit held updates with status, or of any status if it
+// is empty. A nil tenant lists the updates of every tenant.
+func (r *PendingReview) List(ctx context.Context, tenant *string, status string, limit int) ([]model.PendingUpdate, error) {
+	return repository.NewPendingRepository(r.db, r.log).ListPendingUpdates(ctx, tenant, status, limit)
+}
+
+// Get returns a held update, or repository.ErrNotFound.
+func (r *PendingReview) Get(ctx context.Context, id uint) (*model.PendingUpdate, error) {
+	return repository.NewPendingRepository(r.db, r.log).GetPendingUpdate(ctx, id)
+}
+
+// Approve applies a held update through the same version guard as the
+// workers. If a newer version was applied meanwhile it is marked superseded
+// instead.
+func (r *PendingReview) Approve(ctx context.Context, id uint, note string) (*model.PendingUpdate, error) {
+	return r.resolve(ctx, id, true, note)
+}
+
+// Reject closes a held update without applying it.
+func (r *PendingReview) Reject(ctx context.Context, id uint, note string) (*model.PendingUpdate, error) {
+	return r.resolve(ctx, id, false, note)
+}
+
+// resolve records the review in balance_events and, for an approval, moves
+// the balance in the same transaction.
+func (r *PendingReview) resolve(ctx context.Context, id uint, approve bool, note string) (*model.PendingUpdate, error) {
+	var (
+		pending *model.PendingUpdate
+		applied *model.Balance
+	)
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		pendingRepo := repository.NewPendingRepository(tx, r.log)
+
+		var err error
+		if pending, err = pendingRepo.GetPendingUpdate(ctx, id); err != nil {
+			return err
+		}
+		if pending.Status != model.PendingOpen {
+			return ErrResolved
+		}
+
+		status := model.PendingRejected
+		if approve {
+			_, err := ledger.New(tx, r.log).Apply(ctx, []ledger.Posting{{
+				Kind: model.LedgerAdjustment,
+				Balance: model.Balance{
+					UserID:   pending.UserID,
+					TenantID: pending.TenantID,
+					Currency: pending.Currency,
+					Amount:   pending.Amount,
+					Version:  pending.Version,
+				},
+				EventID: pending.EventID,
+			}})
+			if err != nil {
+				return err
+			}
+			balances := repository.NewBalanceRepository(tx, r.log)
+			current, err := balances.GetBalance(ctx, model.WalletKey{TenantID: pending.TenantID, UserID: pending.UserID, Currency: pending.Currency})
+			if err != nil {
+				return err
+			}
+			status = model.PendingApproved
+			if current.Version > pending.Version {
+				status = model.PendingSuperseded
+			} else {
+				applied = current
+			}
+		}
+
+		// The guard fails if a concurrent review got there first.
+		if err := pendingRepo.ResolvePendingUpdate(ctx, id, status, note); err != nil {
+			if errors.Is(err, repository.ErrNotFound) {
+				return ErrResolved
+			}
+			return err
+		}
+		if pending, err = pendingRepo.GetPendingUpdate(ctx, id); err != nil {
+			return err
+		}
+
+		return repository.NewEventRepository(tx, r.log).SaveEvent(ctx, &model.BalanceEvent{
+			UserID:    pending.UserID,
+			TenantID:  pending.TenantID,
+			Currency:  pending.Currency,
+			Amount:    pending.Amount,
+			Version:   pending.Version,
+			UpdatedAt: pending.EventTime,
+			Metadata: model.EventMetadata{
+				Policy:    PolicyHold,
+				PendingID: pending.ID,
+				Review:    status,
+			},
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if applied != nil {
+		r.cache.Store(applied.Key(), applied.Amount)
+	}
+	r.log.WithFields(logrus.Fields{
+		"pending_id": pending.ID,
+		"user_id":    pending.UserID,
+		"tenant_id":  pending.TenantID,
+		"currency":   pending.Currency,
+		"version":    pending.Version,
+		"amount":     pending.Amount,
+		"status":     pending.Status,
+	}).Warn("held balance update reviewed")
+	return pending, nil
+}
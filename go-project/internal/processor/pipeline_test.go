@@ -0,0 +1,392 @@
+package processor_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"balance-service/internal/breaker"
+	"balance-service/internal/codec"
+	"balance-service/internal/config"
+	"balance-service/internal/database"
+	"balance-service/internal/ledger"
+	"balance-service/internal/model"
+	"balance-service/internal/processor"
+	"balance-service/internal/repository"
+	"balance-service/internal/tuning"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// acks records how each delivery was settled, by delivery tag.
+type acks struct {
+	mu      sync.Mutex
+	settled map[uint64]string
+}
+
+func (a *acks) set(tag uint64, how string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.settled[tag] = how
+	return nil
+}
+
+func (a *acks) Ack(tag uint64, _ bool) error { return a.set(tag, "ack") }
+
+func (a *acks) Nack(tag uint64, _ bool, requeue bool) error {
+	if requeue {
+		return a.set(tag, "requeue")
+	}
+	return a.set(tag, "drop")
+}
+
+func (a *acks) Reject(tag uint64, requeue bool) error { return a.Nack(tag, false, requeue) }
+
+func (a *acks) get(tag uint64) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.settled[tag]
+}
+
+// commits collects the batches the processor reports as committed.
+type commits struct {
+	mu      sync.Mutex
+	changes []processor.BalanceChange
+}
+
+func (c *commits) BatchCommitted(_ context.Context, commit processor.Commit) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.changes = append(c.changes, commit.Changes...)
+}
+
+func testLogger() *logrus.Logger {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	return log
+}
+
+// pipeline runs the processor workers on db the way serve does, fed with
+// JSON bodies decoded by the consumer's codecs.
+type pipeline struct {
+	t       *testing.T
+	db      *gorm.DB
+	cache   sync.Map
+	acks    *acks
+	commits *commits
+	updates chan processor.IncomingUpdate
+	pool    *processor.Pool
+	codecs  *codec.Registry
+	tag     uint64
+}
+
+func startPipeline(t *testing.T, hooks processor.Hooks) *pipeline {
+	t.Helper()
+	db, log := database.OpenTest(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	p := &pipeline{
+		t:       t,
+		db:      db,
+		acks:    &acks{settled: make(map[uint64]string)},
+		commits: &commits{},
+		updates: make(chan processor.IncomingUpdate, 100),
+		codecs:  codec.NewRegistry(),
+	}
+	hooks.Observers = append(hooks.Observers, p.commits)
+
+	br := breaker.New(ctx, breaker.Config{Threshold: 5, MinBackoff: time.Second, MaxBackoff: time.Second}, (&database.Database{DB: db}).Ping, log)
+	settings := tuning.NewStore(tuning.Settings{
+		BatchSize:     100,
+		BatchInterval: 20 * time.Millisecond,
+		Prefetch:      100,
+		LogLevel:      "info",
+		SyncInterval:  time.Minute,
+	})
+	p.pool = processor.StartProcessorPool(
+		ctx,
+		repository.NewBalanceRepository(p.db, log),
+		repository.NewEventRepository(p.db, log),
+		&p.cache,
+		p.updates,
+		br,
+		config.RabbitConfig{Workers: 1},
+		settings,
+		hooks,
+		log,
+	)
+	return p
+}
+
+// send decodes body like the consumer does and hands it to the workers. It
+// returns the delivery tag.
+func (p *pipeline) send(body string) uint64 {
+	p.t.Helper()
+	return p.sendDecided(body, "")
+}
+
+// sendDecided is send for an update the negative balance policy decided on.
+func (p *pipeline) sendDecided(body, decision string) uint64 {
+	p.t.Helper()
+	msg, err := p.codecs.Decode("application/json", nil, []byte(body))
+	if err != nil {
+		p.t.Fatalf("decode %s: %v", body, err)
+	}
+	msg.DefaultCurrency("USD")
+	p.tag++
+	p.updates <- processor.IncomingUpdate{
+		Payload:  msg,
+		Decision: decision,
+		Delivery: amqp.Delivery{Acknowledger: p.acks, DeliveryTag: p.tag},
+	}
+	return p.tag
+}
+
+// settle waits until every delivery sent so far is settled.
+func (p *pipeline) settle() {
+	p.t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for tag := uint64(1); tag <= p.tag; tag++ {
+		for p.acks.get(tag) == "" {
+			if time.Now().After(deadline) {
+				p.t.Fatalf("delivery %d was not settled", tag)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+}
+
+func (p *pipeline) stop() {
+	p.t.Helper()
+	close(p.updates)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := p.pool.Wait(ctx); err != nil {
+		p.t.Fatal(err)
+	}
+}
+
+func (p *pipeline) balance(userID uint, currency string) model.Balance {
+	p.t.Helper()
+	b, err := repository.NewBalanceRepository(p.db, testLogger()).GetBalance(context.Background(), model.WalletKey{UserID: userID, Currency: currency})
+	if err != nil {
+		p.t.Fatalf("balance of user %d: %v", userID, err)
+	}
+	return *b
+}
+
+func update(userID uint, version uint, amount float64, eventID string) string {
+	return fmt.Sprintf(`{"user_id":%d,"new_amount":%.2f,"version":%d,"event_id":%q,"timestamp":"2026-01-02 03:04:05"}`,
+		userID, amount, version, eventID)
+}
+
+func TestPipelineAppliesUpdates(t *testing.T) {
+	p := startPipeline(t, processor.Hooks{})
+
+	p.send(update(1, 1, 100, "e1"))
+	p.send(update(1, 3, 130, "e3"))
+	p.send(update(2, 1, 20, "e4"))
+	p.settle()
+	// Arrives after version 3 was applied and must not move the balance back.
+	p.send(update(1, 2, 120, "e2"))
+	// A redelivery of an applied message.
+	p.send(update(2, 1, 20, "e4"))
+	p.settle()
+	p.stop()
+
+	for tag := uint64(1); tag <= p.tag; tag++ {
+		if got := p.acks.get(tag); got != "ack" {
+			t.Errorf("delivery %d: %s, want ack", tag, got)
+		}
+	}
+
+	if b := p.balance(1, "USD"); b.Amount != 130 || b.Version != 3 {
+		t.Errorf("user 1: amount %v version %d, want 130 and 3", b.Amount, b.Version)
+	}
+	if b := p.balance(2, "USD"); b.Amount != 20 || b.Version != 1 {
+		t.Errorf("user 2: amount %v version %d, want 20 and 1", b.Amount, b.Version)
+	}
+
+	cached, ok := p.cache.Load(model.WalletKey{UserID: 1, Currency: "USD"})
+	if !ok || cached.(float64) != 130 {
+		t.Errorf("cached balance of user 1: %v, want 130", cached)
+	}
+
+	var events []model.BalanceEvent
+	if err := p.db.Order("id").Find(&events).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 4 {
+		t.Fatalf("%d events stored, want 4 (the redelivery is recorded once)", len(events))
+	}
+	for _, e := range events {
+		if e.EventID == "e2" && e.Metadata.Anomaly != model.AnomalyStale {
+			t.Errorf("late event e2: anomaly %q, want %q", e.Metadata.Anomaly, model.AnomalyStale)
+		}
+	}
+
+	// The first batch moved both wallets; the second one changed nothing.
+	if len(p.commits.changes) != 2 {
+		t.Errorf("%d committed changes, want 2", len(p.commits.changes))
+	}
+
+	report, err := ledger.Check(context.Background(), p.db, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() {
+		t.Errorf("ledger check failed: %+v", report.Violations)
+	}
+}
+
+// deadLetters records the messages handed to the dead-letter queue and, if
+// db is set, the events stored by then.
+type deadLetters struct {
+	mu     sync.Mutex
+	causes map[uint64]error
+	db     *gorm.DB
+	events map[uint64][]string
+}
+
+func (d *deadLetters) DeadLetter(_ context.Context, msg amqp.Delivery, cause error, _ int) bool {
+	d.mu.Lock()
+	d.causes[msg.DeliveryTag] = cause
+	if d.db != nil {
+		var ids []string
+		_ = d.db.Model(&model.BalanceEvent{}).Order("id").Pluck("event_id", &ids).Error
+		d.events[msg.DeliveryTag] = ids
+	}
+	d.mu.Unlock()
+	_ = msg.Ack(false)
+	return true
+}
+
+func TestPipelineDeadLettersRejectedMessages(t *testing.T) {
+	dlq := &deadLetters{causes: make(map[uint64]error)}
+	p := startPipeline(t, processor.Hooks{DeadLetter: dlq})
+	// The database refuses user 13 for good, as a constraint would.
+	err := p.db.Exec(`CREATE TRIGGER poison BEFORE INSERT ON balances WHEN NEW.user_id = 13
+		BEGIN SELECT RAISE(ABORT, 'user 13 is refused'); END`).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.send(update(12, 1, 10, "p1"))
+	poison := p.send(update(13, 1, 10, "p2"))
+	p.send(update(14, 1, 10, "p3"))
+	p.settle()
+	p.stop()
+
+	var invalid *processor.ValidationError
+	if cause := dlq.causes[poison]; !errors.As(cause, &invalid) || invalid.Reason != processor.ReasonRejected {
+		t.Errorf("poison message dead-lettered with %v, want reason %s", cause, processor.ReasonRejected)
+	}
+	if len(dlq.causes) != 1 {
+		t.Errorf("%d messages dead-lettered, want 1", len(dlq.causes))
+	}
+	// The rest of the batch is stored on its own.
+	for _, userID := range []uint{12, 14} {
+		if b := p.balance(userID, "USD"); b.Amount != 10 {
+			t.Errorf("user %d: amount %v, want 10", userID, b.Amount)
+		}
+	}
+}
+
+func TestPipelineDeadLettersRejectedUpdatesOnceRecorded(t *testing.T) {
+	dlq := &deadLetters{causes: make(map[uint64]error), events: make(map[uint64][]string)}
+	p := startPipeline(t, processor.Hooks{DeadLetter: dlq})
+	dlq.db = p.db
+
+	p.send(update(31, 1, 50, "r1"))
+	p.settle()
+	rejected := p.sendDecided(update(31, 2, -20, "r2"), processor.PolicyReject)
+	applied := p.send(update(32, 1, 5, "r3"))
+	p.settle()
+	p.stop()
+
+	var invalid *processor.ValidationError
+	if cause := dlq.causes[rejected]; !errors.As(cause, &invalid) || invalid.Reason != processor.ReasonNegativeAmount {
+		t.Errorf("rejected update dead-lettered with %v, want reason %s", cause, processor.ReasonNegativeAmount)
+	}
+	if len(dlq.causes) != 1 || p.acks.get(applied) != "ack" {
+		t.Errorf("dead-lettered %v, update of user 32 settled with %q; want only the rejected one", dlq.causes, p.acks.get(applied))
+	}
+	// The rejection was committed before the message left the queue.
+	if ids := dlq.events[rejected]; len(ids) != 3 || (ids[1] != "r2" && ids[2] != "r2") {
+		t.Errorf("events %v stored when the rejected update was dead-lettered, want r2 among them", ids)
+	}
+
+	var event model.BalanceEvent
+	if err := p.db.Where("event_id = ?", "r2").First(&event).Error; err != nil {
+		t.Fatal(err)
+	}
+	if event.Metadata.Policy != processor.PolicyReject || event.Amount != -20 {
+		t.Errorf("rejected update recorded as %+v", event)
+	}
+	if b := p.balance(31, "USD"); b.Amount != 50 || b.Version != 1 {
+		t.Errorf("user 31: amount %v version %d, want the rejected update left out", b.Amount, b.Version)
+	}
+}
+
+func TestPipelineHoldsUpdatesForReview(t *testing.T) {
+	p := startPipeline(t, processor.Hooks{})
+
+	p.send(update(21, 1, 50, "h1"))
+	p.send(update(22, 1, 50, "h2"))
+	p.settle()
+	p.sendDecided(update(21, 2, -10, "h3"), processor.PolicyHold)
+	p.sendDecided(update(22, 2, -20, "h4"), processor.PolicyHold)
+	// A redelivery of a held update is parked once.
+	p.sendDecided(update(22, 2, -20, "h4"), processor.PolicyHold)
+	p.settle()
+	p.stop()
+
+	review := processor.NewPendingReview(p.db, &p.cache, testLogger())
+	ctx := context.Background()
+	open, err := review.List(ctx, nil, model.PendingOpen, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(open) != 2 {
+		t.Fatalf("%d updates held, want 2", len(open))
+	}
+	held := make(map[uint]uint, len(open))
+	for _, u := range open {
+		held[u.UserID] = u.ID
+	}
+	for _, b := range []model.Balance{p.balance(21, "USD"), p.balance(22, "USD")} {
+		if b.Amount != 50 || b.Version != 1 {
+			t.Errorf("user %d: amount %v version %d before the review, want 50 and 1", b.UserID, b.Amount, b.Version)
+		}
+	}
+
+	approved, err := review.Approve(ctx, held[21], "checked")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if approved.Status != model.PendingApproved {
+		t.Errorf("approved update has status %s", approved.Status)
+	}
+	if _, err := review.Reject(ctx, held[22], "wrong"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := review.Reject(ctx, held[21], "again"); !errors.Is(err, processor.ErrResolved) {
+		t.Errorf("second review: %v, want ErrResolved", err)
+	}
+	if _, err := review.Get(ctx, 999); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("unknown update: %v, want ErrNotFound", err)
+	}
+
+	if b := p.balance(21, "USD"); b.Amount != -10 || b.Version != 2 {
+		t.Errorf("user 21: amount %v version %d, want the approved -10 and 2", b.Amount, b.Version)
+	}
+	if b := p.balance(22, "USD"); b.Amount != 50 || b.Version != 1 {
+		t.Errorf("user 22: amount %v version %d, want the rejected update left out", b.Amount, b.Version)
+	}
+}
@@ -0,0 +1,137 @@
+package processor
+
+import (
+	"context"
+
+	"balance-service/internal/config"
+	"balance-service/internal/metrics"
+	"balance-service/internal/model"
+	"balance-service/internal/repository"
+	"github.com/sirupsen/logrus"
+)
+
+// Modes of the negative balance policy.
+const (
+	PolicyAllow  = "allow"
+	PolicyReject = "reject"
+	PolicyClamp  = "clamp"
+	PolicyHold   = "hold"
+)
+
+// ReasonNegativeAmount is the dead-letter reason of updates refused by the
+// reject policy.
+const ReasonNegativeAmount = "negative_amount"
+
+var policyDecisions = metrics.NewCounter("balance_negative_policy_decisions_total", "Updates with a negative amount, by the policy applied.", "policy")
+
+// NegativePolicy decides what happens to updates with a negative amount. A
+// nil policy allows them.
+type NegativePolicy struct {
+	mode     string
+	segments []segment
+}
+
+type segment struct {
+	mode     string
+	users    map[uint]bool
+	min, max uint
+}
+
+func (s segment) contains(userID uint) bool {
+	if s.users[userID] {
+		return true
+	}
+	if s.min == 0 && s.max == 0 {
+		return false
+	}
+	return userID >= s.min && (s.max == 0 || userID <= s.max)
+}
+
+func NewNegativePolicy(cfg config.NegativeBalanceConfig) *NegativePolicy {
+	p := &NegativePolicy{mode: cfg.Mode}
+	for _, s := range cfg.Segments {
+		users := make(map[uint]bool, len(s.UserIDs))
+		for _, id := range s.UserIDs {
+			users[id] = true
+		}
+		p.segments = append(p.segments, segment{mode: s.Mode, users: users, min: s.MinUserID, max: s.MaxUserID})
+	}
+	return p
+}
+
+// Decide returns the mode that applies to msg, or "" if its amount is not
+// negative.
+func (p *NegativePolicy) Decide(msg BalanceMessage) string {
+	if msg.GetAmount() >= 0 {
+		return ""
+	}
+
+	mode := PolicyAllow
+	if p != nil {
+		mode = p.mode
+		for _, s := range p.segments {
+			if s.contains(msg.UserID) {
+				mode = s.mode
+				break
+			}
+		}
+	}
+	policyDecisions.Inc(mode)
+	return mode
+}
+
+// clamped returns the update with its amount raised to zero, and the
+// amount it had.
+func (u IncomingUpdate) clamped() (IncomingUpdate, float64) {
+	original := u.Payload.GetAmount()
+	zero := 0.0
+	u.Payload.NewAmount, u.Payload.Amount = &zero, nil
+	return u, original
+}
+
+// parkUpdates records the updates the policy kept from being applied:
+// rejected ones in the event log only, held ones also in the pending table
+// where they wait for review.
+func parkUpdates(ctx context.Context, pendingRepo *repository.PendingRepository, parked []IncomingUpdate, log *logrus.Logger) ([]model.BalanceEvent, error) {
+	events := make([]model.BalanceEvent, 0, len(parked))
+	for _, upd := range parked {
+		meta := model.EventMetadata{Policy: upd.Decision}
+		if upd.Decision == PolicyHold {
+			pending := &model.PendingUpdate{
+				UserID:    upd.Payload.UserID,
+				TenantID:  upd.Payload.TenantID,
+				Currency:  upd.Payload.Currency,
+				Version:   upd.Payload.Version,
+				Amount:    upd.Payload.GetAmount(),
+				EventID:   upd.Payload.EventID,
+				EventTime: upd.eventTime(),
+				Status:    model.PendingOpen,
+			}
+			if err := pendingRepo.SavePendingUpdate(ctx, pending); err != nil {
+				return nil, err
+			}
+			meta.PendingID = pending.ID
+		}
+
+		events = append(events, model.BalanceEvent{
+			UserID:    upd.Payload.UserID,
+			TenantID:  upd.Payload.TenantID,
+			Currency:  upd.Payload.Currency,
+			Amount:    upd.Payload.GetAmount(),
+			Version:   upd.Payload.Version,
+			UpdatedAt: upd.eventTime(),
+			EventID:   upd.Payload.EventID,
+			Metadata:  meta,
+		})
+		log.WithFields(logrus.Fields{
+			"user_id":    upd.Payload.UserID,
+			"tenant_id":  upd.Payload.TenantID,
+			"currency":   upd.Payload.Currency,
+			"version":    upd.Payload.Version,
+			"amount":     upd.Payload.GetAmount(),
+			"policy":     upd.Decision,
+			"pending_id": meta.PendingID,
+		}).Warn("negative balance update not applied")
+	}
+	return events, nil
+}
@@ -1,252 +1,469 @@
 package processor
 
 import (
-    "context"
-    "sort"
-    "strings"
-    "sync"
-    "time"
+	"context"
+	"sync"
+	"time"
 
-    "balance-service/internal/config"
+	"balance-service/internal/breaker"
+	"balance-service/internal/config"
+	"balance-service/internal/ledger"
 	"balance-service/internal/model"
 	"balance-service/internal/repository"
+	"balance-service/internal/tuning"
 	"github.com/rabbitmq/amqp091-go"
 	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
 )
 
 const (
-	batchTimeout = 5 * time.Second
-	dbTimeout    = 10 * time.Second
+	batchTimeout      = 5 * time.Second
+	dbTimeout         = 10 * time.Second
+	maxRetries        = 3
+	maxTransientPause = 30 * time.Second
 )
 
-// BalanceMessage represents the message format from RabbitMQ
-type BalanceMessage struct {
-	UserID    uint    `json:"user_id"`
-	NewAmount float64 `json:"new_amount"` // PHP sends "new_amount"
-	Amount    float64 `json:"amount"`     // Alternative field name
-	Version   uint    `json:"version"`
-	Timestamp string  `json:"timestamp"`   // ISO8601 format
-	UpdatedAt string  `json:"updated_at"` // Alternative field name
-	EventID   string  `json:"event_id"`
+type IncomingUpdate struct {
+	Payload  BalanceMessage
+	Delivery amqp091.Delivery
+	// Decision is the negative balance policy applied to the update, empty
+	// if its amount is not negative. Rejected updates are only recorded;
+	// their deliveries are dead-lettered once the record is committed.
+	Decision string
 }
 
-// GetAmount returns the amount value (handles both field names)
-func (m *BalanceMessage) GetAmount() float64 {
-	if m.NewAmount != 0 {
-		return m.NewAmount
+// eventTime is when the update happened according to its producer. Version 1
+// messages may omit the timestamp; then the publish time set by the broker
+// client is used, and failing that the time the update is stored.
+func (u IncomingUpdate) eventTime() time.Time {
+	ts, err := u.Payload.ParseTimestamp()
+	switch {
+	case err == nil:
+		return ts
+	case !u.Delivery.Timestamp.IsZero():
+		return u.Delivery.Timestamp
+	default:
+		return time.Now()
 	}
-	return m.Amount
 }
 
-// GetTimestamp returns the timestamp (handles both field names)
-func (m *BalanceMessage) GetTimestamp() string {
-	if m.UpdatedAt != "" {
-		return m.UpdatedAt
+// Pool tracks the processor workers so shutdown can wait for their final
+// flush and acks.
+type Pool struct {
+	wg sync.WaitGroup
+}
+
+// Wait blocks until every worker has returned or ctx is done. Workers return
+// once the updates channel is closed and their last batch is settled.
+func (p *Pool) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
-	return m.Timestamp
 }
 
-// ParseTimestamp parses the timestamp string to time.Time
-func (m *BalanceMessage) ParseTimestamp() (time.Time, error) {
-	ts := m.GetTimestamp()
-	if ts == "" {
-		return time.Now(), nil
+// StartProcessorPool starts the workers. They keep running until updates is
+// closed; cancelling ctx aborts in-flight database work instead and should
+// only be used when a graceful drain ran out of time.
+func StartProcessorPool(
+	ctx context.Context,
+	balanceRepo *repository.BalanceRepository,
+	eventRepo *repository.EventRepository,
+	cache *sync.Map,
+	updates <-chan IncomingUpdate,
+	br *breaker.Breaker,
+	rabbitCfg config.RabbitConfig,
+	settings *tuning.Store,
+	hooks Hooks,
+	log *logrus.Logger,
+) *Pool {
+	//     numWorkers := runtime.NumCPU()
+	numWorkers := rabbitCfg.Workers
+	log.Infof("Starting processor pool with %d workers", numWorkers)
+
+	pool := &Pool{}
+	for i := 0; i < numWorkers; i++ {
+		pool.wg.Add(1)
+		go func(id int) {
+			defer pool.wg.Done()
+			runWorker(ctx, id, balanceRepo, eventRepo, cache, updates, br, settings, hooks, log)
+		}(i)
 	}
+	return pool
+}
 
-	// Try ISO8601 format
-	t, err := time.Parse(time.RFC3339, ts)
-	if err != nil {
-		// Try other common formats
-		formats := []string{
-			"2006-01-02T15:04:05Z07:00",
-			"2006-01-02 15:04:05",
-			time.RFC3339Nano,
-		}
-		for _, format := range formats {
-			if t, err := time.Parse(format, ts); err == nil {
-				return t, nil
+func runWorker(
+	ctx context.Context,
+	id int,
+	balanceRepo *repository.BalanceRepository,
+	eventRepo *repository.EventRepository,
+	cache *sync.Map,
+	updates <-chan IncomingUpdate,
+	br *breaker.Breaker,
+	settings *tuning.Store,
+	hooks Hooks,
+	log *logrus.Logger,
+) {
+	changes := settings.Subscribe()
+	current := settings.Get()
+	batchSize := current.BatchSize
+	ticker := time.NewTicker(flushInterval(id, current.BatchInterval))
+	defer ticker.Stop()
+
+	batch := make([]IncomingUpdate, 0, batchSize)
+
+	// connFailures counts consecutive flushes that failed because the
+	// database was unreachable; it drives how long the worker pauses.
+	connFailures := 0
+
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		localBatch := batch
+		batch = make([]IncomingUpdate, 0, batchSize)
+
+		// While the breaker is open the database is known to be down; hand
+		// the messages back without another doomed round trip.
+		if br.State() == breaker.Open {
+			nackAll(localBatch, true)
+			return
+		}
+
+		commit, err := handleBatchWithRetry(ctx, id, balanceRepo, eventRepo, cache, localBatch, log)
+		if err == nil {
+			connFailures = 0
+			br.Success()
+			settleCommitted(ctx, id, localBatch, hooks)
+			hooks.committed(ctx, commit, log)
+			return
+		}
+
+		switch class := repository.ClassifyError(err); class {
+		case repository.ErrorPermanent:
+			connFailures = 0
+			log.WithError(err).Errorf("Worker %d: permanent error, isolating %d messages", id, len(localBatch))
+			settleIndividually(ctx, id, balanceRepo, eventRepo, cache, localBatch, hooks, log)
+		case repository.ErrorTransient:
+			if ctx.Err() == nil {
+				br.Failure()
+			}
+			connFailures++
+			pause := transientPause(connFailures)
+			log.WithError(err).Errorf("Worker %d: database unavailable, requeueing batch and pausing for %s", id, pause)
+			nackAll(localBatch, true)
+			sleepCtx(ctx, pause)
+		default:
+			connFailures = 0
+			log.WithError(err).Errorf("Worker %d: retries exhausted (%s), requeueing batch", id, class)
+			nackAll(localBatch, true)
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			flush()
+			return
+		case upd, ok := <-updates:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, upd)
+			if len(batch) >= batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-changes:
+			current = settings.Get()
+			batchSize = current.BatchSize
+			ticker.Reset(flushInterval(id, current.BatchInterval))
+			if len(batch) >= batchSize {
+				flush()
 			}
 		}
-		return time.Now(), err
 	}
-	return t, nil
 }
 
-type IncomingUpdate struct {
-	Payload  BalanceMessage
-	Delivery amqp091.Delivery
+// flushInterval staggers the workers' flushes by a tenth of the interval each
+// so they don't all hit the balances table at the same moment.
+func flushInterval(id int, interval time.Duration) time.Duration {
+	return interval + time.Duration(id)*interval/10
 }
 
-func StartProcessorPool(
-    ctx context.Context,
-    balanceRepo *repository.BalanceRepository,
-    eventRepo *repository.EventRepository,
-    cache *sync.Map,
-    updates <-chan IncomingUpdate,
-    rabbitCfg config.RabbitConfig,
-    batchSize int,
-    log *logrus.Logger,
-) {
-//     numWorkers := runtime.NumCPU()
-    numWorkers := rabbitCfg.Workers
-    log.Infof("Starting processor pool with %d workers", numWorkers)
+// ProcessMessages applies messages that did not come from RabbitMQ, e.g. a
+// replayed file, through the same batching path and negative balance policy
+// as the workers. Rejected messages are only recorded.
+func ProcessMessages(
+	ctx context.Context,
+	balanceRepo *repository.BalanceRepository,
+	eventRepo *repository.EventRepository,
+	cache *sync.Map,
+	messages []BalanceMessage,
+	policy *NegativePolicy,
+	log *logrus.Logger,
+) error {
+	updates := make([]IncomingUpdate, 0, len(messages))
+	for _, msg := range messages {
+		updates = append(updates, IncomingUpdate{Payload: msg, Decision: policy.Decide(msg)})
+	}
+	_, err := handleBatchWithRetry(ctx, 0, balanceRepo, eventRepo, cache, updates, log)
+	return err
+}
+
+// handleBatchWithRetry retries handleBatch while the failure is classified as
+// retryable (deadlocks, lock wait timeouts).
+func handleBatchWithRetry(
+	ctx context.Context,
+	id int,
+	balanceRepo *repository.BalanceRepository,
+	eventRepo *repository.EventRepository,
+	cache *sync.Map,
+	updates []IncomingUpdate,
+	log *logrus.Logger,
+) (Commit, error) {
+	var err error
+	for attempt := 1; attempt <= maxRetries; attempt++ {
+		var commit Commit
+		commit, err = handleBatch(ctx, balanceRepo, eventRepo, cache, updates, log)
+		if err == nil || repository.ClassifyError(err) != repository.ErrorRetryable {
+			return commit, err
+		}
 
-    for i := 0; i < numWorkers; i++ {
-        go runWorker(ctx, i, balanceRepo, eventRepo, cache, updates, batchSize, log)
-    }
+		log.Warnf("Worker %d: retryable database error (attempt %d/%d): %v", id, attempt, maxRetries, err)
+		sleepCtx(ctx, time.Millisecond*time.Duration(100*attempt))
+	}
+	return Commit{}, err
 }
 
-func runWorker(
-    ctx context.Context,
-    id int,
-    balanceRepo *repository.BalanceRepository,
-    eventRepo *repository.EventRepository,
-    cache *sync.Map,
-    updates <-chan IncomingUpdate,
-    batchSize int,
-    log *logrus.Logger,
+// settleIndividually replays a batch that failed with a permanent error one
+// message at a time, so that a single poison message is dead-lettered instead
+// of taking the rest of the batch down with it.
+func settleIndividually(
+	ctx context.Context,
+	id int,
+	balanceRepo *repository.BalanceRepository,
+	eventRepo *repository.EventRepository,
+	cache *sync.Map,
+	updates []IncomingUpdate,
+	hooks Hooks,
+	log *logrus.Logger,
 ) {
-    flushInterval := time.Duration(2000+(id*500)) * time.Millisecond
-    ticker := time.NewTicker(flushInterval)
-    defer ticker.Stop()
-
-    batch := make([]IncomingUpdate, 0, batchSize)
-
-    flush := func() {
-        if len(batch) == 0 {
-            return
-        }
-        localBatch := batch
-        batch = make([]IncomingUpdate, 0, batchSize)
-
-        maxRetries := 3
-        var err error
-
-        for i := 0; i < maxRetries; i++ {
-            err = handleBatch(ctx, balanceRepo, eventRepo, cache, localBatch, log)
-            if err == nil {
-                break
-            }
-
-            if strings.Contains(err.Error(), "1213") || strings.Contains(err.Error(), "Deadlock") {
-                log.Warnf("Worker %d: Deadlock detected (attempt %d/%d). Retrying...", id, i+1, maxRetries)
-                time.Sleep(time.Millisecond * time.Duration(100*(i+1)))
-                continue
-            }
-            break
-        }
-
-        if err != nil {
-            log.Errorf("Worker %d fatal error after retries: %v", id, err)
-            for _, upd := range localBatch {
-                _ = upd.Delivery.Nack(false, true)
-            }
-        } else {
-            for _, upd := range localBatch {
-                _ = upd.Delivery.Ack(false)
-            }
-        }
-    }
-
-    for {
-        select {
-        case <-ctx.Done():
-            flush()
-            return
-        case upd, ok := <-updates:
-            if !ok {
-                flush()
-                return
-            }
-            batch = append(batch, upd)
-            if len(batch) >= batchSize {
-                flush()
-            }
-        case <-ticker.C:
-            flush()
-        }
-    }
+	for _, upd := range updates {
+		commit, err := handleBatchWithRetry(ctx, id, balanceRepo, eventRepo, cache, []IncomingUpdate{upd}, log)
+		switch {
+		case err == nil:
+			settleCommitted(ctx, id, []IncomingUpdate{upd}, hooks)
+			hooks.committed(ctx, commit, log)
+		case repository.ClassifyError(err) == repository.ErrorPermanent:
+			log.WithFields(logrus.Fields{
+				"user_id":  upd.Payload.UserID,
+				"version":  upd.Payload.Version,
+				"event_id": upd.Payload.EventID,
+			}).WithError(err).Errorf("Worker %d: dead-lettering message", id)
+			hooks.deadLetter(ctx, upd.Delivery, Invalid(ReasonRejected, "", "%v", err), id)
+		default:
+			_ = upd.Delivery.Nack(false, true)
+		}
+	}
+}
+
+// settleCommitted settles the deliveries of committed updates: rejected
+// ones go to the dead-letter queue, now that the rejection is recorded, and
+// the rest are acked. A rejected update whose copy cannot be published is
+// requeued and recorded once more when it returns, like any redelivery.
+func settleCommitted(ctx context.Context, id int, updates []IncomingUpdate, hooks Hooks) {
+	for _, upd := range updates {
+		if upd.Decision == PolicyReject {
+			amount := upd.Payload.GetAmount()
+			hooks.deadLetter(ctx, upd.Delivery, Invalid(ReasonNegativeAmount, "new_amount", "%v is negative", amount), id)
+			continue
+		}
+		_ = upd.Delivery.Ack(false)
+	}
+}
+
+func nackAll(updates []IncomingUpdate, requeue bool) {
+	for _, upd := range updates {
+		_ = upd.Delivery.Nack(false, requeue)
+	}
+}
+
+// transientPause grows exponentially with consecutive connection failures so
+// that an unreachable database is not hammered by every worker.
+func transientPause(failures int) time.Duration {
+	pause := time.Second << uint(failures-1)
+	if pause <= 0 || pause > maxTransientPause {
+		return maxTransientPause
+	}
+	return pause
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
 }
 
 func handleBatch(
-    ctx context.Context,
-    balanceRepo *repository.BalanceRepository,
-    eventRepo *repository.EventRepository,
-    cache *sync.Map,
-    updates []IncomingUpdate,
-    log *logrus.Logger,
-) error {
-    ctx, cancel := context.WithTimeout(ctx, dbTimeout)
-    defer cancel()
-
-    deduped := make(map[uint]IncomingUpdate)
-    events := make([]model.BalanceEvent, 0, len(updates))
-    seenEventIDs := make(map[string]bool)
-
-    for _, upd := range updates {
-        payload := upd.Payload
-        if payload.UserID == 0 {
-            continue
-        }
-
-        if payload.EventID != "" {
-            if !seenEventIDs[payload.EventID] {
-                seenEventIDs[payload.EventID] = true
-                ts, _ := payload.ParseTimestamp()
-                events = append(events, model.BalanceEvent{
-                    UserID:    payload.UserID,
-                    Amount:    payload.GetAmount(),
-                    Version:   payload.Version,
-                    UpdatedAt: ts,
-                    EventID:   payload.EventID,
-                })
-            }
-        }
-
-        existing, ok := deduped[payload.UserID]
-        if !ok || payload.Version > existing.Payload.Version {
-            deduped[payload.UserID] = upd
-        }
-    }
-
-    if len(events) > 0 {
-        if err := eventRepo.SaveEventsBatch(ctx, events); err != nil {
-            return err
-        }
-    }
-
-    balances := make([]model.Balance, 0, len(deduped))
-    userIDs := make([]uint, 0, len(deduped))
-    for _, upd := range deduped {
-        balances = append(balances, model.Balance{
-            UserID:  upd.Payload.UserID,
-            Amount:  upd.Payload.GetAmount(),
-            Version: upd.Payload.Version,
-        })
-        userIDs = append(userIDs, upd.Payload.UserID)
-    }
-
-    sort.Slice(balances, func(i, j int) bool {
-        return balances[i].UserID < balances[j].UserID
-    })
-
-    if len(balances) > 0 {
-        if err := balanceRepo.SaveBalancesBatch(ctx, balances); err != nil {
-            return err
-        }
-
-        updatedBalances, err := balanceRepo.GetBalancesByUserIDs(ctx, userIDs)
-        if err == nil {
-            for _, b := range updatedBalances {
-                cache.Store(b.UserID, b.Amount)
-            }
-        }
-
-        log.WithFields(logrus.Fields{
-            "balances": len(balances),
-            "events":   len(events),
-        }).Info("batch upsert committed")
-    }
-
-    return nil
+	ctx context.Context,
+	balanceRepo *repository.BalanceRepository,
+	eventRepo *repository.EventRepository,
+	cache *sync.Map,
+	updates []IncomingUpdate,
+	log *logrus.Logger,
+) (Commit, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	deduped := make(map[model.WalletKey]IncomingUpdate)
+	events := make([]model.BalanceEvent, 0, len(updates))
+	seenEventIDs := make(map[string]bool)
+	checks := make([]versionCheck, 0, len(updates))
+	// policies are the policy notes of events by index, merged into their
+	// metadata once checkVersions has written it.
+	policies := make(map[int]model.EventMetadata)
+	var parked []IncomingUpdate
+
+	for _, upd := range updates {
+		payload := upd.Payload
+		if payload.UserID == 0 {
+			continue
+		}
+		// A redelivery within the batch is the same event, not a second
+		// arrival of its version.
+		if payload.EventID != "" {
+			if seenEventIDs[payload.EventID] {
+				continue
+			}
+			seenEventIDs[payload.EventID] = true
+		}
+
+		var policy model.EventMetadata
+		switch upd.Decision {
+		case PolicyHold, PolicyReject:
+			parked = append(parked, upd)
+			continue
+		case PolicyClamp:
+			var original float64
+			upd, original = upd.clamped()
+			payload = upd.Payload
+			policy.OriginalAmount = &original
+		}
+		policy.Policy = upd.Decision
+
+		check := versionCheck{wallet: payload.Wallet(), version: payload.Version, event: -1}
+		if payload.EventID != "" || upd.Decision != "" {
+			check.event = len(events)
+			if upd.Decision != "" {
+				policies[check.event] = policy
+			}
+			events = append(events, model.BalanceEvent{
+				UserID:    payload.UserID,
+				TenantID:  payload.TenantID,
+				Currency:  payload.Currency,
+				Amount:    payload.GetAmount(),
+				Version:   payload.Version,
+				UpdatedAt: upd.eventTime(),
+				EventID:   payload.EventID,
+			})
+		}
+		checks = append(checks, check)
+
+		existing, ok := deduped[payload.Wallet()]
+		if !ok || payload.Version > existing.Payload.Version {
+			deduped[payload.Wallet()] = upd
+		}
+	}
+
+	postings := make([]ledger.Posting, 0, len(deduped))
+	userIDs := make([]uint, 0, len(deduped))
+	for _, upd := range deduped {
+		postings = append(postings, ledger.Posting{
+			Kind: model.LedgerAdjustment,
+			Balance: model.Balance{
+				UserID:   upd.Payload.UserID,
+				TenantID: upd.Payload.TenantID,
+				Currency: upd.Payload.Currency,
+				Amount:   upd.Payload.GetAmount(),
+				Version:  upd.Payload.Version,
+			},
+			EventID: upd.Payload.EventID,
+		})
+		userIDs = append(userIDs, upd.Payload.UserID)
+	}
+
+	// The events, the ledger and the balances projection commit together.
+	var (
+		updatedBalances []model.Balance
+		before          map[model.WalletKey]model.Balance
+		gaps            []VersionGap
+		anomalies       map[string]int
+	)
+	err := balanceRepo.Transaction(ctx, func(tx *gorm.DB) error {
+		led := ledger.New(tx, log)
+		// Compare with what is applied before the upsert moves it forward,
+		// holding the wallets so no other batch moves them in between.
+		var err error
+		if before, err = currentBalances(ctx, led, checks); err != nil {
+			return err
+		}
+		gaps, anomalies = checkVersions(checks, events, before)
+		for i, policy := range policies {
+			events[i].Metadata.Policy, events[i].Metadata.OriginalAmount = policy.Policy, policy.OriginalAmount
+		}
+
+		txEvents := repository.NewEventRepository(tx, log)
+		parkedEvents, err := parkUpdates(ctx, repository.NewPendingRepository(tx, log), parked, log)
+		if err != nil {
+			return err
+		}
+		events = append(events, parkedEvents...)
+
+		if len(events) > 0 {
+			if err := txEvents.SaveEventsBatch(ctx, events); err != nil {
+				return err
+			}
+		}
+		if len(postings) == 0 {
+			return nil
+		}
+		if _, err := led.Apply(ctx, postings); err != nil {
+			return err
+		}
+		updatedBalances, err = repository.NewBalanceRepository(tx, log).GetBalancesByUserIDs(ctx, userIDs)
+		return err
+	})
+	if err != nil {
+		return Commit{}, err
+	}
+
+	var changes []BalanceChange
+	if len(postings) > 0 {
+		for _, b := range updatedBalances {
+			cache.Store(b.Key(), b.Amount)
+		}
+		changes = changedBalances(before, updatedBalances, deduped)
+
+		log.WithFields(logrus.Fields{
+			"balances": len(postings),
+			"events":   len(events),
+		}).Info("batch upsert committed")
+	}
+
+	recordAnomalies(gaps, anomalies, log)
+	return Commit{Changes: changes, Gaps: gaps}, nil
 }
@@ -0,0 +1,178 @@
+package processor
+
+import (
+	"context"
+	"time"
+
+	"balance-service/internal/ledger"
+	"balance-service/internal/metrics"
+	"balance-service/internal/model"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	versionAnomalies = metrics.NewCounter("balance_version_anomalies_total", "Updates whose version did not follow the applied one, by kind.", "kind")
+	versionsMissing  = metrics.NewCounter("balance_versions_missing_total", "Versions skipped by gaps between applied and received updates.")
+)
+
+// VersionGap is a wallet whose received version skipped versions that never
+// arrived.
+type VersionGap struct {
+	UserID          uint
+	TenantID        string
+	Currency        string
+	AppliedVersion  uint
+	ReceivedVersion uint
+	MissingVersions uint
+}
+
+// ResyncRequest asks the producer to re-send the authoritative state of a
+// wallet after a version gap.
+type ResyncRequest struct {
+	UserID          uint   `json:"user_id"`
+	TenantID        string `json:"tenant_id,omitempty"`
+	Currency        string `json:"currency"`
+	AppliedVersion  uint   `json:"applied_version"`
+	ReceivedVersion uint   `json:"received_version"`
+	MissingVersions uint   `json:"missing_versions"`
+	RequestedAt     string `json:"requested_at"`
+}
+
+// Resyncer delivers resync requests to the producer.
+type Resyncer interface {
+	RequestResync(ctx context.Context, req ResyncRequest) error
+}
+
+// versionCheck is one update of a batch, compared with the applied version.
+type versionCheck struct {
+	wallet  model.WalletKey
+	version uint
+	event   int // index into the batch's events, -1 if it has none
+}
+
+// currentBalances locks the wallets of checks for the batch's transaction
+// and returns the stored balances of their users. Reading them under the
+// lock keeps a concurrent batch from moving a wallet between the comparison
+// and the upsert.
+func currentBalances(ctx context.Context, led *ledger.Ledger, checks []versionCheck) (map[model.WalletKey]model.Balance, error) {
+	if len(checks) == 0 {
+		return nil, nil
+	}
+
+	wallets := make([]model.WalletKey, 0, len(checks))
+	for _, c := range checks {
+		wallets = append(wallets, c.wallet)
+	}
+	current, err := led.Lock(ctx, wallets)
+	if err != nil {
+		return nil, err
+	}
+	balances := make(map[model.WalletKey]model.Balance, len(current))
+	for _, b := range current {
+		balances[b.Key()] = b
+	}
+	return balances, nil
+}
+
+// checkVersions compares the updates of a batch, in the order they arrived,
+// with the versions already applied, as locked at the start of the batch's
+// transaction, and with the highest version the batch itself carried so far:
+// an update older than one before it in the batch is stale even if it is
+// newer than the stored balance. Events are annotated in place; the gaps are
+// returned once per wallet. Wallets without a stored balance have no
+// baseline until their first update.
+func checkVersions(checks []versionCheck, events []model.BalanceEvent, before map[model.WalletKey]model.Balance) ([]VersionGap, map[string]int) {
+	type state struct {
+		last  uint
+		known bool
+		gap   int // index into gaps, -1 until the wallet has one
+	}
+	wallets := make(map[model.WalletKey]*state)
+	var gaps []VersionGap
+	counts := make(map[string]int)
+	for _, c := range checks {
+		w, ok := wallets[c.wallet]
+		if !ok {
+			applied, known := before[c.wallet]
+			w = &state{last: applied.Version, known: known, gap: -1}
+			wallets[c.wallet] = w
+		}
+		if !w.known {
+			w.last, w.known = c.version, true
+			continue
+		}
+
+		meta := model.EventMetadata{AppliedVersion: w.last}
+		switch {
+		case c.version == w.last:
+			meta.Anomaly = model.AnomalyDuplicate
+		case c.version < w.last:
+			meta.Anomaly = model.AnomalyStale
+		case c.version > w.last+1:
+			meta.Anomaly = model.AnomalyGap
+			meta.MissingVersions = c.version - w.last - 1
+			if w.gap < 0 {
+				w.gap = len(gaps)
+				gaps = append(gaps, VersionGap{
+					UserID: c.wallet.UserID, TenantID: c.wallet.TenantID, Currency: c.wallet.Currency,
+					AppliedVersion: before[c.wallet].Version,
+				})
+			}
+			gaps[w.gap].MissingVersions += meta.MissingVersions
+			gaps[w.gap].ReceivedVersion = c.version
+		}
+		if c.version > w.last {
+			w.last = c.version
+		}
+
+		if meta.Anomaly == "" {
+			continue
+		}
+		counts[meta.Anomaly]++
+		if c.event >= 0 {
+			events[c.event].Metadata = meta
+		}
+	}
+	return gaps, counts
+}
+
+// recordAnomalies counts what a committed batch contained.
+func recordAnomalies(gaps []VersionGap, counts map[string]int, log *logrus.Logger) {
+	for kind, n := range counts {
+		versionAnomalies.Add(float64(n), kind)
+	}
+	for _, gap := range gaps {
+		versionsMissing.Add(float64(gap.MissingVersions))
+		log.WithFields(logrus.Fields{
+			"user_id":          gap.UserID,
+			"tenant_id":        gap.TenantID,
+			"currency":         gap.Currency,
+			"applied_version":  gap.AppliedVersion,
+			"received_version": gap.ReceivedVersion,
+			"missing_versions": gap.MissingVersions,
+		}).Warn("version gap detected")
+	}
+}
+
+// requestResyncs asks the producer to re-send the wallets with gaps. Failures
+// are logged only: the batch is already committed and the next gap, or the
+// reconciliation job, will catch the user again.
+func requestResyncs(ctx context.Context, resync Resyncer, gaps []VersionGap, log *logrus.Logger) {
+	if resync == nil {
+		return
+	}
+	for _, gap := range gaps {
+		err := resync.RequestResync(ctx, ResyncRequest{
+			UserID:          gap.UserID,
+			TenantID:        gap.TenantID,
+			Currency:        gap.Currency,
+			AppliedVersion:  gap.AppliedVersion,
+			ReceivedVersion: gap.ReceivedVersion,
+			MissingVersions: gap.MissingVersions,
+			RequestedAt:     time.Now().UTC().Format(time.RFC3339),
+		})
+		if err != nil {
+			log.WithError(err).WithField("user_id", gap.UserID).Warn("failed to request resync")
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package processor
+
+import (
+	"testing"
+
+	"balance-service/internal/model"
+)
+
+func TestCheckVersions(t *testing.T) {
+	known := model.WalletKey{UserID: 1, Currency: "USD"}
+	fresh := model.WalletKey{UserID: 2, Currency: "USD"}
+	before := map[model.WalletKey]model.Balance{known: {UserID: 1, Currency: "USD", Version: 5}}
+
+	events := make([]model.BalanceEvent, 5)
+	checks := []versionCheck{
+		{wallet: known, version: 9, event: 0},
+		{wallet: known, version: 4, event: 1},
+		{wallet: known, version: 6, event: 2},
+		{wallet: known, version: 6, event: 3},
+		// A wallet without a stored balance has no baseline.
+		{wallet: fresh, version: 3, event: 4},
+	}
+
+	gaps, counts := checkVersions(checks, events, before)
+
+	// Updates are checked in arrival order: once version 9 is in, the older
+	// ones of the batch are stale, although newer than the stored version.
+	want := map[int]model.EventMetadata{
+		0: {Anomaly: model.AnomalyGap, AppliedVersion: 5, MissingVersions: 3},
+		1: {Anomaly: model.AnomalyStale, AppliedVersion: 9},
+		2: {Anomaly: model.AnomalyStale, AppliedVersion: 9},
+		3: {Anomaly: model.AnomalyStale, AppliedVersion: 9},
+		4: {},
+	}
+	for i, meta := range want {
+		if events[i].Metadata != meta {
+			t.Errorf("event %d: %+v, want %+v", i, events[i].Metadata, meta)
+		}
+	}
+
+	if len(gaps) != 1 || gaps[0].UserID != 1 || gaps[0].AppliedVersion != 5 || gaps[0].ReceivedVersion != 9 || gaps[0].MissingVersions != 3 {
+		t.Errorf("gaps %+v, want user 1 from 5 to 9 missing 3", gaps)
+	}
+	if counts[model.AnomalyGap] != 1 || counts[model.AnomalyStale] != 3 || counts[model.AnomalyDuplicate] != 0 {
+		t.Errorf("counts %v, want one gap and three stale updates", counts)
+	}
+}
+
+func TestCheckVersionsInOrder(t *testing.T) {
+	known := model.WalletKey{UserID: 1, Currency: "USD"}
+	fresh := model.WalletKey{UserID: 2, Currency: "USD"}
+	before := map[model.WalletKey]model.Balance{known: {UserID: 1, Currency: "USD", Version: 5}}
+
+	events := make([]model.BalanceEvent, 7)
+	checks := []versionCheck{
+		{wallet: known, version: 6, event: 0},
+		{wallet: fresh, version: 3, event: 1},
+		{wallet: known, version: 6, event: 2},
+		{wallet: known, version: 8, event: 3},
+		// The first update sets the baseline of a new wallet.
+		{wallet: fresh, version: 4, event: 4},
+		{wallet: fresh, version: 7, event: 5},
+		{wallet: known, version: 10, event: 6},
+	}
+
+	gaps, counts := checkVersions(checks, events, before)
+
+	want := map[int]model.EventMetadata{
+		0: {},
+		1: {},
+		2: {Anomaly: model.AnomalyDuplicate, AppliedVersion: 6},
+		3: {Anomaly: model.AnomalyGap, AppliedVersion: 6, MissingVersions: 1},
+		4: {},
+		5: {Anomaly: model.AnomalyGap, AppliedVersion: 4, MissingVersions: 2},
+		6: {Anomaly: model.AnomalyGap, AppliedVersion: 8, MissingVersions: 1},
+	}
+	for i, meta := range want {
+		if events[i].Metadata != meta {
+			t.Errorf("event %d: %+v, want %+v", i, events[i].Metadata, meta)
+		}
+	}
+
+	// One gap per wallet, in the order the wallets first skipped a version.
+	if len(gaps) != 2 ||
+		gaps[0].UserID != 1 || gaps[0].AppliedVersion != 5 || gaps[0].ReceivedVersion != 10 || gaps[0].MissingVersions != 2 ||
+		gaps[1].UserID != 2 || gaps[1].ReceivedVersion != 7 || gaps[1].MissingVersions != 2 {
+		t.Errorf("gaps %+v, want user 1 missing 2 up to 10 and user 2 missing 2 up to 7", gaps)
+	}
+	if counts[model.AnomalyGap] != 3 || counts[model.AnomalyDuplicate] != 1 || counts[model.AnomalyStale] != 0 {
+		t.Errorf("counts %v, want three gaps and a duplicate", counts)
+	}
+}
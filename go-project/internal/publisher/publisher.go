@@ -0,0 +1,337 @@
+// Package publisher is the Go counterpart of the Laravel RabbitMQService: it
+// declares the exchange and the queue, publishes persistent messages with
+// publisher confirms and retries on a fresh connection when a publish fails.
+package publisher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"balance-service/internal/codec"
+	"balance-service/internal/config"
+	"balance-service/internal/metrics"
+	"balance-service/internal/processor"
+	"balance-service/internal/rabbitmq"
+	"balance-service/internal/signing"
+	"balance-service/internal/tenant"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/sirupsen/logrus"
+)
+
+// Formats a Publisher can encode balance messages in.
+const (
+	FormatJSON        = "json"
+	FormatProtobuf    = "protobuf"
+	FormatCloudEvents = "cloudevents"
+)
+
+const (
+	// confirmTimeout matches wait_for_pending_acks(5) in the PHP producer.
+	confirmTimeout = 5 * time.Second
+
+	cloudEventSource = "balance-service"
+	cloudEventType   = "balance.updated"
+)
+
+var (
+	published      = metrics.NewCounter("balance_publisher_published_total", "Messages confirmed by the broker.")
+	publishRetries = metrics.NewCounter("balance_publisher_retries_total", "Publish attempts that failed and were retried.")
+	publishFailed  = metrics.NewCounter("balance_publisher_failed_total", "Messages that could not be published after all retries.")
+)
+
+// Options tune a Publisher. Zero values fall back to the Laravel producer's
+// behaviour: JSON, unsigned, three attempts one second apart.
+type Options struct {
+	Format string
+	// SigningKeyID and SigningKey sign every message the way the consumer
+	// verifies them.
+	SigningKeyID string
+	SigningKey   string
+	MaxRetries   int
+	RetryDelay   time.Duration
+	// Queues are declared on connect in addition to the configured queue,
+	// for messages sent to them directly with Send.
+	Queues []string
+	// Exchanges are declared on connect as durable topic exchanges, for
+	// messages whose consumers bind their own queues.
+	Exchanges []string
+}
+
+type Publisher struct {
+	cfg  config.RabbitConfig
+	opts Options
+	log  *logrus.Logger
+
+	// publish makes one attempt at a message; tests replace it.
+	publish func(ctx context.Context, exchange, routingKey string, msg amqp.Publishing) error
+
+	mu   sync.Mutex
+	conn *amqp.Connection
+	ch   *amqp.Channel
+}
+
+// New prepares a publisher. It connects on the first publish.
+func New(cfg config.RabbitConfig, opts Options, log *logrus.Logger) (*Publisher, error) {
+	switch opts.Format {
+	case "":
+		opts.Format = FormatJSON
+	case FormatJSON, FormatProtobuf, FormatCloudEvents:
+	default:
+		return nil, fmt.Errorf("unsupported format %q, expected %s, %s or %s", opts.Format, FormatJSON, FormatProtobuf, FormatCloudEvents)
+	}
+	if (opts.SigningKeyID == "") != (opts.SigningKey == "") {
+		return nil, fmt.Errorf("signing needs both a key ID and a key")
+	}
+	if opts.MaxRetries < 1 {
+		opts.MaxRetries = 3
+	}
+	if opts.RetryDelay <= 0 {
+		opts.RetryDelay = time.Second
+	}
+
+	p := &Publisher{cfg: cfg, opts: opts, log: log}
+	p.publish = p.publishOnce
+	return p, nil
+}
+
+// Declare connects and declares the topology without publishing anything.
+func (p *Publisher) Declare() error {
+	_, err := p.channel()
+	return err
+}
+
+// Publish sends msg to the exchange, routed to the configured queue, and
+// waits for the broker's confirm. A failed attempt drops the connection and
+// is retried after a delay that grows with each attempt.
+func (p *Publisher) Publish(ctx context.Context, msg processor.BalanceMessage) error {
+	publishing, err := p.encode(msg)
+	if err != nil {
+		return err
+	}
+	return p.Send(ctx, p.cfg.Exchange, p.cfg.Queue, publishing)
+}
+
+// Send publishes an already encoded message with the same confirms and
+// retries as Publish. An empty exchange routes straight to the queue named
+// by routingKey.
+func (p *Publisher) Send(ctx context.Context, exchange, routingKey string, msg amqp.Publishing) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = p.publish(ctx, exchange, routingKey, msg); err == nil {
+			published.Inc()
+			return nil
+		}
+
+		if attempt >= p.opts.MaxRetries || ctx.Err() != nil {
+			publishFailed.Inc()
+			return fmt.Errorf("failed to publish after %d attempts: %w", attempt, err)
+		}
+
+		publishRetries.Inc()
+		p.log.WithError(err).WithFields(logrus.Fields{
+			"attempt":     attempt,
+			"max_retries": p.opts.MaxRetries,
+		}).Warn("failed to publish message to RabbitMQ")
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(p.retryDelay(attempt)):
+		}
+	}
+}
+
+// retryDelay is the pause after the given failed attempt.
+func (p *Publisher) retryDelay(attempt int) time.Duration {
+	return p.opts.RetryDelay * time.Duration(attempt)
+}
+
+// publishOnce publishes msg on the open channel and waits for its confirm.
+// A failure drops the connection.
+func (p *Publisher) publishOnce(ctx context.Context, exchange, routingKey string, msg amqp.Publishing) error {
+	ch, err := p.channel()
+	if err != nil {
+		return err
+	}
+	if err := confirmed(ctx, ch, exchange, routingKey, msg); err != nil {
+		p.reset(ch)
+		return err
+	}
+	return nil
+}
+
+func confirmed(ctx context.Context, ch *amqp.Channel, exchange, routingKey string, msg amqp.Publishing) error {
+	confirm, err := ch.PublishWithDeferredConfirmWithContext(ctx, exchange, routingKey, false, false, msg)
+	if err != nil {
+		return fmt.Errorf("failed to publish: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, confirmTimeout)
+	defer cancel()
+
+	acked, err := confirm.WaitContext(ctx)
+	if err != nil {
+		return fmt.Errorf("no confirm from broker: %w", err)
+	}
+	if !acked {
+		return fmt.Errorf("broker rejected the message")
+	}
+	return nil
+}
+
+// channel returns the open channel, connecting and declaring the topology
+// first if needed.
+func (p *Publisher) channel() (*amqp.Channel, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.ch != nil && !p.ch.IsClosed() {
+		return p.ch, nil
+	}
+	p.closeLocked()
+
+	conn, err := rabbitmq.Dial(p.cfg)
+	if err != nil {
+		return nil, err
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to open channel: %w", err)
+	}
+
+	if err := declare(ch, p.cfg.Exchange, p.cfg.Queue); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	for _, queue := range p.opts.Queues {
+		if _, err := ch.QueueDeclare(queue, true, false, false, false, nil); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to declare queue %s: %w", queue, err)
+		}
+	}
+	for _, exchange := range p.opts.Exchanges {
+		if err := ch.ExchangeDeclare(exchange, "topic", true, false, false, false, nil); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to declare exchange %s: %w", exchange, err)
+		}
+	}
+	if err := ch.Confirm(false); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to enable publisher confirms: %w", err)
+	}
+
+	p.conn, p.ch = conn, ch
+	p.log.WithFields(logrus.Fields{
+		"host":     p.cfg.Host,
+		"exchange": p.cfg.Exchange,
+	}).Info("publisher connected to RabbitMQ")
+	return ch, nil
+}
+
+// declare sets up the same durable topology as the Laravel producer: a
+// direct exchange and a queue bound under its own name.
+func declare(ch *amqp.Channel, exchange, queue string) error {
+	if err := ch.ExchangeDeclare(
+		exchange,
+		"direct",
+		true,  // durable
+		false, // auto-delete
+		false, // internal
+		false, // no-wait
+		nil,   // arguments
+	); err != nil {
+		return fmt.Errorf("failed to declare exchange: %w", err)
+	}
+
+	if _, err := ch.QueueDeclare(
+		queue,
+		true,  // durable
+		false, // delete when unused
+		false, // exclusive
+		false, // no-wait
+		nil,   // arguments
+	); err != nil {
+		return fmt.Errorf("failed to declare queue: %w", err)
+	}
+
+	if err := ch.QueueBind(queue, queue, exchange, false, nil); err != nil {
+		return fmt.Errorf("failed to bind queue: %w", err)
+	}
+	return nil
+}
+
+func (p *Publisher) encode(msg processor.BalanceMessage) (amqp.Publishing, error) {
+	var (
+		body        []byte
+		contentType string
+		err         error
+	)
+	switch p.opts.Format {
+	case FormatProtobuf:
+		contentType = codec.MediaTypeProtobuf
+		body, err = codec.EncodeProtobuf(msg)
+	case FormatCloudEvents:
+		contentType = codec.MediaTypeCloudEventsJSON
+		body, err = codec.EncodeCloudEvent(msg, cloudEventSource, cloudEventType)
+	default:
+		contentType = codec.MediaTypeJSON
+		body, err = json.Marshal(msg)
+	}
+	if err != nil {
+		return amqp.Publishing{}, fmt.Errorf("failed to encode message: %w", err)
+	}
+
+	publishing := amqp.Publishing{
+		ContentType:  contentType,
+		DeliveryMode: amqp.Persistent,
+		MessageId:    msg.EventID,
+		Timestamp:    time.Now(),
+		Body:         body,
+	}
+	if msg.TenantID != "" {
+		publishing.Headers = amqp.Table{tenant.Header: msg.TenantID}
+	}
+	if p.opts.SigningKeyID != "" {
+		if publishing.Headers == nil {
+			publishing.Headers = amqp.Table{}
+		}
+		// The signature covers the tenant header along with the body.
+		signature := signing.SignMessage([]byte(p.opts.SigningKey), signing.Message{
+			ContentType: contentType,
+			TenantID:    msg.TenantID,
+			Body:        body,
+		})
+		publishing.Headers[signing.HeaderKeyID] = p.opts.SigningKeyID
+		publishing.Headers[signing.HeaderSignature] = signature
+		publishing.Headers[signing.HeaderVersion] = signing.Version2
+	}
+	return publishing, nil
+}
+
+// reset drops the connection of a channel that failed so the next attempt
+// starts clean. A connection another caller already replaced is left alone.
+func (p *Publisher) reset(failed *amqp.Channel) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.ch == failed {
+		p.closeLocked()
+	}
+}
+
+func (p *Publisher) closeLocked() {
+	if p.conn != nil {
+		_ = p.conn.Close()
+	}
+	p.conn, p.ch = nil, nil
+}
+
+// Close closes the connection.
+func (p *Publisher) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closeLocked()
+}
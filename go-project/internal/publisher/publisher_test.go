@@ -0,0 +1,221 @@
+package publisher
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"balance-service/internal/codec"
+	"balance-service/internal/config"
+	"balance-service/internal/processor"
+	"balance-service/internal/signing"
+	"balance-service/internal/tenant"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/sirupsen/logrus"
+)
+
+func testPublisher(t *testing.T, opts Options) *Publisher {
+	t.Helper()
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	p, err := New(config.RabbitConfig{Exchange: "balance_exchange", Queue: "balance_updates"}, opts, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func testMessage(tenantID string) processor.BalanceMessage {
+	amount := 12.5
+	return processor.BalanceMessage{
+		SchemaVersion: processor.LatestSchemaVersion,
+		UserID:        42,
+		NewAmount:     &amount,
+		Version:       3,
+		Timestamp:     "2026-10-18T12:00:00Z",
+		EventID:       "evt-3",
+		Currency:      "EUR",
+		TenantID:      tenantID,
+	}
+}
+
+func TestNewValidatesOptions(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		opts Options
+		err  string
+	}{
+		{"defaults", Options{}, ""},
+		{"protobuf", Options{Format: FormatProtobuf}, ""},
+		{"signed", Options{SigningKeyID: "k1", SigningKey: "secret"}, ""},
+		{"unknown format", Options{Format: "xml"}, `unsupported format "xml"`},
+		{"key without ID", Options{SigningKey: "secret"}, "both a key ID and a key"},
+		{"ID without key", Options{SigningKeyID: "k1"}, "both a key ID and a key"},
+	} {
+		p, err := New(config.RabbitConfig{}, c.opts, logrus.New())
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("%s: error %v, want %q", c.name, err, c.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if c.opts.Format == "" && p.opts.Format != FormatJSON {
+			t.Errorf("%s: format %q, want JSON by default", c.name, p.opts.Format)
+		}
+		if p.opts.MaxRetries != 3 || p.opts.RetryDelay != time.Second {
+			t.Errorf("%s: %d retries %v apart, want the Laravel producer's 3 a second apart", c.name, p.opts.MaxRetries, p.opts.RetryDelay)
+		}
+	}
+}
+
+func TestEncode(t *testing.T) {
+	registry := codec.NewRegistry()
+	for format, contentType := range map[string]string{
+		FormatJSON:        codec.MediaTypeJSON,
+		FormatProtobuf:    codec.MediaTypeProtobuf,
+		FormatCloudEvents: codec.MediaTypeCloudEventsJSON,
+	} {
+		p := testPublisher(t, Options{Format: format})
+		msg := testMessage("acme")
+		publishing, err := p.encode(msg)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if publishing.ContentType != contentType {
+			t.Errorf("%s: content type %q, want %q", format, publishing.ContentType, contentType)
+		}
+		if publishing.DeliveryMode != amqp.Persistent || publishing.MessageId != msg.EventID {
+			t.Errorf("%s: delivery mode %d, message ID %q", format, publishing.DeliveryMode, publishing.MessageId)
+		}
+		if publishing.Headers[tenant.Header] != "acme" {
+			t.Errorf("%s: headers %v, want the tenant", format, publishing.Headers)
+		}
+		if _, signed := publishing.Headers[signing.HeaderSignature]; signed {
+			t.Errorf("%s: signed without a key", format)
+		}
+
+		decoded, err := registry.Decode(publishing.ContentType, publishing.Headers, publishing.Body)
+		if err != nil {
+			t.Fatalf("%s: the consumer cannot decode it: %v", format, err)
+		}
+		if decoded.UserID != msg.UserID || decoded.NewAmount == nil || *decoded.NewAmount != *msg.NewAmount || decoded.Version != msg.Version ||
+			decoded.EventID != msg.EventID || decoded.Currency != msg.Currency {
+			t.Errorf("%s: decoded %+v, want %+v", format, decoded, msg)
+		}
+	}
+
+	// The default tenant sends no header.
+	publishing, err := testPublisher(t, Options{}).encode(testMessage(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if publishing.Headers != nil {
+		t.Errorf("headers %v for the default tenant", publishing.Headers)
+	}
+}
+
+func TestEncodeSignsTheTenantHeader(t *testing.T) {
+	verifier := signing.NewVerifier(map[string]string{"k1": "secret"})
+	for _, format := range []string{FormatJSON, FormatProtobuf, FormatCloudEvents} {
+		p := testPublisher(t, Options{Format: format, SigningKeyID: "k1", SigningKey: "secret"})
+		publishing, err := p.encode(testMessage("acme"))
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		headers := publishing.Headers
+		if headers[signing.HeaderVersion] != signing.Version2 {
+			t.Errorf("%s: signature version %v, want %s", format, headers[signing.HeaderVersion], signing.Version2)
+		}
+		verify := func(tenantID string) error {
+			keyID, _ := headers[signing.HeaderKeyID].(string)
+			signature, _ := headers[signing.HeaderSignature].(string)
+			version, _ := headers[signing.HeaderVersion].(string)
+			return verifier.Verify(keyID, signature, version, signing.Message{
+				ContentType: publishing.ContentType,
+				TenantID:    tenantID,
+				Body:        publishing.Body,
+			})
+		}
+		if err := verify(headers[tenant.Header].(string)); err != nil {
+			t.Errorf("%s: %v", format, err)
+		}
+		// Moving the message to another tenant breaks the signature.
+		if err := verify("globex"); !errors.Is(err, signing.ErrInvalidSignature) {
+			t.Errorf("%s: signature with another tenant header: %v, want %v", format, err, signing.ErrInvalidSignature)
+		}
+	}
+}
+
+func TestSendRetries(t *testing.T) {
+	failure := errors.New("connection reset")
+	for _, c := range []struct {
+		name     string
+		failures int
+		attempts int
+		ok       bool
+	}{
+		{"first attempt", 0, 1, true},
+		{"after a failure", 1, 2, true},
+		{"last attempt", 2, 3, true},
+		{"gives up", 5, 3, false},
+	} {
+		p := testPublisher(t, Options{MaxRetries: 3, RetryDelay: time.Millisecond})
+		attempts := 0
+		p.publish = func(context.Context, string, string, amqp.Publishing) error {
+			attempts++
+			if attempts <= c.failures {
+				return failure
+			}
+			return nil
+		}
+
+		err := p.Send(context.Background(), "", "balance_updates", amqp.Publishing{})
+		if attempts != c.attempts {
+			t.Errorf("%s: %d attempts, want %d", c.name, attempts, c.attempts)
+		}
+		if c.ok && err != nil {
+			t.Errorf("%s: %v", c.name, err)
+		}
+		if !c.ok && !errors.Is(err, failure) {
+			t.Errorf("%s: error %v, want the last failure", c.name, err)
+		}
+	}
+}
+
+func TestSendStopsRetryingWhenCanceled(t *testing.T) {
+	p := testPublisher(t, Options{MaxRetries: 5, RetryDelay: time.Hour})
+	ctx, cancel := context.WithCancel(context.Background())
+	attempts := 0
+	p.publish = func(context.Context, string, string, amqp.Publishing) error {
+		attempts++
+		cancel()
+		return errors.New("connection reset")
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- p.Send(ctx, "", "balance_updates", amqp.Publishing{}) }()
+	select {
+	case err := <-done:
+		if err == nil || attempts != 1 {
+			t.Errorf("Send = %v after %d attempts, want a failure after one", err, attempts)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Send waited out the retry delay after cancellation")
+	}
+}
+
+func TestRetryDelayGrows(t *testing.T) {
+	p := testPublisher(t, Options{RetryDelay: 100 * time.Millisecond})
+	for attempt, want := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 3: 300 * time.Millisecond} {
+		if got := p.retryDelay(attempt); got != want {
+			t.Errorf("delay after attempt %d: %v, want %v", attempt, got, want)
+		}
+	}
+}
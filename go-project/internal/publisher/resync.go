@@ -0,0 +1,165 @@
+package publisher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"balance-service/internal/config"
+	"balance-service/internal/metrics"
+	"balance-service/internal/model"
+	"balance-service/internal/processor"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/sirupsen/logrus"
+)
+
+// ResyncMessageType is the AMQP type of resync requests.
+const ResyncMessageType = "balance.resync_requested"
+
+const (
+	// resyncCooldown keeps a wallet whose gap spans several batches from being
+	// requested over and over while the producer catches up.
+	resyncCooldown = time.Minute
+	resyncBacklog  = 1000
+)
+
+var (
+	resyncRequested = metrics.NewCounter("balance_resync_requested_total", "Resync requests published to the producer.")
+	resyncDropped   = metrics.NewCounter("balance_resync_dropped_total", "Resync requests dropped because the backlog was full or publishing failed.")
+)
+
+// ResyncRequester publishes resync requests to the queue the producer
+// listens on. Requests are queued and sent in the background so that a slow
+// broker never holds up the processor workers.
+type ResyncRequester struct {
+	pub   *Publisher
+	queue string
+	log   *logrus.Logger
+
+	requests chan processor.ResyncRequest
+	done     chan struct{}
+
+	mu     sync.Mutex
+	sent   map[model.WalletKey]time.Time
+	closed bool
+}
+
+// NewResyncRequester starts a requester for cfg.Resync.Queue.
+func NewResyncRequester(cfg config.RabbitConfig, log *logrus.Logger) (*ResyncRequester, error) {
+	pub, err := New(cfg, Options{Queues: []string{cfg.Resync.Queue}}, log)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &ResyncRequester{
+		pub:      pub,
+		queue:    cfg.Resync.Queue,
+		log:      log,
+		requests: make(chan processor.ResyncRequest, resyncBacklog),
+		done:     make(chan struct{}),
+		sent:     make(map[model.WalletKey]time.Time),
+	}
+	go r.run()
+	return r, nil
+}
+
+// RequestResync implements processor.Resyncer. It never blocks.
+func (r *ResyncRequester) RequestResync(_ context.Context, req processor.ResyncRequest) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return fmt.Errorf("resync requester is closed")
+	}
+	wallet := resyncWallet(req)
+	if last, ok := r.sent[wallet]; ok && time.Since(last) < resyncCooldown {
+		return nil
+	}
+	if len(r.sent) >= resyncBacklog {
+		r.pruneLocked()
+	}
+
+	select {
+	case r.requests <- req:
+		// A dropped request must not hold off the next gap's request.
+		r.sent[wallet] = time.Now()
+		return nil
+	default:
+		resyncDropped.Inc()
+		return fmt.Errorf("resync backlog of %d requests is full", resyncBacklog)
+	}
+}
+
+func (r *ResyncRequester) run() {
+	defer close(r.done)
+
+	for req := range r.requests {
+		body, err := json.Marshal(req)
+		if err != nil {
+			resyncDropped.Inc()
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		err = r.pub.Send(ctx, "", r.queue, amqp.Publishing{
+			ContentType:  "application/json",
+			DeliveryMode: amqp.Persistent,
+			Type:         ResyncMessageType,
+			Timestamp:    time.Now(),
+			Body:         body,
+		})
+		cancel()
+		if err != nil {
+			resyncDropped.Inc()
+			r.forget(resyncWallet(req))
+			r.log.WithError(err).WithFields(logrus.Fields{
+				"user_id":   req.UserID,
+				"tenant_id": req.TenantID,
+				"currency":  req.Currency,
+			}).Error("failed to publish resync request")
+			continue
+		}
+
+		resyncRequested.Inc()
+		r.log.WithFields(logrus.Fields{
+			"user_id":          req.UserID,
+			"tenant_id":        req.TenantID,
+			"currency":         req.Currency,
+			"applied_version":  req.AppliedVersion,
+			"received_version": req.ReceivedVersion,
+		}).Info("resync requested")
+	}
+}
+
+func (r *ResyncRequester) pruneLocked() {
+	for wallet, last := range r.sent {
+		if time.Since(last) >= resyncCooldown {
+			delete(r.sent, wallet)
+		}
+	}
+}
+
+// forget lets the next gap of a wallet whose request was lost try again.
+func (r *ResyncRequester) forget(wallet model.WalletKey) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.sent, wallet)
+}
+
+func resyncWallet(req processor.ResyncRequest) model.WalletKey {
+	return model.WalletKey{TenantID: req.TenantID, UserID: req.UserID, Currency: req.Currency}
+}
+
+// Close sends what is queued and closes the connection. Later requests are
+// refused.
+func (r *ResyncRequester) Close() {
+	r.mu.Lock()
+	r.closed = true
+	close(r.requests)
+	r.mu.Unlock()
+
+	<-r.done
+	r.pub.Close()
+}
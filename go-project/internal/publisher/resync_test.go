@@ -0,0 +1,53 @@
+package publisher
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"balance-service/internal/model"
+	"balance-service/internal/processor"
+)
+
+func TestRequestResyncCooldown(t *testing.T) {
+	// No run loop: requests stay queued, so a backlog of one fills up.
+	r := &ResyncRequester{
+		requests: make(chan processor.ResyncRequest, 1),
+		sent:     make(map[model.WalletKey]time.Time),
+	}
+	ctx := context.Background()
+	request := func(req processor.ResyncRequest) error {
+		t.Helper()
+		return r.RequestResync(ctx, req)
+	}
+
+	if err := request(processor.ResyncRequest{UserID: 1, Currency: "USD"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := request(processor.ResyncRequest{UserID: 1, Currency: "USD"}); err != nil {
+		t.Errorf("request within the cooldown: %v, want it skipped quietly", err)
+	}
+	if err := request(processor.ResyncRequest{UserID: 2, Currency: "USD"}); err == nil {
+		t.Error("request beyond the backlog succeeded")
+	}
+	<-r.requests
+
+	// Other wallets of the user have their own cooldown.
+	for _, req := range []processor.ResyncRequest{{UserID: 1, Currency: "EUR"}, {UserID: 1, TenantID: "acme", Currency: "USD"}} {
+		if err := request(req); err != nil {
+			t.Errorf("request for %+v: %v", req, err)
+		}
+		if len(r.requests) != 1 {
+			t.Errorf("request for %+v was not queued", req)
+		}
+		<-r.requests
+	}
+
+	// The dropped request does not start a cooldown.
+	if err := request(processor.ResyncRequest{UserID: 2, Currency: "USD"}); err != nil {
+		t.Errorf("retry of a dropped request: %v", err)
+	}
+	if len(r.requests) != 1 {
+		t.Errorf("%d requests queued, want the retry", len(r.requests))
+	}
+}
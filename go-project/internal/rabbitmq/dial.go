@@ -0,0 +1,82 @@
+// Package rabbitmq holds what the consumer and the publisher share about
+// connecting to the broker.
+package rabbitmq
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"balance-service/internal/config"
+	"balance-service/internal/secrets"
+	"balance-service/internal/tlsutil"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// secretTimeout bounds fetching the password before a connection attempt.
+const secretTimeout = 10 * time.Second
+
+// Dial opens a connection with the settings from DialConfig.
+func Dial(cfg config.RabbitConfig) (*amqp.Connection, error) {
+	dsn, amqpCfg, err := DialConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := amqp.DialConfig(dsn, amqpCfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial RabbitMQ: %w", err)
+	}
+	return conn, nil
+}
+
+// DialConfig builds the broker URL and client settings: amqps with the
+// configured certificates when TLS is enabled, and SASL EXTERNAL when the
+// client certificate is the credential. A password given by reference is
+// fetched again for every attempt so rotated credentials take effect on the
+// next reconnect.
+func DialConfig(cfg config.RabbitConfig) (string, amqp.Config, error) {
+	amqpCfg := amqp.Config{
+		Heartbeat: 60 * time.Second,
+		Dial:      amqp.DefaultDial(time.Second * 30),
+	}
+
+	uri := amqp.URI{
+		Scheme:   "amqp",
+		Host:     cfg.Host,
+		Port:     cfg.Port,
+		Username: cfg.User,
+		Password: cfg.Password,
+		Vhost:    cfg.VHost,
+	}
+
+	if cfg.PasswordRef != "" && cfg.AuthMechanism != "external" {
+		ctx, cancel := context.WithTimeout(context.Background(), secretTimeout)
+		defer cancel()
+
+		password, err := secrets.Resolve(ctx, cfg.PasswordRef)
+		if err != nil {
+			return "", amqpCfg, fmt.Errorf("RabbitMQ credentials: %w", err)
+		}
+		uri.Password = password
+	}
+
+	if cfg.TLS.Enabled {
+		tlsCfg, err := tlsutil.Load(cfg.TLS)
+		if err != nil {
+			return "", amqpCfg, fmt.Errorf("RabbitMQ TLS: %w", err)
+		}
+		if tlsCfg.ServerName == "" {
+			tlsCfg.ServerName = cfg.Host
+		}
+		uri.Scheme = "amqps"
+		amqpCfg.TLSClientConfig = tlsCfg
+	}
+
+	if cfg.AuthMechanism == "external" {
+		uri.Username, uri.Password = "", ""
+		amqpCfg.SASL = []amqp.Authentication{&amqp.ExternalAuth{}}
+	}
+
+	return uri.String(), amqpCfg, nil
+}
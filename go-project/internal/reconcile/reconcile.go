@@ -0,0 +1,343 @@
+// Package reconcile compares the local balances with the upstream Laravel
+// table, which is the source of truth, and optionally repairs the drift left
+// behind by lost messages. Upstream keeps one balance per user, so only the
+// local wallets of the default tenant in the default currency are compared.
+// Repairs go through synthetic events in balance_events so that every correction stays auditable.
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"balance-service/internal/ledger"
+	"balance-service/internal/metrics"
+	"balance-service/internal/model"
+	"balance-service/internal/repository"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// Kinds of difference between the upstream and the local balance of a user.
+const (
+	KindMissing  = "missing"         // upstream only; repairable
+	KindBehind   = "behind"          // local version is older; repairable
+	KindMismatch = "amount_mismatch" // same version, different amount; repairable
+	KindAhead    = "ahead"           // local version is newer; reported only
+	KindExtra    = "extra"           // local only; reported only
+)
+
+var (
+	runs        = metrics.NewCounter("balance_reconcile_runs_total", "Reconciliation runs, by result.", "result")
+	differences = metrics.NewGauge("balance_reconcile_differences", "Differences found by the last reconciliation run, by kind.", "kind")
+	repaired    = metrics.NewCounter("balance_reconcile_repaired_total", "Balances corrected by reconciliation.")
+)
+
+// MaxDetails is how many differences a report lists unless Options say
+// otherwise.
+const MaxDetails = 1000
+
+// Options tune a run.
+type Options struct {
+	BatchSize int
+	Repair    bool
+	// Currency is the local wallet the upstream balances are compared
+	// with.
+	Currency string
+	// MaxDetails caps how many differences the report lists; all of them
+	// are counted regardless.
+	MaxDetails int
+}
+
+// Diff is one user whose balances disagree.
+type Diff struct {
+	UserID          uint     `json:"user_id"`
+	Kind            string   `json:"kind"`
+	UpstreamVersion uint     `json:"upstream_version,omitempty"`
+	UpstreamAmount  *float64 `json:"upstream_amount,omitempty"`
+	LocalVersion    uint     `json:"local_version,omitempty"`
+	LocalAmount     *float64 `json:"local_amount,omitempty"`
+	Repaired        bool     `json:"repaired"`
+	// Superseded is set when the local balance moved past the upstream
+	// version before the repair could apply it.
+	Superseded bool `json:"superseded,omitempty"`
+}
+
+// Report summarises a run.
+type Report struct {
+	StartedAt   time.Time      `json:"started_at"`
+	Duration    string         `json:"duration"`
+	Currency    string         `json:"currency"`
+	Upstream    int            `json:"upstream_balances"`
+	Local       int            `json:"local_balances"`
+	Differences map[string]int `json:"differences"`
+	Repaired    int            `json:"repaired"`
+	Superseded  int            `json:"superseded,omitempty"`
+	Details     []Diff         `json:"details,omitempty"`
+	Truncated   bool           `json:"truncated,omitempty"`
+}
+
+// Unresolved counts the differences a run left in place.
+func (r *Report) Unresolved() int {
+	total := 0
+	for _, n := range r.Differences {
+		total += n
+	}
+	return total - r.Repaired
+}
+
+type Reconciler struct {
+	upstream *repository.BalanceRepository
+	local    *repository.BalanceRepository
+	localDB  *gorm.DB
+	log      *logrus.Logger
+}
+
+// New compares the balances table of upstreamDB with the one of localDB.
+func New(upstreamDB, localDB *gorm.DB, log *logrus.Logger) *Reconciler {
+	return &Reconciler{
+		upstream: repository.NewBalanceRepository(upstreamDB, log),
+		local:    repository.NewBalanceRepository(localDB, log),
+		localDB:  localDB,
+		log:      log,
+	}
+}
+
+// Run walks both tables in user ID order and diffs them by version and
+// amount. With Repair, missing, behind and mismatching balances are set to
+// the upstream state; local balances that are ahead or unknown upstream are
+// only reported, since overwriting them would move a version backwards.
+func (r *Reconciler) Run(ctx context.Context, opts Options) (*Report, error) {
+	report, err := r.run(ctx, opts)
+	if err != nil {
+		runs.Inc("failed")
+		return nil, err
+	}
+	runs.Inc("ok")
+	for _, kind := range []string{KindMissing, KindBehind, KindMismatch, KindAhead, KindExtra} {
+		differences.Set(float64(report.Differences[kind]), kind)
+	}
+	return report, nil
+}
+
+func (r *Reconciler) run(ctx context.Context, opts Options) (*Report, error) {
+	report := &Report{StartedAt: time.Now().UTC(), Currency: opts.Currency, Differences: make(map[string]int)}
+	upstream := &cursor{repo: r.upstream, batchSize: opts.BatchSize}
+	local := &cursor{repo: r.local, scope: &repository.BalanceScope{Currency: opts.Currency}, batchSize: opts.BatchSize}
+	var pending []Diff
+
+	record := func(d Diff) error {
+		report.Differences[d.Kind]++
+		if len(report.Details) < opts.MaxDetails {
+			report.Details = append(report.Details, d)
+		} else {
+			report.Truncated = true
+		}
+
+		if opts.Repair && repairable(d.Kind) {
+			pending = append(pending, d)
+			if len(pending) >= opts.BatchSize {
+				if err := r.repair(ctx, report, opts.Currency, pending); err != nil {
+					return err
+				}
+				pending = pending[:0]
+			}
+		}
+		return nil
+	}
+
+	for {
+		up, err := upstream.peek(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("read upstream balances: %w", err)
+		}
+		loc, err := local.peek(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("read local balances: %w", err)
+		}
+		if up == nil && loc == nil {
+			break
+		}
+
+		var d *Diff
+		switch {
+		case loc == nil || (up != nil && up.UserID < loc.UserID):
+			report.Upstream++
+			upstream.next()
+			d = &Diff{UserID: up.UserID, Kind: KindMissing}
+			d.setUpstream(up)
+		case up == nil || loc.UserID < up.UserID:
+			report.Local++
+			local.next()
+			d = &Diff{UserID: loc.UserID, Kind: KindExtra}
+			d.setLocal(loc)
+		default:
+			report.Upstream++
+			report.Local++
+			upstream.next()
+			local.next()
+			if kind := compare(up, loc); kind != "" {
+				d = &Diff{UserID: up.UserID, Kind: kind}
+				d.setUpstream(up)
+				d.setLocal(loc)
+			}
+		}
+
+		if d != nil {
+			if err := record(*d); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if len(pending) > 0 {
+		if err := r.repair(ctx, report, opts.Currency, pending); err != nil {
+			return nil, err
+		}
+	}
+
+	report.Duration = time.Since(report.StartedAt).String()
+	return report, nil
+}
+
+func compare(up, loc *model.Balance) string {
+	switch {
+	case loc.Version < up.Version:
+		return KindBehind
+	case loc.Version > up.Version:
+		return KindAhead
+	case cents(loc.Amount) != cents(up.Amount):
+		return KindMismatch
+	default:
+		return ""
+	}
+}
+
+// cents compares amounts the way the decimal(15,2) columns store them.
+func cents(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
+
+func repairable(kind string) bool {
+	return kind == KindMissing || kind == KindBehind || kind == KindMismatch
+}
+
+// repair posts the balances of diffs to the upstream state through the
+// ledger and writes a correction event per balance it moved, in one
+// transaction. The version guard leaves a balance alone if the processor
+// moved it past upstream in the meantime; such differences are reported as
+// superseded instead of repaired.
+func (r *Reconciler) repair(ctx context.Context, report *Report, currency string, diffs []Diff) error {
+	now := time.Now().UTC()
+	postings := make([]ledger.Posting, 0, len(diffs))
+	byUser := make(map[uint]Diff, len(diffs))
+	for _, d := range diffs {
+		byUser[d.UserID] = d
+		postings = append(postings, ledger.Posting{
+			Kind: model.LedgerReconciliation,
+			Balance: model.Balance{
+				UserID:   d.UserID,
+				Currency: currency,
+				Amount:   *d.UpstreamAmount,
+				Version:  d.UpstreamVersion,
+			},
+			EventID: fmt.Sprintf("reconcile-%d-%d-%d", d.UserID, d.UpstreamVersion, now.Unix()),
+		})
+	}
+
+	var applied []ledger.Posting
+	err := r.localDB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		if applied, err = ledger.New(tx, r.log).Apply(ctx, postings); err != nil || len(applied) == 0 {
+			return err
+		}
+		events := make([]model.BalanceEvent, 0, len(applied))
+		for _, p := range applied {
+			d := byUser[p.Balance.UserID]
+			events = append(events, model.BalanceEvent{
+				UserID:    d.UserID,
+				Currency:  currency,
+				Amount:    *d.UpstreamAmount,
+				Version:   d.UpstreamVersion,
+				UpdatedAt: now,
+				EventID:   p.EventID,
+				Metadata: model.EventMetadata{
+					AppliedVersion: d.LocalVersion,
+					Source:         model.SourceReconcile,
+					Correction:     d.Kind,
+					PreviousAmount: d.LocalAmount,
+				},
+			})
+		}
+		return repository.NewEventRepository(tx, r.log).SaveEventsBatch(ctx, events)
+	})
+	if err != nil {
+		return fmt.Errorf("repair %d balances: %w", len(diffs), err)
+	}
+
+	report.Repaired += len(applied)
+	report.Superseded += len(diffs) - len(applied)
+	repaired.Add(float64(len(applied)))
+	// Mark the listed differences that this batch covered.
+	fixed := make(map[uint]bool, len(applied))
+	for _, p := range applied {
+		fixed[p.Balance.UserID] = true
+	}
+	for i := range report.Details {
+		d := &report.Details[i]
+		if _, covered := byUser[d.UserID]; !covered || !repairable(d.Kind) {
+			continue
+		}
+		d.Repaired, d.Superseded = fixed[d.UserID], !fixed[d.UserID]
+	}
+
+	r.log.WithFields(logrus.Fields{
+		"balances":   len(applied),
+		"superseded": len(diffs) - len(applied),
+	}).Info("reconciliation repaired balances")
+	return nil
+}
+
+func (d *Diff) setUpstream(b *model.Balance) {
+	amount := b.Amount
+	d.UpstreamVersion, d.UpstreamAmount = b.Version, &amount
+}
+
+func (d *Diff) setLocal(b *model.Balance) {
+	amount := b.Amount
+	d.LocalVersion, d.LocalAmount = b.Version, &amount
+}
+
+// cursor pages through a balances table in user ID order, through the
+// wallets in scope if it is set.
+type cursor struct {
+	repo      *repository.BalanceRepository
+	scope     *repository.BalanceScope
+	batchSize int
+	after     uint
+	page      []model.Balance
+	done      bool
+}
+
+// peek returns the current balance without consuming it, or nil at the end.
+func (c *cursor) peek(ctx context.Context) (*model.Balance, error) {
+	if len(c.page) == 0 && !c.done {
+		page, err := c.repo.ListBalancesAfter(ctx, c.scope, c.after, c.batchSize)
+		if err != nil {
+			return nil, err
+		}
+		c.page, c.done = page, len(page) < c.batchSize
+		if len(page) > 0 {
+			c.after = page[len(page)-1].UserID
+		}
+	}
+	if len(c.page) == 0 {
+		return nil, nil
+	}
+	return &c.page[0], nil
+}
+
+func (c *cursor) next() {
+	c.page = c.page[1:]
+}
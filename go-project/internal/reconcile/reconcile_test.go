@@ -0,0 +1,245 @@
+package reconcile
+
+import (
+	"context"
+	"testing"
+
+	"balance-service/internal/config"
+	"balance-service/internal/database"
+	"balance-service/internal/ledger"
+	"balance-service/internal/model"
+	"balance-service/internal/repository"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// fixture sets up an upstream table with one balance per user, as Laravel
+// keeps it, and a local store that disagrees with it in every way.
+func fixture(t *testing.T) (upstream, local *gorm.DB, log *logrus.Logger) {
+	t.Helper()
+	local, log = database.OpenTest(t)
+	ctx := context.Background()
+
+	// The upstream schema is Laravel's, so that database is not migrated.
+	up, err := database.New(config.DatabaseConfig{Driver: database.DriverSQLite, Path: ":memory:"}, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { up.Close() })
+	err = up.DB.Exec(`CREATE TABLE balances (
+		id INTEGER PRIMARY KEY AUTOINCREMENT, user_id INTEGER NOT NULL UNIQUE,
+		amount DECIMAL(15,2) NOT NULL, version INTEGER NOT NULL,
+		created_at DATETIME, updated_at DATETIME)`).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range [][3]float64{
+		{1, 10, 3}, // in sync
+		{2, 20, 5}, // missing locally
+		{3, 30, 4}, // local is behind
+		{4, 40, 6}, // same version, other amount
+		{5, 50, 2}, // local is ahead
+		{7, 70, 1}, // in sync, only in the default currency
+	} {
+		if err := up.DB.Exec("INSERT INTO balances (user_id, amount, version) VALUES (?, ?, ?)", b[0], b[1], b[2]).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	postings := []ledger.Posting{
+		{Kind: model.LedgerAdjustment, Balance: model.Balance{UserID: 1, Currency: "USD", Amount: 10, Version: 3}},
+		{Kind: model.LedgerAdjustment, Balance: model.Balance{UserID: 3, Currency: "USD", Amount: 25, Version: 2}},
+		{Kind: model.LedgerAdjustment, Balance: model.Balance{UserID: 4, Currency: "USD", Amount: 41, Version: 6}},
+		{Kind: model.LedgerAdjustment, Balance: model.Balance{UserID: 5, Currency: "USD", Amount: 55, Version: 7}},
+		{Kind: model.LedgerAdjustment, Balance: model.Balance{UserID: 6, Currency: "USD", Amount: 60, Version: 1}},
+		{Kind: model.LedgerAdjustment, Balance: model.Balance{UserID: 7, Currency: "USD", Amount: 70, Version: 1}},
+		// Wallets in other currencies and tenants are not compared.
+		{Kind: model.LedgerAdjustment, Balance: model.Balance{UserID: 2, Currency: "EUR", Amount: 1, Version: 1}},
+		{Kind: model.LedgerAdjustment, Balance: model.Balance{UserID: 8, TenantID: "acme", Currency: "USD", Amount: 80, Version: 1}},
+	}
+	err = local.Transaction(func(tx *gorm.DB) error {
+		_, err := ledger.New(tx, log).Apply(ctx, postings)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return up.DB, local, log
+}
+
+func TestRunReportsDifferences(t *testing.T) {
+	upstream, local, log := fixture(t)
+	report, err := New(upstream, local, log).Run(context.Background(), Options{BatchSize: 2, Currency: "USD", MaxDetails: MaxDetails})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]int{KindMissing: 1, KindBehind: 1, KindMismatch: 1, KindAhead: 1, KindExtra: 1}
+	for kind, n := range want {
+		if report.Differences[kind] != n {
+			t.Errorf("%s: %d differences, want %d (%v)", kind, report.Differences[kind], n, report.Differences)
+		}
+	}
+	if report.Upstream != 6 || report.Local != 6 || report.Repaired != 0 || report.Unresolved() != 5 {
+		t.Errorf("report %+v, want 6 upstream, 6 local and 5 unresolved", report)
+	}
+
+	kinds := make(map[uint]string)
+	for _, d := range report.Details {
+		kinds[d.UserID] = d.Kind
+	}
+	for user, kind := range map[uint]string{2: KindMissing, 3: KindBehind, 4: KindMismatch, 5: KindAhead, 6: KindExtra} {
+		if kinds[user] != kind {
+			t.Errorf("user %d listed as %q, want %q", user, kinds[user], kind)
+		}
+	}
+	if report.Truncated {
+		t.Error("report truncated below MaxDetails")
+	}
+
+	// Nothing was written.
+	b, err := repository.NewBalanceRepository(local, log).GetBalance(context.Background(), model.WalletKey{UserID: 3, Currency: "USD"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Version != 2 || b.Amount != 25 {
+		t.Errorf("user 3 is at %+v after a report-only run", b)
+	}
+}
+
+func TestRunTruncatesDetails(t *testing.T) {
+	upstream, local, log := fixture(t)
+	report, err := New(upstream, local, log).Run(context.Background(), Options{BatchSize: 10, Currency: "USD", MaxDetails: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Details) != 2 || !report.Truncated || report.Unresolved() != 5 {
+		t.Errorf("listed %d details, truncated %v, %d unresolved; want 2, true, 5", len(report.Details), report.Truncated, report.Unresolved())
+	}
+}
+
+func TestRunRepairsThroughTheLedger(t *testing.T) {
+	upstream, local, log := fixture(t)
+	ctx := context.Background()
+	report, err := New(upstream, local, log).Run(ctx, Options{BatchSize: 2, Repair: true, Currency: "USD", MaxDetails: MaxDetails})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Repaired != 3 || report.Unresolved() != 2 {
+		t.Errorf("repaired %d with %d unresolved, want 3 and 2", report.Repaired, report.Unresolved())
+	}
+	for _, d := range report.Details {
+		if d.Repaired != repairable(d.Kind) {
+			t.Errorf("user %d (%s) marked repaired %v", d.UserID, d.Kind, d.Repaired)
+		}
+	}
+
+	balances := repository.NewBalanceRepository(local, log)
+	for user, want := range map[uint]model.Balance{
+		2: {Amount: 20, Version: 5},
+		3: {Amount: 30, Version: 4},
+		4: {Amount: 40, Version: 6},
+		5: {Amount: 55, Version: 7}, // ahead, left alone
+		6: {Amount: 60, Version: 1}, // extra, left alone
+	} {
+		b, err := balances.GetBalance(ctx, model.WalletKey{UserID: user, Currency: "USD"})
+		if err != nil {
+			t.Fatalf("user %d: %v", user, err)
+		}
+		if b.Amount != want.Amount || b.Version != want.Version {
+			t.Errorf("user %d at %v v%d, want %v v%d", user, b.Amount, b.Version, want.Amount, want.Version)
+		}
+	}
+
+	// Every repair is a reconciliation journal and an auditable event.
+	var journals []model.LedgerJournal
+	if err := local.Where("kind = ?", model.LedgerReconciliation).Order("user_id").Find(&journals).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(journals) != 3 || journals[0].UserID != 2 || journals[1].UserID != 3 || journals[2].UserID != 4 {
+		t.Errorf("reconciliation journals %+v, want users 2, 3 and 4", journals)
+	}
+	var events []model.BalanceEvent
+	if err := local.Order("user_id").Find(&events).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("stored %d events, want 3 corrections", len(events))
+	}
+	if m := events[1].Metadata; m.Source != model.SourceReconcile || m.Correction != KindBehind || m.AppliedVersion != 2 ||
+		m.PreviousAmount == nil || *m.PreviousAmount != 25 {
+		t.Errorf("correction of user 3 recorded as %+v", m)
+	}
+
+	check, err := ledger.Check(ctx, local, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !check.OK() {
+		t.Errorf("ledger invariants violated after repair: %+v", check.Violations)
+	}
+
+	// A second run finds only what cannot be repaired.
+	again, err := New(upstream, local, log).Run(ctx, Options{BatchSize: 2, Repair: true, Currency: "USD", MaxDetails: MaxDetails})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Repaired != 0 || again.Differences[KindAhead] != 1 || again.Differences[KindExtra] != 1 || again.Unresolved() != 2 {
+		t.Errorf("second run %+v, want only the ahead and extra users", again.Differences)
+	}
+}
+
+func TestRepairReportsSupersededBalances(t *testing.T) {
+	upstream, local, log := fixture(t)
+	ctx := context.Background()
+	r := New(upstream, local, log)
+	report, err := r.Run(ctx, Options{BatchSize: 10, Currency: "USD", MaxDetails: MaxDetails})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The processor moves user 3 past upstream after the diff was taken.
+	err = local.Transaction(func(tx *gorm.DB) error {
+		_, err := ledger.New(tx, log).Apply(ctx, []ledger.Posting{
+			{Kind: model.LedgerAdjustment, Balance: model.Balance{UserID: 3, Currency: "USD", Amount: 33, Version: 9}},
+		})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var diffs []Diff
+	for _, d := range report.Details {
+		if repairable(d.Kind) {
+			diffs = append(diffs, d)
+		}
+	}
+	if err := r.repair(ctx, report, "USD", diffs); err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Repaired != 2 || report.Superseded != 1 || report.Unresolved() != 3 {
+		t.Errorf("repaired %d, superseded %d, %d unresolved; want 2, 1 and 3", report.Repaired, report.Superseded, report.Unresolved())
+	}
+	for _, d := range report.Details {
+		superseded := d.UserID == 3
+		if d.Superseded != superseded || d.Repaired != (repairable(d.Kind) && !superseded) {
+			t.Errorf("user %d (%s) marked repaired %v, superseded %v", d.UserID, d.Kind, d.Repaired, d.Superseded)
+		}
+	}
+
+	b, err := repository.NewBalanceRepository(local, log).GetBalance(ctx, model.WalletKey{UserID: 3, Currency: "USD"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Amount != 33 || b.Version != 9 {
+		t.Errorf("user 3 at %v v%d, want 33 v9", b.Amount, b.Version)
+	}
+	var users []uint
+	if err := local.Model(&model.BalanceEvent{}).Order("user_id").Pluck("user_id", &users).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 2 || users[0] != 2 || users[1] != 4 {
+		t.Errorf("correction events of users %v, want 2 and 4", users)
+	}
+}
@@ -0,0 +1,45 @@
+package reconcile
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Schedule runs the reconciler every interval until ctx is cancelled. A
+// failed run is logged and retried at the next tick.
+func Schedule(ctx context.Context, r *Reconciler, interval time.Duration, opts Options, log *logrus.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		report, err := r.Run(ctx, opts)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.WithError(err).Error("reconciliation failed")
+			}
+			continue
+		}
+
+		entry := log.WithFields(logrus.Fields{
+			"upstream":    report.Upstream,
+			"local":       report.Local,
+			"differences": report.Differences,
+			"repaired":    report.Repaired,
+			"superseded":  report.Superseded,
+			"duration":    report.Duration,
+		})
+		if report.Unresolved() > 0 {
+			entry.Warn("reconciliation found unresolved differences")
+		} else {
+			entry.Info("reconciliation finished")
+		}
+	}
+}
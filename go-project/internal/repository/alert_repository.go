@@ -0,0 +1,38 @@
+package repository
+
+import (
+	"context"
+
+	"balance-service/internal/model"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+type AlertRepository struct {
+	db  *gorm.DB
+	log *logrus.Logger
+}
+
+func NewAlertRepository(db *gorm.DB, log *logrus.Logger) *AlertRepository {
+	return &AlertRepository{
+		db:  db,
+		log: log,
+	}
+}
+
+// SaveAlert stores a rule hit
+func (r *AlertRepository) SaveAlert(ctx context.Context, alert *model.Alert) error {
+	return r.db.WithContext(ctx).Create(alert).Error
+}
+
+// ListAlerts returns the latest limit alerts, newest first, optionally of
+// one user
+func (r *AlertRepository) ListAlerts(ctx context.Context, userID uint, limit int) ([]model.Alert, error) {
+	var alerts []model.Alert
+	query := r.db.WithContext(ctx).Order("id DESC").Limit(limit)
+	if userID != 0 {
+		query = query.Where("user_id = ?", userID)
+	}
+	err := query.Find(&alerts).Error
+	return alerts, err
+}
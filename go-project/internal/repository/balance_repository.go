@@ -2,11 +2,11 @@ package repository
 
 import (
 	"context"
+	"errors"
 
 	"balance-service/internal/model"
 	"github.com/sirupsen/logrus"
 	"gorm.io/gorm"
-	"gorm.io/gorm/clause"
 )
 
 type BalanceRepository struct {
@@ -21,35 +21,55 @@ func NewBalanceRepository(db *gorm.DB, log *logrus.Logger) *BalanceRepository {
 	}
 }
 
+// Transaction runs fn in a transaction on the repository's database.
+// Repositories built on tx take part in it.
+func (r *BalanceRepository) Transaction(ctx context.Context, fn func(tx *gorm.DB) error) error {
+	return r.db.WithContext(ctx).Transaction(fn)
+}
+
 // SaveBalance saves or updates a balance record
 func (r *BalanceRepository) SaveBalance(ctx context.Context, balance *model.Balance) error {
-	return r.db.WithContext(ctx).Clauses(clause.OnConflict{
-		Columns: []clause.Column{{Name: "user_id"}},
-		DoUpdates: clause.Assignments(map[string]interface{}{
-			"amount": gorm.Expr("CASE WHEN balances.version <= VALUES(version) THEN VALUES(amount) ELSE balances.amount END"),
-			"version": gorm.Expr("GREATEST(balances.version, VALUES(version))"),
-			"updated_at": gorm.Expr("NOW()"),
-		}),
-	}).Create(balance).Error
+	return r.db.WithContext(ctx).Clauses(balanceUpsert(r.db)).Create(balance).Error
 }
 
 // SaveBalancesBatch saves multiple balances in a batch
 func (r *BalanceRepository) SaveBalancesBatch(ctx context.Context, balances []model.Balance) error {
-    if len(balances) == 0 {
-        return nil
-    }
-
-    return r.db.WithContext(ctx).Clauses(clause.OnConflict{
-        Columns: []clause.Column{{Name: "user_id"}},
-        DoUpdates: clause.Assignments(map[string]interface{}{
-            "amount":     gorm.Expr("CASE WHEN balances.version <= VALUES(version) THEN VALUES(amount) ELSE balances.amount END"),
-            "version":    gorm.Expr("GREATEST(balances.version, VALUES(version))"),
-            "updated_at": gorm.Expr("NOW()"),
-        }),
-    }).Create(&balances).Error
+	if len(balances) == 0 {
+		return nil
+	}
+
+	return r.db.WithContext(ctx).Clauses(balanceUpsert(r.db)).Create(&balances).Error
+}
+
+// GetBalance returns the balance of one wallet, or ErrNotFound
+func (r *BalanceRepository) GetBalance(ctx context.Context, wallet model.WalletKey) (*model.Balance, error) {
+	var balance model.Balance
+	err := r.db.WithContext(ctx).
+		Where("user_id = ? AND tenant_id = ? AND currency = ?", wallet.UserID, wallet.TenantID, wallet.Currency).
+		Take(&balance).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &balance, nil
+}
+
+// ListUserBalances returns every wallet of a user in a tenant, ordered by
+// currency
+func (r *BalanceRepository) ListUserBalances(ctx context.Context, tenantID string, userID uint) ([]model.Balance, error) {
+	var balances []model.Balance
+	err := r.db.WithContext(ctx).
+		Where("user_id = ? AND tenant_id = ?", userID, tenantID).
+		Order("currency").
+		Find(&balances).Error
+
+	return balances, err
 }
 
-// GetBalancesByUserIDs retrieves balances for given user IDs
+// GetBalancesByUserIDs retrieves every wallet of the given user IDs
 func (r *BalanceRepository) GetBalancesByUserIDs(ctx context.Context, userIDs []uint) ([]model.Balance, error) {
 	var balances []model.Balance
 	if len(userIDs) == 0 {
@@ -67,7 +87,7 @@ func (r *BalanceRepository) GetBalancesByUserIDs(ctx context.Context, userIDs []
 func (r *BalanceRepository) GetAllBalances(ctx context.Context, limit, offset int) ([]model.Balance, error) {
 	var balances []model.Balance
 	err := r.db.WithContext(ctx).
-		Select("user_id", "amount", "version").
+		Select("user_id", "tenant_id", "currency", "amount", "version").
 		Limit(limit).
 		Offset(offset).
 		Find(&balances).Error
@@ -75,6 +95,69 @@ func (r *BalanceRepository) GetAllBalances(ctx context.Context, limit, offset in
 	return balances, err
 }
 
+// ListBalancesByID returns up to limit balances of every tenant and
+// currency with an ID greater than afterID, ordered by ID.
+func (r *BalanceRepository) ListBalancesByID(ctx context.Context, afterID uint, limit int) ([]model.Balance, error) {
+	var balances []model.Balance
+	err := r.db.WithContext(ctx).
+		Where("id > ?", afterID).
+		Order("id").
+		Limit(limit).
+		Find(&balances).Error
+
+	return balances, err
+}
+
+// BalanceScope picks one wallet of each user: the one in a tenant and
+// currency.
+type BalanceScope struct {
+	TenantID string
+	Currency string
+}
+
+// ListBalancesAfter returns up to limit balances with a user ID greater than
+// afterUserID, ordered by user ID, for walking the table in stable pages.
+// A scope restricts it to that wallet of each user; a nil one reads a table
+// with one balance per user, such as the upstream one, which has neither a
+// tenant nor a currency column. Neither is read either way.
+func (r *BalanceRepository) ListBalancesAfter(ctx context.Context, scope *BalanceScope, afterUserID uint, limit int) ([]model.Balance, error) {
+	var balances []model.Balance
+	query := r.db.WithContext(ctx)
+	if scope != nil {
+		query = query.Where("tenant_id = ? AND currency = ?", scope.TenantID, scope.Currency)
+	}
+	err := query.
+		Select("user_id", "amount", "version", "updated_at").
+		Where("user_id > ?", afterUserID).
+		Order("user_id").
+		Limit(limit).
+		Find(&balances).Error
+
+	return balances, err
+}
+
+// CountTenantUsers returns how many users hold a wallet in a tenant
+func (r *BalanceRepository) CountTenantUsers(ctx context.Context, tenantID string) (int64, error) {
+	var count int64
+	err := r.db.WithContext(ctx).
+		Model(&model.Balance{}).
+		Where("tenant_id = ?", tenantID).
+		Distinct("user_id").
+		Count(&count).Error
+	return count, err
+}
+
+// HasTenantUser reports whether a user holds a wallet in a tenant
+func (r *BalanceRepository) HasTenantUser(ctx context.Context, tenantID string, userID uint) (bool, error) {
+	var count int64
+	err := r.db.WithContext(ctx).
+		Model(&model.Balance{}).
+		Where("tenant_id = ? AND user_id = ?", tenantID, userID).
+		Limit(1).
+		Count(&count).Error
+	return count > 0, err
+}
+
 // CountBalances returns total count of balances
 func (r *BalanceRepository) CountBalances(ctx context.Context) (int64, error) {
 	var count int64
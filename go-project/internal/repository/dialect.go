@@ -0,0 +1,29 @@
+package repository
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// balanceUpsert returns the version-guarded ON CONFLICT clause for the
+// balances table in the dialect of db. Rows conflict on the wallet: user,
+// tenant and currency. An incoming row only overwrites the amount when its version is
+// not older than the stored one.
+func balanceUpsert(db *gorm.DB) clause.OnConflict {
+	incoming := func(column string) string { return "VALUES(" + column + ")" }
+	greatest, now := "GREATEST", "NOW()"
+
+	if db.Dialector.Name() == "sqlite" {
+		incoming = func(column string) string { return "excluded." + column }
+		greatest, now = "MAX", "CURRENT_TIMESTAMP"
+	}
+
+	return clause.OnConflict{
+		Columns: []clause.Column{{Name: "user_id"}, {Name: "tenant_id"}, {Name: "currency"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"amount":     gorm.Expr("CASE WHEN balances.version <= " + incoming("version") + " THEN " + incoming("amount") + " ELSE balances.amount END"),
+			"version":    gorm.Expr(greatest + "(balances.version, " + incoming("version") + ")"),
+			"updated_at": gorm.Expr(now),
+		}),
+	}
+}
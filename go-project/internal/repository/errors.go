@@ -0,0 +1,130 @@
+package repository
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// ErrNotFound is returned by lookups of a single record that doesn't exist.
+var ErrNotFound = errors.New("record not found")
+
+// ErrorClass tells callers how to react to a failed database operation.
+type ErrorClass int
+
+const (
+	// ErrorPermanent means retrying the same statement cannot succeed, e.g. a
+	// constraint violation or a value out of range.
+	ErrorPermanent ErrorClass = iota
+	// ErrorRetryable means the statement lost a race (deadlock, lock wait
+	// timeout) and can be retried right away on the same connection pool.
+	ErrorRetryable
+	// ErrorTransient means the database itself is unreachable or refusing
+	// writes (connection reset, failover to a read-only replica). Retrying
+	// immediately is pointless; back off and try again later.
+	ErrorTransient
+)
+
+func (c ErrorClass) String() string {
+	switch c {
+	case ErrorRetryable:
+		return "retryable"
+	case ErrorTransient:
+		return "transient"
+	default:
+		return "permanent"
+	}
+}
+
+// MySQL server error numbers we treat specially.
+// https://dev.mysql.com/doc/mysql-errors/8.0/en/server-error-reference.html
+const (
+	mysqlLockWaitTimeout   = 1205
+	mysqlDeadlock          = 1213
+	mysqlTooManyConns      = 1040
+	mysqlAccessDenied      = 1045
+	mysqlServerShutdown    = 1053
+	mysqlOptionPrevents    = 1290 // --read-only / --super-read-only
+	mysqlReadOnlyTxn       = 1792
+	mysqlReadOnlyMode      = 1836
+	mysqlQueryInterrupted  = 1317
+	mysqlLostConnQueryTime = 3024
+)
+
+// SQLite primary result codes, https://www.sqlite.org/rescode.html
+const (
+	sqliteBusy     = 5
+	sqliteLocked   = 6
+	sqliteReadOnly = 8
+	sqliteIOErr    = 10
+	sqliteCantOpen = 14
+)
+
+// ClassifyError inspects driver error codes to decide whether err is worth
+// retrying. Only lost races, broken connections and the driver codes of an
+// unavailable database are worth it; any other error, such as a driver code
+// we don't know or an error of the service's own, is permanent, since
+// requeueing the message would fail the same way forever.
+func ClassifyError(err error) ErrorClass {
+	if err == nil {
+		return ErrorPermanent
+	}
+
+	var myErr *mysql.MySQLError
+	if errors.As(err, &myErr) {
+		switch myErr.Number {
+		case mysqlDeadlock, mysqlLockWaitTimeout:
+			return ErrorRetryable
+		case mysqlTooManyConns, mysqlAccessDenied, mysqlServerShutdown,
+			mysqlOptionPrevents, mysqlReadOnlyTxn, mysqlReadOnlyMode,
+			mysqlQueryInterrupted, mysqlLostConnQueryTime:
+			return ErrorTransient
+		default:
+			return ErrorPermanent
+		}
+	}
+
+	// modernc/glebarez sqlite errors expose the extended result code.
+	var liteErr interface{ Code() int }
+	if errors.As(err, &liteErr) {
+		switch liteErr.Code() & 0xff {
+		case sqliteBusy, sqliteLocked:
+			return ErrorRetryable
+		case sqliteReadOnly, sqliteIOErr, sqliteCantOpen:
+			return ErrorTransient
+		default:
+			return ErrorPermanent
+		}
+	}
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrorRetryable
+	case isConnectionError(err), errors.Is(err, context.Canceled):
+		return ErrorTransient
+	default:
+		return ErrorPermanent
+	}
+}
+
+// isConnectionError reports whether err was caused by a broken or refused
+// connection rather than by the statement itself.
+func isConnectionError(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, mysql.ErrInvalidConn) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
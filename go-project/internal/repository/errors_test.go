@@ -0,0 +1,93 @@
+package repository
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+
+	"balance-service/internal/database"
+	"balance-service/internal/model"
+	"github.com/go-sql-driver/mysql"
+	"gorm.io/gorm"
+)
+
+// sqliteError stands in for the errors of the SQLite driver, which carry
+// the extended result code.
+type sqliteError int
+
+func (e sqliteError) Error() string { return fmt.Sprintf("sqlite error %d", int(e)) }
+func (e sqliteError) Code() int     { return int(e) }
+
+func TestClassifyError(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		err  error
+		want ErrorClass
+	}{
+		{"nil", nil, ErrorPermanent},
+
+		{"mysql deadlock", &mysql.MySQLError{Number: 1213}, ErrorRetryable},
+		{"mysql lock wait timeout", &mysql.MySQLError{Number: 1205}, ErrorRetryable},
+		{"mysql too many connections", &mysql.MySQLError{Number: 1040}, ErrorTransient},
+		{"mysql access denied", &mysql.MySQLError{Number: 1045}, ErrorTransient},
+		{"mysql shutdown", &mysql.MySQLError{Number: 1053}, ErrorTransient},
+		{"mysql read-only option", &mysql.MySQLError{Number: 1290}, ErrorTransient},
+		{"mysql read-only transaction", &mysql.MySQLError{Number: 1792}, ErrorTransient},
+		{"mysql read-only mode", &mysql.MySQLError{Number: 1836}, ErrorTransient},
+		{"mysql query interrupted", &mysql.MySQLError{Number: 1317}, ErrorTransient},
+		{"mysql lost connection", &mysql.MySQLError{Number: 3024}, ErrorTransient},
+		{"mysql duplicate key", &mysql.MySQLError{Number: 1062}, ErrorPermanent},
+		{"mysql out of range", &mysql.MySQLError{Number: 1264}, ErrorPermanent},
+
+		{"sqlite busy", sqliteError(5), ErrorRetryable},
+		{"sqlite locked", sqliteError(6), ErrorRetryable},
+		{"sqlite busy snapshot", sqliteError(5 | 2<<8), ErrorRetryable},
+		{"sqlite read-only", sqliteError(8), ErrorTransient},
+		{"sqlite I/O error", sqliteError(10), ErrorTransient},
+		{"sqlite can't open", sqliteError(14), ErrorTransient},
+		{"sqlite unique constraint", sqliteError(2067), ErrorPermanent},
+
+		{"deadline", context.DeadlineExceeded, ErrorRetryable},
+		{"canceled", context.Canceled, ErrorTransient},
+		{"bad connection", driver.ErrBadConn, ErrorTransient},
+		{"invalid connection", mysql.ErrInvalidConn, ErrorTransient},
+		{"EOF", io.ErrUnexpectedEOF, ErrorTransient},
+		{"connection reset", syscall.ECONNRESET, ErrorTransient},
+		{"connection refused", &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}, ErrorTransient},
+		{"broken pipe", syscall.EPIPE, ErrorTransient},
+
+		{"gorm invalid data", gorm.ErrInvalidData, ErrorPermanent},
+		{"own error", errors.New("counter-account 7 does not exist"), ErrorPermanent},
+		{"not found", ErrNotFound, ErrorPermanent},
+
+		{"wrapped deadlock", fmt.Errorf("save balances: %w", &mysql.MySQLError{Number: 1213}), ErrorRetryable},
+		{"wrapped sqlite busy", fmt.Errorf("lock wallets: %w", sqliteError(5)), ErrorRetryable},
+		{"wrapped bad connection", fmt.Errorf("begin: %w", driver.ErrBadConn), ErrorTransient},
+		{"wrapped own error", fmt.Errorf("apply postings: %w", fmt.Errorf("found %d of %d accounts", 1, 2)), ErrorPermanent},
+	} {
+		if got := ClassifyError(c.err); got != c.want {
+			t.Errorf("%s: %v is %s, want %s", c.name, c.err, got, c.want)
+		}
+	}
+}
+
+func TestClassifyErrorOfTheSQLiteDriver(t *testing.T) {
+	db, _ := database.OpenTest(t)
+	update := model.PendingUpdate{UserID: 1, Currency: "USD", Version: 2, Amount: -5, Status: model.PendingOpen}
+	if err := db.Create(&update).Error; err != nil {
+		t.Fatal(err)
+	}
+	update.ID = 0
+	err := db.Create(&update).Error
+	if err == nil {
+		t.Fatal("second update of the same wallet version was stored")
+	}
+	if class := ClassifyError(err); class != ErrorPermanent {
+		t.Errorf("%v is %s, want permanent", err, class)
+	}
+}
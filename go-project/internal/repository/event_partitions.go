@@ -0,0 +1,107 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"balance-service/internal/model"
+)
+
+// FuturePartition is the last partition of balance_events. It takes the
+// events newer than every month partition and should stay empty.
+const FuturePartition = "pfuture"
+
+// EventPartition is a monthly MySQL partition of balance_events. It holds
+// the events created before the first day of the month after Month; the
+// oldest partition also holds everything older. Month is zero for
+// FuturePartition.
+type EventPartition struct {
+	Name  string
+	Month time.Time
+}
+
+// End is when the events of the partition end.
+func (p EventPartition) End() time.Time {
+	return p.Month.AddDate(0, 1, 0)
+}
+
+// PartitionName names the partition of a month, e.g. p202601.
+func PartitionName(month time.Time) string {
+	return "p" + month.Format("200601")
+}
+
+// EventPartitions lists the partitions of balance_events in order. It
+// returns none if the table is not partitioned.
+func (r *EventRepository) EventPartitions(ctx context.Context) ([]EventPartition, error) {
+	var names []string
+	err := r.db.WithContext(ctx).Raw(`SELECT PARTITION_NAME FROM information_schema.PARTITIONS
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND PARTITION_NAME IS NOT NULL
+		ORDER BY PARTITION_ORDINAL_POSITION`, model.BalanceEvent{}.TableName()).
+		Scan(&names).Error
+	if err != nil {
+		return nil, err
+	}
+
+	partitions := make([]EventPartition, 0, len(names))
+	for _, name := range names {
+		if name == FuturePartition {
+			partitions = append(partitions, EventPartition{Name: name})
+			continue
+		}
+		month, err := time.Parse("200601", strings.TrimPrefix(name, "p"))
+		if err != nil || PartitionName(month) != name {
+			return nil, fmt.Errorf("partition %s of balance_events is not a monthly partition", name)
+		}
+		partitions = append(partitions, EventPartition{Name: name, Month: month})
+	}
+	return partitions, nil
+}
+
+// PartitionEvents partitions balance_events by month, from month first to
+// month last and FuturePartition. The primary key is extended by
+// created_at, which MySQL requires of the partitioning column; MySQL
+// rebuilds the table for it.
+func (r *EventRepository) PartitionEvents(ctx context.Context, first, last time.Time) error {
+	table := model.BalanceEvent{}.TableName()
+	db := r.db.WithContext(ctx)
+	if err := db.Exec("ALTER TABLE " + table + " DROP PRIMARY KEY, ADD PRIMARY KEY (id, created_at)").Error; err != nil {
+		return fmt.Errorf("extend the primary key: %w", err)
+	}
+	return db.Exec("ALTER TABLE " + table + " PARTITION BY RANGE (TO_DAYS(created_at)) (" + partitionDefinitions(first, last) + ")").Error
+}
+
+// AddEventPartitions splits the partitions of month first to month last
+// off FuturePartition.
+func (r *EventRepository) AddEventPartitions(ctx context.Context, first, last time.Time) error {
+	return r.db.WithContext(ctx).Exec("ALTER TABLE " + model.BalanceEvent{}.TableName() +
+		" REORGANIZE PARTITION " + FuturePartition + " INTO (" + partitionDefinitions(first, last) + ")").Error
+}
+
+// DropEventPartition drops a month partition with every event in it.
+func (r *EventRepository) DropEventPartition(ctx context.Context, p EventPartition) error {
+	return r.db.WithContext(ctx).Exec("ALTER TABLE " + model.BalanceEvent{}.TableName() + " DROP PARTITION " + PartitionName(p.Month)).Error
+}
+
+// CountPartitionEvents counts the events of a month partition with an ID
+// greater than afterID.
+func (r *EventRepository) CountPartitionEvents(ctx context.Context, p EventPartition, afterID uint) (int64, error) {
+	var count int64
+	err := r.db.WithContext(ctx).
+		Raw("SELECT COUNT(*) FROM "+model.BalanceEvent{}.TableName()+" PARTITION ("+PartitionName(p.Month)+") WHERE id > ?", afterID).
+		Scan(&count).Error
+
+	return count, err
+}
+
+// partitionDefinitions defines the partitions of month first to month last
+// and FuturePartition.
+func partitionDefinitions(first, last time.Time) string {
+	var defs []string
+	for month := first; !month.After(last); month = month.AddDate(0, 1, 0) {
+		defs = append(defs, fmt.Sprintf("PARTITION %s VALUES LESS THAN (TO_DAYS('%s'))",
+			PartitionName(month), month.AddDate(0, 1, 0).Format("2006-01-02")))
+	}
+	return strings.Join(append(defs, "PARTITION "+FuturePartition+" VALUES LESS THAN MAXVALUE"), ", ")
+}
@@ -2,6 +2,8 @@ package repository
 
 import (
 	"context"
+	"errors"
+	"time"
 
 	"balance-service/internal/model"
 	"github.com/sirupsen/logrus"
@@ -26,27 +28,178 @@ func (r *EventRepository) SaveEvent(ctx context.Context, event *model.BalanceEve
 	return r.db.WithContext(ctx).Create(event).Error
 }
 
+// SaveEventsBatch stores events, skipping the ones whose event ID is stored
+// for their tenant already, so that a redelivered message is recorded once.
+// Each event ID is claimed in processed_event_ids first; a concurrent batch
+// claiming the same ID waits on the unique key until this one commits or
+// rolls back. It must therefore run in the transaction that applies the
+// batch, or the claims of a failed batch would stay behind.
 func (r *EventRepository) SaveEventsBatch(ctx context.Context, events []model.BalanceEvent) error {
-    if len(events) == 0 {
-        return nil
-    }
+	fresh := make([]model.BalanceEvent, 0, len(events))
+	for _, e := range events {
+		if e.EventID != "" {
+			claimed, err := r.claimEvent(ctx, e.TenantID, e.EventID)
+			if err != nil {
+				return err
+			}
+			if !claimed {
+				continue
+			}
+		}
+		fresh = append(fresh, e)
+	}
+	if len(fresh) == 0 {
+		return nil
+	}
+	return r.db.WithContext(ctx).Create(&fresh).Error
+}
 
-    return r.db.WithContext(ctx).
-        Clauses(clause.OnConflict{
-            Columns:   []clause.Column{{Name: "event_id"}}, // Укажи здесь имя своего уникального индекса
-            DoNothing: true,
-        }).
-        Create(&events).
-        Error
+// claimEvent records that an event ID was processed for a tenant. It
+// reports false if the ID was claimed before.
+func (r *EventRepository) claimEvent(ctx context.Context, tenantID, eventID string) (bool, error) {
+	result := r.db.WithContext(ctx).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&model.ProcessedEvent{TenantID: tenantID, EventID: eventID})
+
+	return result.RowsAffected == 1, result.Error
 }
 
-// EventExists checks if an event with given event_id already exists
-func (r *EventRepository) EventExists(ctx context.Context, eventID string) (bool, error) {
+// EventExists checks if an event with given event_id already exists in the
+// tenant. Event IDs are unique per tenant only.
+func (r *EventRepository) EventExists(ctx context.Context, tenantID, eventID string) (bool, error) {
 	var count int64
 	err := r.db.WithContext(ctx).
 		Model(&model.BalanceEvent{}).
-		Where("event_id = ?", eventID).
+		Where("tenant_id = ? AND event_id = ?", tenantID, eventID).
 		Count(&count).Error
 
 	return count > 0, err
 }
+
+// ListUserEvents returns up to limit events of a user in the tenant with an
+// ID greater than afterID, oldest first. Passing afterID 0 returns the latest
+// limit events. An empty currency lists the events of every wallet.
+func (r *EventRepository) ListUserEvents(ctx context.Context, tenantID string, userID uint, currency string, afterID uint, limit int) ([]model.BalanceEvent, error) {
+	var events []model.BalanceEvent
+	query := r.db.WithContext(ctx).Where("user_id = ? AND tenant_id = ?", userID, tenantID)
+	if currency != "" {
+		query = query.Where("currency = ?", currency)
+	}
+
+	if afterID > 0 {
+		err := query.Where("id > ?", afterID).Order("id").Limit(limit).Find(&events).Error
+		return events, err
+	}
+
+	if err := query.Order("id DESC").Limit(limit).Find(&events).Error; err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+		events[i], events[j] = events[j], events[i]
+	}
+	return events, nil
+}
+
+// ListEventsAfter returns up to limit events with an ID greater than
+// afterID, ordered by ID.
+func (r *EventRepository) ListEventsAfter(ctx context.Context, afterID uint, limit int) ([]model.BalanceEvent, error) {
+	var events []model.BalanceEvent
+	err := r.db.WithContext(ctx).
+		Where("id > ?", afterID).
+		Order("id").
+		Limit(limit).
+		Find(&events).Error
+
+	return events, err
+}
+
+// FirstEvent returns the event with the lowest ID, or ErrNotFound.
+func (r *EventRepository) FirstEvent(ctx context.Context) (*model.BalanceEvent, error) {
+	var event model.BalanceEvent
+	err := r.db.WithContext(ctx).Order("id").Take(&event).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
+
+// DeleteEvents deletes the events with an ID from firstID to lastID that
+// were created before the cutoff, and returns how many it deleted.
+func (r *EventRepository) DeleteEvents(ctx context.Context, firstID, lastID uint, before time.Time) (int64, error) {
+	result := r.db.WithContext(ctx).
+		Where("id >= ? AND id <= ? AND created_at < ?", firstID, lastID, before).
+		Delete(&model.BalanceEvent{})
+
+	return result.RowsAffected, result.Error
+}
+
+// DeleteClaims deletes the claims made before the given time of up to limit
+// event IDs and returns how many it deleted.
+func (r *EventRepository) DeleteClaims(ctx context.Context, before time.Time, limit int) (int64, error) {
+	var eventIDs []string
+	err := r.db.WithContext(ctx).
+		Model(&model.ProcessedEvent{}).
+		Where("created_at < ?", before).
+		Limit(limit).
+		Pluck("event_id", &eventIDs).Error
+	if err != nil || len(eventIDs) == 0 {
+		return 0, err
+	}
+	result := r.db.WithContext(ctx).
+		Where("created_at < ? AND event_id IN ?", before, eventIDs).
+		Delete(&model.ProcessedEvent{})
+
+	return result.RowsAffected, result.Error
+}
+
+// MigrateRestored creates or updates the table of restored events, which
+// only exists once something was restored.
+func (r *EventRepository) MigrateRestored(ctx context.Context) error {
+	return r.db.WithContext(ctx).AutoMigrate(&model.RestoredEvent{})
+}
+
+// SaveRestoredEvents inserts restored events, skipping the ones restored
+// before, and returns how many it inserted.
+func (r *EventRepository) SaveRestoredEvents(ctx context.Context, events []model.RestoredEvent) (int64, error) {
+	if len(events) == 0 {
+		return 0, nil
+	}
+	result := r.db.WithContext(ctx).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&events)
+
+	return result.RowsAffected, result.Error
+}
+
+// ListWalletEvents returns the events of a wallet with a version after
+// afterVersion up to and including lastVersion, ordered by version and ID.
+func (r *EventRepository) ListWalletEvents(ctx context.Context, wallet model.WalletKey, afterVersion, lastVersion uint) ([]model.BalanceEvent, error) {
+	var events []model.BalanceEvent
+	err := r.db.WithContext(ctx).
+		Where("user_id = ? AND tenant_id = ? AND currency = ?", wallet.UserID, wallet.TenantID, wallet.Currency).
+		Where("version > ? AND version <= ?", afterVersion, lastVersion).
+		Order("version, id").
+		Find(&events).Error
+
+	return events, err
+}
+
+// ListRestoredWalletEvents is ListWalletEvents for the restored events. It
+// returns nothing if nothing was ever restored.
+func (r *EventRepository) ListRestoredWalletEvents(ctx context.Context, wallet model.WalletKey, afterVersion, lastVersion uint) ([]model.RestoredEvent, error) {
+	db := r.db.WithContext(ctx)
+	if !db.Migrator().HasTable(&model.RestoredEvent{}) {
+		return nil, nil
+	}
+	var events []model.RestoredEvent
+	err := db.
+		Where("user_id = ? AND tenant_id = ? AND currency = ?", wallet.UserID, wallet.TenantID, wallet.Currency).
+		Where("version > ? AND version <= ?", afterVersion, lastVersion).
+		Order("version, id").
+		Find(&events).Error
+
+	return events, err
+}
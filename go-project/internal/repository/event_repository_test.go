@@ -0,0 +1,143 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"balance-service/internal/config"
+	"balance-service/internal/database"
+	"balance-service/internal/model"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+func TestSaveEventsBatchRecordsConcurrentRedeliveriesOnce(t *testing.T) {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	// A file database with several connections lets the workers' transactions
+	// overlap, which the shared in-memory database would serialise.
+	db, err := database.New(config.DatabaseConfig{Driver: database.DriverSQLite, Path: filepath.Join(t.TempDir(), "events.db")}, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := db.Migrate("USD"); err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(8)
+
+	ctx := context.Background()
+	const workers = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- db.DB.Transaction(func(tx *gorm.DB) error {
+				return NewEventRepository(tx, log).SaveEventsBatch(ctx, []model.BalanceEvent{
+					{UserID: 1, Currency: "USD", Amount: 10, Version: 1, EventID: "evt-1"},
+				})
+			})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var count int64
+	if err := db.DB.Model(&model.BalanceEvent{}).Where("event_id = ?", "evt-1").Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("stored %d events for one event ID, want 1", count)
+	}
+}
+
+func TestSaveEventsBatchKeepsTenantsApart(t *testing.T) {
+	db, log := database.OpenTest(t)
+	repo := NewEventRepository(db, log)
+	ctx := context.Background()
+
+	batch := []model.BalanceEvent{
+		{UserID: 1, Currency: "USD", Amount: 10, Version: 1, EventID: "evt-1"},
+		{UserID: 1, TenantID: "acme", Currency: "USD", Amount: 10, Version: 1, EventID: "evt-1"},
+		{UserID: 1, Currency: "USD", Amount: 10, Version: 1, EventID: "evt-1"},
+		{UserID: 1, Currency: "USD", Amount: 12, Version: 2},
+	}
+	if err := repo.SaveEventsBatch(ctx, batch); err != nil {
+		t.Fatal(err)
+	}
+	// A redelivery in a later batch is skipped too.
+	if err := repo.SaveEventsBatch(ctx, batch[:1]); err != nil {
+		t.Fatal(err)
+	}
+
+	var events []model.BalanceEvent
+	if err := db.Order("id").Find(&events).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("stored %d events, want 3: %+v", len(events), events)
+	}
+	if events[0].TenantID != "" || events[1].TenantID != "acme" || events[2].EventID != "" {
+		t.Errorf("stored %+v, want evt-1 once per tenant and the event without ID", events)
+	}
+}
+
+func TestListUserEventsOfOneTenant(t *testing.T) {
+	db, log := database.OpenTest(t)
+	repo := NewEventRepository(db, log)
+	ctx := context.Background()
+
+	err := repo.SaveEventsBatch(ctx, []model.BalanceEvent{
+		{UserID: 1, Currency: "USD", Amount: 10, Version: 1, EventID: "evt-1"},
+		{UserID: 1, TenantID: "acme", Currency: "USD", Amount: 20, Version: 1, EventID: "evt-1"},
+		{UserID: 1, TenantID: "acme", Currency: "EUR", Amount: 30, Version: 1, EventID: "evt-2"},
+		{UserID: 2, TenantID: "acme", Currency: "USD", Amount: 40, Version: 1, EventID: "evt-3"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		tenant, currency string
+		want             []float64
+	}{
+		{"", "", []float64{10}},
+		{"acme", "", []float64{20, 30}},
+		{"acme", "EUR", []float64{30}},
+		{"globex", "", nil},
+	} {
+		events, err := repo.ListUserEvents(ctx, c.tenant, 1, c.currency, 0, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var amounts []float64
+		for _, e := range events {
+			amounts = append(amounts, e.Amount)
+		}
+		if fmt.Sprint(amounts) != fmt.Sprint(c.want) {
+			t.Errorf("tenant %q currency %q: amounts %v, want %v", c.tenant, c.currency, amounts, c.want)
+		}
+	}
+
+	if exists, err := repo.EventExists(ctx, "acme", "evt-2"); err != nil || !exists {
+		t.Errorf("evt-2 of acme exists = %v, %v", exists, err)
+	}
+	if exists, err := repo.EventExists(ctx, "", "evt-2"); err != nil || exists {
+		t.Errorf("evt-2 of the default tenant exists = %v, %v", exists, err)
+	}
+}
@@ -0,0 +1,244 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"balance-service/internal/model"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ledgerTolerance is below a cent: sums of decimal(…,2) columns that differ
+// by less are equal.
+const ledgerTolerance = 0.005
+
+type LedgerRepository struct {
+	db  *gorm.DB
+	log *logrus.Logger
+}
+
+func NewLedgerRepository(db *gorm.DB, log *logrus.Logger) *LedgerRepository {
+	return &LedgerRepository{
+		db:  db,
+		log: log,
+	}
+}
+
+// EnsureAccounts creates the accounts that do not exist yet. Existing ones
+// are left alone.
+func (r *LedgerRepository) EnsureAccounts(ctx context.Context, accounts []model.LedgerAccount) error {
+	if len(accounts) == 0 {
+		return nil
+	}
+	return r.db.WithContext(ctx).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&accounts).Error
+}
+
+// AccountsOf returns the accounts of kind held by userIDs; the user IDs of
+// counter-accounts are their shards. With lock the rows stay locked until the
+// transaction ends.
+func (r *LedgerRepository) AccountsOf(ctx context.Context, kind string, userIDs []uint, lock bool) ([]model.LedgerAccount, error) {
+	var accounts []model.LedgerAccount
+	query := r.db.WithContext(ctx)
+	if lock {
+		query = query.Clauses(clause.Locking{Strength: "UPDATE"})
+	}
+	err := query.
+		Where("kind = ? AND user_id IN ?", kind, userIDs).
+		Order("id").
+		Find(&accounts).Error
+
+	return accounts, err
+}
+
+// SetAccount stores the balance and version of an account.
+func (r *LedgerRepository) SetAccount(ctx context.Context, account *model.LedgerAccount) error {
+	return r.db.WithContext(ctx).
+		Model(&model.LedgerAccount{}).
+		Where("id = ?", account.ID).
+		Updates(map[string]interface{}{
+			"balance":    account.Balance,
+			"version":    account.Version,
+			"updated_at": time.Now().UTC(),
+		}).Error
+}
+
+// AddToAccount moves delta into an account in place, which keeps the lock on
+// a busy counter-account short, and returns the new balance. The row stays
+// locked until the transaction commits.
+func (r *LedgerRepository) AddToAccount(ctx context.Context, id uint, delta float64) (float64, error) {
+	err := r.db.WithContext(ctx).
+		Model(&model.LedgerAccount{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"balance":    gorm.Expr("balance + ?", delta),
+			"updated_at": time.Now().UTC(),
+		}).Error
+	if err != nil {
+		return 0, err
+	}
+
+	var account model.LedgerAccount
+	err = r.db.WithContext(ctx).Select("balance").Take(&account, id).Error
+	return account.Balance, err
+}
+
+// SaveJournals inserts journals and fills in their IDs.
+func (r *LedgerRepository) SaveJournals(ctx context.Context, journals []model.LedgerJournal) error {
+	if len(journals) == 0 {
+		return nil
+	}
+	return r.db.WithContext(ctx).Create(&journals).Error
+}
+
+// SaveEntries inserts entries.
+func (r *LedgerRepository) SaveEntries(ctx context.Context, entries []model.LedgerEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	return r.db.WithContext(ctx).Create(&entries).Error
+}
+
+// WalletEntry is an entry on a wallet account with its journal.
+type WalletEntry struct {
+	model.LedgerEntry
+	Kind            string `json:"kind"`
+	Version         uint   `json:"version"`
+	PreviousVersion uint   `json:"previous_version"`
+	EventID         string `json:"event_id,omitempty"`
+}
+
+// ListWalletEntries returns up to limit entries of a wallet with an ID
+// greater than afterID, oldest first.
+func (r *LedgerRepository) ListWalletEntries(ctx context.Context, wallet model.WalletKey, afterID uint, limit int) ([]WalletEntry, error) {
+	var entries []WalletEntry
+	err := r.db.WithContext(ctx).
+		Table("ledger_entries AS e").
+		Select("e.*, j.kind, j.version, j.previous_version, j.event_id").
+		Joins("JOIN ledger_journals j ON j.id = e.journal_id").
+		Joins("JOIN ledger_accounts a ON a.id = e.account_id").
+		Where("a.kind = ? AND a.tenant_id = ? AND a.user_id = ? AND a.currency = ?",
+			model.LedgerWallet, wallet.TenantID, wallet.UserID, wallet.Currency).
+		Where("e.id > ?", afterID).
+		Order("e.id").
+		Limit(limit).
+		Scan(&entries).Error
+
+	return entries, err
+}
+
+// ListUnopenedBalances returns up to limit balances with an ID greater than
+// afterID that have no wallet account yet, ordered by ID.
+func (r *LedgerRepository) ListUnopenedBalances(ctx context.Context, afterID uint, limit int) ([]model.Balance, error) {
+	var balances []model.Balance
+	err := r.db.WithContext(ctx).
+		Table("balances AS b").
+		Select("b.*").
+		Joins("LEFT JOIN ledger_accounts a ON a.kind = ? AND a.tenant_id = b.tenant_id AND a.user_id = b.user_id AND a.currency = b.currency", model.LedgerWallet).
+		Where("a.id IS NULL AND b.id > ?", afterID).
+		Order("b.id").
+		Limit(limit).
+		Scan(&balances).Error
+
+	return balances, err
+}
+
+// LedgerTotal is the sum of one kind of balance in a tenant and currency.
+type LedgerTotal struct {
+	TenantID string
+	Currency string
+	Kind     string // an account kind, or "" for the balances table
+	Total    float64
+}
+
+// Totals sums the balances table and the ledger accounts by tenant,
+// currency and account kind.
+func (r *LedgerRepository) Totals(ctx context.Context) ([]LedgerTotal, error) {
+	var projected, accounts []LedgerTotal
+	err := r.db.WithContext(ctx).
+		Model(&model.Balance{}).
+		Select("tenant_id, currency, SUM(amount) AS total").
+		Group("tenant_id, currency").
+		Scan(&projected).Error
+	if err != nil {
+		return nil, err
+	}
+	err = r.db.WithContext(ctx).
+		Model(&model.LedgerAccount{}).
+		Select("tenant_id, currency, kind, SUM(balance) AS total").
+		Group("tenant_id, currency, kind").
+		Scan(&accounts).Error
+
+	return append(projected, accounts...), err
+}
+
+// UnbalancedJournals returns up to limit IDs of journals whose entries do not
+// sum to zero or that do not have exactly two entries.
+func (r *LedgerRepository) UnbalancedJournals(ctx context.Context, limit int) ([]uint, error) {
+	var ids []uint
+	err := r.db.WithContext(ctx).
+		Model(&model.LedgerEntry{}).
+		Select("journal_id").
+		Group("journal_id").
+		Having("ABS(SUM(amount)) >= ? OR COUNT(*) <> 2", ledgerTolerance).
+		Order("journal_id").
+		Limit(limit).
+		Pluck("journal_id", &ids).Error
+
+	return ids, err
+}
+
+// AccountDrift is an account whose balance is not the sum of its entries.
+type AccountDrift struct {
+	AccountID uint    `json:"account_id"`
+	Balance   float64 `json:"balance"`
+	Entries   float64 `json:"entries"`
+}
+
+// DriftingAccounts returns up to limit accounts whose running balance
+// differs from the sum of their entries.
+func (r *LedgerRepository) DriftingAccounts(ctx context.Context, limit int) ([]AccountDrift, error) {
+	var drift []AccountDrift
+	err := r.db.WithContext(ctx).
+		Table("ledger_accounts AS a").
+		Select("a.id AS account_id, a.balance, COALESCE(SUM(e.amount), 0) AS entries").
+		Joins("LEFT JOIN ledger_entries e ON e.account_id = a.id").
+		Group("a.id, a.balance").
+		Having("ABS(a.balance - COALESCE(SUM(e.amount), 0)) >= ?", ledgerTolerance).
+		Order("a.id").
+		Limit(limit).
+		Scan(&drift).Error
+
+	return drift, err
+}
+
+// ProjectionDrift is a stored balance that does not match its wallet
+// account. AccountBalance is nil for a wallet without an account.
+type ProjectionDrift struct {
+	UserID         uint     `json:"user_id"`
+	TenantID       string   `json:"tenant_id,omitempty"`
+	Currency       string   `json:"currency"`
+	Amount         float64  `json:"amount"`
+	Version        uint     `json:"version"`
+	AccountBalance *float64 `json:"account_balance,omitempty"`
+	AccountVersion *uint    `json:"account_version,omitempty"`
+}
+
+// DriftingBalances returns up to limit stored balances that differ from
+// their wallet account in amount or version, or have none.
+func (r *LedgerRepository) DriftingBalances(ctx context.Context, limit int) ([]ProjectionDrift, error) {
+	var drift []ProjectionDrift
+	err := r.db.WithContext(ctx).
+		Table("balances AS b").
+		Select("b.user_id, b.tenant_id, b.currency, b.amount, b.version, a.balance AS account_balance, a.version AS account_version").
+		Joins("LEFT JOIN ledger_accounts a ON a.kind = ? AND a.tenant_id = b.tenant_id AND a.user_id = b.user_id AND a.currency = b.currency", model.LedgerWallet).
+		Where("a.id IS NULL OR ABS(a.balance - b.amount) >= ? OR a.version <> b.version", ledgerTolerance).
+		Order("b.id").
+		Limit(limit).
+		Scan(&drift).Error
+
+	return drift, err
+}
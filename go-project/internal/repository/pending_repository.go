@@ -0,0 +1,83 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"balance-service/internal/model"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// PendingRepository stores the updates the hold policy parks for review.
+type PendingRepository struct {
+	db  *gorm.DB
+	log *logrus.Logger
+}
+
+func NewPendingRepository(db *gorm.DB, log *logrus.Logger) *PendingRepository {
+	return &PendingRepository{
+		db:  db,
+		log: log,
+	}
+}
+
+// SavePendingUpdate parks an update held for review. Holding the same wallet
+// version again returns the existing entry.
+func (r *PendingRepository) SavePendingUpdate(ctx context.Context, pending *model.PendingUpdate) error {
+	return r.db.WithContext(ctx).
+		// Struct conditions would drop the default tenant's empty tenant_id.
+		Where("user_id = ? AND tenant_id = ? AND currency = ? AND version = ?",
+			pending.UserID, pending.TenantID, pending.Currency, pending.Version).
+		FirstOrCreate(pending).Error
+}
+
+// GetPendingUpdate returns a held update, or ErrNotFound
+func (r *PendingRepository) GetPendingUpdate(ctx context.Context, id uint) (*model.PendingUpdate, error) {
+	var pending model.PendingUpdate
+	err := r.db.WithContext(ctx).Take(&pending, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &pending, nil
+}
+
+// ListPendingUpdates returns up to limit held updates with the given status,
+// or of any status if it is empty, oldest first. A nil tenant matches every
+// tenant.
+func (r *PendingRepository) ListPendingUpdates(ctx context.Context, tenant *string, status string, limit int) ([]model.PendingUpdate, error) {
+	var pending []model.PendingUpdate
+	query := r.db.WithContext(ctx).Order("id").Limit(limit)
+	if tenant != nil {
+		query = query.Where("tenant_id = ?", *tenant)
+	}
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	err := query.Find(&pending).Error
+	return pending, err
+}
+
+// ResolvePendingUpdate moves a held update that is still open to status. It
+// returns ErrNotFound if no open update has that ID.
+func (r *PendingRepository) ResolvePendingUpdate(ctx context.Context, id uint, status, note string) error {
+	result := r.db.WithContext(ctx).
+		Model(&model.PendingUpdate{}).
+		Where("id = ? AND status = ?", id, model.PendingOpen).
+		Updates(map[string]interface{}{
+			"status":      status,
+			"note":        note,
+			"resolved_at": time.Now().UTC(),
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package repository
+
+import (
+	"context"
+	"testing"
+
+	"balance-service/internal/database"
+	"balance-service/internal/model"
+)
+
+func TestSavePendingUpdateKeepsTenantsApart(t *testing.T) {
+	db, log := database.OpenTest(t)
+	repo := NewPendingRepository(db, log)
+	ctx := context.Background()
+
+	acme := &model.PendingUpdate{UserID: 1, TenantID: "acme", Currency: "USD", Version: 2, Amount: -5, Status: model.PendingOpen}
+	if err := repo.SavePendingUpdate(ctx, acme); err != nil {
+		t.Fatal(err)
+	}
+	// The same wallet version in the default tenant is another update.
+	held := &model.PendingUpdate{UserID: 1, Currency: "USD", Version: 2, Amount: -7, Status: model.PendingOpen}
+	if err := repo.SavePendingUpdate(ctx, held); err != nil {
+		t.Fatal(err)
+	}
+	if held.ID == acme.ID || held.Amount != -7 {
+		t.Errorf("default tenant update resolved to %+v, want a new entry", held)
+	}
+
+	// Holding it again returns the stored entry.
+	again := &model.PendingUpdate{UserID: 1, Currency: "USD", Version: 2, Amount: -7, Status: model.PendingOpen}
+	if err := repo.SavePendingUpdate(ctx, again); err != nil {
+		t.Fatal(err)
+	}
+	if again.ID != held.ID {
+		t.Errorf("redelivery stored as %d, want the existing %d", again.ID, held.ID)
+	}
+
+	defaultTenant := ""
+	list, err := repo.ListPendingUpdates(ctx, &defaultTenant, model.PendingOpen, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].ID != held.ID {
+		t.Errorf("default tenant lists %+v, want only its own update", list)
+	}
+}
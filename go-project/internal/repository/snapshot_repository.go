@@ -0,0 +1,84 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"balance-service/internal/model"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type SnapshotRepository struct {
+	db  *gorm.DB
+	log *logrus.Logger
+}
+
+func NewSnapshotRepository(db *gorm.DB, log *logrus.Logger) *SnapshotRepository {
+	return &SnapshotRepository{
+		db:  db,
+		log: log,
+	}
+}
+
+// SaveSnapshots inserts snapshots, skipping wallets that already have one
+// for the day, and returns how many it inserted.
+func (r *SnapshotRepository) SaveSnapshots(ctx context.Context, snapshots []model.BalanceSnapshot) (int64, error) {
+	if len(snapshots) == 0 {
+		return 0, nil
+	}
+	result := r.db.WithContext(ctx).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&snapshots)
+
+	return result.RowsAffected, result.Error
+}
+
+// SaveSnapshotDay records that the snapshot of a day was taken, unless it
+// is recorded already.
+func (r *SnapshotRepository) SaveSnapshotDay(ctx context.Context, day *model.SnapshotDay) error {
+	return r.db.WithContext(ctx).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(day).Error
+}
+
+// HasSnapshot reports whether the snapshot of a day was taken.
+func (r *SnapshotRepository) HasSnapshot(ctx context.Context, date time.Time) (bool, error) {
+	var count int64
+	err := r.db.WithContext(ctx).
+		Model(&model.SnapshotDay{}).
+		Where("date = ?", date).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// GetSnapshotDay returns the record of a day whose snapshot was taken, or
+// ErrNotFound
+func (r *SnapshotRepository) GetSnapshotDay(ctx context.Context, date time.Time) (*model.SnapshotDay, error) {
+	var day model.SnapshotDay
+	err := r.db.WithContext(ctx).Where("date = ?", date).Take(&day).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &day, nil
+}
+
+// GetSnapshot returns the snapshot of a wallet on a day, or ErrNotFound
+func (r *SnapshotRepository) GetSnapshot(ctx context.Context, wallet model.WalletKey, date time.Time) (*model.BalanceSnapshot, error) {
+	var snapshot model.BalanceSnapshot
+	err := r.db.WithContext(ctx).
+		Where("date = ? AND user_id = ? AND tenant_id = ? AND currency = ?", date, wallet.UserID, wallet.TenantID, wallet.Currency).
+		Take(&snapshot).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &snapshot, nil
+}
@@ -0,0 +1,50 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"balance-service/internal/model"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+type WatermarkRepository struct {
+	db  *gorm.DB
+	log *logrus.Logger
+}
+
+func NewWatermarkRepository(db *gorm.DB, log *logrus.Logger) *WatermarkRepository {
+	return &WatermarkRepository{
+		db:  db,
+		log: log,
+	}
+}
+
+// GetWatermark returns the bootstrap watermark of source, or ErrNotFound
+func (r *WatermarkRepository) GetWatermark(ctx context.Context, source string) (*model.BootstrapWatermark, error) {
+	var wm model.BootstrapWatermark
+	err := r.db.WithContext(ctx).
+		Where("source = ?", source).
+		Take(&wm).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &wm, nil
+}
+
+// SaveWatermark creates or updates a watermark
+func (r *WatermarkRepository) SaveWatermark(ctx context.Context, wm *model.BootstrapWatermark) error {
+	return r.db.WithContext(ctx).Save(wm).Error
+}
+
+// DeleteWatermark forgets the progress of source
+func (r *WatermarkRepository) DeleteWatermark(ctx context.Context, source string) error {
+	return r.db.WithContext(ctx).
+		Where("source = ?", source).
+		Delete(&model.BootstrapWatermark{}).Error
+}
@@ -0,0 +1,90 @@
+package repository
+
+import (
+	"context"
+
+	"balance-service/internal/model"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+type WebhookRepository struct {
+	db  *gorm.DB
+	log *logrus.Logger
+}
+
+func NewWebhookRepository(db *gorm.DB, log *logrus.Logger) *WebhookRepository {
+	return &WebhookRepository{
+		db:  db,
+		log: log,
+	}
+}
+
+// CreateSubscription stores a new subscription
+func (r *WebhookRepository) CreateSubscription(ctx context.Context, sub *model.WebhookSubscription) error {
+	return r.db.WithContext(ctx).Create(sub).Error
+}
+
+// ListSubscriptions returns all subscriptions, or only the active ones. A nil
+// tenant matches every tenant.
+func (r *WebhookRepository) ListSubscriptions(ctx context.Context, tenant *string, activeOnly bool) ([]model.WebhookSubscription, error) {
+	var subs []model.WebhookSubscription
+	query := r.db.WithContext(ctx).Order("id")
+	if tenant != nil {
+		query = query.Where("tenant_id = ?", *tenant)
+	}
+	if activeOnly {
+		query = query.Where("active = ?", true)
+	}
+	err := query.Find(&subs).Error
+	return subs, err
+}
+
+// SetSubscriptionActive pauses or resumes a subscription, or returns ErrNotFound
+func (r *WebhookRepository) SetSubscriptionActive(ctx context.Context, id uint, active bool) error {
+	result := r.db.WithContext(ctx).
+		Model(&model.WebhookSubscription{}).
+		Where("id = ?", id).
+		Update("active", active)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// DeleteSubscription removes a subscription, or returns ErrNotFound
+func (r *WebhookRepository) DeleteSubscription(ctx context.Context, id uint) error {
+	result := r.db.WithContext(ctx).Delete(&model.WebhookSubscription{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// LogDelivery records a delivery attempt
+func (r *WebhookRepository) LogDelivery(ctx context.Context, delivery *model.WebhookDelivery) error {
+	return r.db.WithContext(ctx).Create(delivery).Error
+}
+
+// ListDeliveries returns the latest limit delivery attempts, newest first,
+// optionally of one subscription. A nil tenant matches the subscriptions of
+// every tenant.
+func (r *WebhookRepository) ListDeliveries(ctx context.Context, tenant *string, subscriptionID uint, limit int) ([]model.WebhookDelivery, error) {
+	var deliveries []model.WebhookDelivery
+	query := r.db.WithContext(ctx).Order("id DESC").Limit(limit)
+	if tenant != nil {
+		query = query.Where("subscription_id IN (?)",
+			r.db.Model(&model.WebhookSubscription{}).Select("id").Where("tenant_id = ?", *tenant))
+	}
+	if subscriptionID != 0 {
+		query = query.Where("subscription_id = ?", subscriptionID)
+	}
+	err := query.Find(&deliveries).Error
+	return deliveries, err
+}
@@ -0,0 +1,47 @@
+package repository
+
+import (
+	"context"
+	"testing"
+
+	"balance-service/internal/database"
+	"balance-service/internal/model"
+)
+
+func TestWebhookRepositoryScopesByTenant(t *testing.T) {
+	db, log := database.OpenTest(t)
+	repo := NewWebhookRepository(db, log)
+	ctx := context.Background()
+
+	own := &model.WebhookSubscription{URL: "https://default.example/hook", Secret: "a", Active: true}
+	acme := &model.WebhookSubscription{TenantID: "acme", URL: "https://acme.example/hook", Secret: "b", Active: true}
+	for _, sub := range []*model.WebhookSubscription{own, acme} {
+		if err := repo.CreateSubscription(ctx, sub); err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.LogDelivery(ctx, &model.WebhookDelivery{SubscriptionID: sub.ID, DeliveryID: sub.URL, EventType: model.WebhookBalanceUpdated, Attempt: 1, Outcome: "delivered"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tenant := "acme"
+	subs, err := repo.ListSubscriptions(ctx, &tenant, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subs) != 1 || subs[0].ID != acme.ID {
+		t.Errorf("acme subscriptions %+v, want only its own", subs)
+	}
+	if all, err := repo.ListSubscriptions(ctx, nil, true); err != nil || len(all) != 2 {
+		t.Errorf("all subscriptions: %d, %v; want 2", len(all), err)
+	}
+
+	defaultTenant := ""
+	deliveries, err := repo.ListDeliveries(ctx, &defaultTenant, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 || deliveries[0].SubscriptionID != own.ID {
+		t.Errorf("default tenant deliveries %+v, want only its own", deliveries)
+	}
+}
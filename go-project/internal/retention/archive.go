@@ -0,0 +1,178 @@
+package retention
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"balance-service/internal/model"
+	"balance-service/internal/repository"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// archivePrefix starts the name of every archive file. The rest is the
+// month of the events and their ID range, zero-padded so that names sort by
+// ID: balance_events/2026/01/00000000000000000001-00000000000000100000.jsonl.gz.
+const archivePrefix = "balance_events/"
+
+func archiveName(month time.Time, firstID, lastID uint) string {
+	return fmt.Sprintf("%s%020d-%020d.jsonl.gz", monthPrefix(month), firstID, lastID)
+}
+
+func monthPrefix(month time.Time) string {
+	return archivePrefix + month.Format("2006/01") + "/"
+}
+
+// monthOf truncates t to the first instant of its month in UTC.
+func monthOf(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// archiveFile collects the events of one archive file as gzip-compressed
+// JSON lines, one model.BalanceEvent per line.
+type archiveFile struct {
+	month   time.Time
+	firstID uint
+	lastID  uint
+	events  int
+	buf     bytes.Buffer
+	gz      *gzip.Writer
+	enc     *json.Encoder
+}
+
+func newArchiveFile(month time.Time) *archiveFile {
+	f := &archiveFile{month: month}
+	f.gz = gzip.NewWriter(&f.buf)
+	f.enc = json.NewEncoder(f.gz)
+	return f
+}
+
+func (f *archiveFile) add(event model.BalanceEvent) error {
+	if f.events == 0 {
+		f.firstID = event.ID
+	}
+	f.lastID = event.ID
+	f.events++
+	return f.enc.Encode(event)
+}
+
+func (f *archiveFile) name() string {
+	return archiveName(f.month, f.firstID, f.lastID)
+}
+
+// bytes finishes the file.
+func (f *archiveFile) bytes() ([]byte, error) {
+	if err := f.gz.Close(); err != nil {
+		return nil, err
+	}
+	return f.buf.Bytes(), nil
+}
+
+// Archives lists the archive files of the months from from to to, or every
+// archive file if from is zero.
+func Archives(ctx context.Context, store Store, from, to time.Time) ([]string, error) {
+	if from.IsZero() {
+		return store.List(ctx, archivePrefix)
+	}
+	var names []string
+	for month, last := monthOf(from), monthOf(to); !month.After(last); month = month.AddDate(0, 1, 0) {
+		found, err := store.List(ctx, monthPrefix(month))
+		if err != nil {
+			return nil, fmt.Errorf("list archives of %s: %w", month.Format("2006-01"), err)
+		}
+		names = append(names, found...)
+	}
+	return names, nil
+}
+
+// Restored is how much of one archive file was restored; events restored
+// before are not restored again.
+type Restored struct {
+	Archive  string `json:"archive"`
+	Events   int    `json:"events"`
+	Restored int64  `json:"restored"`
+}
+
+// Restore imports archive files into balance_events_restored, batchSize
+// events per insert.
+func Restore(ctx context.Context, db *gorm.DB, store Store, names []string, batchSize int, log *logrus.Logger) ([]Restored, error) {
+	events := repository.NewEventRepository(db, log)
+	if err := events.MigrateRestored(ctx); err != nil {
+		return nil, fmt.Errorf("create the restored events table: %w", err)
+	}
+
+	results := make([]Restored, 0, len(names))
+	for _, name := range names {
+		result, err := restoreFile(ctx, events, store, name, batchSize)
+		if err != nil {
+			return results, fmt.Errorf("restore %s: %w", name, err)
+		}
+		results = append(results, result)
+		log.WithFields(logrus.Fields{
+			"archive":  name,
+			"events":   result.Events,
+			"restored": result.Restored,
+		}).Info("archive restored")
+	}
+	return results, nil
+}
+
+func restoreFile(ctx context.Context, events *repository.EventRepository, store Store, name string, batchSize int) (Restored, error) {
+	result := Restored{Archive: name}
+	file, err := store.Open(ctx, name)
+	if err != nil {
+		return result, err
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return result, err
+	}
+	dec := json.NewDecoder(gz)
+
+	now := time.Now().UTC()
+	batch := make([]model.RestoredEvent, 0, batchSize)
+	flush := func() error {
+		n, err := events.SaveRestoredEvents(ctx, batch)
+		result.Restored += n
+		batch = batch[:0]
+		return err
+	}
+	for {
+		var e model.BalanceEvent
+		if err := dec.Decode(&e); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return result, fmt.Errorf("event %d: %w", result.Events+1, err)
+		}
+		result.Events++
+		batch = append(batch, model.RestoredEvent{
+			ID:         e.ID,
+			CreatedAt:  e.CreatedAt,
+			UserID:     e.UserID,
+			TenantID:   e.TenantID,
+			Currency:   e.Currency,
+			Amount:     e.Amount,
+			Version:    e.Version,
+			UpdatedAt:  e.UpdatedAt,
+			EventID:    e.EventID,
+			Metadata:   e.Metadata,
+			Archive:    name,
+			RestoredAt: now,
+		})
+		if len(batch) == batchSize {
+			if err := flush(); err != nil {
+				return result, err
+			}
+		}
+	}
+	return result, flush()
+}
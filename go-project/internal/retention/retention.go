@@ -0,0 +1,370 @@
+// Package retention keeps balance_events bounded. Events older than the
+// retention period are written to archive files in a local directory or an
+// S3-compatible bucket, then deleted in small batches, or, with monthly
+// partitions, dropped a month at a time. Archived events can be restored
+// for audits. The claims of processed event IDs are deleted after a horizon
+// of their own.
+package retention
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"balance-service/internal/metrics"
+	"balance-service/internal/repository"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// partitionsAhead is how many months after the current one have a
+// partition before their events arrive.
+const partitionsAhead = 2
+
+var (
+	runs              = metrics.NewCounter("balance_retention_runs_total", "Retention runs, by result.", "result")
+	archivedEvents    = metrics.NewCounter("balance_retention_archived_events_total", "Balance events written to the archive.")
+	deletedEvents     = metrics.NewCounter("balance_retention_deleted_events_total", "Archived balance events deleted from balance_events, row by row or with their partition.")
+	droppedPartitions = metrics.NewCounter("balance_retention_dropped_partitions_total", "Monthly partitions of balance_events dropped after archiving.")
+	deletedClaims     = metrics.NewCounter("balance_retention_deleted_claims_total", "Claims of processed event IDs deleted after the claim horizon.")
+)
+
+// Options configure a run.
+type Options struct {
+	Days        int // events older than this many days are archived
+	BatchSize   int // events read per query, and the ID span of each delete
+	FileEvents  int // events per archive file at most
+	Partitioned bool
+	// ClaimDays is how long the claim of a processed event ID is kept,
+	// zero for ever. A redelivery that arrives after its claim is deleted
+	// is recorded again, so this must be longer than the broker can hold
+	// on to a message.
+	ClaimDays int
+}
+
+// Report is the result of a run.
+type Report struct {
+	Cutoff            time.Time  `json:"cutoff"`
+	Duration          string     `json:"duration"`
+	Archives          []string   `json:"archives,omitempty"`
+	Archived          int        `json:"archived"`
+	Deleted           int64      `json:"deleted"`
+	AddedPartitions   []string   `json:"added_partitions,omitempty"`
+	DroppedPartitions []string   `json:"dropped_partitions,omitempty"`
+	ClaimCutoff       *time.Time `json:"claim_cutoff,omitempty"`
+	DeletedClaims     int64      `json:"deleted_claims"`
+}
+
+// Job archives and deletes old balance events.
+type Job struct {
+	events *repository.EventRepository
+	store  Store
+	opts   Options
+	log    *logrus.Logger
+}
+
+func New(db *gorm.DB, store Store, opts Options, log *logrus.Logger) *Job {
+	return &Job{
+		events: repository.NewEventRepository(db, log),
+		store:  store,
+		opts:   opts,
+		log:    log,
+	}
+}
+
+// span is the range of IDs archived from one partition.
+type span struct {
+	firstID, lastID uint
+}
+
+// Run archives the events created before the cutoff, in ID order up to the
+// first younger event, and removes them. A file is only removed from the
+// table once it is stored; a run that fails in between writes the same
+// files again next time. Then the claims older than the claim horizon are
+// deleted.
+func (j *Job) Run(ctx context.Context) (*Report, error) {
+	started := time.Now()
+	report := &Report{Cutoff: started.UTC().AddDate(0, 0, -j.opts.Days)}
+	if j.opts.ClaimDays > 0 {
+		cutoff := started.UTC().AddDate(0, 0, -j.opts.ClaimDays)
+		report.ClaimCutoff = &cutoff
+	}
+
+	err := j.run(ctx, report)
+	report.Duration = time.Since(started).Round(time.Millisecond).String()
+	if err != nil {
+		runs.Inc("failed")
+		return report, err
+	}
+	runs.Inc("ok")
+	return report, nil
+}
+
+func (j *Job) run(ctx context.Context, report *Report) error {
+	var partitions []repository.EventPartition
+	if j.opts.Partitioned {
+		var err error
+		if partitions, err = j.extendPartitions(ctx, report); err != nil {
+			return err
+		}
+	}
+
+	// Archived months of partitions that expired as a whole are dropped
+	// with their partition instead of row by row.
+	deferred := make(map[string]*span)
+	expiredPartition := func(month time.Time) (repository.EventPartition, bool) {
+		for _, p := range partitions {
+			if p.Name != repository.FuturePartition && month.Before(p.End()) {
+				return p, !report.Cutoff.Before(p.End())
+			}
+		}
+		return repository.EventPartition{}, false
+	}
+
+	store := func(file *archiveFile) error {
+		data, err := file.bytes()
+		if err != nil {
+			return err
+		}
+		name := file.name()
+		if err := j.store.Put(ctx, name, data); err != nil {
+			return fmt.Errorf("store %s: %w", name, err)
+		}
+		report.Archives = append(report.Archives, name)
+		report.Archived += file.events
+		archivedEvents.Add(float64(file.events))
+		j.log.WithFields(logrus.Fields{"archive": name, "events": file.events}).Info("balance events archived")
+
+		if p, ok := expiredPartition(file.month); ok {
+			if s := deferred[p.Name]; s != nil {
+				s.lastID = file.lastID
+			} else {
+				deferred[p.Name] = &span{firstID: file.firstID, lastID: file.lastID}
+			}
+			return nil
+		}
+		return j.delete(ctx, report, span{firstID: file.firstID, lastID: file.lastID})
+	}
+
+	var (
+		file    *archiveFile
+		afterID uint
+	)
+scan:
+	for {
+		page, err := j.events.ListEventsAfter(ctx, afterID, j.opts.BatchSize)
+		if err != nil {
+			return fmt.Errorf("read events after %d: %w", afterID, err)
+		}
+		for _, event := range page {
+			if !event.CreatedAt.Before(report.Cutoff) {
+				break scan
+			}
+			month := monthOf(event.CreatedAt)
+			if file != nil && (!file.month.Equal(month) || file.events >= j.opts.FileEvents) {
+				if err := store(file); err != nil {
+					return err
+				}
+				file = nil
+			}
+			if file == nil {
+				file = newArchiveFile(month)
+			}
+			if err := file.add(event); err != nil {
+				return fmt.Errorf("encode event %d: %w", event.ID, err)
+			}
+			afterID = event.ID
+		}
+		if len(page) < j.opts.BatchSize {
+			break
+		}
+	}
+	if file != nil {
+		if err := store(file); err != nil {
+			return err
+		}
+	}
+
+	for _, p := range partitions {
+		if p.Name == repository.FuturePartition || report.Cutoff.Before(p.End()) {
+			continue
+		}
+		if err := j.dropPartition(ctx, report, p, afterID, deferred[p.Name]); err != nil {
+			return err
+		}
+	}
+	return j.deleteClaims(ctx, report)
+}
+
+// deleteClaims deletes the claims made before the claim cutoff, BatchSize
+// event IDs per statement.
+func (j *Job) deleteClaims(ctx context.Context, report *Report) error {
+	if report.ClaimCutoff == nil {
+		return nil
+	}
+	for {
+		n, err := j.events.DeleteClaims(ctx, *report.ClaimCutoff, j.opts.BatchSize)
+		if err != nil {
+			return fmt.Errorf("delete claims before %s: %w", report.ClaimCutoff.Format(time.RFC3339), err)
+		}
+		report.DeletedClaims += n
+		deletedClaims.Add(float64(n))
+		if n < int64(j.opts.BatchSize) {
+			break
+		}
+	}
+	if report.DeletedClaims > 0 {
+		j.log.WithField("claims", report.DeletedClaims).Info("processed event claims deleted")
+	}
+	return nil
+}
+
+// delete removes the archived events of s, BatchSize IDs per statement.
+func (j *Job) delete(ctx context.Context, report *Report, s span) error {
+	for first := s.firstID; first <= s.lastID; first += uint(j.opts.BatchSize) {
+		last := first + uint(j.opts.BatchSize) - 1
+		if last > s.lastID || last < first {
+			last = s.lastID
+		}
+		n, err := j.events.DeleteEvents(ctx, first, last, report.Cutoff)
+		if err != nil {
+			return fmt.Errorf("delete events %d to %d: %w", first, last, err)
+		}
+		report.Deleted += n
+		deletedEvents.Add(float64(n))
+		if last == s.lastID {
+			break
+		}
+	}
+	return nil
+}
+
+// dropPartition drops an expired partition once every event in it is
+// archived, i.e. none is newer than the last archived ID. Otherwise the
+// archived events in it are deleted row by row.
+func (j *Job) dropPartition(ctx context.Context, report *Report, p repository.EventPartition, lastArchived uint, archived *span) error {
+	left, err := j.events.CountPartitionEvents(ctx, p, lastArchived)
+	if err != nil {
+		return fmt.Errorf("count events of partition %s: %w", p.Name, err)
+	}
+	if left > 0 {
+		j.log.WithFields(logrus.Fields{"partition": p.Name, "events": left}).
+			Warn("expired partition holds events that are not archived, deleting the archived ones instead")
+		if archived == nil {
+			return nil
+		}
+		return j.delete(ctx, report, *archived)
+	}
+
+	var n int64
+	if archived != nil {
+		// Only what this run archived was left in the partition.
+		if n, err = j.events.CountPartitionEvents(ctx, p, 0); err != nil {
+			return fmt.Errorf("count events of partition %s: %w", p.Name, err)
+		}
+	}
+	if err := j.events.DropEventPartition(ctx, p); err != nil {
+		return fmt.Errorf("drop partition %s: %w", p.Name, err)
+	}
+	report.DroppedPartitions = append(report.DroppedPartitions, p.Name)
+	report.Deleted += n
+	deletedEvents.Add(float64(n))
+	droppedPartitions.Inc()
+	j.log.WithFields(logrus.Fields{"partition": p.Name, "events": n}).Info("balance events partition dropped")
+	return nil
+}
+
+// extendPartitions adds the partitions up to partitionsAhead months from now
+// and returns the partitions of the table.
+func (j *Job) extendPartitions(ctx context.Context, report *Report) ([]repository.EventPartition, error) {
+	partitions, err := j.events.EventPartitions(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list partitions: %w", err)
+	}
+	if len(partitions) == 0 {
+		return nil, errors.New("balance_events is not partitioned, run the retention partition command first")
+	}
+
+	var next time.Time
+	for _, p := range partitions {
+		if p.Name != repository.FuturePartition {
+			next = p.End()
+		}
+	}
+	last := monthOf(time.Now()).AddDate(0, partitionsAhead, 0)
+	if next.IsZero() || next.After(last) {
+		return partitions, nil
+	}
+	if err := j.events.AddEventPartitions(ctx, next, last); err != nil {
+		return nil, fmt.Errorf("add partitions: %w", err)
+	}
+	for month := next; !month.After(last); month = month.AddDate(0, 1, 0) {
+		report.AddedPartitions = append(report.AddedPartitions, repository.PartitionName(month))
+	}
+	return j.events.EventPartitions(ctx)
+}
+
+// Partition partitions balance_events by month, from the month of its
+// oldest event to partitionsAhead months from now. It returns the names of
+// the partitions, and does nothing to a table that is partitioned already.
+func Partition(ctx context.Context, db *gorm.DB, log *logrus.Logger) ([]string, error) {
+	events := repository.NewEventRepository(db, log)
+	partitions, err := events.EventPartitions(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list partitions: %w", err)
+	}
+
+	if len(partitions) == 0 {
+		first := monthOf(time.Now())
+		oldest, err := events.FirstEvent(ctx)
+		switch {
+		case err == nil:
+			first = monthOf(oldest.CreatedAt)
+		case !errors.Is(err, repository.ErrNotFound):
+			return nil, fmt.Errorf("read the oldest event: %w", err)
+		}
+		if err := events.PartitionEvents(ctx, first, monthOf(time.Now()).AddDate(0, partitionsAhead, 0)); err != nil {
+			return nil, fmt.Errorf("partition balance_events: %w", err)
+		}
+		if partitions, err = events.EventPartitions(ctx); err != nil {
+			return nil, fmt.Errorf("list partitions: %w", err)
+		}
+	}
+
+	names := make([]string, len(partitions))
+	for i, p := range partitions {
+		names[i] = p.Name
+	}
+	return names, nil
+}
+
+// Schedule runs the job every interval until ctx is cancelled. A failed run
+// is logged and retried at the next tick.
+func Schedule(ctx context.Context, job *Job, interval time.Duration, log *logrus.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		report, err := job.Run(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.WithError(err).WithField("archived", report.Archived).Error("retention run failed")
+			}
+			continue
+		}
+		log.WithFields(logrus.Fields{
+			"cutoff":             report.Cutoff,
+			"archived":           report.Archived,
+			"deleted":            report.Deleted,
+			"dropped_partitions": len(report.DroppedPartitions),
+			"duration":           report.Duration,
+		}).Info("retention run finished")
+	}
+}
@@ -0,0 +1,196 @@
+package retention
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"balance-service/internal/database"
+	"balance-service/internal/model"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// failingStore fails the uploads of names starting with failPrefix.
+type failingStore struct {
+	Store
+	failPrefix string
+	attempts   []string
+}
+
+func (f *failingStore) Put(ctx context.Context, name string, data []byte) error {
+	f.attempts = append(f.attempts, name)
+	if strings.HasPrefix(name, f.failPrefix) {
+		return errors.New("upload failed")
+	}
+	return f.Store.Put(ctx, name, data)
+}
+
+// openEvents returns a database with two events in each of January and
+// February 2020, one in March 2020 and one from now, IDs 1 to 6.
+func openEvents(t *testing.T) (*gorm.DB, *logrus.Logger) {
+	t.Helper()
+	db, log := database.OpenTest(t)
+
+	var events []model.BalanceEvent
+	for i, created := range []time.Time{
+		time.Date(2020, 1, 10, 0, 0, 0, 0, time.UTC),
+		time.Date(2020, 1, 20, 0, 0, 0, 0, time.UTC),
+		time.Date(2020, 2, 10, 0, 0, 0, 0, time.UTC),
+		time.Date(2020, 2, 20, 0, 0, 0, 0, time.UTC),
+		time.Date(2020, 3, 10, 0, 0, 0, 0, time.UTC),
+		time.Now().UTC(),
+	} {
+		events = append(events, model.BalanceEvent{
+			CreatedAt: created, UpdatedAt: created, UserID: 1, Currency: "USD", Amount: float64(i), Version: uint(i + 1),
+		})
+	}
+	if err := db.Create(&events).Error; err != nil {
+		t.Fatal(err)
+	}
+	return db, log
+}
+
+func remainingEvents(t *testing.T, db *gorm.DB) []uint {
+	t.Helper()
+	var ids []uint
+	if err := db.Model(&model.BalanceEvent{}).Order("id").Pluck("id", &ids).Error; err != nil {
+		t.Fatal(err)
+	}
+	return ids
+}
+
+func TestRunKeepsEventsOfFailedUploads(t *testing.T) {
+	db, log := openEvents(t)
+	store := &failingStore{Store: dirStore{root: t.TempDir()}, failPrefix: "balance_events/2020/02/"}
+	opts := Options{Days: 30, BatchSize: 2, FileEvents: 2}
+
+	report, err := New(db, store, opts, log).Run(context.Background())
+	if err == nil {
+		t.Fatal("Run succeeded with a failing upload")
+	}
+	if len(report.Archives) != 1 || report.Archived != 2 || report.Deleted != 2 {
+		t.Errorf("report %+v, want January archived and deleted only", report)
+	}
+	if ids := remainingEvents(t, db); len(ids) != 4 || ids[0] != 3 {
+		t.Errorf("events %v left, want 3 to 6", ids)
+	}
+
+	// The next run picks up where the failed one stopped.
+	store.failPrefix = "nothing"
+	report, err = New(db, store, opts, log).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Archives) != 2 || report.Archived != 3 || report.Deleted != 3 {
+		t.Errorf("report %+v, want February and March archived and deleted", report)
+	}
+	if ids := remainingEvents(t, db); len(ids) != 1 || ids[0] != 6 {
+		t.Errorf("events %v left, want the recent one", ids)
+	}
+
+	names, err := Archives(context.Background(), store, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		archiveName(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), 1, 2),
+		archiveName(time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC), 3, 4),
+		archiveName(time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC), 5, 5),
+	}
+	if strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Errorf("archives %v, want %v", names, want)
+	}
+}
+
+func TestRunDeletesNothingWhenEveryUploadFails(t *testing.T) {
+	db, log := openEvents(t)
+	store := &failingStore{Store: dirStore{root: t.TempDir()}, failPrefix: archivePrefix}
+
+	report, err := New(db, store, Options{Days: 30, BatchSize: 10, FileEvents: 10}, log).Run(context.Background())
+	if err == nil {
+		t.Fatal("Run succeeded with failing uploads")
+	}
+	if report.Deleted != 0 || len(report.Archives) != 0 || len(store.attempts) != 1 {
+		t.Errorf("report %+v after %v, want one failed upload and nothing deleted", report, store.attempts)
+	}
+	if ids := remainingEvents(t, db); len(ids) != 6 {
+		t.Errorf("events %v left, want all six", ids)
+	}
+}
+
+func TestRunArchivesRestorably(t *testing.T) {
+	db, log := openEvents(t)
+	store, _ := newS3Stub(t, "")
+	ctx := context.Background()
+
+	report, err := New(db, store, Options{Days: 30, BatchSize: 2, FileEvents: 10}, log).Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Archived != 5 || report.Deleted != 5 {
+		t.Fatalf("report %+v, want five events archived and deleted", report)
+	}
+
+	restored, err := Restore(ctx, db, store, report.Archives, 2, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var total int64
+	for _, r := range restored {
+		total += r.Restored
+	}
+	if total != 5 {
+		t.Errorf("restored %d events, want 5", total)
+	}
+	var versions []uint
+	if err := db.Model(&model.RestoredEvent{}).Order("id").Pluck("version", &versions).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 5 || versions[0] != 1 || versions[4] != 5 {
+		t.Errorf("restored versions %v, want 1 to 5", versions)
+	}
+}
+
+func TestRunDeletesOldClaims(t *testing.T) {
+	db, log := openEvents(t)
+	now := time.Now().UTC()
+	claims := []model.ProcessedEvent{
+		{EventID: "a", CreatedAt: now.AddDate(0, 0, -100)},
+		{EventID: "b", CreatedAt: now.AddDate(0, 0, -95)},
+		{EventID: "c", CreatedAt: now.AddDate(0, 0, -91)},
+		{TenantID: "acme", EventID: "a", CreatedAt: now.AddDate(0, 0, -92)},
+		// Within the horizon, whatever the tenant.
+		{TenantID: "acme", EventID: "c", CreatedAt: now.AddDate(0, 0, -89)},
+		{EventID: "d", CreatedAt: now.AddDate(0, 0, -1)},
+	}
+	if err := db.Create(&claims).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := New(db, dirStore{root: t.TempDir()}, Options{Days: 30, BatchSize: 2, FileEvents: 10, ClaimDays: 90}, log).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.DeletedClaims != 4 || report.ClaimCutoff == nil || report.Deleted != 5 {
+		t.Errorf("report %+v, want 4 claims and 5 events deleted", report)
+	}
+	var left []model.ProcessedEvent
+	if err := db.Order("event_id").Find(&left).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(left) != 2 || left[0].TenantID != "acme" || left[0].EventID != "c" || left[1].EventID != "d" {
+		t.Errorf("claims %+v left, want acme's c and d", left)
+	}
+
+	// Without a horizon, claims are kept.
+	report, err = New(db, dirStore{root: t.TempDir()}, Options{Days: 1, BatchSize: 2, FileEvents: 10}, log).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.DeletedClaims != 0 || report.ClaimCutoff != nil {
+		t.Errorf("report %+v, want no claims deleted", report)
+	}
+}
@@ -0,0 +1,100 @@
+package retention
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"balance-service/internal/config"
+)
+
+// Store keeps archive files under names like "balance_events/2026/01/…".
+// Open fails with an error matching fs.ErrNotExist for a missing file.
+type Store interface {
+	Put(ctx context.Context, name string, data []byte) error
+	Open(ctx context.Context, name string) (io.ReadCloser, error)
+	// List returns the names starting with prefix, sorted.
+	List(ctx context.Context, prefix string) ([]string, error)
+}
+
+// NewStore opens the configured archive.
+func NewStore(cfg config.ArchiveConfig) (Store, error) {
+	switch cfg.Type {
+	case "dir":
+		return dirStore{root: cfg.Dir}, nil
+	case "s3":
+		return newS3Store(cfg)
+	default:
+		return nil, fmt.Errorf("unsupported archive type %q", cfg.Type)
+	}
+}
+
+// dirStore keeps the files in a local directory.
+type dirStore struct {
+	root string
+}
+
+func (d dirStore) path(name string) string {
+	return filepath.Join(d.root, filepath.FromSlash(name))
+}
+
+// Put writes the file under a temporary name first, so a crash never
+// leaves a truncated archive behind.
+func (d dirStore) Put(_ context.Context, name string, data []byte) error {
+	path := d.path(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (d dirStore) Open(_ context.Context, name string) (io.ReadCloser, error) {
+	return os.Open(d.path(name))
+}
+
+func (d dirStore) List(_ context.Context, prefix string) ([]string, error) {
+	var names []string
+	err := filepath.WalkDir(d.root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if path == d.root && os.IsNotExist(err) {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			return nil
+		}
+		rel, err := filepath.Rel(d.root, path)
+		if err != nil {
+			return err
+		}
+		if name := filepath.ToSlash(rel); strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+		return nil
+	})
+	sort.Strings(names)
+	return names, err
+}
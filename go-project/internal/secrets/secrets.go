@@ -0,0 +1,100 @@
+// Package secrets resolves credentials that should not be written into the
+// configuration itself. A reference has the form "scheme:ref", for example
+// "file:/run/secrets/db_password" or "env:DB_PASSWORD". The scheme selects a
+// Provider, so another backend only has to implement Provider and Register
+// itself under a new scheme.
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// ErrNotFound is returned by a provider that has no value for a reference.
+var ErrNotFound = errors.New("secret not found")
+
+// Provider fetches the current value of a secret. It is called again on
+// every reconnect, so a provider must not cache values that can rotate.
+type Provider interface {
+	Fetch(ctx context.Context, ref string) (string, error)
+}
+
+// FileProvider reads the secret from a file, the way Docker and Kubernetes
+// mount them. A single trailing newline is dropped.
+type FileProvider struct{}
+
+func (FileProvider) Fetch(_ context.Context, path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	value := strings.TrimSuffix(string(data), "\n")
+	return strings.TrimSuffix(value, "\r"), nil
+}
+
+// EnvProvider reads the secret from an environment variable.
+type EnvProvider struct{}
+
+func (EnvProvider) Fetch(_ context.Context, name string) (string, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", ErrNotFound
+	}
+	return value, nil
+}
+
+var (
+	mu        sync.RWMutex
+	providers = map[string]Provider{
+		"file": FileProvider{},
+		"env":  EnvProvider{},
+	}
+)
+
+// Register makes p available under scheme, replacing any provider already
+// registered for it.
+func Register(scheme string, p Provider) {
+	mu.Lock()
+	defer mu.Unlock()
+	providers[scheme] = p
+}
+
+// FileRef returns the reference for a secret stored in the file at path.
+func FileRef(path string) string {
+	return "file:" + path
+}
+
+// Resolve fetches the current value of reference.
+func Resolve(ctx context.Context, reference string) (string, error) {
+	p, ref, err := lookup(reference)
+	if err != nil {
+		return "", err
+	}
+	value, err := p.Fetch(ctx, ref)
+	if err != nil {
+		return "", fmt.Errorf("secret %s: %w", reference, err)
+	}
+	return value, nil
+}
+
+func lookup(reference string) (Provider, string, error) {
+	scheme, ref, ok := strings.Cut(reference, ":")
+	if !ok || ref == "" {
+		return nil, "", fmt.Errorf("secret reference %q: expected scheme:ref", reference)
+	}
+
+	mu.RLock()
+	p, ok := providers[scheme]
+	mu.RUnlock()
+	if !ok {
+		return nil, "", fmt.Errorf("secret reference %q: unknown provider %q", reference, scheme)
+	}
+	return p, ref, nil
+}
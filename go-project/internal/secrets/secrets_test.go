@@ -0,0 +1,54 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type static map[string]string
+
+func (s static) Fetch(_ context.Context, ref string) (string, error) {
+	value, ok := s[ref]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return value, nil
+}
+
+func TestResolve(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(path, []byte("from-file\r\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_SECRET", "from-env")
+	Register("test", static{"db": "from-test"})
+
+	for ref, want := range map[string]string{
+		FileRef(path):     "from-file",
+		"env:TEST_SECRET": "from-env",
+		"test:db":         "from-test",
+	} {
+		got, err := Resolve(context.Background(), ref)
+		if err != nil || got != want {
+			t.Errorf("Resolve(%s) = %q, %v; want %q", ref, got, err, want)
+		}
+	}
+}
+
+func TestResolveErrors(t *testing.T) {
+	ctx := context.Background()
+	if _, err := Resolve(ctx, FileRef(filepath.Join(t.TempDir(), "missing"))); !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing file: %v, want ErrNotFound", err)
+	}
+	if _, err := Resolve(ctx, "env:TEST_SECRET_UNSET"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("unset variable: %v, want ErrNotFound", err)
+	}
+	for _, ref := range []string{"no-scheme", "file:", "vault:db"} {
+		if _, err := Resolve(ctx, ref); err == nil {
+			t.Errorf("Resolve(%s) succeeded, want an error", ref)
+		}
+	}
+}
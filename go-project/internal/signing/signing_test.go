@@ -0,0 +1,123 @@
+package signing
+
+import (
+	"errors"
+	"testing"
+)
+
+func signed() Message {
+	return Message{
+		ContentType: "application/json",
+		TenantID:    "acme",
+		Attributes:  map[string]string{"cloudEvents:id": "evt-1", "cloudEvents:time": "2026-01-02T03:04:05Z"},
+		Body:        []byte(`{"user_id":1,"new_amount":10,"version":2}`),
+	}
+}
+
+func TestVerifyAcceptsGoodSignature(t *testing.T) {
+	v := NewVerifier(map[string]string{"k1": "secret"})
+	m := signed()
+	if err := v.Verify("k1", SignMessage([]byte("secret"), m), Version2, m); err != nil {
+		t.Fatalf("Verify = %v, want nil", err)
+	}
+
+	// Attribute order does not matter.
+	reordered := signed()
+	reordered.Attributes = map[string]string{"cloudEvents:time": "2026-01-02T03:04:05Z", "cloudEvents:id": "evt-1"}
+	if err := v.Verify("k1", SignMessage([]byte("secret"), m), Version2, reordered); err != nil {
+		t.Fatalf("Verify with reordered attributes = %v, want nil", err)
+	}
+}
+
+func TestVerifyRejectsTampering(t *testing.T) {
+	v := NewVerifier(map[string]string{"k1": "secret"})
+	signature := SignMessage([]byte("secret"), signed())
+
+	for name, tamper := range map[string]func(*Message){
+		"body":         func(m *Message) { m.Body = []byte(`{"user_id":1,"new_amount":1000,"version":2}`) },
+		"content type": func(m *Message) { m.ContentType = "application/x-protobuf" },
+		"tenant":       func(m *Message) { m.TenantID = "other" },
+		"no tenant":    func(m *Message) { m.TenantID = "" },
+		"event id":     func(m *Message) { m.Attributes["cloudEvents:id"] = "evt-2" },
+		"event time":   func(m *Message) { m.Attributes["cloudEvents:time"] = "2030-01-01T00:00:00Z" },
+		"added header": func(m *Message) { m.Attributes["cloudEvents_id"] = "evt-2" },
+		// Moving bytes between fields must not keep the signature valid.
+		"shifted field": func(m *Message) { m.ContentType, m.TenantID = "application/jsonacme", "" },
+	} {
+		m := signed()
+		tamper(&m)
+		if err := v.Verify("k1", signature, Version2, m); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("%s: Verify = %v, want ErrInvalidSignature", name, err)
+		}
+	}
+
+	if err := v.Verify("k1", "not hex", Version2, signed()); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("malformed signature: Verify = %v, want ErrInvalidSignature", err)
+	}
+}
+
+func TestVerifyRejectsUnknownKeys(t *testing.T) {
+	v := NewVerifier(map[string]string{"k1": "secret"})
+	m := signed()
+
+	if err := v.Verify("k2", SignMessage([]byte("secret"), m), Version2, m); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("unknown key ID: Verify = %v, want ErrUnknownKey", err)
+	}
+	if err := v.Verify("", "", "", m); !errors.Is(err, ErrUnsigned) {
+		t.Errorf("unsigned: Verify = %v, want ErrUnsigned", err)
+	}
+	if err := v.Verify("k1", SignMessage([]byte("secret"), m), "3", m); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("version 3: Verify = %v, want ErrUnsupportedVersion", err)
+	}
+}
+
+func TestVerifyDuringKeyRotation(t *testing.T) {
+	m := signed()
+	oldSignature := SignMessage([]byte("old-secret"), m)
+	newSignature := SignMessage([]byte("new-secret"), m)
+
+	// Consumers learn the new key before producers switch to it.
+	both := NewVerifier(map[string]string{"old": "old-secret", "new": "new-secret"})
+	if err := both.Verify("old", oldSignature, Version2, m); err != nil {
+		t.Errorf("old key while rotating: Verify = %v", err)
+	}
+	if err := both.Verify("new", newSignature, Version2, m); err != nil {
+		t.Errorf("new key while rotating: Verify = %v", err)
+	}
+	if err := both.Verify("new", oldSignature, Version2, m); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("old signature under the new key ID: Verify = %v, want ErrInvalidSignature", err)
+	}
+
+	// Once the old key is removed, its messages are refused.
+	rotated := NewVerifier(map[string]string{"new": "new-secret"})
+	if err := rotated.Verify("old", oldSignature, Version2, m); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("retired key: Verify = %v, want ErrUnknownKey", err)
+	}
+}
+
+func TestVerifyVersion1CoversOnlyPlainJSON(t *testing.T) {
+	v := NewVerifier(map[string]string{"k1": "secret"})
+	body := []byte(`{"user_id":1,"new_amount":10,"version":2}`)
+	signature := Sign([]byte("secret"), body)
+
+	for _, contentType := range []string{"", "application/json", "application/json; charset=utf-8"} {
+		if err := v.Verify("k1", signature, "", Message{ContentType: contentType, Body: body}); err != nil {
+			t.Errorf("content type %q: Verify = %v, want nil", contentType, err)
+		}
+	}
+
+	for name, m := range map[string]Message{
+		"tenant header":  {ContentType: "application/json", TenantID: "acme", Body: body},
+		"binary event":   {Attributes: map[string]string{"cloudEvents:id": "evt-1"}, Body: body},
+		"other codec":    {ContentType: "application/cloudevents+json", Body: body},
+		"bad media type": {ContentType: "application/json; =", Body: body},
+	} {
+		if err := v.Verify("k1", signature, Version1, m); !errors.Is(err, ErrUnsignedHeaders) {
+			t.Errorf("%s: Verify = %v, want ErrUnsignedHeaders", name, err)
+		}
+	}
+
+	if err := v.Verify("k1", signature, "", Message{Body: []byte(`{"user_id":2}`)}); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("tampered body: Verify = %v, want ErrInvalidSignature", err)
+	}
+}
@@ -0,0 +1,111 @@
+package statement
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// Formats a statement is written in.
+const (
+	FormatJSON = "json"
+	FormatCSV  = "csv"
+	FormatText = "text"
+)
+
+// ContentTypes maps each format to its HTTP content type.
+var ContentTypes = map[string]string{
+	FormatJSON: "application/json",
+	FormatCSV:  "text/csv; charset=utf-8",
+	FormatText: "text/plain; charset=utf-8",
+}
+
+// Write writes st in format, with times in loc.
+func Write(w io.Writer, st *Statement, format string, loc *time.Location) error {
+	switch format {
+	case FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(st)
+	case FormatCSV:
+		return writeCSV(w, st, loc)
+	case FormatText:
+		return writeText(w, st, loc)
+	default:
+		return fmt.Errorf("unsupported statement format %q", format)
+	}
+}
+
+// writeCSV writes one row per entry: the opening balance, each movement,
+// the closing balance and, if any, the unexplained difference.
+func writeCSV(w io.Writer, st *Statement, loc *time.Location) error {
+	out := csv.NewWriter(w)
+	amount := func(v float64) string { return strconv.FormatFloat(v, 'f', 2, 64) }
+	version := func(v uint) string { return strconv.FormatUint(uint64(v), 10) }
+
+	rows := [][]string{
+		{"entry", "date", "applied_at", "event_time", "event_id", "version", "change", "balance", "note"},
+		{"opening", st.Opening.Date, "", "", "", version(st.Opening.Version), "", amount(st.Opening.Amount), ""},
+	}
+	for _, m := range st.Movements {
+		rows = append(rows, []string{
+			"movement",
+			m.AppliedAt.In(loc).Format("2006-01-02"),
+			m.AppliedAt.In(loc).Format(time.RFC3339),
+			m.EventTime.In(loc).Format(time.RFC3339),
+			m.EventID,
+			version(m.Version),
+			amount(m.Change),
+			amount(m.Balance),
+			m.Note,
+		})
+	}
+	if st.Unexplained != 0 {
+		rows = append(rows, []string{"unexplained", st.Closing.Date, "", "", "", "", amount(st.Unexplained), "", ""})
+	}
+	closing := ""
+	if st.Closing.Live {
+		closing = "current balance"
+	}
+	rows = append(rows, []string{"closing", st.Closing.Date, "", "", "", version(st.Closing.Version), "", amount(st.Closing.Amount), closing})
+
+	if err := out.WriteAll(rows); err != nil {
+		return err
+	}
+	return out.Error()
+}
+
+func writeText(w io.Writer, st *Statement, loc *time.Location) error {
+	tenant := ""
+	if st.TenantID != "" {
+		tenant = ", tenant " + st.TenantID
+	}
+	fmt.Fprintf(w, "Statement of user %d%s, %s\n", st.UserID, tenant, st.Currency)
+	fmt.Fprintf(w, "%s to %s, days close at %s\n\n", st.From, st.To, st.Cutoff)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "Applied at\tEvent\tVersion\tChange\tBalance\tNote\t")
+	fmt.Fprintf(tw, "\tOpening balance of %s\t%d\t\t%.2f\t\t\n", st.Opening.Date, st.Opening.Version, st.Opening.Amount)
+	for _, m := range st.Movements {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%+.2f\t%.2f\t%s\t\n",
+			m.AppliedAt.In(loc).Format("2006-01-02 15:04:05"), m.EventID, m.Version, m.Change, m.Balance, m.Note)
+	}
+	if st.Unexplained != 0 {
+		fmt.Fprintf(tw, "\tUnexplained\t\t%+.2f\t\t\t\n", st.Unexplained)
+	}
+	closing := "Closing balance of " + st.Closing.Date
+	if st.Closing.Live {
+		closing = "Current balance"
+	}
+	fmt.Fprintf(tw, "\t%s\t%d\t\t%.2f\t\t\n", closing, st.Closing.Version, st.Closing.Amount)
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	_, err := fmt.Fprintf(w, "\n%d movements, credits %+.2f, debits %+.2f\n", len(st.Movements), st.Credits, st.Debits)
+	return err
+}
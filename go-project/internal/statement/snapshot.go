@@ -0,0 +1,259 @@
+// Package statement takes a snapshot of every balance when a day closes and
+// builds account statements from the snapshots and the balance events: the
+// opening balance, each movement and the closing balance of a period.
+package statement
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"balance-service/internal/config"
+	"balance-service/internal/database"
+	"balance-service/internal/metrics"
+	"balance-service/internal/model"
+	"balance-service/internal/repository"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// lateAfter is how long after the cutoff a snapshot counts as late: it then
+// includes the updates applied since, which statements attribute to the day
+// before.
+const lateAfter = time.Minute
+
+// A failed snapshot is retried after retryMin, doubling up to retryMax,
+// until it is taken or the next cutoff closes another day.
+const (
+	retryMin = 10 * time.Second
+	retryMax = 5 * time.Minute
+)
+
+var (
+	snapshotRuns     = metrics.NewCounter("balance_snapshot_runs_total", "Daily balance snapshot runs, by result.", "result")
+	snapshotBalances = metrics.NewGauge("balance_snapshot_balances", "Balances in the last snapshot taken.")
+)
+
+// Clock divides time into days that close at the cutoff, a time of day in
+// the configured time zone. Days are named by their date, the date of the
+// instant just before their cutoff.
+type Clock struct {
+	loc          *time.Location
+	hour, minute int
+}
+
+func NewClock(cfg config.SnapshotConfig) (Clock, error) {
+	cutoff, err := time.Parse("15:04", cfg.Cutoff)
+	if err != nil {
+		return Clock{}, fmt.Errorf("invalid snapshot cutoff %q: %w", cfg.Cutoff, err)
+	}
+	loc, err := time.LoadLocation(cfg.Timezone)
+	if err != nil {
+		return Clock{}, fmt.Errorf("invalid snapshot time zone %q: %w", cfg.Timezone, err)
+	}
+	return Clock{loc: loc, hour: cutoff.Hour(), minute: cutoff.Minute()}, nil
+}
+
+func (c Clock) String() string {
+	return fmt.Sprintf("%02d:%02d %s", c.hour, c.minute, c.loc)
+}
+
+func (c Clock) Location() *time.Location { return c.loc }
+
+// LastCutoff returns the latest cutoff at or before now.
+func (c Clock) LastCutoff(now time.Time) time.Time {
+	local := now.In(c.loc)
+	cutoff := time.Date(local.Year(), local.Month(), local.Day(), c.hour, c.minute, 0, 0, c.loc)
+	if cutoff.After(local) {
+		cutoff = time.Date(local.Year(), local.Month(), local.Day()-1, c.hour, c.minute, 0, 0, c.loc)
+	}
+	return cutoff
+}
+
+// NextCutoff returns the first cutoff after now.
+func (c Clock) NextCutoff(now time.Time) time.Time {
+	last := c.LastCutoff(now)
+	return time.Date(last.Year(), last.Month(), last.Day()+1, c.hour, c.minute, 0, 0, c.loc)
+}
+
+// Day returns the day the instant t belongs to.
+func (c Clock) Day(t time.Time) time.Time {
+	return c.closedBy(c.NextCutoff(t))
+}
+
+// closedBy returns the day that closes at cutoff, as midnight UTC of its
+// date, the way DATE columns read back.
+func (c Clock) closedBy(cutoff time.Time) time.Time {
+	local := cutoff.Add(-time.Nanosecond).In(c.loc)
+	return time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// Cutoff returns the instant the day closes at.
+func (c Clock) Cutoff(day time.Time) time.Time {
+	date := day.Day()
+	if c.hour == 0 && c.minute == 0 {
+		date++
+	}
+	return time.Date(day.Year(), day.Month(), date, c.hour, c.minute, 0, 0, c.loc)
+}
+
+// Result is the outcome of taking a snapshot.
+type Result struct {
+	Date     string    `json:"date"`
+	Cutoff   time.Time `json:"cutoff"`
+	TakenAt  time.Time `json:"taken_at"`
+	Balances int64     `json:"balances"`
+	Late     bool      `json:"late,omitempty"`
+	// Existing is set if the snapshot of the day was taken before.
+	Existing bool `json:"existing,omitempty"`
+}
+
+// Job takes the daily snapshots.
+type Job struct {
+	db                 *gorm.DB
+	clock              Clock
+	batchSize          int
+	retryMin, retryMax time.Duration
+	log                *logrus.Logger
+}
+
+func NewJob(db *gorm.DB, clock Clock, batchSize int, log *logrus.Logger) *Job {
+	return &Job{
+		db:        db,
+		clock:     clock,
+		batchSize: batchSize,
+		retryMin:  retryMin,
+		retryMax:  retryMax,
+		log:       log,
+	}
+}
+
+// Take takes the snapshot of the last day that closed before now, unless it
+// exists. Every balance is read in one transaction, so the snapshot is
+// consistent however long it takes; a failed run leaves no rows behind.
+func (j *Job) Take(ctx context.Context, now time.Time) (*Result, error) {
+	cutoff := j.clock.LastCutoff(now)
+	date := j.clock.closedBy(cutoff)
+	result := &Result{Date: date.Format("2006-01-02"), Cutoff: cutoff}
+
+	err := j.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		snapshots := repository.NewSnapshotRepository(tx, j.log)
+		taken, err := snapshots.GetSnapshotDay(ctx, date)
+		if err == nil {
+			result.TakenAt, result.Balances, result.Existing = taken.TakenAt, taken.Balances, true
+			return nil
+		}
+		if !errors.Is(err, repository.ErrNotFound) {
+			return fmt.Errorf("look up the snapshot: %w", err)
+		}
+
+		result.TakenAt = time.Now().UTC()
+		result.Late = result.TakenAt.Sub(cutoff) > lateAfter
+		balances := repository.NewBalanceRepository(tx, j.log)
+		var afterID uint
+		for {
+			page, err := balances.ListBalancesByID(ctx, afterID, j.batchSize)
+			if err != nil {
+				return fmt.Errorf("read balances after %d: %w", afterID, err)
+			}
+			rows := make([]model.BalanceSnapshot, len(page))
+			for i, b := range page {
+				rows[i] = model.BalanceSnapshot{
+					Date:     date,
+					UserID:   b.UserID,
+					TenantID: b.TenantID,
+					Currency: b.Currency,
+					Amount:   b.Amount,
+					Version:  b.Version,
+				}
+			}
+			n, err := snapshots.SaveSnapshots(ctx, rows)
+			if err != nil {
+				return fmt.Errorf("save snapshots: %w", err)
+			}
+			result.Balances += n
+			if len(page) < j.batchSize {
+				break
+			}
+			afterID = page[len(page)-1].ID
+		}
+		return snapshots.SaveSnapshotDay(ctx, &model.SnapshotDay{
+			Date:     date,
+			Cutoff:   cutoff.UTC(),
+			TakenAt:  result.TakenAt,
+			Balances: result.Balances,
+		})
+	}, database.SnapshotTx(j.db, false))
+	switch {
+	case err != nil:
+		snapshotRuns.Inc("failed")
+		return result, err
+	case result.Existing:
+		snapshotRuns.Inc("existing")
+	default:
+		snapshotRuns.Inc("ok")
+		snapshotBalances.Set(float64(result.Balances))
+	}
+	return result, nil
+}
+
+// Schedule takes the snapshot of the last closed day if it is missing, then
+// takes each one at its cutoff until ctx is cancelled. A failed snapshot is
+// retried with backoff while its day is the last closed one; once the next
+// cutoff passes, the balances no longer show that day and it is reported
+// as missed.
+func Schedule(ctx context.Context, job *Job, log *logrus.Logger) {
+	take := func() (*Result, bool) {
+		result, err := job.Take(ctx, time.Now())
+		entry := log.WithField("date", result.Date)
+		switch {
+		case err != nil:
+			if ctx.Err() == nil {
+				entry.WithError(err).Error("balance snapshot failed")
+			}
+			return result, false
+		case result.Existing:
+			entry.Debug("balance snapshot taken before")
+		case result.Late:
+			entry.WithFields(logrus.Fields{"balances": result.Balances, "cutoff": result.Cutoff}).
+				Warn("balance snapshot taken late, it includes the updates applied since the cutoff")
+		default:
+			entry.WithField("balances", result.Balances).Info("balance snapshot taken")
+		}
+		return result, true
+	}
+
+	var retry time.Duration
+	for {
+		result, ok := take()
+		next := job.clock.NextCutoff(time.Now())
+		wait := time.Until(next)
+		if ok {
+			retry = 0
+		} else {
+			if retry = 2 * retry; retry < job.retryMin {
+				retry = job.retryMin
+			} else if retry > job.retryMax {
+				retry = job.retryMax
+			}
+			if retry < wait {
+				wait = retry
+			} else {
+				retry = 0
+				if ctx.Err() == nil {
+					snapshotRuns.Inc("missed")
+					log.WithField("date", result.Date).Error("balance snapshot missed, the day closes without one")
+				}
+			}
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package statement
+
+import (
+	"testing"
+	"time"
+
+	"balance-service/internal/config"
+)
+
+func clock(t *testing.T, cutoff, zone string) Clock {
+	t.Helper()
+	c, err := NewClock(config.SnapshotConfig{Cutoff: cutoff, Timezone: zone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func date(s string) time.Time {
+	d, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+// TestClockAcrossDST walks the weeks around both DST changes of a year in
+// quarter hours and checks that every instant lies in exactly one day.
+func TestClockAcrossDST(t *testing.T) {
+	for _, zone := range []string{"Europe/Kyiv", "America/New_York", "Australia/Sydney"} {
+		for _, cutoff := range []string{"00:00", "02:30", "03:00", "23:00"} {
+			c := clock(t, cutoff, zone)
+			for _, start := range []time.Time{
+				time.Date(2026, 3, 5, 0, 0, 0, 0, time.UTC),
+				time.Date(2026, 9, 28, 0, 0, 0, 0, time.UTC),
+				time.Date(2026, 10, 22, 0, 0, 0, 0, time.UTC),
+			} {
+				prevDay := c.Day(start)
+				prevLast := c.LastCutoff(start)
+				for at := start; at.Before(start.AddDate(0, 0, 40)); at = at.Add(15 * time.Minute) {
+					last, next, day := c.LastCutoff(at), c.NextCutoff(at), c.Day(at)
+					if last.After(at) || !next.After(at) {
+						t.Fatalf("%s %s: %v lies outside [%v, %v)", cutoff, zone, at, last, next)
+					}
+					if length := next.Sub(last); length < 22*time.Hour || length > 26*time.Hour {
+						t.Fatalf("%s %s: day of %v lasts %v", cutoff, zone, at, length)
+					}
+					if !c.Cutoff(day).Equal(next) {
+						t.Fatalf("%s %s: day %s of %v closes at %v, want %v", cutoff, zone, day.Format("2006-01-02"), at, c.Cutoff(day), next)
+					}
+					if !c.closedBy(last).Equal(day.AddDate(0, 0, -1)) {
+						t.Fatalf("%s %s: cutoff %v closes %v, want the day before %v", cutoff, zone, last, c.closedBy(last), day)
+					}
+					switch {
+					case last.Equal(prevLast) && !day.Equal(prevDay):
+						t.Fatalf("%s %s: day changed from %v to %v at %v without a cutoff", cutoff, zone, prevDay, day, at)
+					case !last.Equal(prevLast) && !day.Equal(prevDay.AddDate(0, 0, 1)):
+						t.Fatalf("%s %s: day after %v is %v at %v", cutoff, zone, prevDay, day, at)
+					}
+					prevDay, prevLast = day, last
+				}
+			}
+		}
+	}
+}
+
+func TestClockDaysOfDSTChanges(t *testing.T) {
+	kyiv := clock(t, "00:00", "Europe/Kyiv")
+	for _, c := range []struct {
+		day    string
+		cutoff string // UTC
+		hours  float64
+	}{
+		{"2026-03-28", "2026-03-28T22:00:00Z", 24},
+		{"2026-03-29", "2026-03-29T21:00:00Z", 23}, // clocks go forward
+		{"2026-10-24", "2026-10-24T21:00:00Z", 24},
+		{"2026-10-25", "2026-10-25T22:00:00Z", 25}, // and back
+	} {
+		cutoff := kyiv.Cutoff(date(c.day))
+		if got := cutoff.UTC().Format(time.RFC3339); got != c.cutoff {
+			t.Errorf("%s closes at %s, want %s", c.day, got, c.cutoff)
+		}
+		if hours := cutoff.Sub(kyiv.Cutoff(date(c.day).AddDate(0, 0, -1))).Hours(); hours != c.hours {
+			t.Errorf("%s lasts %vh, want %vh", c.day, hours, c.hours)
+		}
+		if day := kyiv.Day(cutoff.Add(-time.Second)); !day.Equal(date(c.day)) {
+			t.Errorf("the second before %s closes is in %s", c.day, day.Format("2006-01-02"))
+		}
+		if day := kyiv.Day(cutoff); !day.Equal(date(c.day).AddDate(0, 0, 1)) {
+			t.Errorf("the cutoff of %s is in %s, want the next day", c.day, day.Format("2006-01-02"))
+		}
+	}
+
+	// A cutoff that does not exist on the day clocks go forward closes the
+	// day at the instant it would be in summer time, 01:30 on the clock.
+	newYork := clock(t, "02:30", "America/New_York")
+	if got := newYork.Cutoff(date("2026-03-08")).UTC().Format(time.RFC3339); got != "2026-03-08T06:30:00Z" {
+		t.Errorf("2026-03-08 in New York closes at %s, want 01:30 EST", got)
+	}
+	if got := newYork.LastCutoff(time.Date(2026, 3, 8, 12, 0, 0, 0, time.UTC)).UTC().Format(time.RFC3339); got != "2026-03-08T06:30:00Z" {
+		t.Errorf("last cutoff on 2026-03-08 at %s, want 01:30 EST", got)
+	}
+}
@@ -0,0 +1,280 @@
+package statement
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"balance-service/internal/model"
+	"balance-service/internal/processor"
+	"balance-service/internal/repository"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrNoSnapshot means a day the statement starts or ends with has no
+	// snapshot: it closed before snapshots were enabled or while the
+	// service was down.
+	ErrNoSnapshot = errors.New("no balance snapshot")
+	// ErrPeriod means the period is empty or has not started.
+	ErrPeriod = errors.New("invalid statement period")
+)
+
+// Statement is the account statement of a wallet over the days From to To.
+type Statement struct {
+	UserID    uint       `json:"user_id"`
+	TenantID  string     `json:"tenant_id,omitempty"`
+	Currency  string     `json:"currency"`
+	From      string     `json:"from"`
+	To        string     `json:"to"`
+	Cutoff    string     `json:"cutoff"` // when days close
+	Opening   Balance    `json:"opening"`
+	Movements []Movement `json:"movements"`
+	Closing   Balance    `json:"closing"`
+	Credits   float64    `json:"credits"`
+	Debits    float64    `json:"debits"`
+	// Unexplained is the part of the change from opening to closing no
+	// event accounts for, such as updates without an event ID or events
+	// deleted by retention and not restored.
+	Unexplained float64 `json:"unexplained"`
+}
+
+// Balance is the balance a statement opens or closes with.
+type Balance struct {
+	Date    string  `json:"date"` // the day whose snapshot it is
+	Amount  float64 `json:"amount"`
+	Version uint    `json:"version"`
+	// Live is set when the period has not closed yet and the closing
+	// balance is the current one.
+	Live bool `json:"live,omitempty"`
+}
+
+// Movement is one applied balance event.
+type Movement struct {
+	AppliedAt time.Time `json:"applied_at"`
+	EventTime time.Time `json:"event_time"`
+	EventID   string    `json:"event_id,omitempty"`
+	Version   uint      `json:"version"`
+	Change    float64   `json:"change"`
+	Balance   float64   `json:"balance"`
+	Note      string    `json:"note,omitempty"`
+}
+
+// Builder builds statements.
+type Builder struct {
+	snapshots *repository.SnapshotRepository
+	balances  *repository.BalanceRepository
+	events    *repository.EventRepository
+	clock     Clock
+}
+
+func NewBuilder(db *gorm.DB, clock Clock, log *logrus.Logger) *Builder {
+	return &Builder{
+		snapshots: repository.NewSnapshotRepository(db, log),
+		balances:  repository.NewBalanceRepository(db, log),
+		events:    repository.NewEventRepository(db, log),
+		clock:     clock,
+	}
+}
+
+// Clock returns the days the builder works with.
+func (b *Builder) Clock() Clock { return b.clock }
+
+// Build builds the statement of a wallet from the day from to the day to,
+// both dates at midnight UTC. It opens with the snapshot of the day before
+// from and closes with the snapshot of to, or with the current balance if
+// to has not closed yet. Movements are the events applied in between,
+// matched by version rather than by time, so an update applied just around
+// a cutoff is never counted twice or missed.
+func (b *Builder) Build(ctx context.Context, wallet model.WalletKey, from, to time.Time) (*Statement, error) {
+	today := b.clock.Day(time.Now())
+	if to.Before(from) || from.After(today) {
+		return nil, fmt.Errorf("%w: %s to %s", ErrPeriod, from.Format("2006-01-02"), to.Format("2006-01-02"))
+	}
+
+	st := &Statement{
+		UserID:    wallet.UserID,
+		TenantID:  wallet.TenantID,
+		Currency:  wallet.Currency,
+		From:      from.Format("2006-01-02"),
+		To:        to.Format("2006-01-02"),
+		Cutoff:    b.clock.String(),
+		Movements: []Movement{},
+	}
+
+	opening, err := b.snapshot(ctx, wallet, from.AddDate(0, 0, -1))
+	if err != nil {
+		return nil, err
+	}
+	st.Opening = *opening
+
+	if to.Before(today) {
+		closing, err := b.snapshot(ctx, wallet, to)
+		if err != nil {
+			return nil, err
+		}
+		st.Closing = *closing
+	} else {
+		st.Closing = Balance{Date: today.Format("2006-01-02"), Live: true}
+		current, err := b.balances.GetBalance(ctx, wallet)
+		switch {
+		case err == nil:
+			st.Closing.Amount, st.Closing.Version = current.Amount, current.Version
+		case !errors.Is(err, repository.ErrNotFound):
+			return nil, fmt.Errorf("read the balance: %w", err)
+		}
+	}
+
+	if err := b.movements(ctx, wallet, st); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// snapshot returns the balance of a wallet in the snapshot of day. A wallet
+// missing from a snapshot that was taken did not exist yet.
+func (b *Builder) snapshot(ctx context.Context, wallet model.WalletKey, day time.Time) (*Balance, error) {
+	balance := &Balance{Date: day.Format("2006-01-02")}
+	snapshot, err := b.snapshots.GetSnapshot(ctx, wallet, day)
+	if err == nil {
+		balance.Amount, balance.Version = snapshot.Amount, snapshot.Version
+		return balance, nil
+	}
+	if !errors.Is(err, repository.ErrNotFound) {
+		return nil, fmt.Errorf("read the snapshot of %s: %w", balance.Date, err)
+	}
+
+	taken, err := b.snapshots.HasSnapshot(ctx, day)
+	if err != nil {
+		return nil, fmt.Errorf("look up the snapshot of %s: %w", balance.Date, err)
+	}
+	if !taken {
+		return nil, fmt.Errorf("%w of %s", ErrNoSnapshot, balance.Date)
+	}
+	return balance, nil
+}
+
+// movements adds the applied events between the opening and the closing
+// version, including restored ones, with the change each one made.
+func (b *Builder) movements(ctx context.Context, wallet model.WalletKey, st *Statement) error {
+	if st.Closing.Version <= st.Opening.Version {
+		st.Unexplained = round(st.Closing.Amount - st.Opening.Amount)
+		return nil
+	}
+
+	events, err := b.events.ListWalletEvents(ctx, wallet, st.Opening.Version, st.Closing.Version)
+	if err != nil {
+		return fmt.Errorf("read balance events: %w", err)
+	}
+	restored, err := b.events.ListRestoredWalletEvents(ctx, wallet, st.Opening.Version, st.Closing.Version)
+	if err != nil {
+		return fmt.Errorf("read restored balance events: %w", err)
+	}
+	for _, e := range restored {
+		events = append(events, model.BalanceEvent{
+			ID:        e.ID,
+			CreatedAt: e.CreatedAt,
+			Amount:    e.Amount,
+			Version:   e.Version,
+			UpdatedAt: e.UpdatedAt,
+			EventID:   e.EventID,
+			Metadata:  e.Metadata,
+		})
+	}
+	sort.SliceStable(events, func(i, k int) bool {
+		if events[i].Version != events[k].Version {
+			return events[i].Version < events[k].Version
+		}
+		return events[i].ID < events[k].ID
+	})
+
+	amount, version := st.Opening.Amount, st.Opening.Version
+	for _, e := range events {
+		note, ok := applied(e)
+		if !ok || e.Version <= version {
+			continue
+		}
+		change := round(e.Amount - amount)
+		amount, version = e.Amount, e.Version
+		st.Movements = append(st.Movements, Movement{
+			AppliedAt: e.CreatedAt,
+			EventTime: e.UpdatedAt,
+			EventID:   e.EventID,
+			Version:   e.Version,
+			Change:    change,
+			Balance:   e.Amount,
+			Note:      note,
+		})
+		if change > 0 {
+			st.Credits = round(st.Credits + change)
+		} else {
+			st.Debits = round(st.Debits + change)
+		}
+	}
+	st.Unexplained = round(st.Closing.Amount - amount)
+	return nil
+}
+
+// applied reports whether the event changed the balance, and notes how.
+func applied(e model.BalanceEvent) (string, bool) {
+	meta := e.Metadata
+	switch meta.Anomaly {
+	case model.AnomalyStale, model.AnomalyDuplicate:
+		return "", false
+	}
+	switch meta.Policy {
+	case processor.PolicyHold, processor.PolicyReject:
+		if meta.Review != model.PendingApproved {
+			return "", false
+		}
+		return "approved", true
+	case processor.PolicyClamp:
+		return "clamped", true
+	}
+	switch {
+	case meta.Source != "":
+		return meta.Source, true
+	case meta.Anomaly == model.AnomalyGap:
+		return fmt.Sprintf("%d versions missing before", meta.MissingVersions), true
+	}
+	return "", true
+}
+
+// round rounds to cents, the precision of the amount columns.
+func round(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+// ParsePeriod reads a period given as a month, YYYY-MM, or as the dates
+// from and to, YYYY-MM-DD; to defaults to from.
+func ParsePeriod(month, from, to string) (time.Time, time.Time, error) {
+	if month != "" {
+		if from != "" || to != "" {
+			return time.Time{}, time.Time{}, fmt.Errorf("%w: give a month or dates, not both", ErrPeriod)
+		}
+		first, err := time.Parse("2006-01", month)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("%w: month %q is not like 2026-01", ErrPeriod, month)
+		}
+		return first, first.AddDate(0, 1, -1), nil
+	}
+	if from == "" {
+		return time.Time{}, time.Time{}, fmt.Errorf("%w: a month or a from date is required", ErrPeriod)
+	}
+	if to == "" {
+		to = from
+	}
+	first, err := time.Parse("2006-01-02", from)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("%w: from %q is not a date like 2026-01-31", ErrPeriod, from)
+	}
+	last, err := time.Parse("2006-01-02", to)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("%w: to %q is not a date like 2026-01-31", ErrPeriod, to)
+	}
+	return first, last, nil
+}
@@ -0,0 +1,243 @@
+package statement
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"balance-service/internal/database"
+	"balance-service/internal/model"
+	"balance-service/internal/processor"
+	"balance-service/internal/repository"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+var wallet = model.WalletKey{UserID: 1, Currency: "USD"}
+
+func setBalance(t *testing.T, db *gorm.DB, log *logrus.Logger, balances ...model.Balance) {
+	t.Helper()
+	if err := repository.NewBalanceRepository(db, log).SaveBalancesBatch(context.Background(), balances); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// kyivTime is a wall-clock time in Kyiv, whose clocks went forward on
+// 2025-03-30.
+func kyivTime(t *testing.T, value string) time.Time {
+	t.Helper()
+	loc, err := time.LoadLocation("Europe/Kyiv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at, err := time.ParseInLocation("2006-01-02 15:04", value, loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return at
+}
+
+func TestTakeTwiceKeepsTheFirstSnapshot(t *testing.T) {
+	db, log := database.OpenTest(t)
+	ctx := context.Background()
+	job := NewJob(db, clock(t, "00:00", "Europe/Kyiv"), 1, log)
+	setBalance(t, db, log,
+		model.Balance{UserID: 1, Currency: "USD", Amount: 100, Version: 1},
+		model.Balance{UserID: 2, Currency: "USD", Amount: 5, Version: 1},
+	)
+
+	first, err := job.Take(ctx, kyivTime(t, "2025-03-30 12:00"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Existing || first.Balances != 2 || first.Date != "2025-03-29" ||
+		!first.Cutoff.Equal(time.Date(2025, 3, 29, 22, 0, 0, 0, time.UTC)) {
+		t.Errorf("first snapshot %+v, want 2 balances of 2025-03-29, cut off at 22:00 UTC", first)
+	}
+
+	// A restart later the same day finds the snapshot taken.
+	setBalance(t, db, log, model.Balance{UserID: 1, Currency: "USD", Amount: 999, Version: 9})
+	second, err := job.Take(ctx, kyivTime(t, "2025-03-30 23:59"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !second.Existing || second.Balances != 2 || !second.TakenAt.Equal(first.TakenAt) || second.Date != first.Date {
+		t.Errorf("second snapshot %+v, want the first one, %+v", second, first)
+	}
+
+	var rows int64
+	if err := db.Model(&model.BalanceSnapshot{}).Count(&rows).Error; err != nil {
+		t.Fatal(err)
+	}
+	if rows != 2 {
+		t.Errorf("%d snapshot rows, want 2", rows)
+	}
+	snapshot, err := repository.NewSnapshotRepository(db, log).GetSnapshot(ctx, wallet, date("2025-03-29"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.Amount != 100 || snapshot.Version != 1 {
+		t.Errorf("snapshot of user 1 at %v v%d, want 100 v1", snapshot.Amount, snapshot.Version)
+	}
+}
+
+func TestBuildReconcilesOpeningMovementsAndClosing(t *testing.T) {
+	db, log := database.OpenTest(t)
+	ctx := context.Background()
+	c := clock(t, "00:00", "Europe/Kyiv")
+	job := NewJob(db, c, 100, log)
+
+	setBalance(t, db, log, model.Balance{UserID: 1, Currency: "USD", Amount: 100, Version: 1})
+	if _, err := job.Take(ctx, kyivTime(t, "2025-03-29 00:01")); err != nil {
+		t.Fatal(err)
+	}
+
+	amount := func(v float64) *float64 { return &v }
+	applied := kyivTime(t, "2025-03-30 10:00")
+	var events []model.BalanceEvent
+	for _, e := range []struct {
+		amount  float64
+		version uint
+		meta    model.EventMetadata
+	}{
+		{130, 2, model.EventMetadata{}},
+		{130, 2, model.EventMetadata{Anomaly: model.AnomalyDuplicate, AppliedVersion: 2}},
+		{-50, 3, model.EventMetadata{Policy: processor.PolicyHold, Review: model.PendingOpen}},
+		{0, 4, model.EventMetadata{Policy: processor.PolicyClamp, OriginalAmount: amount(-70)}},
+		{-20, 5, model.EventMetadata{Policy: processor.PolicyReject, Review: model.PendingRejected}},
+		{60, 7, model.EventMetadata{Anomaly: model.AnomalyGap, MissingVersions: 2}},
+		{50, 6, model.EventMetadata{Anomaly: model.AnomalyStale, AppliedVersion: 7}},
+		{45, 8, model.EventMetadata{Policy: processor.PolicyHold, Review: model.PendingApproved}},
+	} {
+		events = append(events, model.BalanceEvent{
+			CreatedAt: applied, UpdatedAt: applied, UserID: 1, Currency: "USD", Amount: e.amount, Version: e.version, Metadata: e.meta,
+		})
+		applied = applied.Add(time.Hour)
+	}
+	// Another wallet's events are not listed.
+	events = append(events, model.BalanceEvent{CreatedAt: applied, UpdatedAt: applied, UserID: 2, Currency: "USD", Amount: 1000, Version: 3})
+	if err := db.Create(&events).Error; err != nil {
+		t.Fatal(err)
+	}
+	setBalance(t, db, log, model.Balance{UserID: 1, Currency: "USD", Amount: 45, Version: 8})
+	if _, err := job.Take(ctx, kyivTime(t, "2025-03-31 00:01")); err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := NewBuilder(db, c, log).Build(ctx, wallet, date("2025-03-29"), date("2025-03-30"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Opening.Date != "2025-03-28" || st.Opening.Amount != 100 || st.Opening.Version != 1 {
+		t.Errorf("opens with %+v, want 100 v1 of 2025-03-28", st.Opening)
+	}
+	if st.Closing.Date != "2025-03-30" || st.Closing.Amount != 45 || st.Closing.Version != 8 || st.Closing.Live {
+		t.Errorf("closes with %+v, want 45 v8 of 2025-03-30", st.Closing)
+	}
+
+	want := []struct {
+		version uint
+		change  float64
+		note    string
+	}{
+		{2, 30, ""},
+		{4, -130, "clamped"},
+		{7, 60, "2 versions missing before"},
+		{8, -15, "approved"},
+	}
+	if len(st.Movements) != len(want) {
+		t.Fatalf("movements %+v, want %d", st.Movements, len(want))
+	}
+	total := st.Opening.Amount
+	for i, m := range st.Movements {
+		if m.Version != want[i].version || m.Change != want[i].change || m.Note != want[i].note {
+			t.Errorf("movement %d is %+v, want %+v", i, m, want[i])
+		}
+		total = round(total + m.Change)
+		if m.Balance != total {
+			t.Errorf("movement %d leaves %v, want %v", i, m.Balance, total)
+		}
+	}
+	if total != st.Closing.Amount || st.Unexplained != 0 {
+		t.Errorf("opening and movements add up to %v, closing is %v, %v unexplained", total, st.Closing.Amount, st.Unexplained)
+	}
+	if st.Credits != 90 || st.Debits != -145 || round(st.Credits+st.Debits) != st.Closing.Amount-st.Opening.Amount {
+		t.Errorf("credits %v and debits %v, want 90 and -145", st.Credits, st.Debits)
+	}
+
+	// Until the period closes, the statement closes with the live balance.
+	live, err := NewBuilder(db, c, log).Build(ctx, wallet, date("2025-03-29"), c.Day(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !live.Closing.Live || live.Closing.Amount != 45 || len(live.Movements) != len(want) || live.Unexplained != 0 {
+		t.Errorf("live statement closes with %+v after %d movements", live.Closing, len(live.Movements))
+	}
+}
+
+func TestBuildWithoutSnapshot(t *testing.T) {
+	db, log := database.OpenTest(t)
+	_, err := NewBuilder(db, clock(t, "00:00", "UTC"), log).Build(context.Background(), wallet, date("2025-03-29"), date("2025-03-30"))
+	if err == nil || !errors.Is(err, ErrNoSnapshot) {
+		t.Errorf("Build without snapshots = %v, want ErrNoSnapshot", err)
+	}
+}
+
+func TestScheduleRetriesAFailedSnapshot(t *testing.T) {
+	db, log := database.OpenTest(t)
+	setBalance(t, db, log, model.Balance{UserID: 1, Currency: "USD", Amount: 100, Version: 1})
+	// The database refuses snapshots while outage has a row.
+	for _, stmt := range []string{
+		"CREATE TABLE outage (id INTEGER)",
+		"INSERT INTO outage VALUES (1)",
+		`CREATE TRIGGER refuse_snapshots BEFORE INSERT ON balance_snapshot_days WHEN (SELECT COUNT(*) FROM outage) > 0
+		BEGIN SELECT RAISE(ABORT, 'database unavailable'); END`,
+	} {
+		if err := db.Exec(stmt).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Half a day from the cutoff, the test stays within one day.
+	job := NewJob(db, clock(t, time.Now().UTC().Add(12*time.Hour).Format("15:04"), "UTC"), 100, log)
+	job.retryMin, job.retryMax = time.Millisecond, 20*time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		Schedule(ctx, job, log)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	snapshots := repository.NewSnapshotRepository(db, log)
+	day := job.clock.Day(time.Now()).AddDate(0, 0, -1)
+	time.Sleep(50 * time.Millisecond)
+	if _, err := snapshots.GetSnapshotDay(ctx, day); !errors.Is(err, repository.ErrNotFound) {
+		t.Fatalf("snapshot during the outage: %v", err)
+	}
+	if err := db.Exec("DELETE FROM outage").Error; err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		taken, err := snapshots.GetSnapshotDay(ctx, day)
+		if err == nil {
+			if taken.Balances != 1 {
+				t.Errorf("snapshot of %d balances, want 1", taken.Balances)
+			}
+			return
+		}
+		if !errors.Is(err, repository.ErrNotFound) {
+			t.Fatal(err)
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the snapshot was not retried after the outage")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -2,10 +2,12 @@ package sync
 
 import (
 	"context"
+	"fmt"
 	"sync"
 	"time"
 
 	"balance-service/internal/repository"
+	"balance-service/internal/tuning"
 	"github.com/sirupsen/logrus"
 )
 
@@ -19,9 +21,11 @@ func SyncCache(
 	balanceRepo *repository.BalanceRepository,
 	cache *sync.Map,
 	batchSize int,
-	interval time.Duration,
+	settings *tuning.Store,
 	log *logrus.Logger,
 ) {
+	changes := settings.Subscribe()
+	interval := settings.Get().SyncInterval
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
@@ -35,6 +39,12 @@ func SyncCache(
 			return
 		case <-ticker.C:
 			runSync(ctx, balanceRepo, cache, batchSize, log)
+		case <-changes:
+			if next := settings.Get().SyncInterval; next != interval {
+				interval = next
+				ticker.Reset(interval)
+				log.WithField("interval", interval).Info("cache sync interval changed")
+			}
 		}
 	}
 }
@@ -46,25 +56,50 @@ func runSync(
 	batchSize int,
 	log *logrus.Logger,
 ) {
+	_, err := RunOnce(ctx, balanceRepo, cache, batchSize, log)
+	switch {
+	case err == nil:
+	case ctx.Err() != nil:
+		log.Info("cache sync cancelled")
+	default:
+		log.WithError(err).Error("cache synchronization failed")
+	}
+}
+
+// Result summarises one synchronization pass
+type Result struct {
+	Total    int64 // balances in the database when the pass started
+	Synced   int64 // balances copied into the cache
+	Duration time.Duration
+}
+
+// RunOnce copies every balance from the database into cache in batches
+func RunOnce(
+	ctx context.Context,
+	balanceRepo *repository.BalanceRepository,
+	cache *sync.Map,
+	batchSize int,
+	log *logrus.Logger,
+) (Result, error) {
 	ctx, cancel := context.WithTimeout(ctx, syncTimeout)
 	defer cancel()
 
 	startTime := time.Now()
+	var result Result
 
 	total, err := balanceRepo.CountBalances(ctx)
 	if err != nil {
-		log.WithError(err).Error("failed to count balances for cache sync")
-		return
+		return result, fmt.Errorf("failed to count balances for cache sync: %w", err)
 	}
+	result.Total = total
 
 	if total == 0 {
 		log.Debug("no balances to sync")
-		return
+		return result, nil
 	}
 
 	log.WithField("total", total).Info("starting cache synchronization")
 
-	var synced int64
 	offset := 0
 	errors := 0
 	maxErrors := 3
@@ -73,8 +108,7 @@ func runSync(
 		// Check context cancellation before each batch
 		select {
 		case <-ctx.Done():
-			log.Info("cache sync cancelled")
-			return
+			return result, fmt.Errorf("cache sync cancelled: %w", ctx.Err())
 		default:
 		}
 
@@ -82,21 +116,20 @@ func runSync(
 		if err != nil {
 			errors++
 			log.WithFields(logrus.Fields{
-				"error":   err,
-				"offset":  offset,
-				"errors":  errors,
+				"error":  err,
+				"offset": offset,
+				"errors": errors,
 			}).Error("failed to fetch balances batch")
 
 			if errors >= maxErrors {
-				log.Error("max errors reached, stopping cache sync")
-				break
+				return result, fmt.Errorf("max errors reached, stopping cache sync: %w", err)
 			}
 
 			// Wait a bit before retrying
 			select {
 			case <-time.After(time.Second):
 			case <-ctx.Done():
-				return
+				return result, fmt.Errorf("cache sync cancelled: %w", ctx.Err())
 			}
 			continue
 		}
@@ -107,8 +140,8 @@ func runSync(
 
 		// Update cache safely
 		for _, b := range balances {
-			cache.Store(b.UserID, b.Amount)
-			synced++
+			cache.Store(b.Key(), b.Amount)
+			result.Synced++
 		}
 
 		offset += len(balances)
@@ -120,11 +153,13 @@ func runSync(
 		}
 	}
 
-	duration := time.Since(startTime)
+	result.Duration = time.Since(startTime)
 	log.WithFields(logrus.Fields{
-		"synced":   synced,
-		"total":    total,
-		"duration": duration,
-		"rate":     float64(synced) / duration.Seconds(),
+		"synced":   result.Synced,
+		"total":    result.Total,
+		"duration": result.Duration,
+		"rate":     float64(result.Synced) / result.Duration.Seconds(),
 	}).Info("cache synchronization completed")
+
+	return result, nil
 }
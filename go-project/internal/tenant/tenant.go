@@ -0,0 +1,327 @@
+// Package tenant resolves which tenant a message belongs to and enforces the
+// limits of each tenant. Wallets of different tenants never share a key, so
+// the same user ID in two tenants holds two sets of balances.
+package tenant
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"balance-service/internal/config"
+	"balance-service/internal/metrics"
+	"balance-service/internal/model"
+	"balance-service/internal/processor"
+	"github.com/sirupsen/logrus"
+)
+
+// Header is the AMQP header naming the tenant of a message.
+const Header = "x-tenant-id"
+
+// Reasons a message is dead-lettered for, besides the schema ones.
+const (
+	ReasonUnknownTenant  = "unknown_tenant"
+	ReasonUserLimit      = "tenant_user_limit"
+	ReasonUnsignedTenant = "unsigned_tenant"
+)
+
+var (
+	messagesTotal  = metrics.NewCounter("balance_tenant_messages_total", "Messages admitted per tenant.", "tenant")
+	rejectedTotal  = metrics.NewCounter("balance_tenant_rejected_total", "Messages dead-lettered by tenant checks, by reason.", "tenant", "reason")
+	throttledTotal = metrics.NewCounter("balance_tenant_throttled_total", "Messages delayed by the tenant's message rate limit.", "tenant")
+	usersGauge     = metrics.NewGauge("balance_tenant_users", "Users with a balance per tenant, as counted by the user limit.", "tenant")
+	changesTotal   = metrics.NewCounter("balance_tenant_balance_changes_total", "Committed balance changes per tenant.", "tenant")
+)
+
+// UserCounter counts the users holding a wallet in a tenant.
+type UserCounter interface {
+	CountTenantUsers(ctx context.Context, tenantID string) (int64, error)
+	HasTenantUser(ctx context.Context, tenantID string, userID uint) (bool, error)
+}
+
+// Registry holds the configured tenants. A nil Registry knows only the
+// default tenant.
+type Registry struct {
+	tenants map[string]*state
+	queues  map[string]string // queue name to tenant ID
+	users   UserCounter
+	log     *logrus.Logger
+}
+
+// state is the limit bookkeeping of one tenant.
+type state struct {
+	cfg config.TenantConfig
+
+	// rate limit: a token bucket holding up to one second of messages
+	rateMu sync.Mutex
+	tokens float64
+	last   time.Time
+
+	// user limit: the users with a committed wallet, counted from the
+	// database on first use and again when the limit is reached, and the new
+	// users admitted since whose first balance is not committed yet
+	usersMu  sync.Mutex
+	counted  time.Time
+	count    int64
+	known    map[uint]bool
+	admitted map[uint]time.Time
+}
+
+// admitTTL is how long a new user admitted under the user limit holds its
+// place without a committed balance. Messages of it that are dead-lettered,
+// rejected or held for review never commit one, so the place is freed.
+const admitTTL = time.Minute
+
+// New builds the registry of the configured tenants. users may be nil when
+// no limits are enforced, as in the replay command.
+func New(tenants []config.TenantConfig, users UserCounter, log *logrus.Logger) *Registry {
+	r := &Registry{
+		tenants: make(map[string]*state, len(tenants)),
+		queues:  make(map[string]string),
+		users:   users,
+		log:     log,
+	}
+	for _, t := range tenants {
+		r.tenants[t.ID] = &state{cfg: t, tokens: burst(t.MaxMessagesPerSecond),
+			known: make(map[uint]bool), admitted: make(map[uint]time.Time)}
+		if t.Queue != "" {
+			r.queues[t.Queue] = t.ID
+		}
+	}
+	return r
+}
+
+// Queues returns the tenant queues by tenant ID.
+func (r *Registry) Queues() map[string]string {
+	queues := make(map[string]string)
+	if r == nil {
+		return queues
+	}
+	for queue, id := range r.queues {
+		queues[id] = queue
+	}
+	return queues
+}
+
+// Origin is where a message came from, as far as its tenant is concerned.
+// Its fields may be empty.
+type Origin struct {
+	// Header is the x-tenant-id header of the message.
+	Header string
+	// HeaderSigned reports that a verified signature covers Header.
+	HeaderSigned bool
+	// RequireSigned is set when signatures are checked. Anyone able to
+	// publish could then still pick a tenant through an unsigned header, so
+	// such a header is refused.
+	RequireSigned bool
+	// Queue is the tenant of the queue the message arrived on.
+	Queue string
+}
+
+// Resolve settles the tenant of msg from its tenant_id field, the
+// x-tenant-id header and the tenant of the queue it arrived on. The ones
+// that are set must agree and name a configured tenant; without any the
+// message belongs to the default tenant.
+func (r *Registry) Resolve(msg *processor.BalanceMessage, origin Origin) error {
+	if origin.Header != "" && origin.RequireSigned && !origin.HeaderSigned {
+		return processor.Invalid(ReasonUnsignedTenant, "tenant_id", "the %s header is not covered by the signature", Header)
+	}
+
+	tenant := msg.TenantID
+	for _, source := range []struct{ name, value string }{{Header + " header", origin.Header}, {"queue", origin.Queue}} {
+		if source.value == "" {
+			continue
+		}
+		if tenant != "" && tenant != source.value {
+			return processor.Invalid(processor.ReasonConflict, "tenant_id", "%q does not match %q from the %s", tenant, source.value, source.name)
+		}
+		tenant = source.value
+	}
+	if tenant == "" {
+		return nil
+	}
+
+	if !model.ValidTenantID(tenant) {
+		return processor.Invalid(processor.ReasonInvalid, "tenant_id", "%q is not a valid tenant ID", tenant)
+	}
+	if r.lookup(tenant) == nil {
+		// Not counted per tenant: the label would be whatever producers send.
+		return processor.Invalid(ReasonUnknownTenant, "tenant_id", "%q is not a configured tenant", tenant)
+	}
+	msg.TenantID = tenant
+	return nil
+}
+
+// Admit applies the limits of the message's tenant: it waits while the
+// tenant is over its message rate, and refuses the first message of a user
+// beyond the tenant's user limit with a *processor.ValidationError. Other
+// errors are the context's or the database's.
+func (r *Registry) Admit(ctx context.Context, msg processor.BalanceMessage) error {
+	label := Label(msg.TenantID)
+	t := r.lookup(msg.TenantID)
+	if t == nil {
+		messagesTotal.Inc(label)
+		return nil
+	}
+
+	if wait := t.reserve(time.Now()); wait > 0 {
+		throttledTotal.Inc(label)
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+
+	if t.cfg.MaxUsers > 0 && r.users != nil {
+		if err := r.admitUser(ctx, t, msg.UserID); err != nil {
+			return err
+		}
+	}
+	messagesTotal.Inc(label)
+	return nil
+}
+
+// admitUser lets the user in if it already has a wallet in the tenant or the
+// tenant is below its user limit. A new user counts against the limit once
+// its first balance is committed, and until then for admitTTL.
+func (r *Registry) admitUser(ctx context.Context, t *state, userID uint) error {
+	t.usersMu.Lock()
+	defer t.usersMu.Unlock()
+
+	id := t.cfg.ID
+	now := time.Now()
+	if t.counted.IsZero() {
+		if err := r.countUsers(ctx, t, now); err != nil {
+			return err
+		}
+	}
+	if t.known[userID] {
+		return nil
+	}
+	if _, ok := t.admitted[userID]; ok {
+		t.admitted[userID] = now
+		return nil
+	}
+
+	exists, err := r.users.HasTenantUser(ctx, id, userID)
+	if err != nil {
+		return fmt.Errorf("look up user %d of tenant %s: %w", userID, id, err)
+	}
+	if exists {
+		t.known[userID] = true
+		return nil
+	}
+
+	if t.full() {
+		for user, at := range t.admitted {
+			if now.Sub(at) >= admitTTL {
+				delete(t.admitted, user)
+			}
+		}
+		// Wallets are also opened by approved reviews and other replicas.
+		if t.full() && now.Sub(t.counted) >= admitTTL {
+			if err := r.countUsers(ctx, t, now); err != nil {
+				return err
+			}
+		}
+		if t.full() {
+			rejectedTotal.Inc(id, ReasonUserLimit)
+			return processor.Invalid(ReasonUserLimit, "user_id", "tenant %s already has %d users, the limit is %d",
+				id, t.count+int64(len(t.admitted)), t.cfg.MaxUsers)
+		}
+	}
+	t.admitted[userID] = now
+	r.log.WithFields(logrus.Fields{"tenant_id": id, "user_id": userID, "users": t.count}).Debug("new tenant user admitted")
+	return nil
+}
+
+// countUsers reloads the committed user count of the tenant.
+func (r *Registry) countUsers(ctx context.Context, t *state, now time.Time) error {
+	count, err := r.users.CountTenantUsers(ctx, t.cfg.ID)
+	if err != nil {
+		return fmt.Errorf("count users of tenant %s: %w", t.cfg.ID, err)
+	}
+	t.count, t.counted = count, now
+	usersGauge.Set(float64(count), t.cfg.ID)
+	return nil
+}
+
+// full reports whether the committed and admitted users reach the limit.
+func (t *state) full() bool {
+	return t.count+int64(len(t.admitted)) >= int64(t.cfg.MaxUsers)
+}
+
+// BatchCommitted implements processor.CommitObserver. The admitted users a
+// batch committed a balance of now count against their tenant's limit.
+func (r *Registry) BatchCommitted(_ context.Context, commit processor.Commit) {
+	for _, c := range commit.Changes {
+		changesTotal.Inc(Label(c.TenantID))
+		if t := r.lookup(c.TenantID); t != nil && t.cfg.MaxUsers > 0 {
+			t.commitUser(c.UserID)
+		}
+	}
+}
+
+func (t *state) commitUser(userID uint) {
+	t.usersMu.Lock()
+	defer t.usersMu.Unlock()
+	if _, ok := t.admitted[userID]; !ok {
+		return
+	}
+	delete(t.admitted, userID)
+	t.known[userID] = true
+	t.count++
+	usersGauge.Set(float64(t.count), t.cfg.ID)
+}
+
+func (r *Registry) lookup(tenant string) *state {
+	if r == nil || tenant == "" {
+		return nil
+	}
+	return r.tenants[tenant]
+}
+
+// Label is the metric label of a tenant.
+func Label(tenant string) string {
+	if tenant == "" {
+		return model.DefaultTenant
+	}
+	return tenant
+}
+
+// reserve takes a token from the bucket and returns how long to wait until
+// it is covered. Without a rate limit it never waits.
+func (t *state) reserve(now time.Time) time.Duration {
+	rate := t.cfg.MaxMessagesPerSecond
+	if rate <= 0 {
+		return 0
+	}
+
+	t.rateMu.Lock()
+	defer t.rateMu.Unlock()
+
+	if !t.last.IsZero() {
+		t.tokens += now.Sub(t.last).Seconds() * rate
+		if limit := burst(rate); t.tokens > limit {
+			t.tokens = limit
+		}
+	}
+	t.last = now
+	t.tokens--
+	if t.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-t.tokens / rate * float64(time.Second))
+}
+
+// burst is the size of the bucket: one second of messages, at least one.
+func burst(rate float64) float64 {
+	if rate < 1 {
+		return 1
+	}
+	return rate
+}
@@ -0,0 +1,252 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"balance-service/internal/config"
+	"balance-service/internal/processor"
+	"github.com/sirupsen/logrus"
+)
+
+// users is a UserCounter over a fixed set of users per tenant.
+type users struct {
+	byTenant map[string]map[uint]bool
+	err      error
+}
+
+func (u *users) CountTenantUsers(_ context.Context, tenantID string) (int64, error) {
+	return int64(len(u.byTenant[tenantID])), u.err
+}
+
+func (u *users) HasTenantUser(_ context.Context, tenantID string, userID uint) (bool, error) {
+	return u.byTenant[tenantID][userID], u.err
+}
+
+func testRegistry(counter UserCounter, tenants ...config.TenantConfig) *Registry {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	if len(tenants) == 0 {
+		tenants = []config.TenantConfig{{ID: "acme", Queue: "acme_updates"}, {ID: "globex"}}
+	}
+	return New(tenants, counter, log)
+}
+
+// rejection returns the reason err rejects a message for, or "" for nil.
+func rejection(t *testing.T, err error) string {
+	t.Helper()
+	if err == nil {
+		return ""
+	}
+	var invalid *processor.ValidationError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("error %v is not a *processor.ValidationError", err)
+	}
+	return invalid.Reason
+}
+
+func TestResolve(t *testing.T) {
+	r := testRegistry(nil)
+
+	for name, c := range map[string]struct {
+		body   string
+		origin Origin
+		tenant string
+		reason string
+	}{
+		"none":                  {},
+		"body":                  {body: "acme", tenant: "acme"},
+		"header":                {origin: Origin{Header: "acme"}, tenant: "acme"},
+		"queue":                 {origin: Origin{Queue: "acme"}, tenant: "acme"},
+		"all agree":             {body: "acme", origin: Origin{Header: "acme", Queue: "acme"}, tenant: "acme"},
+		"header vs body":        {body: "acme", origin: Origin{Header: "globex"}, reason: processor.ReasonConflict},
+		"queue vs body":         {body: "globex", origin: Origin{Queue: "acme"}, reason: processor.ReasonConflict},
+		"queue vs header":       {origin: Origin{Header: "globex", Queue: "acme"}, reason: processor.ReasonConflict},
+		"unknown":               {body: "initech", reason: ReasonUnknownTenant},
+		"unknown header":        {origin: Origin{Header: "initech"}, reason: ReasonUnknownTenant},
+		"invalid":               {origin: Origin{Header: "Not A Tenant"}, reason: processor.ReasonInvalid},
+		"reserved default name": {body: "default", reason: ReasonUnknownTenant},
+
+		// With signatures checked, only a signed header names the tenant.
+		"unsigned header": {origin: Origin{Header: "acme", RequireSigned: true}, reason: ReasonUnsignedTenant},
+		"unsigned header matching the body": {
+			body: "acme", origin: Origin{Header: "acme", RequireSigned: true}, reason: ReasonUnsignedTenant,
+		},
+		"signed header":          {origin: Origin{Header: "acme", HeaderSigned: true, RequireSigned: true}, tenant: "acme"},
+		"body, signing checked":  {body: "acme", origin: Origin{RequireSigned: true}, tenant: "acme"},
+		"queue, signing checked": {origin: Origin{Queue: "acme", RequireSigned: true}, tenant: "acme"},
+	} {
+		msg := processor.BalanceMessage{UserID: 1, TenantID: c.body}
+		err := r.Resolve(&msg, c.origin)
+		if got := rejection(t, err); got != c.reason {
+			t.Errorf("%s: rejected with %q, want %q (%v)", name, got, c.reason, err)
+			continue
+		}
+		if err == nil && msg.TenantID != c.tenant {
+			t.Errorf("%s: resolved to %q, want %q", name, msg.TenantID, c.tenant)
+		}
+	}
+
+	// Without configured tenants only the default tenant exists.
+	var none *Registry
+	msg := processor.BalanceMessage{UserID: 1}
+	if err := none.Resolve(&msg, Origin{}); err != nil || msg.TenantID != "" {
+		t.Errorf("nil registry resolved %q, %v; want the default tenant", msg.TenantID, err)
+	}
+	if err := none.Resolve(&msg, Origin{Header: "acme"}); rejection(t, err) != ReasonUnknownTenant {
+		t.Errorf("nil registry accepted a tenant: %v", err)
+	}
+}
+
+func TestQueues(t *testing.T) {
+	queues := testRegistry(nil).Queues()
+	if len(queues) != 1 || queues["acme"] != "acme_updates" {
+		t.Errorf("Queues() = %v, want acme's queue only", queues)
+	}
+}
+
+func TestAdmitUserLimit(t *testing.T) {
+	counter := &users{byTenant: map[string]map[uint]bool{"acme": {1: true, 2: true}}}
+	r := testRegistry(counter, config.TenantConfig{ID: "acme", MaxUsers: 3})
+	ctx := context.Background()
+
+	for _, c := range []struct {
+		user   uint
+		reason string
+	}{
+		{1, ""},              // has a wallet already
+		{3, ""},              // the third user fits
+		{4, ReasonUserLimit}, // the fourth does not
+		{2, ""},              // known users keep coming in
+		{3, ""},              // so does the one admitted above
+		{4, ReasonUserLimit},
+	} {
+		err := r.Admit(ctx, processor.BalanceMessage{UserID: c.user, TenantID: "acme"})
+		if got := rejection(t, err); got != c.reason {
+			t.Errorf("user %d: rejected with %q, want %q", c.user, got, c.reason)
+		}
+	}
+
+	// The default tenant has no limits and needs no counter.
+	if err := r.Admit(ctx, processor.BalanceMessage{UserID: 99}); err != nil {
+		t.Errorf("default tenant: %v", err)
+	}
+}
+
+func TestAdmitCountsCommittedUsers(t *testing.T) {
+	counter := &users{byTenant: map[string]map[uint]bool{"acme": {1: true}}}
+	r := testRegistry(counter, config.TenantConfig{ID: "acme", MaxUsers: 3})
+	ctx := context.Background()
+	admit := func(user uint) string {
+		return rejection(t, r.Admit(ctx, processor.BalanceMessage{UserID: user, TenantID: "acme"}))
+	}
+
+	if admit(2) != "" || admit(3) != "" {
+		t.Fatal("users within the limit rejected")
+	}
+	if got := admit(4); got != ReasonUserLimit {
+		t.Fatalf("user 4 rejected with %q while 2 and 3 are in flight", got)
+	}
+
+	// User 2's balance commits; user 3's message is dead-lettered and never
+	// commits one.
+	r.BatchCommitted(ctx, processor.Commit{Changes: []processor.BalanceChange{{UserID: 2, TenantID: "acme", Currency: "USD"}}})
+	acme := r.lookup("acme")
+	if acme.count != 2 || !acme.known[2] || len(acme.admitted) != 1 {
+		t.Fatalf("count %d, known %v, admitted %v after the commit", acme.count, acme.known, acme.admitted)
+	}
+	if got := admit(4); got != ReasonUserLimit {
+		t.Fatalf("user 4 rejected with %q while 3 holds its place", got)
+	}
+
+	// Once user 3's place lapses, user 4 takes it.
+	acme.admitted[3] = time.Now().Add(-admitTTL)
+	if got := admit(4); got != "" {
+		t.Errorf("user 4 rejected with %q after user 3's place lapsed", got)
+	}
+	if _, ok := acme.admitted[3]; ok {
+		t.Error("user 3 still admitted")
+	}
+	if admit(2) != "" {
+		t.Error("committed user rejected")
+	}
+}
+
+func TestAdmitReportsCounterErrors(t *testing.T) {
+	failure := errors.New("database down")
+	r := testRegistry(&users{err: failure}, config.TenantConfig{ID: "acme", MaxUsers: 3})
+	err := r.Admit(context.Background(), processor.BalanceMessage{UserID: 1, TenantID: "acme"})
+	var invalid *processor.ValidationError
+	if !errors.Is(err, failure) || errors.As(err, &invalid) {
+		t.Errorf("Admit = %v, want the database error so the message is requeued", err)
+	}
+}
+
+func TestReserveTokenBucket(t *testing.T) {
+	s := &state{cfg: config.TenantConfig{ID: "acme", MaxMessagesPerSecond: 2}, tokens: burst(2)}
+	start := time.Unix(1000, 0)
+
+	// A full bucket lets a second of messages through at once.
+	for i := 0; i < 2; i++ {
+		if wait := s.reserve(start); wait != 0 {
+			t.Fatalf("message %d waits %v within the burst", i, wait)
+		}
+	}
+	// The next ones queue up half a second apart.
+	if wait := s.reserve(start); wait != 500*time.Millisecond {
+		t.Errorf("third message waits %v, want 500ms", wait)
+	}
+	if wait := s.reserve(start); wait != time.Second {
+		t.Errorf("fourth message waits %v, want 1s", wait)
+	}
+
+	// Refilled after a long pause, but never beyond the burst.
+	later := start.Add(time.Minute)
+	for i := 0; i < 2; i++ {
+		if wait := s.reserve(later); wait != 0 {
+			t.Fatalf("message %d after a pause waits %v", i, wait)
+		}
+	}
+	if wait := s.reserve(later); wait == 0 {
+		t.Error("bucket refilled beyond one second of messages")
+	}
+
+	// Rates below one message a second still let one message through.
+	slow := &state{cfg: config.TenantConfig{MaxMessagesPerSecond: 0.5}, tokens: burst(0.5)}
+	if wait := slow.reserve(start); wait != 0 {
+		t.Errorf("first slow message waits %v", wait)
+	}
+	if wait := slow.reserve(start); wait != 2*time.Second {
+		t.Errorf("second slow message waits %v, want 2s", wait)
+	}
+
+	unlimited := &state{}
+	if wait := unlimited.reserve(start); wait != 0 {
+		t.Errorf("unlimited tenant waits %v", wait)
+	}
+}
+
+func TestAdmitWaitsForTheRateLimit(t *testing.T) {
+	r := testRegistry(nil, config.TenantConfig{ID: "acme", MaxMessagesPerSecond: 20})
+	msg := processor.BalanceMessage{UserID: 1, TenantID: "acme"}
+
+	started := time.Now()
+	for i := 0; i < 22; i++ {
+		if err := r.Admit(context.Background(), msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 20 pass at once, the two after them wait 50ms each.
+	if elapsed := time.Since(started); elapsed < 90*time.Millisecond {
+		t.Errorf("22 messages at 20/s admitted in %v, want about 100ms", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := r.Admit(ctx, msg); !errors.Is(err, context.Canceled) {
+		t.Errorf("Admit over the limit with a cancelled context = %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,138 @@
+package tlsutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"balance-service/internal/config"
+)
+
+// issue creates a certificate for name signed by parent, or a self-signed CA
+// when parent is nil, and returns it with its key.
+func issue(t *testing.T, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage = x509.KeyUsageCertSign
+		parent, parentKey = tmpl, key
+	} else {
+		tmpl.DNSNames = []string{name}
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		tmpl.KeyUsage = x509.KeyUsageDigitalSignature
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func writePEM(t *testing.T, dir, name, kind string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: kind, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// handshake serves cert on a local listener and connects to it with client.
+func handshake(t *testing.T, cert tls.Certificate, client *tls.Config) error {
+	t.Helper()
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		_ = conn.(*tls.Conn).Handshake()
+		conn.Close()
+	}()
+
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: 5 * time.Second}, "tcp", ln.Addr().String(), client)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func TestLoadTrustsOnlyTheConfiguredCA(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey := issue(t, "test CA", nil, nil)
+	server, serverKey := issue(t, "rabbitmq.internal", ca, caKey)
+	keyDER, err := x509.MarshalECPrivateKey(serverKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.TLSConfig{
+		Enabled:    true,
+		CAFile:     writePEM(t, dir, "ca.pem", "CERTIFICATE", ca.Raw),
+		CertFile:   writePEM(t, dir, "server.pem", "CERTIFICATE", server.Raw),
+		KeyFile:    writePEM(t, dir, "server-key.pem", "EC PRIVATE KEY", keyDER),
+		ServerName: "rabbitmq.internal",
+	}
+
+	tlsCfg, err := Load(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := x509.NewCertPool()
+	want.AddCert(ca)
+	if !tlsCfg.RootCAs.Equal(want) {
+		t.Error("RootCAs holds more than the configured CA")
+	}
+	if len(tlsCfg.Certificates) != 1 {
+		t.Fatalf("%d client certificates, want 1", len(tlsCfg.Certificates))
+	}
+
+	if err := handshake(t, tlsCfg.Certificates[0], tlsCfg); err != nil {
+		t.Errorf("handshake with a server issued by the CA: %v", err)
+	}
+
+	// A server whose certificate comes from another CA is refused.
+	other, otherKey := issue(t, "other CA", nil, nil)
+	impostor, impostorKey := issue(t, "rabbitmq.internal", other, otherKey)
+	if err := handshake(t, tls.Certificate{Certificate: [][]byte{impostor.Raw}, PrivateKey: impostorKey}, tlsCfg); err == nil {
+		t.Error("handshake with a server issued by another CA succeeded")
+	}
+}
+
+func TestLoadRejectsCAFileWithoutCertificates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(path, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(config.TLSConfig{Enabled: true, CAFile: path}); err == nil {
+		t.Error("Load accepted a CA file without certificates")
+	}
+}
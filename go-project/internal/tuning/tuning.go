@@ -0,0 +1,147 @@
+// Package tuning holds the settings that may change while the service runs.
+// Goroutines that depend on them subscribe to the Store and pick up new values
+// on their next iteration instead of being restarted.
+package tuning
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"balance-service/internal/config"
+	"github.com/sirupsen/logrus"
+)
+
+// Settings are the runtime-tunable parts of config.Config.
+type Settings struct {
+	BatchSize     int
+	BatchInterval time.Duration
+	Prefetch      int
+	LogLevel      string
+	SyncInterval  time.Duration
+}
+
+// FromConfig extracts the tunable settings from cfg.
+func FromConfig(cfg *config.Config) Settings {
+	return Settings{
+		BatchSize:     cfg.Batch.Size,
+		BatchInterval: cfg.Batch.Interval,
+		Prefetch:      cfg.Rabbit.Prefetch,
+		LogLevel:      cfg.Log.Level,
+		SyncInterval:  cfg.Sync.Interval,
+	}
+}
+
+// ApplyTo copies the settings into cfg.
+func (s Settings) ApplyTo(cfg *config.Config) {
+	cfg.Batch.Size = s.BatchSize
+	cfg.Batch.Interval = s.BatchInterval
+	cfg.Rabbit.Prefetch = s.Prefetch
+	cfg.Log.Level = s.LogLevel
+	cfg.Sync.Interval = s.SyncInterval
+}
+
+func (s Settings) Validate() error {
+	var errs []error
+	if s.BatchSize < 1 {
+		errs = append(errs, fmt.Errorf("batch_size must be at least 1, got %d", s.BatchSize))
+	}
+	if s.BatchInterval <= 0 {
+		errs = append(errs, fmt.Errorf("batch_interval must be positive, got %s", s.BatchInterval))
+	}
+	if s.Prefetch < 0 {
+		errs = append(errs, fmt.Errorf("prefetch must not be negative, got %d", s.Prefetch))
+	}
+	if _, err := logrus.ParseLevel(s.LogLevel); err != nil {
+		errs = append(errs, fmt.Errorf("log_level: %w", err))
+	}
+	if s.SyncInterval <= 0 {
+		errs = append(errs, fmt.Errorf("sync_interval must be positive, got %s", s.SyncInterval))
+	}
+	return errors.Join(errs...)
+}
+
+type Store struct {
+	mu          sync.RWMutex
+	current     Settings
+	subscribers []chan struct{}
+}
+
+func NewStore(initial Settings) *Store {
+	return &Store{current: initial}
+}
+
+func (s *Store) Get() Settings {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.current
+}
+
+// Subscribe returns a channel that receives a signal after every change.
+// Signals are coalesced: a slow subscriber only sees the latest settings.
+func (s *Store) Subscribe() <-chan struct{} {
+	ch := make(chan struct{}, 1)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subscribers = append(s.subscribers, ch)
+	return ch
+}
+
+// Update validates and publishes next. It returns the previous settings.
+func (s *Store) Update(next Settings) (Settings, error) {
+	if err := next.Validate(); err != nil {
+		return Settings{}, err
+	}
+
+	s.mu.Lock()
+	prev := s.current
+	s.current = next
+	subscribers := s.subscribers
+	s.mu.Unlock()
+
+	if prev == next {
+		return prev, nil
+	}
+	for _, ch := range subscribers {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+	return prev, nil
+}
+
+// Reload re-reads the configuration from path and the environment and
+// publishes its tunable settings. Any other difference from running is
+// rejected, because applying it would need a restart.
+func Reload(running *config.Config, path string, store *Store) (Settings, error) {
+	fresh, err := config.Load(path)
+	if err != nil {
+		return Settings{}, err
+	}
+
+	next := FromConfig(fresh)
+	candidate := *running
+	next.ApplyTo(&candidate)
+	if changed := config.Diff(&candidate, fresh); len(changed) > 0 {
+		return Settings{}, &StaticChangeError{Fields: changed}
+	}
+
+	if _, err := store.Update(next); err != nil {
+		return Settings{}, err
+	}
+	return next, nil
+}
+
+// StaticChangeError lists settings that changed but cannot be applied at
+// runtime.
+type StaticChangeError struct {
+	Fields []string
+}
+
+func (e *StaticChangeError) Error() string {
+	return fmt.Sprintf("cannot change %s at runtime, restart the service instead", strings.Join(e.Fields, ", "))
+}
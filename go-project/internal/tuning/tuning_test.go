@@ -0,0 +1,92 @@
+package tuning
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"balance-service/internal/config"
+)
+
+func settings() Settings {
+	return Settings{BatchSize: 100, BatchInterval: time.Second, Prefetch: 10, LogLevel: "info", SyncInterval: time.Minute}
+}
+
+func TestUpdateNotifiesSubscribersOfChanges(t *testing.T) {
+	store := NewStore(settings())
+	changed := store.Subscribe()
+
+	if _, err := store.Update(settings()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-changed:
+		t.Error("subscriber notified although nothing changed")
+	default:
+	}
+
+	next := settings()
+	next.BatchSize = 200
+	prev, err := store.Update(next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prev.BatchSize != 100 || store.Get().BatchSize != 200 {
+		t.Errorf("Update returned %d and stored %d, want 100 and 200", prev.BatchSize, store.Get().BatchSize)
+	}
+	select {
+	case <-changed:
+	default:
+		t.Error("subscriber not notified of the change")
+	}
+
+	next.LogLevel = "chatty"
+	if _, err := store.Update(next); err == nil {
+		t.Error("Update accepted an invalid log level")
+	}
+	if store.Get().LogLevel != "info" {
+		t.Errorf("log level %q after a rejected update, want info", store.Get().LogLevel)
+	}
+}
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	secret := filepath.Join(dir, "db-password")
+	path := filepath.Join(dir, "config.yaml")
+	write := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(name, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(secret, "first")
+	write(path, "database:\n  driver: sqlite\n  path: balance.db\n  password_ref: file:"+secret+"\nbatch:\n  size: 100\n")
+
+	running, err := config.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewStore(FromConfig(running))
+
+	// A rotated secret and a new batch size are applied.
+	write(secret, "second")
+	write(path, "database:\n  driver: sqlite\n  path: balance.db\n  password_ref: file:"+secret+"\nbatch:\n  size: 250\n")
+	if _, err := Reload(running, path, store); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if got := store.Get().BatchSize; got != 250 {
+		t.Errorf("batch size %d, want 250", got)
+	}
+
+	// Moving the database needs a restart.
+	write(path, "database:\n  driver: sqlite\n  path: other.db\n  password_ref: file:"+secret+"\n")
+	var static *StaticChangeError
+	if _, err := Reload(running, path, store); !errors.As(err, &static) || len(static.Fields) != 1 || static.Fields[0] != "database.path" {
+		t.Errorf("Reload: %v, want a static change of database.path", err)
+	}
+	if got := store.Get().BatchSize; got != 250 {
+		t.Errorf("batch size %d after a refused reload, want 250", got)
+	}
+}
@@ -0,0 +1,99 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Sender posts JSON payloads to HTTP endpoints and retries the failures
+// worth another attempt with exponential backoff. Subscriptions and the
+// alert webhook sink both deliver through it.
+type Sender struct {
+	Client         *http.Client
+	UserAgent      string
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration // zero leaves the backoff uncapped
+}
+
+// Message is one payload for one endpoint.
+type Message struct {
+	URL       string
+	Secret    string // signs the body when set
+	ID        string
+	EventType string
+	Body      []byte
+}
+
+// Attempt describes one try to deliver a message.
+type Attempt struct {
+	Number  int
+	Status  int // zero when no response arrived
+	Elapsed time.Duration
+	Err     error
+	Final   bool // no further attempt follows
+}
+
+// Deliver sends msg until it is accepted, rejected for good, out of
+// attempts, or ctx is done, and returns the error of the last attempt.
+// observe, if set, is told about every attempt.
+func (s *Sender) Deliver(ctx context.Context, msg Message, observe func(Attempt)) error {
+	backoff := s.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		started := time.Now()
+		status, err := s.post(ctx, msg)
+		a := Attempt{Number: attempt, Status: status, Elapsed: time.Since(started), Err: err}
+		a.Final = err == nil || !retryable(status) || attempt >= s.MaxAttempts
+		if observe != nil {
+			observe(a)
+		}
+		if a.Final {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; s.MaxBackoff > 0 && backoff > s.MaxBackoff {
+			backoff = s.MaxBackoff
+		}
+	}
+}
+
+func (s *Sender) post(ctx context.Context, msg Message) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, msg.URL, bytes.NewReader(msg.Body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", s.UserAgent)
+	req.Header.Set(HeaderEvent, msg.EventType)
+	req.Header.Set(HeaderDelivery, msg.ID)
+	if msg.Secret != "" {
+		req.Header.Set(HeaderSignature, Sign(msg.Secret, time.Now(), msg.Body))
+	}
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("endpoint answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// retryable tells failures worth another attempt: no response at all, rate
+// limiting and server errors. Other client errors will not go away.
+func retryable(status int) bool {
+	return status == 0 || status == http.StatusTooManyRequests || status >= 500
+}
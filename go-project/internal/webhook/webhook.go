@@ -0,0 +1,387 @@
+// Package webhook notifies HTTP endpoints about committed balance changes.
+// Every subscription gets its own delivery queue and worker, so a slow or
+// failing endpoint delays only itself. Deliveries are retried with
+// exponential backoff, signed with the subscription's secret and logged in
+// webhook_deliveries.
+package webhook
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"balance-service/internal/config"
+	"balance-service/internal/metrics"
+	"balance-service/internal/model"
+	"balance-service/internal/processor"
+	"balance-service/internal/signing"
+	"github.com/sirupsen/logrus"
+)
+
+// Headers of a delivery. The signature is "t=<unix seconds>,v1=<hex>", where
+// v1 is the HMAC-SHA256 of "<t>.<body>" keyed with the subscription secret;
+// receivers should reject old timestamps to stop replays.
+const (
+	HeaderEvent     = "X-Balance-Event"
+	HeaderDelivery  = "X-Balance-Delivery"
+	HeaderSignature = "X-Balance-Signature"
+)
+
+// Outcomes of a delivery attempt. Dropped deliveries never reached the
+// queue and are only counted.
+const (
+	OutcomeDelivered = "delivered"
+	OutcomeRetrying  = "retrying"
+	OutcomeFailed    = "failed"
+	OutcomeDropped   = "dropped"
+)
+
+// logTimeout bounds writes to the delivery log.
+const logTimeout = 5 * time.Second
+
+var deliveries = metrics.NewCounter("balance_webhook_deliveries_total", "Webhook delivery attempts, by outcome.", "outcome")
+
+// Payload is the JSON body of every delivery.
+type Payload struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// BalanceUpdated is the data of a balance.updated event.
+type BalanceUpdated struct {
+	UserID          uint      `json:"user_id"`
+	TenantID        string    `json:"tenant_id,omitempty"`
+	Currency        string    `json:"currency"`
+	Amount          float64   `json:"amount"`
+	Version         uint      `json:"version"`
+	PreviousAmount  *float64  `json:"previous_amount,omitempty"`
+	PreviousVersion uint      `json:"previous_version,omitempty"`
+	EventID         string    `json:"event_id,omitempty"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// VersionGap is the data of a balance.version_gap event.
+type VersionGap struct {
+	UserID          uint   `json:"user_id"`
+	TenantID        string `json:"tenant_id,omitempty"`
+	Currency        string `json:"currency"`
+	AppliedVersion  uint   `json:"applied_version"`
+	ReceivedVersion uint   `json:"received_version"`
+	MissingVersions uint   `json:"missing_versions"`
+}
+
+// Sign returns the signature header value of body sent at t.
+func Sign(secret string, t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	return "t=" + ts + ",v1=" + signing.Sign([]byte(secret), append([]byte(ts+"."), body...))
+}
+
+type delivery struct {
+	id        string
+	eventType string
+	userID    uint
+	body      []byte
+}
+
+type endpoint struct {
+	sub   model.WebhookSubscription
+	queue chan delivery
+	done  chan struct{} // closed once the worker has drained the queue
+}
+
+// SubscriptionStore is where the dispatcher reads subscriptions and logs
+// deliveries, a *repository.WebhookRepository in production.
+type SubscriptionStore interface {
+	ListSubscriptions(ctx context.Context, tenant *string, activeOnly bool) ([]model.WebhookSubscription, error)
+	LogDelivery(ctx context.Context, delivery *model.WebhookDelivery) error
+}
+
+type Dispatcher struct {
+	cfg    config.WebhookConfig
+	repo   SubscriptionStore
+	sender *Sender
+	log    *logrus.Logger
+
+	// ctx outlives the service context so queued deliveries can still be
+	// sent during shutdown; Close cancels it when its deadline passes.
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu        sync.RWMutex
+	endpoints map[uint]*endpoint
+	closed    bool
+}
+
+// New creates a dispatcher. It delivers nothing until Start.
+func New(cfg config.WebhookConfig, repo SubscriptionStore, log *logrus.Logger) *Dispatcher {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Dispatcher{
+		cfg:  cfg,
+		repo: repo,
+		sender: &Sender{
+			Client:         &http.Client{Timeout: cfg.Timeout},
+			UserAgent:      "balance-service-webhooks",
+			MaxAttempts:    cfg.MaxAttempts,
+			InitialBackoff: cfg.InitialBackoff,
+			MaxBackoff:     cfg.MaxBackoff,
+		},
+		log:       log,
+		ctx:       ctx,
+		cancel:    cancel,
+		endpoints: make(map[uint]*endpoint),
+	}
+}
+
+// Start loads the subscriptions and keeps re-reading them every refresh
+// interval until ctx is cancelled.
+func (d *Dispatcher) Start(ctx context.Context) error {
+	if err := d.Refresh(ctx); err != nil {
+		return err
+	}
+
+	go func() {
+		ticker := time.NewTicker(d.cfg.RefreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := d.Refresh(ctx); err != nil && ctx.Err() == nil {
+					d.log.WithError(err).Warn("failed to refresh webhook subscriptions")
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// Refresh applies the stored subscriptions: new ones get a worker, removed
+// or paused ones finish their queue and stop, changed ones are replaced by a
+// worker that starts once the old one has finished.
+func (d *Dispatcher) Refresh(ctx context.Context) error {
+	subs, err := d.repo.ListSubscriptions(ctx, nil, true)
+	if err != nil {
+		return fmt.Errorf("read webhook subscriptions: %w", err)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return nil
+	}
+
+	seen := make(map[uint]bool, len(subs))
+	for _, sub := range subs {
+		seen[sub.ID] = true
+		prev, ok := d.endpoints[sub.ID]
+		if ok {
+			if sameEndpoint(prev.sub, sub) {
+				continue
+			}
+			close(prev.queue)
+		}
+		d.endpoints[sub.ID] = d.startEndpoint(sub, prev)
+	}
+	for id, ep := range d.endpoints {
+		if !seen[id] {
+			close(ep.queue)
+			delete(d.endpoints, id)
+		}
+	}
+	return nil
+}
+
+func sameEndpoint(a, b model.WebhookSubscription) bool {
+	if a.TenantID != b.TenantID || a.URL != b.URL || a.Secret != b.Secret || len(a.UserIDs) != len(b.UserIDs) || len(a.EventTypes) != len(b.EventTypes) {
+		return false
+	}
+	for i := range a.UserIDs {
+		if a.UserIDs[i] != b.UserIDs[i] {
+			return false
+		}
+	}
+	for i := range a.EventTypes {
+		if a.EventTypes[i] != b.EventTypes[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// startEndpoint starts the worker of sub. A worker replacing prev waits for
+// it, so that an endpoint never gets two deliveries at once or out of order.
+func (d *Dispatcher) startEndpoint(sub model.WebhookSubscription, prev *endpoint) *endpoint {
+	ep := &endpoint{sub: sub, queue: make(chan delivery, d.cfg.QueueSize), done: make(chan struct{})}
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		defer close(ep.done)
+		if prev != nil {
+			<-prev.done
+		}
+		for dl := range ep.queue {
+			if d.ctx.Err() != nil {
+				return
+			}
+			d.deliver(ep.sub, dl)
+		}
+	}()
+	return ep
+}
+
+// BatchCommitted implements processor.CommitObserver. It only queues.
+func (d *Dispatcher) BatchCommitted(_ context.Context, commit processor.Commit) {
+	for _, c := range commit.Changes {
+		d.enqueue(model.WebhookBalanceUpdated, c.TenantID, c.UserID, BalanceUpdated{
+			UserID:          c.UserID,
+			TenantID:        c.TenantID,
+			Currency:        c.Currency,
+			Amount:          c.Amount,
+			Version:         c.Version,
+			PreviousAmount:  c.PreviousAmount,
+			PreviousVersion: c.PreviousVersion,
+			EventID:         c.EventID,
+			UpdatedAt:       c.UpdatedAt,
+		})
+	}
+	for _, g := range commit.Gaps {
+		d.enqueue(model.WebhookVersionGap, g.TenantID, g.UserID, VersionGap{
+			UserID:          g.UserID,
+			TenantID:        g.TenantID,
+			Currency:        g.Currency,
+			AppliedVersion:  g.AppliedVersion,
+			ReceivedVersion: g.ReceivedVersion,
+			MissingVersions: g.MissingVersions,
+		})
+	}
+}
+
+func (d *Dispatcher) enqueue(eventType, tenantID string, userID uint, data interface{}) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.closed {
+		return
+	}
+
+	for _, ep := range d.endpoints {
+		if !ep.sub.Matches(eventType, tenantID, userID) {
+			continue
+		}
+
+		// Each subscription gets its own ID so its deliveries can be told
+		// apart in the log and deduplicated by the receiver.
+		id := newID()
+		body, err := json.Marshal(Payload{ID: id, Type: eventType, CreatedAt: time.Now().UTC(), Data: data})
+		if err != nil {
+			d.log.WithError(err).Error("failed to encode webhook payload")
+			return
+		}
+
+		dl := delivery{id: id, eventType: eventType, userID: userID, body: body}
+		select {
+		case ep.queue <- dl:
+		default:
+			// Not logged in the table: this runs on a processor worker.
+			deliveries.Inc(OutcomeDropped)
+			d.log.WithFields(logrus.Fields{
+				"subscription_id": ep.sub.ID,
+				"event_type":      eventType,
+				"tenant_id":       tenantID,
+				"user_id":         userID,
+			}).Warn("webhook queue is full, dropping delivery")
+		}
+	}
+}
+
+// deliver sends dl until it is accepted, rejected for good, or out of
+// attempts, logging every attempt.
+func (d *Dispatcher) deliver(sub model.WebhookSubscription, dl delivery) {
+	msg := Message{URL: sub.URL, Secret: sub.Secret, ID: dl.id, EventType: dl.eventType, Body: dl.body}
+	_ = d.sender.Deliver(d.ctx, msg, func(a Attempt) {
+		outcome := OutcomeRetrying
+		switch {
+		case a.Err == nil:
+			outcome = OutcomeDelivered
+		case a.Final:
+			outcome = OutcomeFailed
+		}
+		d.record(sub.ID, dl, a.Number, a.Status, a.Elapsed, a.Err, outcome)
+	})
+}
+
+func (d *Dispatcher) record(subID uint, dl delivery, attempt, status int, elapsed time.Duration, err error, outcome string) {
+	deliveries.Inc(outcome)
+
+	entry := model.WebhookDelivery{
+		SubscriptionID: subID,
+		DeliveryID:     dl.id,
+		EventType:      dl.eventType,
+		UserID:         dl.userID,
+		Attempt:        attempt,
+		StatusCode:     status,
+		DurationMs:     elapsed.Milliseconds(),
+		Outcome:        outcome,
+	}
+	if err != nil {
+		entry.Error = truncate(err.Error(), 1024)
+		d.log.WithError(err).WithFields(logrus.Fields{
+			"subscription_id": subID,
+			"delivery_id":     dl.id,
+			"attempt":         attempt,
+			"outcome":         outcome,
+		}).Warn("webhook delivery failed")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), logTimeout)
+	defer cancel()
+	if err := d.repo.LogDelivery(ctx, &entry); err != nil {
+		d.log.WithError(err).Error("failed to log webhook delivery")
+	}
+}
+
+// Close stops accepting events and waits for the queued deliveries until ctx
+// is done; retries still pending then are abandoned.
+func (d *Dispatcher) Close(ctx context.Context) {
+	d.mu.Lock()
+	d.closed = true
+	for id, ep := range d.endpoints {
+		close(ep.queue)
+		delete(d.endpoints, id)
+	}
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		d.log.Warn("abandoning pending webhook deliveries")
+	}
+	d.cancel()
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
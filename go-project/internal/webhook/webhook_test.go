@@ -0,0 +1,241 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"balance-service/internal/config"
+	"balance-service/internal/model"
+	"balance-service/internal/processor"
+	"github.com/sirupsen/logrus"
+)
+
+// store keeps subscriptions and the delivery log in memory.
+type store struct {
+	mu   sync.Mutex
+	subs []model.WebhookSubscription
+	log  []model.WebhookDelivery
+}
+
+func (s *store) ListSubscriptions(_ context.Context, _ *string, _ bool) ([]model.WebhookSubscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]model.WebhookSubscription(nil), s.subs...), nil
+}
+
+func (s *store) LogDelivery(_ context.Context, delivery *model.WebhookDelivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.log = append(s.log, *delivery)
+	return nil
+}
+
+func (s *store) outcomes() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var outcomes []string
+	for _, d := range s.log {
+		outcomes = append(outcomes, d.Outcome)
+	}
+	return outcomes
+}
+
+func testLogger() *logrus.Logger {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	return log
+}
+
+func newDispatcher(t *testing.T, repo *store) *Dispatcher {
+	t.Helper()
+	d := New(config.WebhookConfig{
+		QueueSize:       100,
+		MaxAttempts:     3,
+		InitialBackoff:  time.Millisecond,
+		MaxBackoff:      time.Millisecond,
+		Timeout:         time.Second,
+		RefreshInterval: time.Hour,
+	}, repo, testLogger())
+	if err := d.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func closeDispatcher(t *testing.T, d *Dispatcher) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	d.Close(ctx)
+}
+
+func commit(userID uint, versions ...uint) processor.Commit {
+	var c processor.Commit
+	for _, v := range versions {
+		c.Changes = append(c.Changes, processor.BalanceChange{UserID: userID, Currency: "USD", Amount: float64(v), Version: v})
+	}
+	return c
+}
+
+// verify checks the signature header of a request with body against secret.
+func verify(secret string, header string, body []byte) bool {
+	ts, _, ok := strings.Cut(strings.TrimPrefix(header, "t="), ",")
+	if !ok {
+		return false
+	}
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return false
+	}
+	return Sign(secret, time.Unix(sec, 0), body) == header
+}
+
+func TestDeliveriesAreSignedAndRetried(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		attempts int
+		received []Payload
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if !verify("s3cret", r.Header.Get(HeaderSignature), body) || r.Header.Get(HeaderEvent) != model.WebhookBalanceUpdated {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if attempts++; attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var p Payload
+		_ = json.Unmarshal(body, &p)
+		received = append(received, p)
+	}))
+	defer server.Close()
+
+	repo := &store{subs: []model.WebhookSubscription{{ID: 1, URL: server.URL, Secret: "s3cret", Active: true}}}
+	d := newDispatcher(t, repo)
+	d.BatchCommitted(context.Background(), commit(7, 1))
+	closeDispatcher(t, d)
+
+	if got := strings.Join(repo.outcomes(), ","); got != "retrying,delivered" {
+		t.Errorf("outcomes %s, want retrying,delivered", got)
+	}
+	if len(received) != 1 || received[0].Type != model.WebhookBalanceUpdated {
+		t.Fatalf("received %+v, want one balance.updated", received)
+	}
+	if data, _ := received[0].Data.(map[string]interface{}); data["user_id"] != float64(7) {
+		t.Errorf("payload data %v, want user 7", received[0].Data)
+	}
+}
+
+func TestClientErrorsAreNotRetried(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	repo := &store{subs: []model.WebhookSubscription{{ID: 1, URL: server.URL, Secret: "s3cret", Active: true}}}
+	d := newDispatcher(t, repo)
+	d.BatchCommitted(context.Background(), commit(7, 1))
+	closeDispatcher(t, d)
+
+	if got := strings.Join(repo.outcomes(), ","); got != "failed" {
+		t.Errorf("outcomes %s, want failed", got)
+	}
+}
+
+func TestEndpointGetsDeliveriesInOrderAcrossRefresh(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		inFlight int
+		overlap  bool
+		versions []uint
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		if inFlight++; inFlight > 1 {
+			overlap = true
+		}
+		mu.Unlock()
+
+		time.Sleep(2 * time.Millisecond)
+		var p struct{ Data BalanceUpdated }
+		_ = json.NewDecoder(r.Body).Decode(&p)
+
+		mu.Lock()
+		inFlight--
+		versions = append(versions, p.Data.Version)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	repo := &store{subs: []model.WebhookSubscription{{ID: 1, URL: server.URL, Secret: "old", Active: true}}}
+	d := newDispatcher(t, repo)
+	d.BatchCommitted(context.Background(), commit(7, 1, 2, 3, 4, 5))
+
+	// Rotating the secret replaces the worker while it is still busy.
+	repo.mu.Lock()
+	repo.subs[0].Secret = "new"
+	repo.mu.Unlock()
+	if err := d.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	d.BatchCommitted(context.Background(), commit(7, 6, 7, 8, 9, 10))
+	closeDispatcher(t, d)
+
+	if overlap {
+		t.Error("the endpoint got two deliveries at once")
+	}
+	if len(versions) != 10 {
+		t.Fatalf("%d deliveries, want 10", len(versions))
+	}
+	for i, v := range versions {
+		if v != uint(i+1) {
+			t.Fatalf("deliveries in order %v, want 1 to 10", versions)
+		}
+	}
+}
+
+func TestSubscriptionsOnlyGetTheirTenant(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		paths = map[string][]string{}
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p struct{ Data BalanceUpdated }
+		_ = json.NewDecoder(r.Body).Decode(&p)
+		mu.Lock()
+		paths[r.URL.Path] = append(paths[r.URL.Path], p.Data.TenantID)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	repo := &store{subs: []model.WebhookSubscription{
+		{ID: 1, URL: server.URL + "/default", Secret: "a", Active: true},
+		{ID: 2, TenantID: "acme", URL: server.URL + "/acme", Secret: "b", Active: true},
+	}}
+	d := newDispatcher(t, repo)
+	d.BatchCommitted(context.Background(), processor.Commit{Changes: []processor.BalanceChange{
+		{UserID: 7, Currency: "USD", Amount: 1, Version: 1},
+		{UserID: 7, TenantID: "acme", Currency: "USD", Amount: 2, Version: 1},
+		{UserID: 7, TenantID: "other", Currency: "USD", Amount: 3, Version: 1},
+	}})
+	closeDispatcher(t, d)
+
+	if got := paths["/default"]; len(got) != 1 || got[0] != "" {
+		t.Errorf("default tenant endpoint got tenants %q, want only the default one", got)
+	}
+	if got := paths["/acme"]; len(got) != 1 || got[0] != "acme" {
+		t.Errorf("acme endpoint got tenants %q, want only acme", got)
+	}
+}
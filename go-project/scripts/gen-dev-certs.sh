@@ -0,0 +1,40 @@
+#!/usr/bin/env sh
+# Generates a throwaway CA plus server and client certificates for trying
+# TLS against local RabbitMQ and MySQL containers. Not for production use.
+#
+#   ./scripts/gen-dev-certs.sh [out-dir] [server-hostname...]
+#
+# Point RABBITMQ_TLS_CA_FILE / DB_TLS_CA_FILE at ca.pem and the *_CERT_FILE /
+# *_KEY_FILE variables at client.pem / client-key.pem. The client certificate's
+# CN is "balance-service", which is the user name RabbitMQ derives for SASL
+# EXTERNAL.
+set -eu
+
+out=${1:-certs}
+[ $# -gt 0 ] && shift
+hosts=${*:-"localhost rabbitmq mysql-go"}
+
+mkdir -p "$out"
+cd "$out"
+
+san="subjectAltName="
+for h in $hosts; do san="${san}DNS:${h},"; done
+san="${san}IP:127.0.0.1"
+
+openssl req -x509 -newkey rsa:2048 -nodes -days 365 \
+	-subj "/CN=balance-dev-ca" -keyout ca-key.pem -out ca.pem
+
+openssl req -newkey rsa:2048 -nodes -subj "/CN=balance-server" \
+	-keyout server-key.pem -out server.csr
+printf '%s\nextendedKeyUsage=serverAuth\n' "$san" > server.ext
+openssl x509 -req -in server.csr -CA ca.pem -CAkey ca-key.pem -CAcreateserial \
+	-days 365 -extfile server.ext -out server.pem
+
+openssl req -newkey rsa:2048 -nodes -subj "/CN=balance-service" \
+	-keyout client-key.pem -out client.csr
+printf 'extendedKeyUsage=clientAuth\n' > client.ext
+openssl x509 -req -in client.csr -CA ca.pem -CAkey ca-key.pem -CAcreateserial \
+	-days 365 -extfile client.ext -out client.pem
+
+rm -f ./*.csr ./*.ext ca.srl
+echo "certificates written to $(pwd)"
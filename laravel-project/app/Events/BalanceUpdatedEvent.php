@@ -28,11 +28,12 @@ public function __construct(
     public function toArray(): array
     {
         return [
-            'user_id'    => $this->userId,
-            'new_amount' => $this->newAmount,
-            'version'    => $this->version,
-            'timestamp'  => $this->timestamp->format('c'), // ISO 8601 format
-            'event_id'   => uniqid('balance_', true), // Unique event ID for idempotency
+            'schema_version' => 2, // Payload contract version checked by the Go consumer
+            'user_id'        => $this->userId,
+            'new_amount'     => $this->newAmount,
+            'version'        => $this->version,
+            'timestamp'      => $this->timestamp->format('c'), // ISO 8601 format
+            'event_id'       => uniqid('balance_', true), // Unique event ID for idempotency
         ];
     }
 }
@@ -18,7 +18,9 @@ public function register(): void
                 config('rabbitmq.port'),
                 config('rabbitmq.user'),
                 config('rabbitmq.password'),
-                config('rabbitmq.vhost')
+                config('rabbitmq.vhost'),
+                config('rabbitmq.signing_key_id'),
+                config('rabbitmq.signing_key')
             );
         });
     }
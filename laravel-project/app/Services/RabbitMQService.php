@@ -7,6 +7,7 @@
 use PhpAmqpLib\Channel\AMQPChannel;
 use PhpAmqpLib\Connection\AMQPStreamConnection;
 use PhpAmqpLib\Message\AMQPMessage;
+use PhpAmqpLib\Wire\AMQPTable;
 
 class RabbitMQService
 {
@@ -20,7 +21,9 @@ public function __construct(
         private readonly int $port,
         private readonly string $user,
         private readonly string $password,
-        private readonly string $vhost = '/'
+        private readonly string $vhost = '/',
+        private readonly ?string $signingKeyId = NULL,
+        private readonly ?string $signingKey = NULL
     )
     {
     }
@@ -71,11 +74,7 @@ public function publish(string $queueName, array $data, string $exchangeName = '
                 $messageBody = json_encode($data, JSON_THROW_ON_ERROR);
                 $message     = new AMQPMessage(
                     $messageBody,
-                    [
-                        'delivery_mode' => AMQPMessage::DELIVERY_MODE_PERSISTENT, // Make message persistent
-                        'content_type'  => 'application/json',
-                        'timestamp'     => time(),
-                    ]
+                    $this->messageProperties($messageBody)
                 );
 
                 // Enable publisher confirms
@@ -120,6 +119,44 @@ public function publish(string $queueName, array $data, string $exchangeName = '
         );
     }
 
+    /**
+     * Build message properties, signing the message when a key is configured.
+     */
+    private function messageProperties(string $messageBody): array
+    {
+        $contentType = 'application/json';
+        $properties = [
+            'delivery_mode' => AMQPMessage::DELIVERY_MODE_PERSISTENT, // Make message persistent
+            'content_type'  => $contentType,
+            'timestamp'     => time(),
+        ];
+
+        if (!empty($this->signingKeyId) && !empty($this->signingKey)) {
+            $properties['application_headers'] = new AMQPTable([
+                'x-signature-key-id'  => $this->signingKeyId,
+                'x-signature'         => hash_hmac('sha256', $this->canonicalMessage($contentType, $messageBody), $this->signingKey),
+                'x-signature-version' => '2',
+            ]);
+        }
+
+        return $properties;
+    }
+
+    /**
+     * Canonical form covered by a version 2 signature: the version, content
+     * type, tenant header and CloudEvents headers (none here), each written
+     * as "<length>:<value>\n", followed by the body.
+     */
+    private function canonicalMessage(string $contentType, string $messageBody): string
+    {
+        $canonical = '';
+        foreach (['2', $contentType, '', '0'] as $field) {
+            $canonical .= strlen($field) . ':' . $field . "\n";
+        }
+
+        return $canonical . $messageBody;
+    }
+
     /**
      * Close connections gracefully.
      */
@@ -8,5 +8,9 @@
     'vhost' => env('RABBITMQ_VHOST', '/'),
     'exchange' => env('RABBITMQ_EXCHANGE', 'balance_exchange'),
     'queue' => env('RABBITMQ_QUEUE', 'balance_updates'),
+    // HMAC-SHA256 signing of published messages, verified by the Go consumer.
+    // Leave the key empty to publish unsigned messages.
+    'signing_key_id' => env('RABBITMQ_SIGNING_KEY_ID'),
+    'signing_key' => env('RABBITMQ_SIGNING_KEY'),
 ];
 